@@ -15,7 +15,7 @@ import (
 	"unicode"
 	"unicode/utf8"
 
-	"github.com/joelkehle/pinakes/pkg/busclient"
+	"github.com/joelkehle/techtransfer-agency/pkg/busclient"
 )
 
 func main() {
@@ -43,7 +43,9 @@ func main() {
 			case <-ctx.Done():
 				return
 			case <-time.After(60 * time.Second):
-				_ = client.RegisterAgent(ctx, *agentID, *secret, []string{*capability})
+				if err := client.Heartbeat(ctx, *agentID, *secret, nil); err != nil {
+					log.Printf("heartbeat: %v", err)
+				}
 			}
 		}
 	}()
@@ -54,6 +56,9 @@ func main() {
 		select {
 		case <-ctx.Done():
 			log.Println("shutting down")
+			if err := client.Deregister(context.Background(), *agentID, *secret, "shutdown"); err != nil {
+				log.Printf("deregister: %v", err)
+			}
 			return
 		default:
 		}
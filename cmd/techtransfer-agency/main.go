@@ -1,20 +1,38 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
+	"github.com/joelkehle/techtransfer-agency/internal/agentauth"
+	"github.com/joelkehle/techtransfer-agency/internal/attachverify"
+	"github.com/joelkehle/techtransfer-agency/internal/blobstore"
 	"github.com/joelkehle/techtransfer-agency/internal/bus"
+	"github.com/joelkehle/techtransfer-agency/internal/grpcapi"
 	"github.com/joelkehle/techtransfer-agency/internal/httpapi"
+	"github.com/joelkehle/techtransfer-agency/internal/telemetry"
 )
 
 func main() {
 	dbFlag := flag.String("db", "", "path to SQLite database file (overrides DB_PATH env var)")
 	flag.Parse()
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	shutdownTracing, err := telemetry.InitFromEnv(ctx, "techtransfer-agency")
+	if err != nil {
+		log.Fatalf("failed to initialize tracing: %v", err)
+	}
+
 	addr := ":8080"
 	if port := os.Getenv("PORT"); port != "" {
 		addr = ":" + port
@@ -31,7 +49,49 @@ func main() {
 		PushMaxAttempts:        3,
 		PushBaseBackoff:        500 * time.Millisecond,
 		MaxInboxEventsPerAgent: 10000,
+		MaxPendingPerAgent:     1000,
+		BackpressureRetryAfter: 5 * time.Second,
 		MaxObserveEvents:       50000,
+		MaxBatchMessages:       100,
+	}
+	if raw := os.Getenv("CONVERSATION_IDLE_TIMEOUT"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d < 0 {
+			log.Fatalf("invalid CONVERSATION_IDLE_TIMEOUT %q", raw)
+		}
+		cfg.ConversationIdleTimeout = d
+	}
+
+	blobDir := os.Getenv("BLOB_DIR")
+	if blobDir == "" {
+		blobDir = "./data/blobs"
+	}
+	blobs, err := blobstore.New(blobDir)
+	if err != nil {
+		log.Fatalf("failed to initialize blob store (%s): %v", blobDir, err)
+	}
+	blobs.MaxSize = 100 << 20
+	if raw := os.Getenv("BLOB_MAX_BYTES"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n < 0 {
+			log.Fatalf("invalid BLOB_MAX_BYTES %q", raw)
+		}
+		blobs.MaxSize = n
+	}
+	verifier, err := attachverify.NewFromEnv(blobs)
+	if err != nil {
+		log.Fatalf("failed to configure attachment verification: %v", err)
+	}
+	if verifier.Enabled() {
+		cfg.VerifyAttachments = verifier.Verify
+		log.Printf("attachment verification: %s", verifier.Mode)
+	}
+	cfg.BlobUploaders = func(sha string) []string {
+		b, err := blobs.Stat(sha)
+		if err != nil {
+			return nil
+		}
+		return b.Uploaders
 	}
 
 	// Resolve DB path: --db flag > DB_PATH env > empty (use legacy backend).
@@ -70,9 +130,101 @@ func main() {
 		}
 	}
 
-	h := httpapi.NewServer(store)
+	// Both transports share agent secrets so an agent registered over one can
+	// authenticate on the other.
+	creds := agentauth.NewRegistryFromEnv()
+
+	grpcAddr := ":9090"
+	if port := os.Getenv("GRPC_PORT"); port != "" {
+		grpcAddr = ":" + port
+	}
+	if grpcAddr != ":off" {
+		lis, err := net.Listen("tcp", grpcAddr)
+		if err != nil {
+			log.Fatalf("failed to listen for grpc on %s: %v", grpcAddr, err)
+		}
+		grpcSrv := grpcapi.NewServer(store, creds)
+		go func() {
+			log.Printf("techtransfer-agency grpc listening on %s", grpcAddr)
+			if err := grpcSrv.Serve(lis); err != nil {
+				log.Printf("grpc server: %v", err)
+			}
+		}()
+		go func() {
+			<-ctx.Done()
+			// Inbox and observe streams never finish on their own, so
+			// graceful stop is bounded like the HTTP shutdown.
+			stopped := make(chan struct{})
+			go func() {
+				grpcSrv.GracefulStop()
+				close(stopped)
+			}()
+			select {
+			case <-stopped:
+			case <-time.After(5 * time.Second):
+				grpcSrv.Stop()
+			}
+		}()
+	}
+
+	go collectBlobs(ctx, blobs, store)
+	go deliverScheduled(ctx, store)
+
+	srv := &http.Server{Addr: addr, Handler: httpapi.NewServerWithBlobs(store, creds, blobs)}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
 	log.Printf("techtransfer-agency listening on %s", addr)
-	if err := http.ListenAndServe(addr, h); err != nil {
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatal(err)
 	}
+
+	// Flush buffered spans before exit.
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		log.Printf("tracing shutdown: %v", err)
+	}
+}
+
+// collectBlobs periodically removes blobs no message references. Uploads get
+// an hour to be attached to a message before they are eligible. The bus never
+// drops messages, so once attached a blob is kept for good: in practice this
+// only removes uploads that were never attached.
+func collectBlobs(ctx context.Context, blobs *blobstore.Store, store bus.API) {
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			removed, err := blobs.Collect(store.ReferencedBlobs(), time.Now().Add(-time.Hour))
+			if err != nil {
+				log.Printf("blob gc: %v", err)
+			}
+			if len(removed) > 0 {
+				log.Printf("blob gc: removed %d unreferenced blobs", len(removed))
+			}
+		}
+	}
+}
+
+// deliverScheduled delivers scheduled messages as they come due, so push
+// agents and idle buses get them without waiting for other traffic.
+func deliverScheduled(ctx context.Context, store bus.API) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			store.DeliverDue()
+		}
+	}
 }
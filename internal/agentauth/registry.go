@@ -0,0 +1,101 @@
+// Package agentauth holds the per-agent HMAC secrets and registration
+// allowlist shared by every bus transport (HTTP, WebSocket, gRPC).
+package agentauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/joelkehle/techtransfer-agency/internal/bus"
+)
+
+type Registry struct {
+	mu       sync.RWMutex
+	secrets  map[string]string
+	allowset map[string]struct{}
+}
+
+// NewRegistryFromEnv returns an empty registry gated by AGENT_ALLOWLIST.
+func NewRegistryFromEnv() *Registry {
+	allowset := map[string]struct{}{}
+	for _, raw := range strings.Split(os.Getenv("AGENT_ALLOWLIST"), ",") {
+		v := strings.TrimSpace(raw)
+		if v != "" {
+			allowset[v] = struct{}{}
+		}
+	}
+	return &Registry{
+		secrets:  map[string]string{},
+		allowset: allowset,
+	}
+}
+
+func (r *Registry) IsAllowed(agentID string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.allowset) == 0 {
+		return true
+	}
+	_, ok := r.allowset[agentID]
+	return ok
+}
+
+func (r *Registry) SetSecret(agentID, secret string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.secrets[agentID] = secret
+}
+
+// Verify checks a hex HMAC-SHA256 signature (optionally "sha256=" prefixed)
+// of payload against the agent's registered secret.
+func (r *Registry) Verify(agentID, signature string, payload []byte) error {
+	mac, err := r.MAC(agentID)
+	if err != nil {
+		return err
+	}
+	_, _ = mac.Write(payload)
+	return VerifySum(signature, mac.Sum(nil))
+}
+
+// MAC returns an HMAC-SHA256 keyed with the agent's registered secret, for
+// payloads that are streamed rather than held in memory. Check the final sum
+// with VerifySum.
+func (r *Registry) MAC(agentID string) (hash.Hash, error) {
+	agentID = strings.TrimSpace(agentID)
+	if agentID == "" {
+		return nil, &bus.Error{Code: bus.CodeUnauthorized, Message: "agent id required", Status: 401}
+	}
+	r.mu.RLock()
+	secret, ok := r.secrets[agentID]
+	r.mu.RUnlock()
+	if !ok || strings.TrimSpace(secret) == "" {
+		return nil, &bus.Error{Code: bus.CodeUnauthorized, Message: "agent secret not registered", Status: 401}
+	}
+	return hmac.New(sha256.New, []byte(secret)), nil
+}
+
+// VerifySum compares a hex signature (optionally "sha256=" prefixed) with a
+// MAC sum.
+func VerifySum(signature string, sum []byte) error {
+	if strings.TrimSpace(signature) == "" {
+		return &bus.Error{Code: bus.CodeUnauthorized, Message: "X-Bus-Signature required", Status: 401}
+	}
+
+	sig := strings.TrimSpace(signature)
+	if strings.HasPrefix(strings.ToLower(sig), "sha256=") {
+		sig = sig[len("sha256="):]
+	}
+	provided, decErr := hex.DecodeString(strings.ToLower(sig))
+	if decErr != nil {
+		return &bus.Error{Code: bus.CodeUnauthorized, Message: "invalid signature encoding", Status: 401}
+	}
+	if !hmac.Equal(sum, provided) {
+		return &bus.Error{Code: bus.CodeUnauthorized, Message: "invalid signature", Status: 401}
+	}
+	return nil
+}
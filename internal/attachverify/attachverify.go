@@ -0,0 +1,292 @@
+// Package attachverify fetches message attachments at send time and checks
+// their size, digest and content type, so a receiver can trust the metadata
+// a sender declared.
+package attachverify
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/joelkehle/techtransfer-agency/internal/blobstore"
+	"github.com/joelkehle/techtransfer-agency/internal/bus"
+)
+
+type Mode string
+
+const (
+	// ModeOff skips verification.
+	ModeOff Mode = "off"
+	// ModeFlag records failures on the attachment and sends the message.
+	ModeFlag Mode = "flag"
+	// ModeReject refuses a message with any failed attachment.
+	ModeReject Mode = "reject"
+)
+
+// DefaultMaxSize caps fetched attachments when ATTACHMENT_MAX_BYTES is unset.
+const DefaultMaxSize = 100 << 20
+
+type Verifier struct {
+	Mode Mode
+	// ContentTypes is the allowlist of media types; an entry may end in /*
+	// to allow a whole type. Empty allows any.
+	ContentTypes []string
+	// MaxSize caps attachment size in bytes; zero means no limit.
+	MaxSize int64
+	// Blobs serves attachments that point at the bus's own /v1/blobs. Without
+	// it those attachments fail verification.
+	Blobs  *blobstore.Store
+	Client *http.Client
+	Now    func() time.Time
+}
+
+// NewFromEnv configures a verifier from ATTACHMENT_VERIFY (off, flag or
+// reject; default off), ATTACHMENT_CONTENT_TYPES (comma-separated),
+// ATTACHMENT_MAX_BYTES and ATTACHMENT_ALLOWED_CIDRS (comma-separated networks
+// that may be fetched even though they are not public).
+func NewFromEnv(blobs *blobstore.Store) (*Verifier, error) {
+	v := &Verifier{
+		Mode:    ModeOff,
+		MaxSize: DefaultMaxSize,
+		Blobs:   blobs,
+		Now:     time.Now,
+	}
+	switch mode := Mode(strings.TrimSpace(os.Getenv("ATTACHMENT_VERIFY"))); mode {
+	case "":
+	case ModeOff, ModeFlag, ModeReject:
+		v.Mode = mode
+	default:
+		return nil, fmt.Errorf("ATTACHMENT_VERIFY must be off, flag or reject, got %q", mode)
+	}
+	for _, raw := range strings.Split(os.Getenv("ATTACHMENT_CONTENT_TYPES"), ",") {
+		if ct := strings.ToLower(strings.TrimSpace(raw)); ct != "" {
+			v.ContentTypes = append(v.ContentTypes, ct)
+		}
+	}
+	if raw := strings.TrimSpace(os.Getenv("ATTACHMENT_MAX_BYTES")); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid ATTACHMENT_MAX_BYTES %q", raw)
+		}
+		v.MaxSize = n
+	}
+	var allowed []netip.Prefix
+	for _, raw := range strings.Split(os.Getenv("ATTACHMENT_ALLOWED_CIDRS"), ",") {
+		if raw = strings.TrimSpace(raw); raw == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ATTACHMENT_ALLOWED_CIDRS entry %q", raw)
+		}
+		allowed = append(allowed, prefix.Masked())
+	}
+	v.Client = NewClient(allowed)
+	return v, nil
+}
+
+// nonPublic lists the networks, beyond those net/netip classifies as
+// loopback, private, link-local, multicast or unspecified, that attachment
+// fetches never reach unless allowed.
+var nonPublic = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// NewClient returns the client NewFromEnv fetches attachments with. It
+// refuses to connect to an address that is not public, such as loopback,
+// private ranges or link-local metadata endpoints (169.254.169.254), unless
+// one of allowed contains it. The check runs on the resolved address of
+// every connection, so DNS names and redirects cannot get around it.
+// Proxies from the environment are not used, since the proxy would make the
+// connection instead.
+func NewClient(allowed []netip.Prefix) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			return checkAddress(address, allowed)
+		},
+	}
+	return &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}
+}
+
+// checkAddress rejects a dial to host:port address unless the host is public
+// or inside one of allowed.
+func checkAddress(address string, allowed []netip.Prefix) error {
+	ap, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("unexpected dial address %q", address)
+	}
+	ip := ap.Addr().Unmap()
+	for _, p := range allowed {
+		if p.Contains(ip) {
+			return nil
+		}
+	}
+	public := !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified()
+	for _, p := range nonPublic {
+		if p.Contains(ip) {
+			public = false
+		}
+	}
+	if !public {
+		return fmt.Errorf("address %s is not public", ip)
+	}
+	return nil
+}
+
+// Enabled reports whether messages should be verified at all.
+func (v *Verifier) Enabled() bool {
+	return v != nil && v.Mode != "" && v.Mode != ModeOff
+}
+
+// Verify checks each attachment and returns copies carrying the result. In
+// reject mode the first failure is returned as a validation error instead.
+// It has the signature of bus.Config.VerifyAttachments.
+func (v *Verifier) Verify(atts []bus.Attachment) ([]bus.Attachment, error) {
+	out := make([]bus.Attachment, len(atts))
+	for i, a := range atts {
+		res := v.check(a)
+		if res.Status == bus.AttachmentVerifyFailed && v.Mode == ModeReject {
+			return nil, &bus.Error{
+				Code:    bus.CodeValidation,
+				Message: fmt.Sprintf("attachments[%d]: %s", i, res.Reason),
+				Status:  400,
+			}
+		}
+		a.Verification = &res
+		out[i] = a
+	}
+	return out, nil
+}
+
+func (v *Verifier) check(a bus.Attachment) bus.AttachmentVerification {
+	res := bus.AttachmentVerification{Status: bus.AttachmentVerifyFailed, CheckedAt: v.Now().UTC()}
+
+	body, served, err := v.open(a.URL)
+	if err != nil {
+		res.Reason = err.Error()
+		return res
+	}
+	defer body.Close()
+
+	h := sha256.New()
+	var r io.Reader = body
+	if v.MaxSize > 0 {
+		r = io.LimitReader(body, v.MaxSize+1)
+	}
+	n, err := io.Copy(h, r)
+	if err != nil {
+		res.Reason = "read failed: " + err.Error()
+		return res
+	}
+	res.Size = n
+	res.SHA256 = hex.EncodeToString(h.Sum(nil))
+	res.ContentType = served
+
+	declared := mediaType(a.ContentType)
+	effective := declared
+	if effective == "" {
+		effective = mediaType(served)
+	}
+	switch {
+	case v.MaxSize > 0 && n > v.MaxSize:
+		res.Size = 0
+		res.SHA256 = ""
+		res.Reason = fmt.Sprintf("exceeds maximum size of %d bytes", v.MaxSize)
+	case a.Size > 0 && a.Size != n:
+		res.Reason = fmt.Sprintf("size mismatch: declared %d, got %d", a.Size, n)
+	case a.SHA256 != "" && !strings.EqualFold(a.SHA256, res.SHA256):
+		res.Reason = "sha256 mismatch"
+	case declared != "" && mediaType(served) != "" && mediaType(served) != "application/octet-stream" && declared != mediaType(served):
+		res.Reason = fmt.Sprintf("content type mismatch: declared %s, served %s", declared, mediaType(served))
+	case !v.allowed(effective):
+		res.Reason = fmt.Sprintf("content type %q is not allowed", effective)
+	default:
+		res.Status = bus.AttachmentVerified
+	}
+	return res
+}
+
+// open returns the attachment content and the content type its source
+// reports. Bus blob URLs are read from the local blob store; http and https
+// URLs are fetched with Client, which NewClient restricts to public
+// addresses.
+func (v *Verifier) open(raw string) (io.ReadCloser, string, error) {
+	if sha, ok := bus.BlobSHA(raw); ok {
+		if v.Blobs == nil {
+			return nil, "", fmt.Errorf("blob store not configured")
+		}
+		f, blob, err := v.Blobs.Open(sha)
+		if err != nil {
+			return nil, "", fmt.Errorf("blob %s: %w", sha, err)
+		}
+		return f, blob.ContentType, nil
+	}
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, "", fmt.Errorf("cannot fetch %q URLs", u.Scheme)
+	}
+	resp, err := v.Client.Get(u.String())
+	if err != nil {
+		return nil, "", fmt.Errorf("fetch failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, "", fmt.Errorf("fetch failed: status %d", resp.StatusCode)
+	}
+	return resp.Body, resp.Header.Get("Content-Type"), nil
+}
+
+func (v *Verifier) allowed(ct string) bool {
+	if len(v.ContentTypes) == 0 {
+		return true
+	}
+	for _, allow := range v.ContentTypes {
+		if allow == ct {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(allow, "/*"); ok && strings.HasPrefix(ct, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// mediaType returns the lowercased media type of a Content-Type value,
+// without parameters.
+func mediaType(ct string) string {
+	if strings.TrimSpace(ct) == "" {
+		return ""
+	}
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(strings.Split(ct, ";")[0]))
+	}
+	return mt
+}
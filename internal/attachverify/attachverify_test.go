@@ -0,0 +1,172 @@
+package attachverify
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joelkehle/techtransfer-agency/internal/blobstore"
+	"github.com/joelkehle/techtransfer-agency/internal/bus"
+)
+
+func TestVerifyChecksFetchedContent(t *testing.T) {
+	pdf := []byte("%PDF-1.7 disclosure")
+	sum := sha256.Sum256(pdf)
+	digest := hex.EncodeToString(sum[:])
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/disclosure.pdf" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		_, _ = w.Write(pdf)
+	}))
+	defer srv.Close()
+
+	blobs, err := blobstore.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("new blob store: %v", err)
+	}
+	u, err := blobs.Stage(strings.NewReader("notes"))
+	if err != nil {
+		t.Fatalf("stage: %v", err)
+	}
+	blob, err := u.Commit("text/plain", "a")
+	if err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	now := time.Date(2026, 2, 17, 0, 0, 0, 0, time.UTC)
+	v := &Verifier{
+		Mode:         ModeFlag,
+		ContentTypes: []string{"application/pdf", "text/*"},
+		MaxSize:      64,
+		Blobs:        blobs,
+		Client:       srv.Client(),
+		Now:          func() time.Time { return now },
+	}
+
+	cases := []struct {
+		name   string
+		att    bus.Attachment
+		reason string
+	}{
+		{"url", bus.Attachment{URL: srv.URL + "/disclosure.pdf", ContentType: "application/pdf", Size: int64(len(pdf)), SHA256: digest}, ""},
+		{"blob", bus.Attachment{URL: bus.BlobPathPrefix + blob.SHA256}, ""},
+		{"size", bus.Attachment{URL: srv.URL + "/disclosure.pdf", Size: 3}, "size mismatch: declared 3, got 19"},
+		{"digest", bus.Attachment{URL: srv.URL + "/disclosure.pdf", SHA256: strings.Repeat("0", 64)}, "sha256 mismatch"},
+		{"declared type", bus.Attachment{URL: srv.URL + "/disclosure.pdf", ContentType: "image/png"}, "content type mismatch: declared image/png, served application/pdf"},
+		{"missing", bus.Attachment{URL: srv.URL + "/missing.pdf"}, "fetch failed: status 404"},
+		{"scheme", bus.Attachment{URL: "file:///tmp/disclosure.pdf"}, `cannot fetch "file" URLs`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := v.Verify([]bus.Attachment{tc.att})
+			if err != nil {
+				t.Fatalf("verify: %v", err)
+			}
+			got := out[0].Verification
+			if got == nil || !got.CheckedAt.Equal(now) {
+				t.Fatalf("unexpected verification %#v", got)
+			}
+			if tc.reason == "" && got.Status != bus.AttachmentVerified {
+				t.Fatalf("expected verified, got %#v", got)
+			}
+			if tc.reason != "" && (got.Status != bus.AttachmentVerifyFailed || got.Reason != tc.reason) {
+				t.Fatalf("expected failure %q, got %#v", tc.reason, got)
+			}
+		})
+	}
+
+	v.ContentTypes = []string{"image/*"}
+	out, _ := v.Verify([]bus.Attachment{{URL: srv.URL + "/disclosure.pdf"}})
+	if out[0].Verification.Reason != `content type "application/pdf" is not allowed` {
+		t.Fatalf("expected allowlist failure, got %#v", out[0].Verification)
+	}
+
+	v.ContentTypes = nil
+	v.MaxSize = 8
+	out, _ = v.Verify([]bus.Attachment{{URL: srv.URL + "/disclosure.pdf"}})
+	if out[0].Verification.Reason != "exceeds maximum size of 8 bytes" {
+		t.Fatalf("expected size cap failure, got %#v", out[0].Verification)
+	}
+
+	v.Mode = ModeReject
+	_, err = v.Verify([]bus.Attachment{{URL: bus.BlobPathPrefix + blob.SHA256}, {URL: srv.URL + "/disclosure.pdf"}})
+	if be, ok := err.(*bus.Error); !ok || be.Code != bus.CodeValidation || be.Message != "attachments[1]: exceeds maximum size of 8 bytes" {
+		t.Fatalf("expected validation error for second attachment, got %v", err)
+	}
+}
+
+func TestNewClientRefusesNonPublicAddresses(t *testing.T) {
+	internal, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skipf("no second loopback address: %v", err)
+	}
+	target := &httptest.Server{Listener: internal, Config: &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("secret"))
+	})}}
+	target.Start()
+	defer target.Close()
+	redirect := httptest.NewServer(http.RedirectHandler(target.URL+"/latest/meta-data", http.StatusFound))
+	defer redirect.Close()
+
+	now := time.Date(2026, 2, 17, 0, 0, 0, 0, time.UTC)
+	verify := func(client *http.Client, url string) bus.AttachmentVerification {
+		v := &Verifier{Mode: ModeFlag, Client: client, Now: func() time.Time { return now }}
+		out, err := v.Verify([]bus.Attachment{{URL: url}})
+		if err != nil {
+			t.Fatalf("verify %s: %v", url, err)
+		}
+		return *out[0].Verification
+	}
+
+	for _, url := range []string{target.URL, redirect.URL, "http://169.254.169.254/latest/meta-data"} {
+		if res := verify(NewClient(nil), url); res.Status != bus.AttachmentVerifyFailed || !strings.Contains(res.Reason, "is not public") {
+			t.Fatalf("expected %s to be refused, got %+v", url, res)
+		}
+	}
+	// Allowing the redirecting host does not allow where it redirects to.
+	loopback1 := []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")}
+	if res := verify(NewClient(loopback1), redirect.URL); res.Status != bus.AttachmentVerifyFailed || !strings.Contains(res.Reason, "127.0.0.2 is not public") {
+		t.Fatalf("expected redirect to 127.0.0.2 to be refused, got %+v", res)
+	}
+	loopback := []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+	if res := verify(NewClient(loopback), redirect.URL); res.Status != bus.AttachmentVerified || res.Size != 6 {
+		t.Fatalf("expected allowed network to be fetched, got %+v", res)
+	}
+}
+
+func TestNewFromEnv(t *testing.T) {
+	t.Setenv("ATTACHMENT_VERIFY", "reject")
+	t.Setenv("ATTACHMENT_CONTENT_TYPES", "application/pdf, Text/*")
+	t.Setenv("ATTACHMENT_MAX_BYTES", "1024")
+	t.Setenv("ATTACHMENT_ALLOWED_CIDRS", "10.20.0.0/16")
+	v, err := NewFromEnv(nil)
+	if err != nil {
+		t.Fatalf("new from env: %v", err)
+	}
+	if !v.Enabled() || v.Mode != ModeReject || strings.Join(v.ContentTypes, ",") != "application/pdf,text/*" || v.MaxSize != 1024 {
+		t.Fatalf("unexpected verifier: %+v", v)
+	}
+
+	if err := checkAddress("10.20.1.2:443", []netip.Prefix{netip.MustParsePrefix("10.20.0.0/16")}); err != nil {
+		t.Fatalf("expected allowed network to pass: %v", err)
+	}
+
+	t.Setenv("ATTACHMENT_ALLOWED_CIDRS", "intranet")
+	if _, err := NewFromEnv(nil); err == nil {
+		t.Fatalf("expected invalid network error")
+	}
+	t.Setenv("ATTACHMENT_ALLOWED_CIDRS", "")
+	t.Setenv("ATTACHMENT_VERIFY", "sometimes")
+	if _, err := NewFromEnv(nil); err == nil {
+		t.Fatalf("expected invalid mode error")
+	}
+}
@@ -0,0 +1,254 @@
+// Package blobstore keeps attachment content on local disk, addressed by its
+// SHA-256, so agents on different hosts can exchange files through the bus
+// instead of sharing file paths.
+package blobstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned for a blob that is not stored.
+	ErrNotFound = errors.New("blob not found")
+	// ErrTooLarge is returned by Stage when the content exceeds MaxSize.
+	ErrTooLarge = errors.New("blob exceeds maximum size")
+)
+
+// Blob describes a stored blob. It is kept next to the content as a JSON
+// sidecar.
+type Blob struct {
+	SHA256      string    `json:"sha256"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	// UploadedAt is the last time the content was uploaded. Unreferenced
+	// blobs are collected relative to it, so a re-upload restarts the grace
+	// period.
+	UploadedAt time.Time `json:"uploaded_at"`
+	// Uploaders lists every agent that has uploaded this content.
+	Uploaders []string `json:"uploaders,omitempty"`
+}
+
+type Store struct {
+	// MaxSize caps the size of a single blob in bytes; zero means no limit.
+	MaxSize int64
+
+	dir string
+	// mu orders commits against collection so a blob re-uploaded while a
+	// collection is running is not removed.
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+// New returns a store rooted at dir, creating it if needed.
+func New(dir string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "tmp"), 0o755); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir, now: time.Now}, nil
+}
+
+// ValidSHA256 reports whether v is a lowercase hex SHA-256 digest.
+func ValidSHA256(v string) bool {
+	if len(v) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range v {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Store) contentPath(sha string) string {
+	return filepath.Join(s.dir, sha[:2], sha)
+}
+
+func (s *Store) metaPath(sha string) string {
+	return s.contentPath(sha) + ".json"
+}
+
+// Upload is content written to a temporary file and hashed but not yet
+// visible in the store. Callers Commit it once the upload is authorized, or
+// Abort it.
+type Upload struct {
+	store *Store
+	tmp   string
+	SHA   string
+	Size  int64
+}
+
+// Stage streams r to a temporary file, hashing it on the way.
+func (s *Store) Stage(r io.Reader) (*Upload, error) {
+	f, err := os.CreateTemp(filepath.Join(s.dir, "tmp"), "upload-*")
+	if err != nil {
+		return nil, err
+	}
+	if s.MaxSize > 0 {
+		r = io.LimitReader(r, s.MaxSize+1)
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, h), r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && s.MaxSize > 0 && n > s.MaxSize {
+		err = ErrTooLarge
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return nil, err
+	}
+	return &Upload{store: s, tmp: f.Name(), SHA: hex.EncodeToString(h.Sum(nil)), Size: n}, nil
+}
+
+// Abort discards the staged content.
+func (u *Upload) Abort() {
+	_ = os.Remove(u.tmp)
+}
+
+// Commit moves the staged content into place, or drops it when the same
+// content is already stored, and records the upload.
+func (u *Upload) Commit(contentType, uploader string) (Blob, error) {
+	s := u.store
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now().UTC()
+	b, err := s.readMeta(u.SHA)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		if err := os.MkdirAll(filepath.Dir(s.contentPath(u.SHA)), 0o755); err != nil {
+			u.Abort()
+			return Blob{}, err
+		}
+		if err := os.Rename(u.tmp, s.contentPath(u.SHA)); err != nil {
+			u.Abort()
+			return Blob{}, err
+		}
+		b = Blob{SHA256: u.SHA, Size: u.Size, ContentType: contentType, CreatedAt: now}
+	case err != nil:
+		u.Abort()
+		return Blob{}, err
+	default:
+		u.Abort()
+		if b.ContentType == "" {
+			b.ContentType = contentType
+		}
+	}
+	b.UploadedAt = now
+	if uploader = strings.TrimSpace(uploader); uploader != "" && !slices.Contains(b.Uploaders, uploader) {
+		b.Uploaders = append(b.Uploaders, uploader)
+	}
+	if err := s.writeMeta(b); err != nil {
+		return Blob{}, err
+	}
+	return b, nil
+}
+
+func (s *Store) readMeta(sha string) (Blob, error) {
+	blob, err := os.ReadFile(s.metaPath(sha))
+	if os.IsNotExist(err) {
+		return Blob{}, ErrNotFound
+	}
+	if err != nil {
+		return Blob{}, err
+	}
+	var b Blob
+	if err := json.Unmarshal(blob, &b); err != nil {
+		return Blob{}, err
+	}
+	return b, nil
+}
+
+func (s *Store) writeMeta(b Blob) error {
+	blob, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	tmp := s.metaPath(b.SHA256) + ".tmp"
+	if err := os.WriteFile(tmp, blob, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.metaPath(b.SHA256))
+}
+
+// Stat returns the blob's metadata.
+func (s *Store) Stat(sha string) (Blob, error) {
+	if !ValidSHA256(sha) {
+		return Blob{}, ErrNotFound
+	}
+	return s.readMeta(sha)
+}
+
+// Open returns the blob's content and metadata. The caller closes the file.
+func (s *Store) Open(sha string) (*os.File, Blob, error) {
+	b, err := s.Stat(sha)
+	if err != nil {
+		return nil, Blob{}, err
+	}
+	f, err := os.Open(s.contentPath(sha))
+	if os.IsNotExist(err) {
+		return nil, Blob{}, ErrNotFound
+	}
+	if err != nil {
+		return nil, Blob{}, err
+	}
+	return f, b, nil
+}
+
+// Collect removes blobs that have no references in refs and were last
+// uploaded before olderThan, and returns their digests. Staged uploads left
+// behind by an interrupted request are removed on the same schedule.
+func (s *Store) Collect(refs map[string]int, olderThan time.Time) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	metas, err := filepath.Glob(filepath.Join(s.dir, "*", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, path := range metas {
+		sha := strings.TrimSuffix(filepath.Base(path), ".json")
+		if !ValidSHA256(sha) || refs[sha] > 0 {
+			continue
+		}
+		b, err := s.readMeta(sha)
+		if err != nil {
+			return removed, err
+		}
+		if !b.UploadedAt.Before(olderThan) {
+			continue
+		}
+		if err := os.Remove(s.contentPath(sha)); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		if err := os.Remove(path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, sha)
+	}
+
+	staged, err := os.ReadDir(filepath.Join(s.dir, "tmp"))
+	if err != nil {
+		return removed, err
+	}
+	for _, e := range staged {
+		if info, err := e.Info(); err == nil && info.ModTime().Before(olderThan) {
+			_ = os.Remove(filepath.Join(s.dir, "tmp", e.Name()))
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,112 @@
+package blobstore
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStageCommitDeduplicates(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	put := func(content, uploader string) Blob {
+		t.Helper()
+		u, err := s.Stage(strings.NewReader(content))
+		if err != nil {
+			t.Fatalf("stage: %v", err)
+		}
+		b, err := u.Commit("text/plain", uploader)
+		if err != nil {
+			t.Fatalf("commit: %v", err)
+		}
+		return b
+	}
+
+	first := put("hello", "a")
+	second := put("hello", "b")
+	if first.SHA256 != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" || first.Size != 5 {
+		t.Fatalf("unexpected blob: %+v", first)
+	}
+	if second.SHA256 != first.SHA256 || strings.Join(second.Uploaders, ",") != "a,b" {
+		t.Fatalf("expected second upload to join the first: %+v", second)
+	}
+
+	f, b, err := s.Open(first.SHA256)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer f.Close()
+	content, _ := io.ReadAll(f)
+	if string(content) != "hello" || b.ContentType != "text/plain" {
+		t.Fatalf("open returned %q %+v", content, b)
+	}
+	if _, _, err := s.Open("../../etc/passwd"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected invalid digest to be not found, got %v", err)
+	}
+
+	staged, _ := os.ReadDir(s.dir + "/tmp")
+	if len(staged) != 0 {
+		t.Fatalf("expected no staged files left, got %d", len(staged))
+	}
+}
+
+func TestStageEnforcesMaxSize(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	s.MaxSize = 4
+	if _, err := s.Stage(strings.NewReader("hello")); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected ErrTooLarge, got %v", err)
+	}
+	u, err := s.Stage(strings.NewReader("hell"))
+	if err != nil {
+		t.Fatalf("stage at limit: %v", err)
+	}
+	u.Abort()
+}
+
+func TestCollectKeepsReferencedAndRecentBlobs(t *testing.T) {
+	s, err := New(t.TempDir())
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	now := time.Date(2026, 2, 17, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	put := func(content string) string {
+		t.Helper()
+		u, err := s.Stage(strings.NewReader(content))
+		if err != nil {
+			t.Fatalf("stage: %v", err)
+		}
+		b, err := u.Commit("", "a")
+		if err != nil {
+			t.Fatalf("commit: %v", err)
+		}
+		return b.SHA256
+	}
+	referenced, orphan := put("kept"), put("dropped")
+	now = now.Add(time.Hour)
+	recent := put("fresh")
+
+	removed, err := s.Collect(map[string]int{referenced: 1}, now.Add(-30*time.Minute))
+	if err != nil {
+		t.Fatalf("collect: %v", err)
+	}
+	if len(removed) != 1 || removed[0] != orphan {
+		t.Fatalf("removed=%v want [%s]", removed, orphan)
+	}
+	for _, sha := range []string{referenced, recent} {
+		if _, err := s.Stat(sha); err != nil {
+			t.Fatalf("expected %s kept: %v", sha, err)
+		}
+	}
+	if _, err := s.Stat(orphan); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected orphan removed, got %v", err)
+	}
+}
@@ -6,15 +6,31 @@ import "time"
 // It allows swapping in-memory and persistent implementations.
 type API interface {
 	RegisterAgent(input RegisterAgentInput) (*Agent, error)
-	ListAgents(capability string) []Agent
+	Heartbeat(input HeartbeatInput) (*Agent, error)
+	DeregisterAgent(input DeregisterAgentInput) (*DeregisterResult, error)
+	ListAgents(filter ListAgentsFilter) ([]Agent, string, error)
+	GetAgent(agentID string) (*Agent, error)
 	CreateConversation(input CreateConversationInput) (*Conversation, error)
-	ListConversations(filter ListConversationsFilter) []Conversation
+	ListConversations(filter ListConversationsFilter) ([]Conversation, string, error)
+	GetConversation(conversationID string) (*Conversation, error)
+	SetConversationStatus(input ConversationStatusInput) (*Conversation, error)
+	UpdateConversation(input UpdateConversationInput) (*Conversation, error)
+	ConversationHistory(conversationID string) ([]ConversationEvent, error)
 	SendMessage(input SendMessageInput) (*Message, bool, error)
+	SendMessages(input SendMessagesInput) ([]SendMessageResult, error)
+	Call(input SendMessageInput) (*CallResult, error)
+	GetMessage(messageID string) (*MessageDetail, error)
+	ListScheduled(agentID string) ([]Message, error)
+	CancelScheduled(input CancelScheduledInput) (*Message, error)
+	DeliverDue() int
 	PollInbox(input PollInboxInput) ([]InboxEvent, int, error)
 	Ack(input AckInput) error
 	PostEvent(input EventInput) error
 	Inject(input InjectInput) (*Message, error)
 	ListConversationMessages(input ListConversationMessagesInput) (string, []Message, int, error)
+	Search(input SearchInput) ([]SearchHit, error)
+	BlobReferences(sha string) BlobReferences
+	ReferencedBlobs() map[string]int
 	ObserveSince(afterID int64, filter ObserveFilter, wait time.Duration) ([]ObserveEvent, int64)
 	Health() map[string]any
 	SystemStatus() map[string]any
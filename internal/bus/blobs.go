@@ -0,0 +1,174 @@
+package bus
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// BlobPathPrefix is the path under which the bus serves uploaded blobs.
+// Attachments whose URL path is BlobPathPrefix followed by a SHA-256 digest
+// count as references to that blob.
+const BlobPathPrefix = "/v1/blobs/"
+
+// BlobReferences lists the messages that reference a blob and the agents
+// allowed to read it: the senders and recipients of those messages and the
+// declared participants of their conversations. Sending into a conversation
+// does not make an agent a participant, so an agent that only posted there
+// cannot read the blobs other messages in it carry.
+type BlobReferences struct {
+	SHA256          string   `json:"sha256"`
+	RefCount        int      `json:"ref_count"`
+	MessageIDs      []string `json:"message_ids"`
+	ConversationIDs []string `json:"conversation_ids"`
+	Members         []string `json:"members"`
+}
+
+// BlobSHA returns the digest of the bus blob an attachment URL points at.
+// Absolute URLs match on path alone so references survive a change of bus
+// host name.
+func BlobSHA(raw string) (string, bool) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", false
+	}
+	i := strings.LastIndex(u.Path, BlobPathPrefix)
+	if i < 0 {
+		return "", false
+	}
+	sha := u.Path[i+len(BlobPathPrefix):]
+	if len(sha) != 64 || strings.Trim(sha, "0123456789abcdef") != "" {
+		return "", false
+	}
+	return sha, true
+}
+
+// messageBlobs returns the blob digests referenced by m's attachments and
+// result attachments.
+func messageBlobs(m *Message) []string {
+	var out []string
+	add := func(atts []Attachment) {
+		for _, a := range atts {
+			if sha, ok := BlobSHA(a.URL); ok {
+				out = append(out, sha)
+			}
+		}
+	}
+	add(m.Attachments)
+	if m.Result != nil {
+		add(m.Result.Attachments)
+	}
+	return out
+}
+
+// indexBlobsLocked records the blobs m references. It is called when m is
+// created and whenever its result is set, and is idempotent.
+func (s *Store) indexBlobsLocked(m *Message) {
+	for _, sha := range messageBlobs(m) {
+		refs := s.blobRefs[sha]
+		if refs == nil {
+			refs = map[string]struct{}{}
+			s.blobRefs[sha] = refs
+		}
+		refs[m.MessageID] = struct{}{}
+	}
+}
+
+// reindexBlobsLocked rebuilds the blob reference index after messages are
+// loaded from a backend.
+func (s *Store) reindexBlobsLocked() {
+	s.blobRefs = map[string]map[string]struct{}{}
+	for _, m := range s.messages {
+		s.indexBlobsLocked(m)
+	}
+}
+
+// BlobReferences reports which messages and conversations reference the blob
+// with digest sha.
+func (s *Store) BlobReferences(sha string) BlobReferences {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.blobReferencesLocked(sha)
+}
+
+func (s *Store) blobReferencesLocked(sha string) BlobReferences {
+	out := BlobReferences{SHA256: sha, MessageIDs: []string{}, ConversationIDs: []string{}, Members: []string{}}
+	convs := map[string]struct{}{}
+	members := map[string]struct{}{}
+	for id := range s.blobRefs[sha] {
+		m, ok := s.messages[id]
+		if !ok {
+			continue
+		}
+		out.MessageIDs = append(out.MessageIDs, id)
+		convs[m.ConversationID] = struct{}{}
+		members[m.From] = struct{}{}
+		if m.To != "" {
+			members[m.To] = struct{}{}
+		}
+		for _, r := range m.Recipients {
+			members[r] = struct{}{}
+		}
+	}
+	for cid := range convs {
+		out.ConversationIDs = append(out.ConversationIDs, cid)
+		if c, ok := s.conversations[cid]; ok {
+			for _, p := range c.Participants {
+				members[p] = struct{}{}
+			}
+		}
+	}
+	for id := range members {
+		out.Members = append(out.Members, id)
+	}
+	sortMessageIDs(out.MessageIDs)
+	sort.Strings(out.ConversationIDs)
+	sort.Strings(out.Members)
+	out.RefCount = len(out.MessageIDs)
+	return out
+}
+
+// checkBlobAccessLocked rejects attachments naming a bus blob that agentID
+// neither uploaded nor can already read. Blob digests are visible in message
+// listings, so without this any agent could attach one to a message of its
+// own and so become a member allowed to download it.
+func (s *Store) checkBlobAccessLocked(agentID string, atts []Attachment) error {
+	if s.cfg.BlobUploaders == nil {
+		return nil
+	}
+	for i, a := range atts {
+		sha, ok := BlobSHA(a.URL)
+		if !ok {
+			continue
+		}
+		if slices.Contains(s.cfg.BlobUploaders(sha), agentID) || slices.Contains(s.blobReferencesLocked(sha).Members, agentID) {
+			continue
+		}
+		return newError(CodeUnauthorized, fmt.Sprintf("attachments[%d]: %s has not uploaded and cannot read blob %s", i, agentID, sha), false, 0)
+	}
+	return nil
+}
+
+// ReferencedBlobs returns the number of messages referencing each blob that
+// has at least one reference. Blob garbage collection keeps every digest in
+// it.
+func (s *Store) ReferencedBlobs() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := map[string]int{}
+	for sha, refs := range s.blobRefs {
+		n := 0
+		for id := range refs {
+			if _, ok := s.messages[id]; ok {
+				n++
+			}
+		}
+		if n > 0 {
+			out[sha] = n
+		}
+	}
+	return out
+}
@@ -0,0 +1,252 @@
+package bus
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A capability is a name with an optional version, "patent-screen" or
+// "patent-screen@2.1". Versions are MAJOR[.MINOR[.PATCH]] with missing
+// components read as zero, so "@2.1" is 2.1.0.
+//
+// Queries (ListAgents filters and capability-addressed sends) name a
+// capability with an optional semver range: "patent-screen" matches every
+// version, "patent-screen@^2" only versioned capabilities in range. Ranges
+// are space-separated comparators that must all hold; each is a version,
+// partial version or wildcard (2, 2.x, *) optionally prefixed with =, >, >=,
+// <, <=, ^ or ~, with the usual npm meaning.
+
+type capabilityVersion [3]int
+
+func (v capabilityVersion) compare(o capabilityVersion) int {
+	for i := range v {
+		if v[i] != o[i] {
+			if v[i] < o[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// bump returns the lowest version above every version sharing v's first
+// i+1 components.
+func (v capabilityVersion) bump(i int) capabilityVersion {
+	var out capabilityVersion
+	copy(out[:i], v[:i])
+	out[i] = v[i] + 1
+	return out
+}
+
+// splitCapability splits a capability or query at its '@'.
+func splitCapability(s string) (name, version string, versioned bool) {
+	name, version, versioned = strings.Cut(strings.TrimSpace(s), "@")
+	return strings.TrimSpace(name), strings.TrimSpace(version), versioned
+}
+
+// parseVersion parses MAJOR[.MINOR[.PATCH]]. With wildcards allowed, an x,
+// X or * component ends the version early. It returns the number of
+// components given.
+func parseVersion(s string, wildcards bool) (capabilityVersion, int, error) {
+	var v capabilityVersion
+	parts := strings.Split(s, ".")
+	if s == "" || len(parts) > 3 {
+		return v, 0, fmt.Errorf("invalid version %q", s)
+	}
+	for i, part := range parts {
+		if wildcards && (part == "x" || part == "X" || part == "*") {
+			return v, i, nil
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || part != strconv.Itoa(n) {
+			return v, 0, fmt.Errorf("invalid version %q", s)
+		}
+		v[i] = n
+	}
+	return v, len(parts), nil
+}
+
+// validateCapability checks that a declared capability has a name and, when
+// versioned, a valid version.
+func validateCapability(c string) error {
+	name, version, versioned := splitCapability(c)
+	if name == "" {
+		return fmt.Errorf("capability %q has no name", c)
+	}
+	if versioned {
+		if _, _, err := parseVersion(version, false); err != nil {
+			return fmt.Errorf("capability %q: %v", c, err)
+		}
+	}
+	return nil
+}
+
+// versionBound is the half-open interval [lo, hi); a zero hi is unbounded.
+type versionBound struct {
+	lo, hi capabilityVersion
+	hasHi  bool
+}
+
+func (b versionBound) contains(v capabilityVersion) bool {
+	return v.compare(b.lo) >= 0 && (!b.hasHi || v.compare(b.hi) < 0)
+}
+
+// parseComparator turns one comparator into the interval it allows.
+func parseComparator(c string) (versionBound, error) {
+	op := ""
+	for _, prefix := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+		if rest, ok := strings.CutPrefix(c, prefix); ok {
+			op, c = prefix, strings.TrimSpace(rest)
+			break
+		}
+	}
+	v, n, err := parseVersion(c, true)
+	if err != nil {
+		return versionBound{}, err
+	}
+	if n == 0 {
+		// A bare wildcard allows everything, whatever the operator.
+		if op == "<" || op == ">" {
+			return versionBound{}, fmt.Errorf("invalid range %q", op+c)
+		}
+		return versionBound{}, nil
+	}
+	// The versions a partial version names: 2.1 is [2.1.0, 2.2.0).
+	next := v.bump(n - 1)
+	switch op {
+	case "", "=":
+		return versionBound{lo: v, hi: next, hasHi: true}, nil
+	case ">=":
+		return versionBound{lo: v}, nil
+	case ">":
+		return versionBound{lo: next}, nil
+	case "<":
+		return versionBound{hi: v, hasHi: true}, nil
+	case "<=":
+		return versionBound{hi: next, hasHi: true}, nil
+	case "~":
+		if n >= 2 {
+			return versionBound{lo: v, hi: v.bump(1), hasHi: true}, nil
+		}
+		return versionBound{lo: v, hi: v.bump(0), hasHi: true}, nil
+	default: // ^
+		for i := 0; i < n; i++ {
+			if v[i] != 0 {
+				return versionBound{lo: v, hi: v.bump(i), hasHi: true}, nil
+			}
+		}
+		return versionBound{lo: v, hi: next, hasHi: true}, nil
+	}
+}
+
+// capabilityQuery selects capabilities by name and optional version range.
+type capabilityQuery struct {
+	raw    string
+	name   string
+	ranged bool
+	bounds []versionBound
+}
+
+func parseCapabilityQuery(q string) (capabilityQuery, error) {
+	name, rng, ranged := splitCapability(q)
+	out := capabilityQuery{raw: strings.TrimSpace(q), name: name, ranged: ranged}
+	if name == "" {
+		return out, newError(CodeValidation, fmt.Sprintf("capability %q has no name", q), false, 0)
+	}
+	if !ranged {
+		return out, nil
+	}
+	comparators := strings.Fields(rng)
+	if len(comparators) == 0 {
+		return out, newError(CodeValidation, fmt.Sprintf("capability %q has an empty version range", q), false, 0)
+	}
+	for _, c := range comparators {
+		b, err := parseComparator(c)
+		if err != nil {
+			return out, newError(CodeValidation, fmt.Sprintf("capability %q: %v", q, err), false, 0)
+		}
+		out.bounds = append(out.bounds, b)
+	}
+	return out, nil
+}
+
+// match reports whether capability c satisfies q, and c's version.
+func (q capabilityQuery) match(c string) (capabilityVersion, bool) {
+	name, version, versioned := splitCapability(c)
+	if name != q.name {
+		return capabilityVersion{}, false
+	}
+	if !versioned {
+		return capabilityVersion{}, !q.ranged
+	}
+	v, _, err := parseVersion(version, false)
+	if err != nil {
+		return capabilityVersion{}, false
+	}
+	for _, b := range q.bounds {
+		if !b.contains(v) {
+			return v, false
+		}
+	}
+	return v, true
+}
+
+// best returns the highest-versioned of capabilities matching q.
+func (q capabilityQuery) best(capabilities []string) (string, capabilityVersion, bool) {
+	var (
+		found   string
+		version capabilityVersion
+		ok      bool
+	)
+	for _, c := range capabilities {
+		v, match := q.match(c)
+		if match && (!ok || v.compare(version) > 0) {
+			found, version, ok = c, v, true
+		}
+	}
+	return found, version, ok
+}
+
+// routeLocked picks the active agent to receive a request addressed to a
+// capability rather than an agent: agents reporting themselves unhealthy
+// are skipped, the highest matching version among the rest wins, and agents
+// tied on version take turns.
+func (s *Store) routeLocked(q capabilityQuery) (*Agent, error) {
+	var (
+		candidates []*Agent
+		top        capabilityVersion
+		skipped    int
+	)
+	for _, a := range s.agents {
+		if a.Status != AgentStatusActive {
+			continue
+		}
+		_, v, ok := q.best(a.Capabilities)
+		if !ok {
+			continue
+		}
+		if a.unhealthy() {
+			skipped++
+			continue
+		}
+		switch cmp := v.compare(top); {
+		case len(candidates) == 0 || cmp > 0:
+			candidates, top = []*Agent{a}, v
+		case cmp == 0:
+			candidates = append(candidates, a)
+		}
+	}
+	if len(candidates) == 0 && skipped > 0 {
+		return nil, newError(CodeUnavailable, "every agent offering capability "+q.raw+" reports itself unhealthy", true, 0)
+	}
+	if len(candidates) == 0 {
+		return nil, newError(CodeNotFound, "no active agent offers capability "+q.raw, false, 0)
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].AgentID < candidates[j].AgentID })
+	turn := s.routeTurns[q.raw]
+	s.routeTurns[q.raw] = turn + 1
+	return candidates[turn%len(candidates)], nil
+}
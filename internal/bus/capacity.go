@@ -0,0 +1,157 @@
+package bus
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// An agent that declares metadata.max_in_flight is handed at most that many
+// requests at once; a request is in flight from delivery to its inbox until
+// it reaches a terminal state. Further requests are held on the bus and
+// delivered as earlier ones finish, highest priority first and oldest first
+// within a priority. Sends are refused rather than dropped when an agent's
+// held queue reaches MaxPendingPerAgent (rate_limited) or when a pull agent
+// has MaxInboxEventsPerAgent events it has not polled yet (unavailable).
+
+// loadLocked counts the requests delivered to agentID and not yet finished,
+// and the requests held for it.
+func (s *Store) loadLocked(agentID string) (inFlight, held int) {
+	for _, m := range s.messages {
+		if m.To != agentID || m.Type != MessageTypeRequest {
+			continue
+		}
+		switch {
+		case m.State == StateWaitingAck || m.State == StateExecuting:
+			inFlight++
+		case m.State == StatePending && m.HeldForCapacity:
+			held++
+		}
+	}
+	return inFlight, held
+}
+
+// mustHoldLocked reports whether a new request to a has to wait on the bus:
+// a is at its max_in_flight, or earlier requests are already waiting.
+func (s *Store) mustHoldLocked(a *Agent) bool {
+	inFlight, held := s.loadLocked(a.AgentID)
+	return held > 0 || (a.Metadata.MaxInFlight > 0 && inFlight >= a.Metadata.MaxInFlight)
+}
+
+// inboxFullLocked reports whether pull agent a has MaxInboxEventsPerAgent
+// events it has not polled past, counting planned events not yet appended.
+// Push agents are handed every event by callback, so their inbox never
+// fills.
+func (s *Store) inboxFullLocked(a *Agent, planned int) bool {
+	limit := s.cfg.MaxInboxEventsPerAgent
+	if limit <= 0 || a.Mode == AgentModePush {
+		return false
+	}
+	base := s.inboxBase[a.AgentID]
+	read := max(s.inboxRead[a.AgentID], base)
+	return base+len(s.inboxes[a.AgentID])-read+planned >= limit
+}
+
+// sendLoad is what the sends planned so far in one call will add to each
+// target once applied. Batches plan every item before applying any, so each
+// item's capacity check has to count the items planned ahead of it.
+type sendLoad struct {
+	inFlight map[string]int
+	held     map[string]int
+	inbox    map[string]int
+	keys     map[string]bool
+}
+
+func newSendLoad() *sendLoad {
+	return &sendLoad{inFlight: map[string]int{}, held: map[string]int{}, inbox: map[string]int{}, keys: map[string]bool{}}
+}
+
+// backpressureLocked returns the error for a send to a that the bus cannot
+// take now, given the sends already in planned, and otherwise adds the send
+// to planned. request says whether the send is a request, which waits on the
+// bus rather than in the inbox when a is at capacity. A send repeating the
+// dedupe key of one already planned is the same message and adds nothing.
+func (s *Store) backpressureLocked(a *Agent, request bool, key string, planned *sendLoad) error {
+	if planned.keys[key] {
+		return nil
+	}
+	retry := s.cfg.BackpressureRetryAfter
+	id := a.AgentID
+	inFlight, held := s.loadLocked(id)
+	inFlight += planned.inFlight[id]
+	held += planned.held[id]
+	if request && (held > 0 || (a.Metadata.MaxInFlight > 0 && inFlight >= a.Metadata.MaxInFlight)) {
+		if held >= s.cfg.MaxPendingPerAgent {
+			return newError(CodeRateLimited, fmt.Sprintf("agent %s has %d requests waiting for capacity", id, held), true, retry)
+		}
+		planned.held[id]++
+		planned.keys[key] = true
+		return nil
+	}
+	if s.inboxFullLocked(a, planned.inbox[id]) {
+		return newError(CodeUnavailable, fmt.Sprintf("agent %s has %d unread inbox events", id, s.cfg.MaxInboxEventsPerAgent), true, retry)
+	}
+	if request {
+		planned.inFlight[id]++
+	}
+	planned.inbox[id]++
+	planned.keys[key] = true
+	return nil
+}
+
+// deliverLocked hands a pending request to agent a: into its inbox, and to
+// its callback when it is in push mode.
+func (s *Store) deliverLocked(m *Message, a *Agent, reason string, now time.Time) {
+	m.DeliveredAt = now
+	s.transitionLocked(m, StateTransition{To: StateWaitingAck, Reason: reason}, now)
+	s.appendInboxLocked(a.AgentID, inboxEventFor(m))
+	if a.Mode == AgentModePush && strings.TrimSpace(a.CallbackURL) != "" {
+		s.pushLocked(a.AgentID, strings.TrimSpace(a.CallbackURL), pushPayloadFor(m))
+	}
+}
+
+// releaseHeldLocked delivers the requests held for agentID in priority
+// order while it is active and has room under its max_in_flight.
+func (s *Store) releaseHeldLocked(agentID string, now time.Time) {
+	a, ok := s.agents[agentID]
+	if !ok || a.Status != AgentStatusActive {
+		return
+	}
+	var queue []*Message
+	inFlight := 0
+	for _, m := range s.messages {
+		if m.To != agentID || m.Type != MessageTypeRequest {
+			continue
+		}
+		switch {
+		case m.State == StateWaitingAck || m.State == StateExecuting:
+			inFlight++
+		case m.State == StatePending && m.HeldForCapacity:
+			queue = append(queue, m)
+		}
+	}
+	sort.Slice(queue, func(i, j int) bool { return deliverBefore(queue[i], queue[j]) })
+	for _, m := range queue {
+		if a.Metadata.MaxInFlight > 0 && inFlight >= a.Metadata.MaxInFlight {
+			return
+		}
+		m.HeldForCapacity = false
+		s.deliverLocked(m, a, "released from pending queue", now)
+		inFlight++
+	}
+}
+
+var priorityRank = map[MessagePriority]int{PriorityLow: 0, PriorityNormal: 1, "": 1, PriorityHigh: 2, PriorityUrgent: 3}
+
+// deliverBefore orders held requests: higher priority first, then by when
+// they were sent, then by message ID.
+func deliverBefore(a, b *Message) bool {
+	if pa, pb := priorityRank[a.Priority], priorityRank[b.Priority]; pa != pb {
+		return pa > pb
+	}
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return messageIDLess(a.MessageID, b.MessageID)
+}
@@ -22,6 +22,9 @@ type Error struct {
 	Transient  bool
 	RetryAfter int
 	Status     int
+	// Field is a JSON pointer to the input that failed validation, when
+	// known.
+	Field string
 }
 
 func (e *Error) Error() string {
@@ -0,0 +1,129 @@
+package bus
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// normalizeMetadata trims metadata fields and checks label keys, which must
+// be non-empty and free of '=' so they can be used in label filters.
+func normalizeMetadata(md AgentMetadata) (AgentMetadata, error) {
+	out := AgentMetadata{
+		Version:     strings.TrimSpace(md.Version),
+		BuildSHA:    strings.TrimSpace(md.BuildSHA),
+		MaxInFlight: md.MaxInFlight,
+		CostClass:   strings.TrimSpace(md.CostClass),
+	}
+	if out.MaxInFlight < 0 {
+		return out, newError(CodeValidation, "metadata.max_in_flight must be >= 0", false, 0)
+	}
+	if len(md.Labels) > 0 {
+		out.Labels = make(map[string]string, len(md.Labels))
+	}
+	for k, v := range md.Labels {
+		key := strings.TrimSpace(k)
+		if key == "" || strings.Contains(key, "=") {
+			return out, newError(CodeValidation, fmt.Sprintf("metadata.labels: invalid key %q", k), false, 0)
+		}
+		out.Labels[key] = strings.TrimSpace(v)
+	}
+	return out, nil
+}
+
+// labelsMatch reports whether labels satisfy every key=value or bare key
+// selector.
+func labelsMatch(labels map[string]string, selectors []string) bool {
+	for _, sel := range selectors {
+		key, value, hasValue := strings.Cut(strings.TrimSpace(sel), "=")
+		got, ok := labels[strings.TrimSpace(key)]
+		if !ok || (hasValue && got != strings.TrimSpace(value)) {
+			return false
+		}
+	}
+	return true
+}
+
+var healthRank = map[HealthStatus]int{"": 0, HealthOK: 0, HealthDegraded: 1, HealthUnhealthy: 2}
+
+func validHealthStatus(status HealthStatus) bool {
+	_, ok := healthRank[status]
+	return ok
+}
+
+// unhealthy reports whether routing should skip a.
+func (a *Agent) unhealthy() bool {
+	return a.Health != nil && a.Health.Status == HealthUnhealthy
+}
+
+// Heartbeat renews an active agent's registration for its TTL and records
+// the health it reports. An agent that has already expired must register
+// again.
+func (s *Store) Heartbeat(input HeartbeatInput) (*Agent, error) {
+	now := s.now()
+	agentID := strings.TrimSpace(input.AgentID)
+	if agentID == "" {
+		return nil, newError(CodeValidation, "agent_id is required", false, 0)
+	}
+	if input.InFlight < 0 || input.Load < 0 {
+		return nil, newError(CodeValidation, "in_flight and load must be >= 0", false, 0)
+	}
+	if !validHealthStatus(input.Status) {
+		return nil, newError(CodeValidation, "status must be ok, degraded, or unhealthy", false, 0)
+	}
+	health := &AgentHealth{
+		Status:     input.Status,
+		InFlight:   input.InFlight,
+		Load:       input.Load,
+		ReportedAt: now,
+	}
+	for i, c := range input.Checks {
+		c.Name = strings.TrimSpace(c.Name)
+		if c.Name == "" || c.Status == "" || !validHealthStatus(c.Status) {
+			return nil, newError(CodeValidation, fmt.Sprintf("checks[%d]: name and a status of ok, degraded, or unhealthy are required", i), false, 0)
+		}
+		health.Checks = append(health.Checks, c)
+		if healthRank[c.Status] > healthRank[health.Status] {
+			health.Status = c.Status
+		}
+	}
+	if health.Status == "" {
+		health.Status = HealthOK
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweepLocked(now)
+
+	agent, ok := s.agents[agentID]
+	if !ok {
+		return nil, newError(CodeNotFound, "agent not registered", false, 0)
+	}
+	if agent.Status != AgentStatusActive {
+		return nil, newError(CodeNotFound, "agent registration expired; register again", false, 0)
+	}
+	previous := HealthStatus("")
+	if agent.Health != nil {
+		previous = agent.Health.Status
+	}
+	agent.Health = health
+	agent.ExpiresAt = now.Add(time.Duration(agent.TTLSeconds) * time.Second)
+	if previous != health.Status {
+		s.publishLocked(
+			ObserveAgentHealth,
+			map[string]any{
+				"agent_id": agent.AgentID,
+				"status":   health.Status,
+				"previous": previous,
+				"checks":   health.Checks,
+				"at":       now,
+			},
+			"",
+			[]string{agent.AgentID},
+			now,
+		)
+	}
+
+	cp := *agent
+	return &cp, nil
+}
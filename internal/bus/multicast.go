@@ -0,0 +1,310 @@
+package bus
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A multicast inform names its recipients instead of a single target. The
+// sender's message is stored once, with the resolved agent IDs in
+// Recipients, and each recipient gets its own copy carrying ParentID: that
+// copy is what lands in the recipient's inbox or push callback. A copy stays
+// pending until a poll returns it or its push callback succeeds, when it
+// completes; a push that exhausts its retries, a recipient that leaves the
+// bus first, or the send's TTL running out fails it. Copies are left out of conversation listings and
+// search, where the parent stands for them.
+
+const (
+	recipientCapability   = "capability:"
+	recipientConversation = "conversation:"
+)
+
+// planMulticastLocked validates a send with recipients and resolves them to
+// agents. p has passed the checks common to every send. Each recipient's copy
+// is added to planned, so later sends in a batch see it in the inbox.
+func (s *Store) planMulticastLocked(p *sendPlan, planned *sendLoad, now time.Time) (*sendPlan, error) {
+	input := p.input
+	switch {
+	case p.to != "":
+		return nil, newError(CodeValidation, "to and recipients cannot both be set", false, 0)
+	case p.msgType != MessageTypeInform:
+		return nil, newError(CodeValidation, "recipients is only supported for inform messages", false, 0)
+	case strings.TrimSpace(input.InReplyTo) != "" || input.CompleteRequest:
+		return nil, newError(CodeValidation, "recipients cannot be combined with in_reply_to", false, 0)
+	case !p.deliverAt.IsZero():
+		return nil, newError(CodeValidation, "recipients cannot be combined with a scheduled delivery", false, 0)
+	}
+	if p.ttl <= 0 {
+		p.ttl = int(s.cfg.DefaultMessageTTL.Seconds())
+	}
+
+	sender, ok := s.agents[p.from]
+	if !ok || sender.Status != AgentStatusActive {
+		return nil, newError(CodeUnauthorized, "sender is not registered/active", false, 0)
+	}
+	if err := s.checkBlobAccessLocked(p.from, input.Attachments); err != nil {
+		return nil, err
+	}
+	p.key = dedupeKey(p.from, "*", p.requestID)
+	if existing := s.idempotentMessageLocked(p.key, now); existing != nil {
+		p.duplicate = existing
+		return p, nil
+	}
+	if err := s.checkConversationOpenLocked(input.ConversationID); err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{p.from: true}
+	add := func(ids ...string) {
+		for _, id := range ids {
+			if a, ok := s.agents[id]; ok && !seen[id] {
+				seen[id] = true
+				p.recipients = append(p.recipients, a)
+			}
+		}
+	}
+	for i, raw := range input.Recipients {
+		r := strings.TrimSpace(raw)
+		switch {
+		case strings.HasPrefix(r, recipientCapability):
+			ids, err := s.capabilityRecipientsLocked(strings.TrimPrefix(r, recipientCapability))
+			if err != nil {
+				return nil, newError(CodeValidation, fmt.Sprintf("recipients[%d]: %s", i, err.(*Error).Message), false, 0)
+			}
+			add(ids...)
+		case strings.HasPrefix(r, recipientConversation):
+			id := strings.TrimSpace(strings.TrimPrefix(r, recipientConversation))
+			if _, ok := s.conversations[id]; !ok {
+				return nil, newError(CodeNotFound, fmt.Sprintf("recipients[%d]: conversation %q not found", i, id), false, 0)
+			}
+			add(s.conversationParticipantsLocked(id)...)
+		case r == "":
+			return nil, newError(CodeValidation, fmt.Sprintf("recipients[%d] is empty", i), false, 0)
+		default:
+			if _, ok := s.agents[r]; !ok {
+				return nil, newError(CodeNotFound, fmt.Sprintf("recipients[%d]: agent %s not registered", i, r), false, 0)
+			}
+			add(r)
+		}
+	}
+	if len(p.recipients) == 0 {
+		return nil, newError(CodeNotFound, "recipients matched no agents", false, 0)
+	}
+	for _, a := range p.recipients {
+		planned.inbox[a.AgentID]++
+	}
+	return p, nil
+}
+
+// capabilityRecipientsLocked returns the active agents offering a capability
+// matching query, or every active agent for "*".
+func (s *Store) capabilityRecipientsLocked(query string) ([]string, error) {
+	query = strings.TrimSpace(query)
+	var q capabilityQuery
+	if query != "*" {
+		var err error
+		if q, err = parseCapabilityQuery(query); err != nil {
+			return nil, err
+		}
+	}
+	var ids []string
+	for id, a := range s.agents {
+		if a.Status != AgentStatusActive {
+			continue
+		}
+		if _, _, ok := q.best(a.Capabilities); query == "*" || ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// conversationParticipantsLocked returns the conversation's declared
+// participants followed by everyone who has sent or received a message in
+// it.
+func (s *Store) conversationParticipantsLocked(conversationID string) []string {
+	ids := append([]string{}, s.conversations[conversationID].Participants...)
+	for _, mid := range s.conversationMessages[conversationID] {
+		if m, ok := s.messages[mid]; ok {
+			ids = append(ids, m.From, m.To)
+			ids = append(ids, m.Recipients...)
+		}
+	}
+	return ids
+}
+
+// applyMulticastLocked stores the sender's message and delivers a copy to
+// each recipient. A copy that cannot be delivered ends in the error state
+// without failing the others.
+func (s *Store) applyMulticastLocked(p *sendPlan, now time.Time) *Message {
+	participants := []string{p.from}
+	for _, a := range p.recipients {
+		participants = append(participants, a.AgentID)
+	}
+	conv := s.ensureConversationLocked(CreateConversationInput{
+		ConversationID: p.input.ConversationID,
+		Participants:   participants,
+	}, now)
+
+	s.nextMessageID++
+	parent := &Message{
+		MessageID:      fmt.Sprintf("m-%06d", s.nextMessageID),
+		Type:           MessageTypeInform,
+		From:           p.from,
+		ConversationID: conv.ConversationID,
+		RequestID:      p.requestID,
+		Recipients:     participants[1:],
+		Priority:       p.priority,
+		Body:           p.body,
+		Meta:           p.input.Meta,
+		Attachments:    append([]Attachment{}, p.input.Attachments...),
+		State:          StateCompleted,
+		TraceParent:    strings.TrimSpace(p.input.TraceParent),
+		CreatedAt:      now,
+	}
+	s.messages[parent.MessageID] = parent
+	s.indexMessageLocked(parent)
+	s.indexBlobsLocked(parent)
+	s.conversationMessages[conv.ConversationID] = append(s.conversationMessages[conv.ConversationID], parent.MessageID)
+	conv.MessageCount = len(s.conversationMessages[conv.ConversationID])
+	conv.LastMessageAt = now
+	s.idempotency[p.key] = idempotencyEntry{MessageID: parent.MessageID, CreatedAt: now}
+
+	s.publishLocked(
+		ObserveMessage,
+		map[string]any{
+			"message_id":      parent.MessageID,
+			"type":            parent.Type,
+			"from":            parent.From,
+			"recipients":      parent.Recipients,
+			"conversation_id": parent.ConversationID,
+			"body":            parent.Body,
+			"created_at":      parent.CreatedAt,
+		},
+		parent.ConversationID,
+		participants,
+		now,
+	)
+
+	for _, a := range p.recipients {
+		s.nextMessageID++
+		m := &Message{
+			MessageID:      fmt.Sprintf("m-%06d", s.nextMessageID),
+			Type:           MessageTypeInform,
+			From:           parent.From,
+			To:             a.AgentID,
+			ConversationID: parent.ConversationID,
+			RequestID:      parent.RequestID,
+			ParentID:       parent.MessageID,
+			Priority:       parent.Priority,
+			Body:           parent.Body,
+			Meta:           parent.Meta,
+			Attachments:    append([]Attachment{}, parent.Attachments...),
+			State:          StatePending,
+			TraceParent:    parent.TraceParent,
+			CreatedAt:      now,
+			TTLExpiresAt:   now.Add(time.Duration(p.ttl) * time.Second),
+		}
+		s.messages[m.MessageID] = m
+		s.indexChildLocked(m)
+		if s.trackChanges {
+			s.changedMessages[m.MessageID] = struct{}{}
+		}
+
+		expired := a.Status == AgentStatusExpired && !now.After(a.ExpiresAt.Add(s.cfg.GracePeriod))
+		switch {
+		case a.Status != AgentStatusActive && !expired:
+			m.State = StateError
+			m.Result = &Result{Body: "target agent unavailable", ErrorCode: CodeUnavailable}
+		case s.inboxFullLocked(a, 0):
+			m.State = StateError
+			m.Result = &Result{Body: fmt.Sprintf("agent has %d unread inbox events", s.cfg.MaxInboxEventsPerAgent), ErrorCode: CodeUnavailable}
+		default:
+			// An expired agent finds the message in its inbox when it
+			// registers again.
+			s.appendInboxLocked(a.AgentID, inboxEventFor(m))
+			if a.Status == AgentStatusActive && a.Mode == AgentModePush && strings.TrimSpace(a.CallbackURL) != "" {
+				s.pushLocked(a.AgentID, strings.TrimSpace(a.CallbackURL), pushPayloadFor(m))
+			}
+		}
+	}
+
+	cp := *parent
+	return &cp
+}
+
+// settleCopyLocked records the outcome of handing multicast copy messageID to
+// its recipient: delivered, or failed with reason. Other messages, and copies
+// already settled, are left alone.
+func (s *Store) settleCopyLocked(messageID string, delivered bool, reason string, now time.Time) {
+	m, ok := s.messages[messageID]
+	if !ok || m.ParentID == "" || m.State != StatePending {
+		return
+	}
+	if delivered {
+		m.DeliveredAt = now
+		s.transitionLocked(m, StateTransition{To: StateCompleted, Reason: reason}, now)
+		return
+	}
+	m.Result = &Result{Body: reason, ErrorCode: CodeUnavailable}
+	s.transitionLocked(m, StateTransition{To: StateError, Reason: reason}, now)
+}
+
+// sweepCopyLocked fails pending copy m once its TTL passes, or once its
+// recipient has deregistered or stayed expired past its grace period, since
+// nothing will take it.
+func (s *Store) sweepCopyLocked(m *Message, now time.Time) {
+	if !m.TTLExpiresAt.IsZero() && now.After(m.TTLExpiresAt) {
+		m.Result = &Result{Body: "ttl timeout", ErrorCode: CodeTimeout}
+		s.transitionLocked(m, StateTransition{To: StateError, Reason: "ttl timeout"}, now)
+		return
+	}
+	a, ok := s.agents[m.To]
+	if ok && (a.Status == AgentStatusActive || (a.Status == AgentStatusExpired && !now.After(a.ExpiresAt.Add(s.cfg.GracePeriod)))) {
+		return
+	}
+	s.settleCopyLocked(m.MessageID, false, "target agent unavailable", now)
+}
+
+// indexChildLocked records m against the multicast message it copies.
+func (s *Store) indexChildLocked(m *Message) {
+	if m.ParentID != "" {
+		s.children[m.ParentID] = append(s.children[m.ParentID], m.MessageID)
+	}
+}
+
+// reindexChildrenLocked rebuilds the multicast index after messages are
+// loaded from a backend.
+func (s *Store) reindexChildrenLocked() {
+	s.children = map[string][]string{}
+	var ids []string
+	for id, m := range s.messages {
+		if m.ParentID != "" {
+			ids = append(ids, id)
+		}
+	}
+	sortMessageIDs(ids)
+	for _, id := range ids {
+		s.indexChildLocked(s.messages[id])
+	}
+}
+
+// deliveriesLocked reports the state of each recipient's copy of m.
+func (s *Store) deliveriesLocked(m *Message) []Delivery {
+	var out []Delivery
+	for _, id := range s.children[m.MessageID] {
+		c, ok := s.messages[id]
+		if !ok {
+			continue
+		}
+		d := Delivery{AgentID: c.To, MessageID: c.MessageID, State: c.State, DeliveredAt: optionalTime(c.DeliveredAt)}
+		if c.State == StateError && c.Result != nil {
+			d.Error = c.Result.Body
+		}
+		out = append(out, d)
+	}
+	return out
+}
@@ -0,0 +1,157 @@
+package bus
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Sort keys accepted by ListAgents and ListConversations.
+const (
+	SortAgentID       = "agent_id"
+	SortRegisteredAt  = "registered_at"
+	SortExpiresAt     = "expires_at"
+	SortCreatedAt     = "created_at"
+	SortLastMessageAt = "last_message_at"
+)
+
+// Sort orders. The empty order is ascending.
+const (
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+// Page sizes of agent and conversation listings: a zero limit gets the
+// default, and larger limits are capped.
+const (
+	listLimitDefault = 50
+	listLimitMax     = 200
+)
+
+// pageKey positions an item within a sorted listing: the sort value (Unix
+// nanoseconds for time sorts, zero otherwise) with the item ID breaking ties.
+type pageKey struct {
+	Value int64  `json:"v,omitempty"`
+	ID    string `json:"id"`
+}
+
+func (k pageKey) less(o pageKey) bool {
+	if k.Value != o.Value {
+		return k.Value < o.Value
+	}
+	return k.ID < o.ID
+}
+
+func timeKey(t time.Time, id string) pageKey {
+	return pageKey{Value: t.UnixNano(), ID: id}
+}
+
+// pageCursor is the decoded form of a listing cursor. It records the sort it
+// was issued for so a cursor cannot be replayed against a different order.
+type pageCursor struct {
+	Sort  string  `json:"s"`
+	Order string  `json:"o"`
+	After pageKey `json:"a"`
+}
+
+// pageRequest is the validated paging part of a listing filter.
+type pageRequest struct {
+	sort  string
+	desc  bool
+	after *pageKey
+	limit int
+}
+
+// newPageRequest validates sortBy against allowed (the first entry is the
+// default), order, cursor and limit.
+func newPageRequest(sortBy, order, cursor string, limit int, allowed ...string) (pageRequest, error) {
+	req := pageRequest{sort: strings.TrimSpace(sortBy), limit: limit}
+	if req.sort == "" {
+		req.sort = allowed[0]
+	}
+	valid := false
+	for _, a := range allowed {
+		if a == req.sort {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return req, newError(CodeValidation, "sort must be one of "+strings.Join(allowed, ", "), false, 0)
+	}
+	switch strings.TrimSpace(order) {
+	case "", OrderAsc:
+	case OrderDesc:
+		req.desc = true
+	default:
+		return req, newError(CodeValidation, "order must be asc or desc", false, 0)
+	}
+	if req.limit < 0 {
+		return req, newError(CodeValidation, "limit must be >= 0", false, 0)
+	}
+	if req.limit == 0 {
+		req.limit = listLimitDefault
+	}
+	if req.limit > listLimitMax {
+		req.limit = listLimitMax
+	}
+	if cursor = strings.TrimSpace(cursor); cursor != "" {
+		blob, err := base64.RawURLEncoding.DecodeString(cursor)
+		var pc pageCursor
+		if err == nil {
+			err = json.Unmarshal(blob, &pc)
+		}
+		if err != nil {
+			return req, newError(CodeValidation, "cursor is invalid", false, 0)
+		}
+		if pc.Sort != req.sort || pc.Order != req.orderName() {
+			return req, newError(CodeValidation, "cursor was issued for a different sort or order", false, 0)
+		}
+		req.after = &pc.After
+	}
+	return req, nil
+}
+
+func (r pageRequest) orderName() string {
+	if r.desc {
+		return OrderDesc
+	}
+	return OrderAsc
+}
+
+// paginate sorts items by key, drops everything up to and including the
+// cursor position and trims the result to the limit. The returned cursor is
+// empty on the last page.
+func paginate[T any](items []T, key func(T) pageKey, req pageRequest) ([]T, string) {
+	before := func(a, b pageKey) bool {
+		if req.desc {
+			return b.less(a)
+		}
+		return a.less(b)
+	}
+	sort.Slice(items, func(i, j int) bool { return before(key(items[i]), key(items[j])) })
+	if req.after != nil {
+		start := sort.Search(len(items), func(i int) bool { return before(*req.after, key(items[i])) })
+		items = items[start:]
+	}
+	if len(items) <= req.limit {
+		return items, ""
+	}
+	items = items[:req.limit]
+	blob, _ := json.Marshal(pageCursor{Sort: req.sort, Order: req.orderName(), After: key(items[len(items)-1])})
+	return items, base64.RawURLEncoding.EncodeToString(blob)
+}
+
+// inTimeRange reports whether t is strictly after after and strictly before
+// before, ignoring whichever bound is zero.
+func inTimeRange(t, after, before time.Time) bool {
+	if !after.IsZero() && !t.After(after) {
+		return false
+	}
+	if !before.IsZero() && !t.Before(before) {
+		return false
+	}
+	return true
+}
@@ -9,19 +9,26 @@ import (
 )
 
 type persistentState struct {
-	NextConversationID   int64                       `json:"next_conversation_id"`
-	NextMessageID        int64                       `json:"next_message_id"`
-	NextObserveID        int64                       `json:"next_observe_id"`
-	PushFailures         int64                       `json:"push_failures"`
-	PushSuccesses        int64                       `json:"push_successes"`
-	Agents               map[string]Agent            `json:"agents"`
-	Conversations        map[string]Conversation     `json:"conversations"`
-	Messages             map[string]Message          `json:"messages"`
-	ConversationMessages map[string][]string         `json:"conversation_messages"`
-	Inboxes              map[string][]InboxEvent     `json:"inboxes"`
-	InboxBase            map[string]int              `json:"inbox_base"`
-	ObserveEvents        []ObserveEvent              `json:"observe_events"`
-	Idempotency          map[string]idempotencyEntry `json:"idempotency"`
+	NextConversationID   int64                          `json:"next_conversation_id"`
+	NextMessageID        int64                          `json:"next_message_id"`
+	NextObserveID        int64                          `json:"next_observe_id"`
+	PushFailures         int64                          `json:"push_failures"`
+	PushSuccesses        int64                          `json:"push_successes"`
+	Agents               map[string]Agent               `json:"agents"`
+	Conversations        map[string]Conversation        `json:"conversations"`
+	Messages             map[string]Message             `json:"messages"`
+	ConversationMessages map[string][]string            `json:"conversation_messages"`
+	Inboxes              map[string][]InboxEvent        `json:"inboxes"`
+	InboxBase            map[string]int                 `json:"inbox_base"`
+	InboxRead            map[string]int                 `json:"inbox_read,omitempty"`
+	InboxServed          map[string]int                 `json:"inbox_served,omitempty"`
+	ObserveEvents        []ObserveEvent                 `json:"observe_events"`
+	Idempotency          map[string]idempotencyEntry    `json:"idempotency"`
+	StateHistory         map[string][]StateTransition   `json:"state_history,omitempty"`
+	ConversationHistory  map[string][]ConversationEvent `json:"conversation_history,omitempty"`
+	// Held lists the requests waiting for their target's max_in_flight,
+	// which Message does not serialize.
+	Held []string `json:"held,omitempty"`
 }
 
 type PersistentStore struct {
@@ -59,8 +66,12 @@ func (p *PersistentStore) stateSnapshot() persistentState {
 		ConversationMessages: map[string][]string{},
 		Inboxes:              map[string][]InboxEvent{},
 		InboxBase:            map[string]int{},
+		InboxRead:            map[string]int{},
+		InboxServed:          map[string]int{},
 		ObserveEvents:        append([]ObserveEvent{}, p.inner.observeEvents...),
 		Idempotency:          map[string]idempotencyEntry{},
+		StateHistory:         map[string][]StateTransition{},
+		ConversationHistory:  map[string][]ConversationEvent{},
 	}
 	for k, v := range p.inner.agents {
 		cp := *v
@@ -73,6 +84,9 @@ func (p *PersistentStore) stateSnapshot() persistentState {
 	for k, v := range p.inner.messages {
 		cp := *v
 		state.Messages[k] = cp
+		if v.HeldForCapacity {
+			state.Held = append(state.Held, k)
+		}
 	}
 	for k, v := range p.inner.conversationMessages {
 		state.ConversationMessages[k] = append([]string{}, v...)
@@ -83,9 +97,21 @@ func (p *PersistentStore) stateSnapshot() persistentState {
 	for k, v := range p.inner.inboxBase {
 		state.InboxBase[k] = v
 	}
+	for k, v := range p.inner.inboxRead {
+		state.InboxRead[k] = v
+	}
+	for k, v := range p.inner.inboxServed {
+		state.InboxServed[k] = v
+	}
 	for k, v := range p.inner.idempotency {
 		state.Idempotency[k] = v
 	}
+	for k, v := range p.inner.stateHistory {
+		state.StateHistory[k] = append([]StateTransition{}, v...)
+	}
+	for k, v := range p.inner.conversationHistory {
+		state.ConversationHistory[k] = append([]ConversationEvent{}, v...)
+	}
 	return state
 }
 
@@ -126,11 +152,36 @@ func (p *PersistentStore) applyState(state persistentState) {
 	for k, v := range state.InboxBase {
 		p.inner.inboxBase[k] = v
 	}
+	p.inner.inboxRead = map[string]int{}
+	for k, v := range state.InboxRead {
+		p.inner.inboxRead[k] = v
+	}
+	p.inner.inboxServed = map[string]int{}
+	for k, v := range state.InboxServed {
+		p.inner.inboxServed[k] = v
+	}
+	for _, id := range state.Held {
+		if m, ok := p.inner.messages[id]; ok {
+			m.HeldForCapacity = true
+		}
+	}
 	p.inner.observeEvents = append([]ObserveEvent{}, state.ObserveEvents...)
 	p.inner.idempotency = map[string]idempotencyEntry{}
 	for k, v := range state.Idempotency {
 		p.inner.idempotency[k] = v
 	}
+	p.inner.stateHistory = map[string][]StateTransition{}
+	for k, v := range state.StateHistory {
+		p.inner.stateHistory[k] = append([]StateTransition{}, v...)
+	}
+	p.inner.conversationHistory = map[string][]ConversationEvent{}
+	for k, v := range state.ConversationHistory {
+		p.inner.conversationHistory[k] = append([]ConversationEvent{}, v...)
+	}
+	p.inner.reindexRepliesLocked()
+	p.inner.reindexChildrenLocked()
+	p.inner.reindexBlobsLocked()
+	p.inner.reindexSearchLocked()
 }
 
 func (p *PersistentStore) persist() error {
@@ -197,10 +248,30 @@ func (p *PersistentStore) RegisterAgent(input RegisterAgentInput) (*Agent, error
 	return out, err
 }
 
-func (p *PersistentStore) ListAgents(capability string) []Agent {
-	out := p.inner.ListAgents(capability)
+func (p *PersistentStore) Heartbeat(input HeartbeatInput) (*Agent, error) {
+	out, err := p.inner.Heartbeat(input)
+	if err == nil {
+		if perr := p.persist(); perr != nil {
+			return nil, perr
+		}
+	}
+	return out, err
+}
+
+func (p *PersistentStore) DeregisterAgent(input DeregisterAgentInput) (*DeregisterResult, error) {
+	out, err := p.inner.DeregisterAgent(input)
+	if err == nil {
+		if perr := p.persist(); perr != nil {
+			return nil, perr
+		}
+	}
+	return out, err
+}
+
+func (p *PersistentStore) ListAgents(filter ListAgentsFilter) ([]Agent, string, error) {
+	out, next, err := p.inner.ListAgents(filter)
 	p.persistBestEffort()
-	return out
+	return out, next, err
 }
 
 func (p *PersistentStore) CreateConversation(input CreateConversationInput) (*Conversation, error) {
@@ -213,20 +284,112 @@ func (p *PersistentStore) CreateConversation(input CreateConversationInput) (*Co
 	return out, err
 }
 
-func (p *PersistentStore) ListConversations(filter ListConversationsFilter) []Conversation {
-	out := p.inner.ListConversations(filter)
+func (p *PersistentStore) ListConversations(filter ListConversationsFilter) ([]Conversation, string, error) {
+	out, next, err := p.inner.ListConversations(filter)
 	p.persistBestEffort()
-	return out
+	return out, next, err
 }
 
 func (p *PersistentStore) SendMessage(input SendMessageInput) (*Message, bool, error) {
-	m, dup, err := p.inner.SendMessage(input)
+	m, dup, err := p.inner.sendMessage(input)
+	if err != nil {
+		return nil, false, err
+	}
+	if perr := p.persist(); perr != nil {
+		return nil, false, perr
+	}
+	return p.inner.awaitResult(m, input.Wait), dup, nil
+}
+
+func (p *PersistentStore) Call(input SendMessageInput) (*CallResult, error) {
+	m, dup, err := p.inner.sendCall(input)
+	if err != nil {
+		return nil, err
+	}
+	if perr := p.persist(); perr != nil {
+		return nil, perr
+	}
+	return p.inner.awaitCall(m, dup, input.Wait), nil
+}
+
+func (p *PersistentStore) SendMessages(input SendMessagesInput) ([]SendMessageResult, error) {
+	results, err := p.inner.SendMessages(input)
 	if err == nil {
 		if perr := p.persist(); perr != nil {
-			return nil, false, perr
+			return nil, perr
 		}
 	}
-	return m, dup, err
+	return results, err
+}
+
+func (p *PersistentStore) GetMessage(messageID string) (*MessageDetail, error) {
+	out, err := p.inner.GetMessage(messageID)
+	p.persistBestEffort()
+	return out, err
+}
+
+func (p *PersistentStore) ListScheduled(agentID string) ([]Message, error) {
+	out, err := p.inner.ListScheduled(agentID)
+	p.persistBestEffort()
+	return out, err
+}
+
+func (p *PersistentStore) CancelScheduled(input CancelScheduledInput) (*Message, error) {
+	out, err := p.inner.CancelScheduled(input)
+	if err == nil {
+		if perr := p.persist(); perr != nil {
+			return nil, perr
+		}
+	}
+	return out, err
+}
+
+// DeliverDue saves the store only when something was delivered, since the
+// server calls it every second.
+func (p *PersistentStore) DeliverDue() int {
+	n := p.inner.DeliverDue()
+	if n > 0 {
+		p.persistBestEffort()
+	}
+	return n
+}
+
+func (p *PersistentStore) GetAgent(agentID string) (*Agent, error) {
+	out, err := p.inner.GetAgent(agentID)
+	p.persistBestEffort()
+	return out, err
+}
+
+func (p *PersistentStore) SetConversationStatus(input ConversationStatusInput) (*Conversation, error) {
+	out, err := p.inner.SetConversationStatus(input)
+	if err == nil {
+		if perr := p.persist(); perr != nil {
+			return nil, perr
+		}
+	}
+	return out, err
+}
+
+func (p *PersistentStore) UpdateConversation(input UpdateConversationInput) (*Conversation, error) {
+	out, err := p.inner.UpdateConversation(input)
+	if err == nil {
+		if perr := p.persist(); perr != nil {
+			return nil, perr
+		}
+	}
+	return out, err
+}
+
+func (p *PersistentStore) GetConversation(conversationID string) (*Conversation, error) {
+	out, err := p.inner.GetConversation(conversationID)
+	p.persistBestEffort()
+	return out, err
+}
+
+func (p *PersistentStore) ConversationHistory(conversationID string) ([]ConversationEvent, error) {
+	out, err := p.inner.ConversationHistory(conversationID)
+	p.persistBestEffort()
+	return out, err
 }
 
 func (p *PersistentStore) PollInbox(input PollInboxInput) ([]InboxEvent, int, error) {
@@ -271,6 +434,18 @@ func (p *PersistentStore) ListConversationMessages(input ListConversationMessage
 	return cid, messages, cursor, err
 }
 
+func (p *PersistentStore) Search(input SearchInput) ([]SearchHit, error) {
+	return p.inner.Search(input)
+}
+
+func (p *PersistentStore) BlobReferences(sha string) BlobReferences {
+	return p.inner.BlobReferences(sha)
+}
+
+func (p *PersistentStore) ReferencedBlobs() map[string]int {
+	return p.inner.ReferencedBlobs()
+}
+
 func (p *PersistentStore) ObserveSince(afterID int64, filter ObserveFilter, wait time.Duration) ([]ObserveEvent, int64) {
 	events, last := p.inner.ObserveSince(afterID, filter, wait)
 	p.persistBestEffort()
@@ -294,3 +469,7 @@ func (p *PersistentStore) SystemStatus() map[string]any {
 	}
 	return out
 }
+
+func (p *PersistentStore) MessageTraceParent(messageID string) string {
+	return p.inner.MessageTraceParent(messageID)
+}
@@ -49,7 +49,10 @@ func TestPersistentStoreRoundTrip(t *testing.T) {
 	if err != nil {
 		t.Fatalf("re-open persistent store: %v", err)
 	}
-	agents := s2.ListAgents("")
+	agents, _, err := s2.ListAgents(ListAgentsFilter{})
+	if err != nil {
+		t.Fatalf("list agents after restore: %v", err)
+	}
 	if len(agents) != 2 {
 		t.Fatalf("expected 2 agents after restore, got %d", len(agents))
 	}
@@ -65,6 +68,80 @@ func TestPersistentStoreRoundTrip(t *testing.T) {
 	}
 }
 
+func persistentTestConfig() Config {
+	now := time.Date(2026, 2, 17, 0, 0, 0, 0, time.UTC)
+	return Config{
+		GracePeriod:            30 * time.Second,
+		ProgressMinInterval:    2 * time.Second,
+		IdempotencyWindow:      24 * time.Hour,
+		InboxWaitMax:           1 * time.Second,
+		AckTimeout:             10 * time.Second,
+		DefaultMessageTTL:      600 * time.Second,
+		DefaultRegistrationTTL: 60 * time.Second,
+		Clock: func() time.Time {
+			return now
+		},
+	}
+}
+
+// sendPersistedRequest registers a and b and sends one request from a to b.
+func sendPersistedRequest(t *testing.T, s *PersistentStore, body string) *Message {
+	t.Helper()
+	for _, id := range []string{"a", "b"} {
+		if _, err := s.RegisterAgent(RegisterAgentInput{AgentID: id, Mode: AgentModePull, TTLSeconds: 60}); err != nil {
+			t.Fatalf("register %s: %v", id, err)
+		}
+	}
+	msg, _, err := s.SendMessage(SendMessageInput{To: "b", From: "a", RequestID: "rid-persist", Type: MessageTypeRequest, Body: body})
+	if err != nil {
+		t.Fatalf("send message: %v", err)
+	}
+	return msg
+}
+
+func TestPersistentStoreStateHistoryRoundTrip(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	cfg := persistentTestConfig()
+	s1, err := NewPersistentStore(statePath, cfg)
+	if err != nil {
+		t.Fatalf("new persistent store: %v", err)
+	}
+	msg := sendPersistedRequest(t, s1, "track me")
+	if err := s1.Ack(AckInput{AgentID: "b", MessageID: msg.MessageID, Status: "accepted"}); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+
+	s2, err := NewPersistentStore(statePath, cfg)
+	if err != nil {
+		t.Fatalf("re-open persistent store: %v", err)
+	}
+	detail, err := s2.GetMessage(msg.MessageID)
+	if err != nil {
+		t.Fatalf("get message after restore: %v", err)
+	}
+	if len(detail.StateHistory) != 1 || detail.StateHistory[0].To != StateExecuting || detail.StateHistory[0].Actor != "b" {
+		t.Fatalf("expected ack transition after restore, got %#v", detail.StateHistory)
+	}
+}
+
+func TestPersistentStoreSearchIndexRebuiltOnLoad(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	cfg := persistentTestConfig()
+	s1, err := NewPersistentStore(statePath, cfg)
+	if err != nil {
+		t.Fatalf("new persistent store: %v", err)
+	}
+	msg := sendPersistedRequest(t, s1, "persist me")
+
+	s2, err := NewPersistentStore(statePath, cfg)
+	if err != nil {
+		t.Fatalf("re-open persistent store: %v", err)
+	}
+	if hits, err := s2.Search(SearchInput{Query: "persist"}); err != nil || len(hits) != 1 || hits[0].MessageID != msg.MessageID {
+		t.Fatalf("expected search index rebuilt on load, got %#v (%v)", hits, err)
+	}
+}
+
 func TestPersistentStoreReadSweepPersist(t *testing.T) {
 	tmp := t.TempDir()
 	statePath := filepath.Join(tmp, "state.json")
@@ -107,7 +184,9 @@ func TestPersistentStoreReadSweepPersist(t *testing.T) {
 	}
 
 	current = current.Add(5 * time.Second)
-	_ = store.ListAgents("")
+	if _, _, err := store.ListAgents(ListAgentsFilter{}); err != nil {
+		t.Fatalf("list agents for read sweep test: %v", err)
+	}
 
 	restored, err := NewPersistentStore(statePath, cfg)
 	if err != nil {
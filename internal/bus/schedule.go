@@ -0,0 +1,151 @@
+package bus
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// A message sent with deliver_at or delay_seconds waits on the bus in the
+// scheduled state until it is due, with its TTL counted from then. The sweep
+// every store call runs delivers due messages, and the server also calls
+// DeliverDue on a timer so push agents and idle buses get them on time.
+// Delivery follows the rules for the target as it is at that moment: a
+// request may be held for capacity or queued for an expired agent, and one
+// whose agent has since deregistered is routed again by capability. Until it
+// is delivered, the sender can cancel it.
+
+// deliverDueLocked delivers the scheduled messages whose time has come,
+// earliest first, and returns how many there were.
+func (s *Store) deliverDueLocked(now time.Time) int {
+	var due []*Message
+	for _, m := range s.messages {
+		if m.State == StateScheduled && m.DeliverAt != nil && !now.Before(*m.DeliverAt) {
+			due = append(due, m)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		a, b := due[i], due[j]
+		if !a.DeliverAt.Equal(*b.DeliverAt) {
+			return a.DeliverAt.Before(*b.DeliverAt)
+		}
+		return deliverBefore(a, b)
+	})
+	for _, m := range due {
+		s.deliverScheduledLocked(m, now)
+	}
+	return len(due)
+}
+
+func (s *Store) deliverScheduledLocked(m *Message, now time.Time) {
+	const reason = "scheduled delivery"
+	target, ok := s.agents[m.To]
+	if m.Type == MessageTypeRequest && m.Capability != "" && (!ok || target.Status == AgentStatusDeregistered) {
+		if q, err := parseCapabilityQuery(m.Capability); err == nil {
+			if next, err := s.routeLocked(q); err == nil {
+				target, ok = next, true
+				m.To = next.AgentID
+				s.indexMessageLocked(m)
+			}
+		}
+	}
+	graceUntil := time.Time{}
+	if ok && target.Status == AgentStatusExpired {
+		graceUntil = target.ExpiresAt.Add(s.cfg.GracePeriod)
+	}
+
+	switch {
+	case !ok || target.Status == AgentStatusDeregistered || (!graceUntil.IsZero() && now.After(graceUntil)):
+		m.Result = &Result{Body: "target agent unavailable at scheduled delivery", ErrorCode: CodeUnavailable}
+		s.transitionLocked(m, StateTransition{To: StateError, Reason: m.Result.Body}, now)
+	case m.Type != MessageTypeRequest:
+		// An expired agent finds the message in its inbox when it
+		// registers again.
+		s.transitionLocked(m, StateTransition{To: StateCompleted, Reason: reason}, now)
+		s.appendInboxLocked(m.To, inboxEventFor(m))
+		if target.Status == AgentStatusActive && target.Mode == AgentModePush && strings.TrimSpace(target.CallbackURL) != "" {
+			s.pushLocked(m.To, strings.TrimSpace(target.CallbackURL), pushPayloadFor(m))
+		}
+	case !graceUntil.IsZero():
+		m.QueuedForAgent = true
+		m.GraceUntil = graceUntil
+		s.transitionLocked(m, StateTransition{To: StatePending, Reason: reason + "; target agent expired"}, now)
+	case s.mustHoldLocked(target):
+		m.HeldForCapacity = true
+		s.transitionLocked(m, StateTransition{To: StatePending, Reason: reason + "; held for capacity"}, now)
+	default:
+		s.deliverLocked(m, target, reason, now)
+	}
+}
+
+// DeliverDue runs the sweep, delivering scheduled messages that have come
+// due, and returns how many it delivered.
+func (s *Store) DeliverDue() int {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.deliverDueLocked(now)
+	s.sweepLocked(now)
+	return n
+}
+
+// ListScheduled returns the messages agentID has scheduled and that have not
+// been delivered yet, in the order they are due.
+func (s *Store) ListScheduled(agentID string) ([]Message, error) {
+	now := s.now()
+	agentID = strings.TrimSpace(agentID)
+	if agentID == "" {
+		return nil, newError(CodeValidation, "agent_id is required", false, 0)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweepLocked(now)
+
+	var scheduled []*Message
+	for _, m := range s.messages {
+		if m.From == agentID && m.State == StateScheduled {
+			scheduled = append(scheduled, m)
+		}
+	}
+	sort.Slice(scheduled, func(i, j int) bool {
+		a, b := scheduled[i], scheduled[j]
+		if !a.DeliverAt.Equal(*b.DeliverAt) {
+			return a.DeliverAt.Before(*b.DeliverAt)
+		}
+		return deliverBefore(a, b)
+	})
+	out := make([]Message, 0, len(scheduled))
+	for _, m := range scheduled {
+		out = append(out, *m)
+	}
+	return out, nil
+}
+
+// CancelScheduled cancels a scheduled message that has not been delivered.
+func (s *Store) CancelScheduled(input CancelScheduledInput) (*Message, error) {
+	now := s.now()
+	agentID := strings.TrimSpace(input.AgentID)
+	messageID := strings.TrimSpace(input.MessageID)
+	if agentID == "" || messageID == "" {
+		return nil, newError(CodeValidation, "agent_id and message_id are required", false, 0)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweepLocked(now)
+
+	m, ok := s.messages[messageID]
+	if !ok {
+		return nil, newError(CodeNotFound, "message not found", false, 0)
+	}
+	if m.From != agentID {
+		return nil, newError(CodeUnauthorized, "only the sender can cancel a scheduled message", false, 0)
+	}
+	if m.State != StateScheduled {
+		return nil, newError(CodeRejected, "message is "+string(m.State)+", not scheduled", false, 0)
+	}
+	s.transitionLocked(m, StateTransition{To: StateCancelled, Actor: agentID, Reason: "cancelled by sender"}, now)
+	cp := *m
+	return &cp, nil
+}
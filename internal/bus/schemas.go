@@ -0,0 +1,156 @@
+package bus
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/santhosh-tekuri/jsonschema/v6/kind"
+)
+
+// CapabilitySchema holds the JSON Schemas an agent declares for one of its
+// capabilities. Request is checked against requests sent to the agent for
+// that capability, and Response against the agent's responses and final
+// events for them. Both validate the document {"body": ..., "meta": ...},
+// where body is the parsed JSON when the message body is valid JSON and the
+// body string otherwise, so failing fields are reported as /body/... or
+// /meta/....
+type CapabilitySchema struct {
+	Request  json.RawMessage `json:"request,omitempty"`
+	Response json.RawMessage `json:"response,omitempty"`
+}
+
+const (
+	schemaRequest  = "request"
+	schemaResponse = "response"
+)
+
+// compileSchema compiles an agent-supplied schema. External $refs are not
+// resolved, so a schema cannot make the bus read files or fetch URLs.
+func compileSchema(raw json.RawMessage) (*jsonschema.Schema, error) {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	c := jsonschema.NewCompiler()
+	c.UseLoader(jsonschema.SchemeURLLoader{})
+	if err := c.AddResource("schema.json", doc); err != nil {
+		return nil, err
+	}
+	return c.Compile("schema.json")
+}
+
+// normalizeSchemas checks that every schema belongs to a declared capability
+// and compiles.
+func normalizeSchemas(schemas map[string]CapabilitySchema, capabilities []string) (map[string]CapabilitySchema, error) {
+	if len(schemas) == 0 {
+		return nil, nil
+	}
+	out := make(map[string]CapabilitySchema, len(schemas))
+	for capability, cs := range schemas {
+		if !slices.Contains(capabilities, capability) {
+			return nil, newError(CodeValidation, fmt.Sprintf("schemas.%s: not one of the agent's capabilities", capability), false, 0)
+		}
+		for name, raw := range map[string]json.RawMessage{schemaRequest: cs.Request, schemaResponse: cs.Response} {
+			if len(raw) == 0 {
+				continue
+			}
+			if _, err := compileSchema(raw); err != nil {
+				return nil, newError(CodeValidation, fmt.Sprintf("schemas.%s.%s: %v", capability, name, err), false, 0)
+			}
+		}
+		out[capability] = cs
+	}
+	return out, nil
+}
+
+// schemaCapability resolves which of agent's capabilities a message is for:
+// the one named, or the agent's only capability when none is named.
+func schemaCapability(agent *Agent, capability string) string {
+	if capability == "" && agent != nil && len(agent.Capabilities) == 1 {
+		return agent.Capabilities[0]
+	}
+	return capability
+}
+
+// validateSchemaLocked checks body and meta against the named schema agent
+// declares for capability. Messages for capabilities without that schema
+// pass.
+func (s *Store) validateSchemaLocked(agent *Agent, capability, which, body string, meta any) error {
+	if agent == nil {
+		return nil
+	}
+	capability = schemaCapability(agent, capability)
+	cs, ok := agent.Schemas[capability]
+	if !ok {
+		return nil
+	}
+	raw := cs.Request
+	if which == schemaResponse {
+		raw = cs.Response
+	}
+	if len(raw) == 0 {
+		return nil
+	}
+
+	sch, ok := s.schemaCache[string(raw)]
+	if !ok {
+		var err error
+		if sch, err = compileSchema(raw); err != nil {
+			return newError(CodeInternal, fmt.Sprintf("%s schema for capability %s: %v", which, capability, err), false, 0)
+		}
+		s.schemaCache[string(raw)] = sch
+	}
+
+	inst, err := schemaInstance(body, meta)
+	if err != nil {
+		return newError(CodeValidation, "meta: "+err.Error(), false, 0)
+	}
+	err = sch.Validate(inst)
+	if err == nil {
+		return nil
+	}
+	ve, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return newError(CodeValidation, err.Error(), false, 0)
+	}
+	leaf, field := schemaFailure(ve)
+	e := newError(CodeValidation, fmt.Sprintf("%s does not match the %s schema for capability %s: %s", which, which, capability, leaf.Error()), false, 0)
+	e.Field = field
+	return e
+}
+
+// schemaInstance builds the document a capability schema validates.
+func schemaInstance(body string, meta any) (any, error) {
+	var bodyValue any = body
+	if parsed, err := jsonschema.UnmarshalJSON(strings.NewReader(body)); err == nil {
+		bodyValue = parsed
+	}
+	blob, err := json.Marshal(map[string]any{"body": bodyValue, "meta": meta})
+	if err != nil {
+		return nil, err
+	}
+	return jsonschema.UnmarshalJSON(bytes.NewReader(blob))
+}
+
+// schemaFailure follows the first cause of a validation error down to the
+// failure that explains it, and returns it with a JSON pointer to the field
+// at fault. A missing required property is pointed at directly.
+func schemaFailure(ve *jsonschema.ValidationError) (*jsonschema.ValidationError, string) {
+	for len(ve.Causes) > 0 {
+		ve = ve.Causes[0]
+	}
+	loc := append([]string{}, ve.InstanceLocation...)
+	if req, ok := ve.ErrorKind.(*kind.Required); ok && len(req.Missing) > 0 {
+		loc = append(loc, req.Missing[0])
+	}
+	var sb strings.Builder
+	for _, tok := range loc {
+		sb.WriteByte('/')
+		sb.WriteString(strings.NewReplacer("~", "~0", "/", "~1").Replace(tok))
+	}
+	return ve, sb.String()
+}
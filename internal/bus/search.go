@@ -0,0 +1,471 @@
+package bus
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Search hit kinds.
+const (
+	SearchKindMessage      = "message"
+	SearchKindConversation = "conversation"
+)
+
+const (
+	searchLimitDefault = 20
+	searchLimitMax     = 100
+	// snippetTokens is the snippet window in tokens, matching the FTS5
+	// snippet() call in SQLiteStore.
+	snippetTokens = 12
+)
+
+// searchDoc is one indexed item: a message's body and meta, or a
+// conversation's title and meta, plus the fields search filters on.
+type searchDoc struct {
+	kind           string
+	id             string
+	conversationID string
+	msgType        MessageType
+	agents         []string
+	at             time.Time
+	text           string
+}
+
+func (d searchDoc) key() string {
+	return d.kind + ":" + d.id
+}
+
+func messageSearchDoc(m *Message) searchDoc {
+	text := m.Body
+	if meta := metaText(m.Meta); meta != "" {
+		text += "\n" + meta
+	}
+	return searchDoc{
+		kind:           SearchKindMessage,
+		id:             m.MessageID,
+		conversationID: m.ConversationID,
+		msgType:        m.Type,
+		agents:         append([]string{m.From, m.To}, m.Recipients...),
+		at:             m.CreatedAt,
+		text:           text,
+	}
+}
+
+func conversationSearchDoc(c *Conversation) searchDoc {
+	text := c.Title
+	if meta := metaText(c.Meta); meta != "" {
+		text += "\n" + meta
+	}
+	return searchDoc{
+		kind:           SearchKindConversation,
+		id:             c.ConversationID,
+		conversationID: c.ConversationID,
+		agents:         append([]string{}, c.Participants...),
+		at:             c.CreatedAt,
+		text:           text,
+	}
+}
+
+// metaText flattens the scalar values of meta into searchable text, so case
+// numbers and inventor names stored there can be found. Map keys are visited
+// in sorted order to keep the text stable.
+func metaText(meta any) string {
+	var parts []string
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case nil:
+		case string:
+			parts = append(parts, v)
+		case map[string]any:
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				walk(v[k])
+			}
+		case []any:
+			for _, item := range v {
+				walk(item)
+			}
+		default:
+			parts = append(parts, fmt.Sprint(v))
+		}
+	}
+	walk(meta)
+	return strings.Join(parts, "\n")
+}
+
+// searchToken is a run of letters and digits in indexed text. text is
+// lower-cased; start and end are byte offsets into the original.
+type searchToken struct {
+	text       string
+	start, end int
+}
+
+// tokenize splits text the way the FTS5 unicode61 tokenizer does: runs of
+// letters and digits, compared case-insensitively.
+func tokenize(text string) []searchToken {
+	var out []searchToken
+	start := -1
+	for i, r := range text {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r)
+		switch {
+		case word && start < 0:
+			start = i
+		case !word && start >= 0:
+			out = append(out, searchToken{text: strings.ToLower(text[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		out = append(out, searchToken{text: strings.ToLower(text[start:]), start: start, end: len(text)})
+	}
+	return out
+}
+
+// searchTerm is one word of a query. A trailing * on the word makes it a
+// prefix match.
+type searchTerm struct {
+	text   string
+	prefix bool
+}
+
+func (t searchTerm) matches(token string) bool {
+	if t.prefix {
+		return strings.HasPrefix(token, t.text)
+	}
+	return token == t.text
+}
+
+func parseSearchQuery(q string) []searchTerm {
+	var terms []searchTerm
+	for _, field := range strings.Fields(q) {
+		tokens := tokenize(field)
+		for i, tok := range tokens {
+			terms = append(terms, searchTerm{text: tok.text, prefix: i == len(tokens)-1 && strings.HasSuffix(field, "*")})
+		}
+	}
+	return terms
+}
+
+// ftsQuery renders terms as an FTS5 query: every term must match, each one
+// quoted so query syntax in user input is taken literally.
+func ftsQuery(terms []searchTerm) string {
+	parts := make([]string, 0, len(terms))
+	for _, t := range terms {
+		part := `"` + strings.ReplaceAll(t.text, `"`, `""`) + `"`
+		if t.prefix {
+			part += "*"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " ")
+}
+
+var snippetSpace = regexp.MustCompile(`\s+`)
+
+// makeSnippet returns the stretch of text around the first matching token,
+// with matches wrapped in ** and elided ends marked with an ellipsis, in the
+// same shape as FTS5's snippet().
+func makeSnippet(text string, terms []searchTerm) string {
+	tokens := tokenize(text)
+	if len(tokens) == 0 {
+		return ""
+	}
+	matches := func(tok searchToken) bool {
+		for _, t := range terms {
+			if t.matches(tok.text) {
+				return true
+			}
+		}
+		return false
+	}
+	first := 0
+	for i, tok := range tokens {
+		if matches(tok) {
+			first = i
+			break
+		}
+	}
+	from := first - snippetTokens/2
+	if from < 0 {
+		from = 0
+	}
+	to := from + snippetTokens
+	if to > len(tokens) {
+		to = len(tokens)
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	for i := from; i < to; i++ {
+		tok := tokens[i]
+		if i > from {
+			b.WriteString(snippetSpace.ReplaceAllString(text[tokens[i-1].end:tok.start], " "))
+		}
+		if matches(tok) {
+			b.WriteString("**" + text[tok.start:tok.end] + "**")
+		} else {
+			b.WriteString(text[tok.start:tok.end])
+		}
+	}
+	if to < len(tokens) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// prepareSearch validates input, applies the limit defaults and parses the
+// query into terms.
+func prepareSearch(input SearchInput) (SearchInput, []searchTerm, error) {
+	input.Kind = strings.TrimSpace(input.Kind)
+	input.AgentID = strings.TrimSpace(input.AgentID)
+	input.ConversationID = strings.TrimSpace(input.ConversationID)
+	terms := parseSearchQuery(input.Query)
+	if len(terms) == 0 {
+		return input, nil, newError(CodeValidation, "q must contain at least one word", false, 0)
+	}
+	switch input.Kind {
+	case "", SearchKindMessage, SearchKindConversation:
+	default:
+		return input, nil, newError(CodeValidation, "kind must be message or conversation", false, 0)
+	}
+	switch input.Type {
+	case "", MessageTypeRequest, MessageTypeResponse, MessageTypeInform:
+	default:
+		return input, nil, newError(CodeValidation, "type must be request, response, or inform", false, 0)
+	}
+	if input.Type != "" {
+		if input.Kind == SearchKindConversation {
+			return input, nil, newError(CodeValidation, "type only applies to message results", false, 0)
+		}
+		input.Kind = SearchKindMessage
+	}
+	if input.Limit < 0 {
+		return input, nil, newError(CodeValidation, "limit must be >= 0", false, 0)
+	}
+	if input.Limit == 0 {
+		input.Limit = searchLimitDefault
+	}
+	if input.Limit > searchLimitMax {
+		input.Limit = searchLimitMax
+	}
+	return input, terms, nil
+}
+
+func (in SearchInput) admits(doc searchDoc) bool {
+	if in.Kind != "" && doc.kind != in.Kind {
+		return false
+	}
+	if in.ConversationID != "" && doc.conversationID != in.ConversationID {
+		return false
+	}
+	if in.Type != "" && doc.msgType != in.Type {
+		return false
+	}
+	if in.AgentID != "" {
+		found := false
+		for _, a := range doc.agents {
+			if a == in.AgentID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return inTimeRange(doc.at, in.After, in.Before)
+}
+
+// searchIndex is the in-memory inverted index used by Store: token to
+// document key to the number of times the token occurs there.
+type searchIndex struct {
+	docs     map[string]searchDoc
+	postings map[string]map[string]int
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{docs: map[string]searchDoc{}, postings: map[string]map[string]int{}}
+}
+
+func (ix *searchIndex) put(doc searchDoc) {
+	key := doc.key()
+	ix.remove(key)
+	ix.docs[key] = doc
+	for _, tok := range tokenize(doc.text) {
+		docs := ix.postings[tok.text]
+		if docs == nil {
+			docs = map[string]int{}
+			ix.postings[tok.text] = docs
+		}
+		docs[key]++
+	}
+}
+
+func (ix *searchIndex) remove(key string) {
+	doc, ok := ix.docs[key]
+	if !ok {
+		return
+	}
+	delete(ix.docs, key)
+	for _, tok := range tokenize(doc.text) {
+		if docs := ix.postings[tok.text]; docs != nil {
+			delete(docs, key)
+			if len(docs) == 0 {
+				delete(ix.postings, tok.text)
+			}
+		}
+	}
+}
+
+// search returns the documents containing every term that pass the input's
+// filters, best first: more term occurrences, then newer, then by key.
+func (ix *searchIndex) search(input SearchInput, terms []searchTerm) []searchDoc {
+	var scores map[string]int
+	for _, term := range terms {
+		hits := map[string]int{}
+		if term.prefix {
+			for token, docs := range ix.postings {
+				if term.matches(token) {
+					for key, n := range docs {
+						hits[key] += n
+					}
+				}
+			}
+		} else {
+			for key, n := range ix.postings[term.text] {
+				hits[key] = n
+			}
+		}
+		if scores == nil {
+			scores = hits
+			continue
+		}
+		for key := range scores {
+			if n, ok := hits[key]; ok {
+				scores[key] += n
+			} else {
+				delete(scores, key)
+			}
+		}
+	}
+
+	out := []searchDoc{}
+	for key := range scores {
+		if doc := ix.docs[key]; input.admits(doc) {
+			out = append(out, doc)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		si, sj := scores[out[i].key()], scores[out[j].key()]
+		if si != sj {
+			return si > sj
+		}
+		if !out[i].at.Equal(out[j].at) {
+			return out[i].at.After(out[j].at)
+		}
+		return out[i].key() < out[j].key()
+	})
+	if len(out) > input.Limit {
+		out = out[:input.Limit]
+	}
+	return out
+}
+
+// indexMessageLocked and indexConversationLocked keep the in-memory search
+// index current. Backends with their own index leave s.search nil.
+func (s *Store) indexMessageLocked(m *Message) {
+	if s.search != nil {
+		s.search.put(messageSearchDoc(m))
+	}
+}
+
+func (s *Store) indexConversationLocked(c *Conversation) {
+	if s.search != nil {
+		s.search.put(conversationSearchDoc(c))
+	}
+}
+
+// reindexSearchLocked rebuilds the search index after state is loaded from
+// a backend.
+func (s *Store) reindexSearchLocked() {
+	if s.search == nil {
+		return
+	}
+	s.search = newSearchIndex()
+	for _, c := range s.conversations {
+		s.indexConversationLocked(c)
+	}
+	for _, m := range s.messages {
+		if m.ParentID == "" {
+			s.indexMessageLocked(m)
+		}
+	}
+}
+
+// searchHitLocked builds the result for an indexed document, or reports false
+// if it no longer exists.
+func (s *Store) searchHitLocked(kind, id, snippet string) (SearchHit, bool) {
+	hit := SearchHit{Kind: kind, Snippet: snippet}
+	switch kind {
+	case SearchKindMessage:
+		m, ok := s.messages[id]
+		if !ok {
+			return hit, false
+		}
+		hit.MessageID = m.MessageID
+		hit.ConversationID = m.ConversationID
+		hit.Type = m.Type
+		hit.From = m.From
+		hit.To = m.To
+		hit.CreatedAt = m.CreatedAt
+	case SearchKindConversation:
+		c, ok := s.conversations[id]
+		if !ok {
+			return hit, false
+		}
+		hit.ConversationID = c.ConversationID
+		hit.CreatedAt = c.CreatedAt
+	default:
+		return hit, false
+	}
+	if c, ok := s.conversations[hit.ConversationID]; ok {
+		hit.Title = c.Title
+	}
+	return hit, true
+}
+
+// Search finds messages and conversations whose text contains every word of
+// the query.
+func (s *Store) Search(input SearchInput) ([]SearchHit, error) {
+	now := s.now()
+	input, terms, err := prepareSearch(input)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweepLocked(now)
+
+	if s.search == nil {
+		return nil, newError(CodeUnavailable, "search index is not available", false, 0)
+	}
+	out := []SearchHit{}
+	for _, doc := range s.search.search(input, terms) {
+		if hit, ok := s.searchHitLocked(doc.kind, doc.id, makeSnippet(doc.text, terms)); ok {
+			out = append(out, hit)
+		}
+	}
+	return out, nil
+}
@@ -33,7 +33,10 @@ CREATE TABLE IF NOT EXISTS agents (
 	status        TEXT NOT NULL DEFAULT 'active',
 	registered_at TEXT NOT NULL,
 	expires_at    TEXT NOT NULL,
-	ttl_seconds   INTEGER NOT NULL DEFAULT 60
+	ttl_seconds   INTEGER NOT NULL DEFAULT 60,
+	schemas       TEXT NOT NULL DEFAULT '{}',
+	metadata      TEXT NOT NULL DEFAULT '{}',
+	health        TEXT
 );
 
 CREATE TABLE IF NOT EXISTS conversations (
@@ -44,7 +47,10 @@ CREATE TABLE IF NOT EXISTS conversations (
 	message_count   INTEGER NOT NULL DEFAULT 0,
 	created_at      TEXT NOT NULL,
 	last_message_at TEXT NOT NULL,
-	meta            TEXT
+	meta            TEXT,
+	status_changed_at TEXT NOT NULL DEFAULT '',
+	status_reason     TEXT NOT NULL DEFAULT '',
+	tags              TEXT NOT NULL DEFAULT '[]'
 );
 
 CREATE TABLE IF NOT EXISTS messages (
@@ -64,7 +70,15 @@ CREATE TABLE IF NOT EXISTS messages (
 	last_progress_at TEXT NOT NULL DEFAULT '',
 	ttl_expires_at   TEXT NOT NULL DEFAULT '',
 	grace_until      TEXT NOT NULL DEFAULT '',
-	queued_for_agent INTEGER NOT NULL DEFAULT 0
+	queued_for_agent INTEGER NOT NULL DEFAULT 0,
+	trace_parent     TEXT NOT NULL DEFAULT '',
+	result           TEXT,
+	capability       TEXT NOT NULL DEFAULT '',
+	held_for_capacity INTEGER NOT NULL DEFAULT 0,
+	priority         TEXT NOT NULL DEFAULT '',
+	deliver_at       TEXT NOT NULL DEFAULT '',
+	parent_id        TEXT NOT NULL DEFAULT '',
+	recipients       TEXT
 );
 
 CREATE TABLE IF NOT EXISTS conversation_messages (
@@ -74,12 +88,96 @@ CREATE TABLE IF NOT EXISTS conversation_messages (
 	PRIMARY KEY (conversation_id, position)
 );
 
+CREATE TABLE IF NOT EXISTS message_transitions (
+	message_id TEXT NOT NULL,
+	seq        INTEGER NOT NULL,
+	from_state TEXT NOT NULL,
+	to_state   TEXT NOT NULL,
+	at         TEXT NOT NULL,
+	actor      TEXT NOT NULL DEFAULT '',
+	reason     TEXT NOT NULL DEFAULT '',
+	body       TEXT NOT NULL DEFAULT '',
+	meta       TEXT,
+	PRIMARY KEY (message_id, seq)
+);
+
+CREATE TABLE IF NOT EXISTS conversation_events (
+	conversation_id TEXT NOT NULL,
+	seq             INTEGER NOT NULL,
+	type            TEXT NOT NULL,
+	at              TEXT NOT NULL,
+	actor           TEXT NOT NULL DEFAULT '',
+	message_id      TEXT NOT NULL DEFAULT '',
+	from_status     TEXT NOT NULL DEFAULT '',
+	to_status       TEXT NOT NULL DEFAULT '',
+	reason          TEXT NOT NULL DEFAULT '',
+	changed         TEXT NOT NULL DEFAULT '[]',
+	body            TEXT NOT NULL DEFAULT '',
+	meta            TEXT,
+	PRIMARY KEY (conversation_id, seq)
+);
+
+-- search_docs holds the filterable fields of each search document; its id
+-- is the rowid of the document's text in the search_text FTS5 table.
+CREATE TABLE IF NOT EXISTS search_docs (
+	id              INTEGER PRIMARY KEY,
+	kind            TEXT NOT NULL,
+	doc_id          TEXT NOT NULL,
+	conversation_id TEXT NOT NULL DEFAULT '',
+	type            TEXT NOT NULL DEFAULT '',
+	agents          TEXT NOT NULL DEFAULT '',
+	at_ns           INTEGER NOT NULL DEFAULT 0,
+	UNIQUE (kind, doc_id)
+);
+
+CREATE VIRTUAL TABLE IF NOT EXISTS search_text USING fts5(text);
+
 CREATE TABLE IF NOT EXISTS counters (
 	key   TEXT PRIMARY KEY,
 	value INTEGER NOT NULL DEFAULT 0
 );
 `
 
+// sqliteColumnMigrations adds columns introduced after the initial schema to
+// databases created by older builds. CREATE TABLE IF NOT EXISTS leaves
+// existing tables untouched, so each new column must also be listed here.
+var sqliteColumnMigrations = []struct {
+	table  string
+	column string
+	decl   string
+}{
+	{"messages", "trace_parent", "TEXT NOT NULL DEFAULT ''"},
+	{"messages", "result", "TEXT"},
+	{"conversations", "status_changed_at", "TEXT NOT NULL DEFAULT ''"},
+	{"conversations", "status_reason", "TEXT NOT NULL DEFAULT ''"},
+	{"conversations", "tags", "TEXT NOT NULL DEFAULT '[]'"},
+	{"agents", "schemas", "TEXT NOT NULL DEFAULT '{}'"},
+	{"messages", "capability", "TEXT NOT NULL DEFAULT ''"},
+	{"agents", "metadata", "TEXT NOT NULL DEFAULT '{}'"},
+	{"agents", "health", "TEXT"},
+	{"messages", "held_for_capacity", "INTEGER NOT NULL DEFAULT 0"},
+	{"messages", "priority", "TEXT NOT NULL DEFAULT ''"},
+	{"messages", "deliver_at", "TEXT NOT NULL DEFAULT ''"},
+	{"messages", "parent_id", "TEXT NOT NULL DEFAULT ''"},
+	{"messages", "recipients", "TEXT"},
+}
+
+func migrateSQLiteColumns(db *sqlx.DB) error {
+	for _, m := range sqliteColumnMigrations {
+		var count int
+		if err := db.Get(&count, "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", m.table, m.column); err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", m.table, m.column, m.decl)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func NewSQLiteStore(dbPath string, cfg Config) (*SQLiteStore, error) {
 	db, err := sqlx.Open("sqlite", dbPath+"?_pragma=journal_mode(wal)&_pragma=busy_timeout(5000)")
 	if err != nil {
@@ -91,8 +189,15 @@ func NewSQLiteStore(dbPath string, cfg Config) (*SQLiteStore, error) {
 		db.Close()
 		return nil, fmt.Errorf("create schema: %w", err)
 	}
+	if err := migrateSQLiteColumns(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrate schema: %w", err)
+	}
 
 	inner := NewStore(cfg)
+	inner.trackChanges = true
+	// Search runs against the FTS5 tables instead of the in-memory index.
+	inner.search = nil
 	s := &SQLiteStore{
 		inner: inner,
 		db:    db,
@@ -128,7 +233,16 @@ func (s *SQLiteStore) loadAll() error {
 	if err := s.loadConversationMessages(); err != nil {
 		return err
 	}
-	return nil
+	if err := s.loadTransitions(); err != nil {
+		return err
+	}
+	if err := s.loadConversationEvents(); err != nil {
+		return err
+	}
+	s.inner.reindexRepliesLocked()
+	s.inner.reindexChildrenLocked()
+	s.inner.reindexBlobsLocked()
+	return s.backfillSearch()
 }
 
 func (s *SQLiteStore) loadCounters() error {
@@ -154,18 +268,24 @@ func (s *SQLiteStore) loadCounters() error {
 }
 
 func (s *SQLiteStore) loadAgents() error {
-	rows, err := s.db.Query("SELECT agent_id, capabilities, description, mode, callback_url, status, registered_at, expires_at, ttl_seconds FROM agents")
+	rows, err := s.db.Query("SELECT agent_id, capabilities, description, mode, callback_url, status, registered_at, expires_at, ttl_seconds, schemas, metadata, health FROM agents")
 	if err != nil {
 		return err
 	}
 	defer rows.Close()
 	for rows.Next() {
 		var a Agent
-		var capsJSON, registeredAt, expiresAt string
-		if err := rows.Scan(&a.AgentID, &capsJSON, &a.Description, &a.Mode, &a.CallbackURL, &a.Status, &registeredAt, &expiresAt, &a.TTLSeconds); err != nil {
+		var capsJSON, registeredAt, expiresAt, schemasJSON, metadataJSON string
+		var healthJSON sql.NullString
+		if err := rows.Scan(&a.AgentID, &capsJSON, &a.Description, &a.Mode, &a.CallbackURL, &a.Status, &registeredAt, &expiresAt, &a.TTLSeconds, &schemasJSON, &metadataJSON, &healthJSON); err != nil {
 			return err
 		}
 		_ = json.Unmarshal([]byte(capsJSON), &a.Capabilities)
+		_ = json.Unmarshal([]byte(schemasJSON), &a.Schemas)
+		_ = json.Unmarshal([]byte(metadataJSON), &a.Metadata)
+		if healthJSON.Valid {
+			_ = json.Unmarshal([]byte(healthJSON.String), &a.Health)
+		}
 		a.RegisteredAt, _ = time.Parse(time.RFC3339Nano, registeredAt)
 		a.ExpiresAt, _ = time.Parse(time.RFC3339Nano, expiresAt)
 		s.inner.agents[a.AgentID] = &a
@@ -178,19 +298,26 @@ func (s *SQLiteStore) loadAgents() error {
 }
 
 func (s *SQLiteStore) loadConversations() error {
-	rows, err := s.db.Query("SELECT conversation_id, title, participants, status, message_count, created_at, last_message_at, meta FROM conversations")
+	rows, err := s.db.Query(`SELECT conversation_id, title, participants, status, message_count, created_at, last_message_at, meta,
+		status_changed_at, status_reason, tags FROM conversations`)
 	if err != nil {
 		return err
 	}
 	defer rows.Close()
 	for rows.Next() {
 		var c Conversation
-		var participantsJSON, createdAt, lastMessageAt string
+		var participantsJSON, createdAt, lastMessageAt, statusChangedAt, tagsJSON string
 		var metaJSON sql.NullString
-		if err := rows.Scan(&c.ConversationID, &c.Title, &participantsJSON, &c.Status, &c.MessageCount, &createdAt, &lastMessageAt, &metaJSON); err != nil {
+		if err := rows.Scan(&c.ConversationID, &c.Title, &participantsJSON, &c.Status, &c.MessageCount, &createdAt, &lastMessageAt, &metaJSON,
+			&statusChangedAt, &c.StatusReason, &tagsJSON); err != nil {
 			return err
 		}
+		if statusChangedAt != "" {
+			t, _ := time.Parse(time.RFC3339Nano, statusChangedAt)
+			c.StatusChangedAt = &t
+		}
 		_ = json.Unmarshal([]byte(participantsJSON), &c.Participants)
+		_ = json.Unmarshal([]byte(tagsJSON), &c.Tags)
 		c.CreatedAt, _ = time.Parse(time.RFC3339Nano, createdAt)
 		c.LastMessageAt, _ = time.Parse(time.RFC3339Nano, lastMessageAt)
 		if metaJSON.Valid && metaJSON.String != "" {
@@ -204,7 +331,7 @@ func (s *SQLiteStore) loadConversations() error {
 func (s *SQLiteStore) loadMessages() error {
 	rows, err := s.db.Query(`SELECT message_id, type, from_agent, to_agent, conversation_id,
 		request_id, in_reply_to, body, meta, attachments, state,
-		created_at, delivered_at, last_progress_at, ttl_expires_at, grace_until, queued_for_agent
+		created_at, delivered_at, last_progress_at, ttl_expires_at, grace_until, queued_for_agent, trace_parent, result, capability, held_for_capacity, priority, deliver_at, parent_id, recipients
 		FROM messages`)
 	if err != nil {
 		return err
@@ -212,19 +339,26 @@ func (s *SQLiteStore) loadMessages() error {
 	defer rows.Close()
 	for rows.Next() {
 		var m Message
-		var metaJSON sql.NullString
+		var metaJSON, resultJSON, recipientsJSON sql.NullString
 		var attachmentsJSON string
-		var createdAt, deliveredAt, lastProgressAt, ttlExpiresAt, graceUntil string
-		var queued int
+		var createdAt, deliveredAt, lastProgressAt, ttlExpiresAt, graceUntil, deliverAt string
+		var queued, held int
 		if err := rows.Scan(&m.MessageID, &m.Type, &m.From, &m.To, &m.ConversationID,
 			&m.RequestID, &m.InReplyTo, &m.Body, &metaJSON, &attachmentsJSON, &m.State,
-			&createdAt, &deliveredAt, &lastProgressAt, &ttlExpiresAt, &graceUntil, &queued); err != nil {
+			&createdAt, &deliveredAt, &lastProgressAt, &ttlExpiresAt, &graceUntil, &queued, &m.TraceParent, &resultJSON, &m.Capability, &held, &m.Priority, &deliverAt, &m.ParentID, &recipientsJSON); err != nil {
 			return err
 		}
 		if metaJSON.Valid && metaJSON.String != "" {
 			_ = json.Unmarshal([]byte(metaJSON.String), &m.Meta)
 		}
 		_ = json.Unmarshal([]byte(attachmentsJSON), &m.Attachments)
+		if recipientsJSON.Valid && recipientsJSON.String != "" {
+			_ = json.Unmarshal([]byte(recipientsJSON.String), &m.Recipients)
+		}
+		if resultJSON.Valid && resultJSON.String != "" {
+			m.Result = &Result{}
+			_ = json.Unmarshal([]byte(resultJSON.String), m.Result)
+		}
 		m.CreatedAt, _ = time.Parse(time.RFC3339Nano, createdAt)
 		if deliveredAt != "" {
 			m.DeliveredAt, _ = time.Parse(time.RFC3339Nano, deliveredAt)
@@ -238,7 +372,13 @@ func (s *SQLiteStore) loadMessages() error {
 		if graceUntil != "" {
 			m.GraceUntil, _ = time.Parse(time.RFC3339Nano, graceUntil)
 		}
+		if deliverAt != "" {
+			if t, err := time.Parse(time.RFC3339Nano, deliverAt); err == nil {
+				m.DeliverAt = &t
+			}
+		}
 		m.QueuedForAgent = queued != 0
+		m.HeldForCapacity = held != 0
 		s.inner.messages[m.MessageID] = &m
 	}
 	return rows.Err()
@@ -260,6 +400,53 @@ func (s *SQLiteStore) loadConversationMessages() error {
 	return rows.Err()
 }
 
+func (s *SQLiteStore) loadTransitions() error {
+	rows, err := s.db.Query(`SELECT message_id, from_state, to_state, at, actor, reason, body, meta
+		FROM message_transitions ORDER BY message_id, seq`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var mid, at string
+		var t StateTransition
+		var metaJSON sql.NullString
+		if err := rows.Scan(&mid, &t.From, &t.To, &at, &t.Actor, &t.Reason, &t.Body, &metaJSON); err != nil {
+			return err
+		}
+		t.At, _ = time.Parse(time.RFC3339Nano, at)
+		if metaJSON.Valid && metaJSON.String != "" {
+			_ = json.Unmarshal([]byte(metaJSON.String), &t.Meta)
+		}
+		s.inner.stateHistory[mid] = append(s.inner.stateHistory[mid], t)
+	}
+	return rows.Err()
+}
+
+func (s *SQLiteStore) loadConversationEvents() error {
+	rows, err := s.db.Query(`SELECT conversation_id, type, at, actor, message_id, from_status, to_status, reason, changed, body, meta
+		FROM conversation_events ORDER BY conversation_id, seq`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid, at, changedJSON string
+		var e ConversationEvent
+		var metaJSON sql.NullString
+		if err := rows.Scan(&cid, &e.Type, &at, &e.Actor, &e.MessageID, &e.FromStatus, &e.ToStatus, &e.Reason, &changedJSON, &e.Body, &metaJSON); err != nil {
+			return err
+		}
+		e.At, _ = time.Parse(time.RFC3339Nano, at)
+		_ = json.Unmarshal([]byte(changedJSON), &e.Changed)
+		if metaJSON.Valid && metaJSON.String != "" {
+			_ = json.Unmarshal([]byte(metaJSON.String), &e.Meta)
+		}
+		s.inner.conversationHistory[cid] = append(s.inner.conversationHistory[cid], e)
+	}
+	return rows.Err()
+}
+
 // --- persist helpers ---
 
 func timeToString(t time.Time) string {
@@ -269,6 +456,13 @@ func timeToString(t time.Time) string {
 	return t.UTC().Format(time.RFC3339Nano)
 }
 
+func timePtrToString(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return timeToString(*t)
+}
+
 func marshalJSON(v any) string {
 	if v == nil {
 		return ""
@@ -291,9 +485,9 @@ func nullableJSON(v any) sql.NullString {
 	return sql.NullString{String: string(b), Valid: true}
 }
 
-func (s *SQLiteStore) saveAgent(a *Agent) error {
-	_, err := s.db.Exec(`INSERT OR REPLACE INTO agents (agent_id, capabilities, description, mode, callback_url, status, registered_at, expires_at, ttl_seconds)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+func (s *SQLiteStore) saveAgent(ex sqlExecer, a *Agent) error {
+	_, err := ex.Exec(`INSERT OR REPLACE INTO agents (agent_id, capabilities, description, mode, callback_url, status, registered_at, expires_at, ttl_seconds, schemas, metadata, health)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		a.AgentID,
 		marshalJSON(a.Capabilities),
 		a.Description,
@@ -303,13 +497,21 @@ func (s *SQLiteStore) saveAgent(a *Agent) error {
 		timeToString(a.RegisteredAt),
 		timeToString(a.ExpiresAt),
 		a.TTLSeconds,
+		marshalJSON(a.Schemas),
+		marshalJSON(a.Metadata),
+		nullableHealth(a.Health),
 	)
 	return err
 }
 
-func (s *SQLiteStore) saveConversation(c *Conversation) error {
-	_, err := s.db.Exec(`INSERT OR REPLACE INTO conversations (conversation_id, title, participants, status, message_count, created_at, last_message_at, meta)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+func (s *SQLiteStore) saveConversation(ex sqlExecer, c *Conversation) error {
+	var statusChangedAt string
+	if c.StatusChangedAt != nil {
+		statusChangedAt = timeToString(*c.StatusChangedAt)
+	}
+	_, err := ex.Exec(`INSERT OR REPLACE INTO conversations (conversation_id, title, participants, status, message_count, created_at, last_message_at, meta,
+		status_changed_at, status_reason, tags)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		c.ConversationID,
 		c.Title,
 		marshalJSON(c.Participants),
@@ -318,15 +520,21 @@ func (s *SQLiteStore) saveConversation(c *Conversation) error {
 		timeToString(c.CreatedAt),
 		timeToString(c.LastMessageAt),
 		nullableJSON(c.Meta),
+		statusChangedAt,
+		c.StatusReason,
+		marshalJSON(c.Tags),
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	return saveSearchDoc(ex, conversationSearchDoc(c))
 }
 
-func (s *SQLiteStore) saveMessage(m *Message) error {
-	_, err := s.db.Exec(`INSERT OR REPLACE INTO messages (message_id, type, from_agent, to_agent, conversation_id,
+func (s *SQLiteStore) saveMessage(ex sqlExecer, m *Message) error {
+	_, err := ex.Exec(`INSERT OR REPLACE INTO messages (message_id, type, from_agent, to_agent, conversation_id,
 		request_id, in_reply_to, body, meta, attachments, state,
-		created_at, delivered_at, last_progress_at, ttl_expires_at, grace_until, queued_for_agent)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		created_at, delivered_at, last_progress_at, ttl_expires_at, grace_until, queued_for_agent, trace_parent, result, capability, held_for_capacity, priority, deliver_at, parent_id, recipients)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		m.MessageID,
 		string(m.Type),
 		m.From,
@@ -344,10 +552,32 @@ func (s *SQLiteStore) saveMessage(m *Message) error {
 		timeToString(m.TTLExpiresAt),
 		timeToString(m.GraceUntil),
 		boolToInt(m.QueuedForAgent),
+		m.TraceParent,
+		nullableResult(m.Result),
+		m.Capability,
+		boolToInt(m.HeldForCapacity),
+		string(m.Priority),
+		timePtrToString(m.DeliverAt),
+		m.ParentID,
+		nullableJSON(m.Recipients),
 	)
 	return err
 }
 
+func nullableResult(r *Result) sql.NullString {
+	if r == nil {
+		return sql.NullString{}
+	}
+	return nullableJSON(r)
+}
+
+func nullableHealth(h *AgentHealth) sql.NullString {
+	if h == nil {
+		return sql.NullString{}
+	}
+	return nullableJSON(h)
+}
+
 func boolToInt(b bool) int {
 	if b {
 		return 1
@@ -355,63 +585,182 @@ func boolToInt(b bool) int {
 	return 0
 }
 
-func (s *SQLiteStore) saveConversationMessage(cid, mid string, position int) error {
-	_, err := s.db.Exec(`INSERT OR REPLACE INTO conversation_messages (conversation_id, message_id, position) VALUES (?, ?, ?)`,
+func (s *SQLiteStore) saveConversationMessage(ex sqlExecer, cid, mid string, position int) error {
+	_, err := ex.Exec(`INSERT OR REPLACE INTO conversation_messages (conversation_id, message_id, position) VALUES (?, ?, ?)`,
 		cid, mid, position)
 	return err
 }
 
-func (s *SQLiteStore) saveCounters() error {
+func (s *SQLiteStore) saveCounters(ex sqlExecer) error {
 	s.inner.mu.Lock()
 	nextConv := s.inner.nextConversationID
 	nextMsg := s.inner.nextMessageID
 	s.inner.mu.Unlock()
 
-	_, err := s.db.Exec(`INSERT OR REPLACE INTO counters (key, value) VALUES ('next_conversation_id', ?), ('next_message_id', ?)`,
+	_, err := ex.Exec(`INSERT OR REPLACE INTO counters (key, value) VALUES ('next_conversation_id', ?), ('next_message_id', ?)`,
 		nextConv, nextMsg)
 	return err
 }
 
+// sqlExecer is satisfied by both *sqlx.DB and *sqlx.Tx so the save helpers
+// can run standalone or inside a batch transaction.
+type sqlExecer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
 // persistAfterSend persists new message, conversation, and counters after SendMessage.
 func (s *SQLiteStore) persistAfterSend(m *Message) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if err := s.saveSent(s.db, m); err != nil {
+		return err
+	}
+	return s.saveCounters(s.db)
+}
+
+// persistAfterSendBatch persists every message of a batch in one transaction.
+func (s *SQLiteStore) persistAfterSendBatch(msgs []*Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	for _, m := range msgs {
+		if err := s.saveSent(tx, m); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	if err := s.saveCounters(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) saveSent(ex sqlExecer, m *Message) error {
 	s.inner.mu.Lock()
-	conv := s.inner.conversations[m.ConversationID]
+	var conv Conversation
+	convOK := false
+	if c := s.inner.conversations[m.ConversationID]; c != nil {
+		conv, convOK = *c, true
+	}
+	position := -1
 	convMsgs := s.inner.conversationMessages[m.ConversationID]
+	for i := len(convMsgs) - 1; i >= 0; i-- {
+		if convMsgs[i] == m.MessageID {
+			position = i
+			break
+		}
+	}
 	s.inner.mu.Unlock()
 
-	if conv != nil {
-		if err := s.saveConversation(conv); err != nil {
+	if convOK {
+		if err := s.saveConversation(ex, &conv); err != nil {
 			return err
 		}
 	}
-	if err := s.saveMessage(m); err != nil {
+	if err := s.saveMessage(ex, m); err != nil {
 		return err
 	}
-	position := len(convMsgs) - 1
-	if err := s.saveConversationMessage(m.ConversationID, m.MessageID, position); err != nil {
+	if err := saveSearchDoc(ex, messageSearchDoc(m)); err != nil {
 		return err
 	}
-	return s.saveCounters()
+	return s.saveConversationMessage(ex, m.ConversationID, m.MessageID, position)
 }
 
-// persistMessageState saves just the message row (state change after ack/event).
+// persistMessageState saves the message row (progress timestamps after an
+// event) together with every message and conversation whose state has moved
+// since the last save, including changes made by the sweep.
 func (s *SQLiteStore) persistMessageState(messageID string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	s.inner.mu.Lock()
-	m, ok := s.inner.messages[messageID]
-	if !ok {
-		s.inner.mu.Unlock()
-		return nil
+	var cp *Message
+	if m, ok := s.inner.messages[messageID]; ok {
+		c := *m
+		cp = &c
 	}
-	cp := *m
+	changes := s.inner.takeChangesLocked()
 	s.inner.mu.Unlock()
 
-	return s.saveMessage(&cp)
+	if cp != nil {
+		if err := s.saveMessage(s.db, cp); err != nil {
+			return err
+		}
+	}
+	return s.saveChanges(changes)
+}
+
+// persistChanges saves messages and conversations whose state moved since
+// the last save.
+func (s *SQLiteStore) persistChanges() error {
+	return s.persistMessageState("")
+}
+
+func (s *SQLiteStore) saveChanges(changes storeChanges) error {
+	if len(changes.messages) == 0 && len(changes.conversations) == 0 {
+		return nil
+	}
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	for i := range changes.messages {
+		m := &changes.messages[i]
+		if err := s.saveMessage(tx, m); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		if err := s.saveTransitions(tx, m.MessageID, changes.history[m.MessageID]); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	for i := range changes.conversations {
+		c := &changes.conversations[i]
+		if err := s.saveConversation(tx, c); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		if err := s.saveConversationEvents(tx, c.ConversationID, changes.conversationHistory[c.ConversationID]); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) saveTransitions(ex sqlExecer, messageID string, history []StateTransition) error {
+	for i, t := range history {
+		_, err := ex.Exec(`INSERT OR REPLACE INTO message_transitions (message_id, seq, from_state, to_state, at, actor, reason, body, meta)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			messageID, i, string(t.From), string(t.To), timeToString(t.At), t.Actor, t.Reason, t.Body, nullableJSON(t.Meta))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteStore) saveConversationEvents(ex sqlExecer, conversationID string, history []ConversationEvent) error {
+	for i, e := range history {
+		changed := e.Changed
+		if changed == nil {
+			changed = []string{}
+		}
+		_, err := ex.Exec(`INSERT OR REPLACE INTO conversation_events (conversation_id, seq, type, at, actor, message_id, from_status, to_status, reason, changed, body, meta)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			conversationID, i, string(e.Type), timeToString(e.At), e.Actor, e.MessageID, e.FromStatus, e.ToStatus, e.Reason, marshalJSON(changed), e.Body, nullableJSON(e.Meta))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // --- bus.API implementation ---
@@ -423,14 +772,43 @@ func (s *SQLiteStore) RegisterAgent(input RegisterAgentInput) (*Agent, error) {
 	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if perr := s.saveAgent(out); perr != nil {
+	if perr := s.saveAgent(s.db, out); perr != nil {
+		return nil, perr
+	}
+	return out, nil
+}
+
+func (s *SQLiteStore) Heartbeat(input HeartbeatInput) (*Agent, error) {
+	out, err := s.inner.Heartbeat(input)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if perr := s.saveAgent(s.db, out); perr != nil {
+		return nil, perr
+	}
+	return out, nil
+}
+
+func (s *SQLiteStore) DeregisterAgent(input DeregisterAgentInput) (*DeregisterResult, error) {
+	out, err := s.inner.DeregisterAgent(input)
+	if err != nil {
+		return nil, err
+	}
+	if perr := s.persistChanges(); perr != nil {
+		return nil, perr
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if perr := s.saveAgent(s.db, &out.Agent); perr != nil {
 		return nil, perr
 	}
 	return out, nil
 }
 
-func (s *SQLiteStore) ListAgents(capability string) []Agent {
-	return s.inner.ListAgents(capability)
+func (s *SQLiteStore) ListAgents(filter ListAgentsFilter) ([]Agent, string, error) {
+	return s.inner.ListAgents(filter)
 }
 
 func (s *SQLiteStore) CreateConversation(input CreateConversationInput) (*Conversation, error) {
@@ -440,21 +818,24 @@ func (s *SQLiteStore) CreateConversation(input CreateConversationInput) (*Conver
 	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if perr := s.saveConversation(out); perr != nil {
+	if perr := s.saveConversation(s.db, out); perr != nil {
 		return nil, perr
 	}
-	if perr := s.saveCounters(); perr != nil {
+	if perr := s.saveCounters(s.db); perr != nil {
 		return nil, perr
 	}
 	return out, nil
 }
 
-func (s *SQLiteStore) ListConversations(filter ListConversationsFilter) []Conversation {
-	return s.inner.ListConversations(filter)
+func (s *SQLiteStore) ListConversations(filter ListConversationsFilter) ([]Conversation, string, error) {
+	out, next, err := s.inner.ListConversations(filter)
+	// The sweep may have closed idle conversations.
+	_ = s.persistChanges()
+	return out, next, err
 }
 
 func (s *SQLiteStore) SendMessage(input SendMessageInput) (*Message, bool, error) {
-	m, dup, err := s.inner.SendMessage(input)
+	m, dup, err := s.inner.sendMessage(input)
 	if err != nil {
 		return nil, false, err
 	}
@@ -463,11 +844,124 @@ func (s *SQLiteStore) SendMessage(input SendMessageInput) (*Message, bool, error
 			return nil, false, perr
 		}
 	}
-	return m, dup, nil
+	if perr := s.persistChanges(); perr != nil {
+		return nil, false, perr
+	}
+	return s.inner.awaitResult(m, input.Wait), dup, nil
+}
+
+func (s *SQLiteStore) Call(input SendMessageInput) (*CallResult, error) {
+	m, dup, err := s.inner.sendCall(input)
+	if err != nil {
+		return nil, err
+	}
+	if !dup {
+		if perr := s.persistAfterSend(m); perr != nil {
+			return nil, perr
+		}
+	}
+	if perr := s.persistChanges(); perr != nil {
+		return nil, perr
+	}
+	return s.inner.awaitCall(m, dup, input.Wait), nil
+}
+
+func (s *SQLiteStore) SendMessages(input SendMessagesInput) ([]SendMessageResult, error) {
+	results, err := s.inner.SendMessages(input)
+	if err != nil {
+		return results, err
+	}
+	var sent []*Message
+	for _, r := range results {
+		if r.Message != nil && !r.Duplicate {
+			sent = append(sent, r.Message)
+		}
+	}
+	if len(sent) > 0 {
+		if perr := s.persistAfterSendBatch(sent); perr != nil {
+			return nil, perr
+		}
+	}
+	if perr := s.persistChanges(); perr != nil {
+		return nil, perr
+	}
+	return results, nil
+}
+
+func (s *SQLiteStore) GetMessage(messageID string) (*MessageDetail, error) {
+	out, err := s.inner.GetMessage(messageID)
+	// A lookup may be what trips a timeout in the sweep; save it so the
+	// history survives a restart.
+	_ = s.persistChanges()
+	return out, err
+}
+
+func (s *SQLiteStore) ListScheduled(agentID string) ([]Message, error) {
+	out, err := s.inner.ListScheduled(agentID)
+	_ = s.persistChanges()
+	return out, err
+}
+
+func (s *SQLiteStore) CancelScheduled(input CancelScheduledInput) (*Message, error) {
+	out, err := s.inner.CancelScheduled(input)
+	if err != nil {
+		return nil, err
+	}
+	if perr := s.persistChanges(); perr != nil {
+		return nil, perr
+	}
+	return out, nil
+}
+
+func (s *SQLiteStore) DeliverDue() int {
+	n := s.inner.DeliverDue()
+	_ = s.persistChanges()
+	return n
+}
+
+func (s *SQLiteStore) GetAgent(agentID string) (*Agent, error) {
+	out, err := s.inner.GetAgent(agentID)
+	return out, err
+}
+
+func (s *SQLiteStore) GetConversation(conversationID string) (*Conversation, error) {
+	out, err := s.inner.GetConversation(conversationID)
+	_ = s.persistChanges()
+	return out, err
+}
+
+func (s *SQLiteStore) ConversationHistory(conversationID string) ([]ConversationEvent, error) {
+	out, err := s.inner.ConversationHistory(conversationID)
+	_ = s.persistChanges()
+	return out, err
+}
+
+func (s *SQLiteStore) SetConversationStatus(input ConversationStatusInput) (*Conversation, error) {
+	out, err := s.inner.SetConversationStatus(input)
+	if err != nil {
+		return nil, err
+	}
+	if perr := s.persistChanges(); perr != nil {
+		return nil, perr
+	}
+	return out, nil
+}
+
+func (s *SQLiteStore) UpdateConversation(input UpdateConversationInput) (*Conversation, error) {
+	out, err := s.inner.UpdateConversation(input)
+	if err != nil {
+		return nil, err
+	}
+	if perr := s.persistChanges(); perr != nil {
+		return nil, perr
+	}
+	return out, nil
 }
 
 func (s *SQLiteStore) PollInbox(input PollInboxInput) ([]InboxEvent, int, error) {
-	return s.inner.PollInbox(input)
+	events, cursor, err := s.inner.PollInbox(input)
+	_ = s.persistChanges()
+	return events, cursor, err
 }
 
 func (s *SQLiteStore) Ack(input AckInput) error {
@@ -502,6 +996,9 @@ func (s *SQLiteStore) Inject(input InjectInput) (*Message, error) {
 	if perr := s.persistAfterSend(m); perr != nil {
 		return nil, perr
 	}
+	if perr := s.persistChanges(); perr != nil {
+		return nil, perr
+	}
 	return m, nil
 }
 
@@ -509,6 +1006,18 @@ func (s *SQLiteStore) ListConversationMessages(input ListConversationMessagesInp
 	return s.inner.ListConversationMessages(input)
 }
 
+func (s *SQLiteStore) Search(input SearchInput) ([]SearchHit, error) {
+	return s.search(input)
+}
+
+func (s *SQLiteStore) BlobReferences(sha string) BlobReferences {
+	return s.inner.BlobReferences(sha)
+}
+
+func (s *SQLiteStore) ReferencedBlobs() map[string]int {
+	return s.inner.ReferencedBlobs()
+}
+
 func (s *SQLiteStore) ObserveSince(afterID int64, filter ObserveFilter, wait time.Duration) ([]ObserveEvent, int64) {
 	return s.inner.ObserveSince(afterID, filter, wait)
 }
@@ -521,6 +1030,10 @@ func (s *SQLiteStore) SystemStatus() map[string]any {
 	return s.inner.SystemStatus()
 }
 
+func (s *SQLiteStore) MessageTraceParent(messageID string) string {
+	return s.inner.MessageTraceParent(messageID)
+}
+
 func (s *SQLiteStore) GetMessageForTest(messageID string) (Message, bool) {
 	return s.inner.GetMessageForTest(messageID)
 }
@@ -0,0 +1,145 @@
+package bus
+
+import (
+	"strings"
+)
+
+// saveSearchDoc upserts doc's filter row in search_docs and replaces its text
+// in the search_text FTS5 table under the same rowid.
+func saveSearchDoc(ex sqlExecer, doc searchDoc) error {
+	if _, err := ex.Exec(`INSERT INTO search_docs (kind, doc_id, conversation_id, type, agents, at_ns)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (kind, doc_id) DO UPDATE SET conversation_id = excluded.conversation_id,
+			type = excluded.type, agents = excluded.agents, at_ns = excluded.at_ns`,
+		doc.kind,
+		doc.id,
+		doc.conversationID,
+		string(doc.msgType),
+		searchAgents(doc.agents),
+		doc.at.UnixNano(),
+	); err != nil {
+		return err
+	}
+	if _, err := ex.Exec(`DELETE FROM search_text WHERE rowid = (SELECT id FROM search_docs WHERE kind = ? AND doc_id = ?)`,
+		doc.kind, doc.id); err != nil {
+		return err
+	}
+	_, err := ex.Exec(`INSERT INTO search_text (rowid, text) SELECT id, ? FROM search_docs WHERE kind = ? AND doc_id = ?`,
+		doc.text, doc.kind, doc.id)
+	return err
+}
+
+// searchAgents stores agent IDs space-delimited on both ends so a single
+// agent can be matched with LIKE '% id %'.
+func searchAgents(agents []string) string {
+	return " " + strings.Join(agents, " ") + " "
+}
+
+// backfillSearch indexes every loaded conversation and message when the
+// search tables are empty, as they are on databases created before search
+// existed.
+func (s *SQLiteStore) backfillSearch() error {
+	var count int
+	if err := s.db.Get(&count, "SELECT COUNT(*) FROM search_docs"); err != nil {
+		return err
+	}
+	if count > 0 || (len(s.inner.conversations) == 0 && len(s.inner.messages) == 0) {
+		return nil
+	}
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	for _, c := range s.inner.conversations {
+		if err := saveSearchDoc(tx, conversationSearchDoc(c)); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	for _, m := range s.inner.messages {
+		if err := saveSearchDoc(tx, messageSearchDoc(m)); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// search runs input against the FTS5 index, ranked by bm25 and then by
+// recency, and fills in the hits from the in-memory store.
+func (s *SQLiteStore) search(input SearchInput) ([]SearchHit, error) {
+	input, terms, err := prepareSearch(input)
+	if err != nil {
+		return nil, err
+	}
+
+	where := []string{"search_text MATCH ?"}
+	args := []any{ftsQuery(terms)}
+	if input.Kind != "" {
+		where = append(where, "d.kind = ?")
+		args = append(args, input.Kind)
+	}
+	if input.ConversationID != "" {
+		where = append(where, "d.conversation_id = ?")
+		args = append(args, input.ConversationID)
+	}
+	if input.Type != "" {
+		where = append(where, "d.type = ?")
+		args = append(args, string(input.Type))
+	}
+	if input.AgentID != "" {
+		where = append(where, "d.agents LIKE ? ESCAPE '\\'")
+		args = append(args, "% "+escapeLike(input.AgentID)+" %")
+	}
+	if !input.After.IsZero() {
+		where = append(where, "d.at_ns > ?")
+		args = append(args, input.After.UnixNano())
+	}
+	if !input.Before.IsZero() {
+		where = append(where, "d.at_ns < ?")
+		args = append(args, input.Before.UnixNano())
+	}
+	args = append(args, input.Limit)
+
+	s.mu.Lock()
+	rows, err := s.db.Query(`SELECT d.kind, d.doc_id, snippet(search_text, 0, '**', '**', '…', 12)
+		FROM search_text JOIN search_docs d ON d.id = search_text.rowid
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY bm25(search_text), d.at_ns DESC, d.kind, d.doc_id
+		LIMIT ?`, args...)
+	if err != nil {
+		s.mu.Unlock()
+		return nil, err
+	}
+	type match struct{ kind, id, snippet string }
+	var matches []match
+	for rows.Next() {
+		var m match
+		if err := rows.Scan(&m.kind, &m.id, &m.snippet); err != nil {
+			rows.Close()
+			s.mu.Unlock()
+			return nil, err
+		}
+		matches = append(matches, m)
+	}
+	err = rows.Err()
+	rows.Close()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	s.inner.mu.Lock()
+	defer s.inner.mu.Unlock()
+	out := []SearchHit{}
+	for _, m := range matches {
+		if hit, ok := s.inner.searchHitLocked(m.kind, m.id, m.snippet); ok {
+			out = append(out, hit)
+		}
+	}
+	return out, nil
+}
+
+func escapeLike(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(v)
+}
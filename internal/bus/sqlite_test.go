@@ -1,9 +1,13 @@
 package bus
 
 import (
+	"encoding/json"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/jmoiron/sqlx"
 )
 
 func TestSQLiteRoundTrip(t *testing.T) {
@@ -54,7 +58,10 @@ func TestSQLiteRoundTrip(t *testing.T) {
 	}
 	defer s2.Close()
 
-	agents := s2.ListAgents("")
+	agents, _, err := s2.ListAgents(ListAgentsFilter{})
+	if err != nil {
+		t.Fatalf("list agents after restore: %v", err)
+	}
 	if len(agents) != 2 {
 		t.Fatalf("expected 2 agents after restore, got %d", len(agents))
 	}
@@ -79,6 +86,90 @@ func TestSQLiteRoundTrip(t *testing.T) {
 	}
 }
 
+func TestSQLiteAgentMetadataPersists(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "metadata.db")
+	cfg := sqliteTestConfig()
+
+	s1, err := NewSQLiteStore(dbPath, cfg)
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	if _, err := s1.RegisterAgent(RegisterAgentInput{AgentID: "a", Mode: AgentModePull, TTLSeconds: 60, Metadata: AgentMetadata{Version: "1.4.0", Labels: map[string]string{"team": "patent"}}}); err != nil {
+		t.Fatalf("register a: %v", err)
+	}
+	if _, err := s1.Heartbeat(HeartbeatInput{AgentID: "a", InFlight: 1, Status: HealthDegraded}); err != nil {
+		t.Fatalf("heartbeat a: %v", err)
+	}
+	if _, err := s1.RegisterAgent(RegisterAgentInput{AgentID: "b", Mode: AgentModePull, TTLSeconds: 60}); err != nil {
+		t.Fatalf("register b: %v", err)
+	}
+	s1.Close()
+
+	s2, err := NewSQLiteStore(dbPath, cfg)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer s2.Close()
+
+	a, err := s2.GetAgent("a")
+	if err != nil {
+		t.Fatalf("get a: %v", err)
+	}
+	if a.Metadata.Version != "1.4.0" || a.Metadata.Labels["team"] != "patent" || a.Health == nil || a.Health.Status != HealthDegraded {
+		t.Fatalf("expected metadata and health for a after restore, got %#v", a)
+	}
+	b, err := s2.GetAgent("b")
+	if err != nil {
+		t.Fatalf("get b: %v", err)
+	}
+	if b.Health != nil {
+		t.Fatalf("expected no health for b, got %#v", b.Health)
+	}
+}
+
+func TestSQLiteCapabilitySchemasPersist(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "schemas.db")
+	cfg := sqliteTestConfig()
+
+	s1, err := NewSQLiteStore(dbPath, cfg)
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	if _, err := s1.RegisterAgent(RegisterAgentInput{AgentID: "a", Mode: AgentModePull, TTLSeconds: 60}); err != nil {
+		t.Fatalf("register a: %v", err)
+	}
+	schema := json.RawMessage(`{"properties":{"body":{"type":"string"}}}`)
+	if _, err := s1.RegisterAgent(RegisterAgentInput{AgentID: "b", Mode: AgentModePull, Capabilities: []string{"y"}, Schemas: map[string]CapabilitySchema{"y": {Request: schema}}, TTLSeconds: 60}); err != nil {
+		t.Fatalf("register b: %v", err)
+	}
+	msg, _, err := s1.SendMessage(SendMessageInput{To: "b", From: "a", RequestID: "rid-schema", Type: MessageTypeRequest, Body: "checked"})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	s1.Close()
+
+	s2, err := NewSQLiteStore(dbPath, cfg)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer s2.Close()
+
+	b, err := s2.GetAgent("b")
+	if err != nil {
+		t.Fatalf("get b: %v", err)
+	}
+	if got := b.Schemas["y"].Request; string(got) != string(schema) {
+		t.Fatalf("expected schema for b after restore, got %s", got)
+	}
+	detail, err := s2.GetMessage(msg.MessageID)
+	if err != nil {
+		t.Fatalf("get message: %v", err)
+	}
+	if detail.Capability != "y" {
+		t.Fatalf("expected capability y after restore, got %q", detail.Capability)
+	}
+}
+
 func TestSQLiteAckPersists(t *testing.T) {
 	tmp := t.TempDir()
 	dbPath := filepath.Join(tmp, "ack.db")
@@ -132,6 +223,53 @@ func TestSQLiteAckPersists(t *testing.T) {
 	}
 }
 
+func TestSQLiteHeldRequestsPersist(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "held.db")
+	now := time.Date(2026, 2, 17, 0, 0, 0, 0, time.UTC)
+	cfg := Config{Clock: func() time.Time { return now }}
+
+	s1, err := NewSQLiteStore(dbPath, cfg)
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	if _, err := s1.RegisterAgent(RegisterAgentInput{AgentID: "a", Mode: AgentModePull, TTLSeconds: 600}); err != nil {
+		t.Fatalf("register a: %v", err)
+	}
+	if _, err := s1.RegisterAgent(RegisterAgentInput{AgentID: "b", Mode: AgentModePull, TTLSeconds: 600, Metadata: AgentMetadata{MaxInFlight: 1}}); err != nil {
+		t.Fatalf("register b: %v", err)
+	}
+	first, _, err := s1.SendMessage(SendMessageInput{To: "b", From: "a", RequestID: "rid-1", Body: "one"})
+	if err != nil {
+		t.Fatalf("send first: %v", err)
+	}
+	second, _, err := s1.SendMessage(SendMessageInput{To: "b", From: "a", RequestID: "rid-2", Body: "two"})
+	if err != nil {
+		t.Fatalf("send second: %v", err)
+	}
+	s1.Close()
+
+	s2, err := NewSQLiteStore(dbPath, cfg)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if m, _ := s2.GetMessageForTest(second.MessageID); !m.HeldForCapacity || m.State != StatePending {
+		t.Fatalf("expected second request still held after reopen, got %#v", m)
+	}
+	if err := s2.PostEvent(EventInput{ActorAgentID: "b", MessageID: first.MessageID, Type: "final", Body: "done"}); err != nil {
+		t.Fatalf("final: %v", err)
+	}
+	s2.Close()
+
+	s3, err := NewSQLiteStore(dbPath, cfg)
+	if err != nil {
+		t.Fatalf("reopen again: %v", err)
+	}
+	defer s3.Close()
+	if m, _ := s3.GetMessageForTest(second.MessageID); m.HeldForCapacity || m.State != StateWaitingAck {
+		t.Fatalf("expected released request to persist, got %#v", m)
+	}
+}
+
 func TestSQLiteEventFinalPersists(t *testing.T) {
 	tmp := t.TempDir()
 	dbPath := filepath.Join(tmp, "final.db")
@@ -188,6 +326,81 @@ func TestSQLiteEventFinalPersists(t *testing.T) {
 	}
 }
 
+func TestSQLiteRequestResultPersists(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "result.db")
+	now := time.Date(2026, 2, 17, 0, 0, 0, 0, time.UTC)
+	cfg := Config{Clock: func() time.Time { return now }}
+
+	s1, err := NewSQLiteStore(dbPath, cfg)
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	for _, id := range []string{"a", "b"} {
+		if _, err := s1.RegisterAgent(RegisterAgentInput{AgentID: id, Mode: AgentModePull, TTLSeconds: 3600}); err != nil {
+			t.Fatalf("register %s: %v", id, err)
+		}
+	}
+	msg, _, err := s1.SendMessage(SendMessageInput{To: "b", From: "a", RequestID: "rid-result", Body: "complete me"})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	resultBlob := strings.Repeat("ab", 32)
+	if err := s1.PostEvent(EventInput{ActorAgentID: "b", MessageID: msg.MessageID, Type: "final", Body: "done",
+		Attachments: []Attachment{{URL: BlobPathPrefix + resultBlob, Name: "report.pdf"}}}); err != nil {
+		t.Fatalf("final event: %v", err)
+	}
+	s1.Close()
+
+	s2, err := NewSQLiteStore(dbPath, cfg)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer s2.Close()
+	restored, _ := s2.GetMessageForTest(msg.MessageID)
+	if restored.Result == nil || restored.Result.Body != "done" || len(restored.Result.Attachments) != 1 {
+		t.Fatalf("expected final result after reopen, got %#v", restored.Result)
+	}
+	if refs := s2.BlobReferences(resultBlob); refs.RefCount != 1 || refs.MessageIDs[0] != msg.MessageID {
+		t.Fatalf("expected result attachment blob reference after reopen, got %#v", refs)
+	}
+}
+
+func TestSQLiteReplyIndexPersists(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "replies.db")
+	now := time.Date(2026, 2, 17, 0, 0, 0, 0, time.UTC)
+	cfg := Config{Clock: func() time.Time { return now }}
+
+	s1, err := NewSQLiteStore(dbPath, cfg)
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	for _, id := range []string{"a", "b"} {
+		if _, err := s1.RegisterAgent(RegisterAgentInput{AgentID: id, Mode: AgentModePull, TTLSeconds: 3600}); err != nil {
+			t.Fatalf("register %s: %v", id, err)
+		}
+	}
+	msg, _, err := s1.SendMessage(SendMessageInput{To: "b", From: "a", RequestID: "rid-req", Body: "review this"})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	reply, _, err := s1.SendMessage(SendMessageInput{To: "a", From: "b", RequestID: "rid-reply", Type: MessageTypeResponse, Body: "see attached", InReplyTo: msg.MessageID})
+	if err != nil {
+		t.Fatalf("send reply: %v", err)
+	}
+	s1.Close()
+
+	// The reply index is rebuilt from in_reply_to on load.
+	s2, err := NewSQLiteStore(dbPath, cfg)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer s2.Close()
+	detail, err := s2.GetMessage(msg.MessageID)
+	if err != nil || len(detail.Replies) != 1 || detail.Replies[0] != reply.MessageID {
+		t.Fatalf("expected reply %s indexed after reopen, got %#v err=%v", reply.MessageID, detail, err)
+	}
+}
+
 func TestSQLiteConversationPersists(t *testing.T) {
 	tmp := t.TempDir()
 	dbPath := filepath.Join(tmp, "conv.db")
@@ -224,7 +437,7 @@ func TestSQLiteConversationPersists(t *testing.T) {
 	}
 	defer s2.Close()
 
-	convs := s2.ListConversations(ListConversationsFilter{})
+	convs, _, _ := s2.ListConversations(ListConversationsFilter{})
 	if len(convs) != 1 {
 		t.Fatalf("expected 1 conversation after restore, got %d", len(convs))
 	}
@@ -234,6 +447,29 @@ func TestSQLiteConversationPersists(t *testing.T) {
 	if convs[0].Title != "test conv" {
 		t.Fatalf("expected title 'test conv', got %q", convs[0].Title)
 	}
+
+	if _, err := s2.UpdateConversation(UpdateConversationInput{ConversationID: conv.ConversationID, Meta: map[string]any{"case_id": "TT-1"}, AddTags: []string{"intake"}}); err != nil {
+		t.Fatalf("update conversation: %v", err)
+	}
+	if _, err := s2.SetConversationStatus(ConversationStatusInput{ConversationID: conv.ConversationID, Status: ConversationStatusClosed, Reason: "done"}); err != nil {
+		t.Fatalf("close conversation: %v", err)
+	}
+	s2.Close()
+	s3, err := NewSQLiteStore(dbPath, cfg)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer s3.Close()
+	closed, err := s3.GetConversation(conv.ConversationID)
+	if err != nil {
+		t.Fatalf("get conversation: %v", err)
+	}
+	if closed.Status != ConversationStatusClosed || closed.StatusReason != "done" || closed.StatusChangedAt == nil {
+		t.Fatalf("expected closed status to persist, got %#v", closed)
+	}
+	if len(closed.Tags) != 1 || closed.Tags[0] != "intake" || closed.Meta.(map[string]any)["case_id"] != "TT-1" {
+		t.Fatalf("expected tags and meta to persist, got %#v", closed)
+	}
 }
 
 func TestSQLiteCountersPreserved(t *testing.T) {
@@ -348,3 +584,343 @@ func TestSQLiteInjectPersists(t *testing.T) {
 		t.Fatalf("expected body 'human says hi', got %q", restored.Body)
 	}
 }
+
+func TestSQLiteSendMessagesPersists(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "batch.db")
+	cfg := sqliteTestConfig()
+
+	s1, err := NewSQLiteStore(dbPath, cfg)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	registerPairSQLite(t, s1)
+	results, err := s1.SendMessages(SendMessagesInput{
+		Atomic: true,
+		Messages: []SendMessageInput{
+			{To: "b", From: "a", ConversationID: "conv-batch", RequestID: "rid-1", Body: "one"},
+			{To: "b", From: "a", ConversationID: "conv-batch", RequestID: "rid-2", Body: "two"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("send batch: %v", err)
+	}
+	s1.Close()
+
+	s2, err := NewSQLiteStore(dbPath, cfg)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer s2.Close()
+	_, msgs, _, err := s2.ListConversationMessages(ListConversationMessagesInput{ConversationID: "conv-batch", Limit: 10})
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(msgs) != 2 || msgs[0].MessageID != results[0].Message.MessageID || msgs[1].MessageID != results[1].Message.MessageID {
+		t.Fatalf("expected both batch messages in order after reopen, got %#v", msgs)
+	}
+}
+
+func sqliteTestConfig() Config {
+	now := time.Date(2026, 2, 17, 0, 0, 0, 0, time.UTC)
+	return Config{
+		GracePeriod:            30 * time.Second,
+		ProgressMinInterval:    2 * time.Second,
+		IdempotencyWindow:      24 * time.Hour,
+		InboxWaitMax:           1 * time.Second,
+		AckTimeout:             10 * time.Second,
+		DefaultMessageTTL:      600 * time.Second,
+		DefaultRegistrationTTL: 60 * time.Second,
+		Clock: func() time.Time {
+			return now
+		},
+	}
+}
+
+func TestSQLiteMigratesLegacyMessagesTable(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "legacy.db")
+	legacy, err := sqlx.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("open legacy db: %v", err)
+	}
+	if _, err := legacy.Exec(`CREATE TABLE messages (
+		message_id TEXT PRIMARY KEY, type TEXT NOT NULL, from_agent TEXT NOT NULL,
+		to_agent TEXT NOT NULL DEFAULT '', conversation_id TEXT NOT NULL DEFAULT '',
+		request_id TEXT NOT NULL DEFAULT '', in_reply_to TEXT NOT NULL DEFAULT '',
+		body TEXT NOT NULL DEFAULT '', meta TEXT, attachments TEXT NOT NULL DEFAULT '[]',
+		state TEXT NOT NULL DEFAULT 'pending', created_at TEXT NOT NULL,
+		delivered_at TEXT NOT NULL DEFAULT '', last_progress_at TEXT NOT NULL DEFAULT '',
+		ttl_expires_at TEXT NOT NULL DEFAULT '', grace_until TEXT NOT NULL DEFAULT '',
+		queued_for_agent INTEGER NOT NULL DEFAULT 0)`); err != nil {
+		t.Fatalf("create legacy table: %v", err)
+	}
+	legacy.Close()
+
+	cfg := sqliteTestConfig()
+	s1, err := NewSQLiteStore(dbPath, cfg)
+	if err != nil {
+		t.Fatalf("open migrated store: %v", err)
+	}
+	registerPairSQLite(t, s1)
+	const traceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	msg, _, err := s1.SendMessage(SendMessageInput{
+		To: "b", From: "a", RequestID: "rid-trace", Type: MessageTypeRequest, Body: "traced", TraceParent: traceParent,
+	})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	s1.Close()
+
+	s2, err := NewSQLiteStore(dbPath, cfg)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer s2.Close()
+	if got := s2.MessageTraceParent(msg.MessageID); got != traceParent {
+		t.Fatalf("expected trace_parent %q after reopen, got %q", traceParent, got)
+	}
+}
+
+func registerPairSQLite(t *testing.T, s *SQLiteStore) {
+	t.Helper()
+	if _, err := s.RegisterAgent(RegisterAgentInput{AgentID: "a", Mode: AgentModePull, TTLSeconds: 60}); err != nil {
+		t.Fatalf("register a: %v", err)
+	}
+	if _, err := s.RegisterAgent(RegisterAgentInput{AgentID: "b", Mode: AgentModePull, TTLSeconds: 60}); err != nil {
+		t.Fatalf("register b: %v", err)
+	}
+}
+
+func TestSQLiteStateHistoryPersists(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "history.db")
+	now := time.Date(2026, 2, 17, 0, 0, 0, 0, time.UTC)
+	cfg := sqliteTestConfig()
+	cfg.Clock = func() time.Time { return now }
+
+	s1, err := NewSQLiteStore(dbPath, cfg)
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	registerPairSQLite(t, s1)
+	done, _, err := s1.SendMessage(SendMessageInput{To: "b", From: "a", RequestID: "rid-done", Type: MessageTypeRequest, Body: "finish"})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if err := s1.Ack(AckInput{AgentID: "b", MessageID: done.MessageID, Status: "accepted"}); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	if err := s1.PostEvent(EventInput{ActorAgentID: "b", MessageID: done.MessageID, Type: "final", Body: "done", Meta: map[string]any{"k": "v"}}); err != nil {
+		t.Fatalf("final: %v", err)
+	}
+	stale, _, err := s1.SendMessage(SendMessageInput{To: "b", From: "a", RequestID: "rid-stale", Type: MessageTypeRequest, Body: "ignored"})
+	if err != nil {
+		t.Fatalf("send stale: %v", err)
+	}
+	// The ack timeout fires in the sweep of a read, not in a write.
+	now = now.Add(11 * time.Second)
+	if _, err := s1.GetMessage(stale.MessageID); err != nil {
+		t.Fatalf("get stale: %v", err)
+	}
+	s1.Close()
+
+	s2, err := NewSQLiteStore(dbPath, cfg)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer s2.Close()
+
+	detail, err := s2.GetMessage(done.MessageID)
+	if err != nil {
+		t.Fatalf("get done: %v", err)
+	}
+	if len(detail.StateHistory) != 2 || detail.StateHistory[1].Body != "done" || detail.StateHistory[1].Actor != "b" {
+		t.Fatalf("unexpected history after reopen: %#v", detail.StateHistory)
+	}
+	if m, ok := detail.StateHistory[1].Meta.(map[string]any); !ok || m["k"] != "v" {
+		t.Fatalf("expected final meta after reopen, got %#v", detail.StateHistory[1].Meta)
+	}
+	staleDetail, err := s2.GetMessage(stale.MessageID)
+	if err != nil {
+		t.Fatalf("get stale after reopen: %v", err)
+	}
+	if staleDetail.State != StateError || len(staleDetail.StateHistory) != 1 || staleDetail.StateHistory[0].Reason != "ack timeout" {
+		t.Fatalf("expected persisted ack timeout, got %#v", staleDetail)
+	}
+}
+
+func TestSQLiteConversationHistoryPersists(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "conversation-history.db")
+	now := time.Date(2026, 2, 17, 0, 0, 0, 0, time.UTC)
+	cfg := sqliteTestConfig()
+	cfg.Clock = func() time.Time { return now }
+
+	s1, err := NewSQLiteStore(dbPath, cfg)
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	registerPairSQLite(t, s1)
+	msg, _, err := s1.SendMessage(SendMessageInput{To: "b", From: "a", ConversationID: "case-1", RequestID: "rid-history", Type: MessageTypeRequest, Body: "work"})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if err := s1.PostEvent(EventInput{ActorAgentID: "b", MessageID: msg.MessageID, Type: "progress", Body: "halfway", Meta: map[string]any{"pct": 50.0}}); err != nil {
+		t.Fatalf("progress: %v", err)
+	}
+	if _, err := s1.UpdateConversation(UpdateConversationInput{ConversationID: "case-1", AddTags: []string{"review"}, Actor: "a"}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if _, err := s1.SetConversationStatus(ConversationStatusInput{ConversationID: "case-1", Status: ConversationStatusClosed, Actor: "a", Reason: "done"}); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	s1.Close()
+
+	s2, err := NewSQLiteStore(dbPath, cfg)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer s2.Close()
+
+	h, err := s2.ConversationHistory("case-1")
+	if err != nil {
+		t.Fatalf("history: %v", err)
+	}
+	if len(h) != 3 || h[0].Type != ObserveProgress || h[1].Type != ObserveConversationUpdated || h[2].Type != ObserveConversationStatus {
+		t.Fatalf("unexpected history after reopen: %#v", h)
+	}
+	if m, ok := h[0].Meta.(map[string]any); !ok || m["pct"] != 50.0 || h[0].MessageID != msg.MessageID || h[0].Body != "halfway" {
+		t.Fatalf("unexpected progress after reopen: %#v", h[0])
+	}
+	if len(h[1].Changed) != 1 || h[1].Changed[0] != "tags" || h[1].Actor != "a" {
+		t.Fatalf("unexpected update after reopen: %#v", h[1])
+	}
+	if h[2].ToStatus != ConversationStatusClosed || h[2].Reason != "done" || !h[2].At.Equal(now) {
+		t.Fatalf("unexpected status after reopen: %#v", h[2])
+	}
+}
+
+func TestSQLiteSearchUsesFTSAndBackfills(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "search.db")
+	cfg := sqliteTestConfig()
+
+	s1, err := NewSQLiteStore(dbPath, cfg)
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	registerPairSQLite(t, s1)
+	if _, err := s1.CreateConversation(CreateConversationInput{ConversationID: "case-7", Title: "Nano-coating disclosure", Participants: []string{"a", "b"}}); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, _, err := s1.SendMessage(SendMessageInput{To: "b", From: "a", ConversationID: "case-7", RequestID: "r1", Body: "Please review the nano-coating claims."}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	hits, err := s1.Search(SearchInput{Query: "coat*", AgentID: "a"})
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if len(hits) != 2 || !strings.Contains(hits[0].Snippet, "**coating**") {
+		t.Fatalf("expected message and conversation hits, got %#v", hits)
+	}
+	if hits, _ := s1.Search(SearchInput{Query: "coat*", Kind: SearchKindMessage}); len(hits) != 1 || hits[0].MessageID == "" || hits[0].Title != "Nano-coating disclosure" {
+		t.Fatalf("expected one message hit, got %#v", hits)
+	}
+	// Quotes and FTS operators in the query are taken literally.
+	if _, err := s1.Search(SearchInput{Query: `"nano" OR NEAR(`}); err != nil {
+		t.Fatalf("search with query syntax: %v", err)
+	}
+
+	// Databases from before search existed get indexed on open.
+	if _, err := s1.db.Exec("DELETE FROM search_docs; DELETE FROM search_text;"); err != nil {
+		t.Fatalf("clear index: %v", err)
+	}
+	s1.Close()
+	s2, err := NewSQLiteStore(dbPath, cfg)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer s2.Close()
+	if hits, _ := s2.Search(SearchInput{Query: "claims"}); len(hits) != 1 || hits[0].ConversationID != "case-7" {
+		t.Fatalf("expected backfilled message hit, got %#v", hits)
+	}
+}
+
+func TestSQLiteScheduledMessagesPersist(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "scheduled.db")
+	now := time.Date(2026, 2, 17, 0, 0, 0, 0, time.UTC)
+	cfg := Config{Clock: func() time.Time { return now }}
+
+	s1, err := NewSQLiteStore(dbPath, cfg)
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	for _, id := range []string{"a", "b"} {
+		if _, err := s1.RegisterAgent(RegisterAgentInput{AgentID: id, Mode: AgentModePull, TTLSeconds: 3600}); err != nil {
+			t.Fatalf("register %s: %v", id, err)
+		}
+	}
+	m, _, err := s1.SendMessage(SendMessageInput{To: "b", From: "a", RequestID: "rid-1", Body: "follow up", DelaySeconds: 300})
+	if err != nil {
+		t.Fatalf("schedule: %v", err)
+	}
+	s1.Close()
+
+	s2, err := NewSQLiteStore(dbPath, cfg)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if got, _ := s2.GetMessageForTest(m.MessageID); got.State != StateScheduled || got.DeliverAt == nil || !got.DeliverAt.Equal(now.Add(300*time.Second)) {
+		t.Fatalf("expected message still scheduled after reopen, got %#v", got)
+	}
+	now = now.Add(300 * time.Second)
+	if n := s2.DeliverDue(); n != 1 {
+		t.Fatalf("expected one delivery, got %d", n)
+	}
+	s2.Close()
+
+	s3, err := NewSQLiteStore(dbPath, cfg)
+	if err != nil {
+		t.Fatalf("reopen again: %v", err)
+	}
+	defer s3.Close()
+	if got, _ := s3.GetMessageForTest(m.MessageID); got.State != StateWaitingAck || got.DeliverAt == nil {
+		t.Fatalf("expected delivery to persist, got %#v", got)
+	}
+}
+
+func TestSQLiteMulticastDeliveriesPersist(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "multicast.db")
+	now := time.Date(2026, 2, 17, 0, 0, 0, 0, time.UTC)
+	cfg := Config{Clock: func() time.Time { return now }}
+
+	s1, err := NewSQLiteStore(dbPath, cfg)
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		if _, err := s1.RegisterAgent(RegisterAgentInput{AgentID: id, Mode: AgentModePull, TTLSeconds: 3600}); err != nil {
+			t.Fatalf("register %s: %v", id, err)
+		}
+	}
+	m, _, err := s1.SendMessage(SendMessageInput{From: "a", RequestID: "rid-1", Type: MessageTypeInform, Body: "withdrawn", Recipients: []string{"b", "c"}})
+	if err != nil {
+		t.Fatalf("multicast: %v", err)
+	}
+	if _, _, err := s1.PollInbox(PollInboxInput{AgentID: "b"}); err != nil {
+		t.Fatalf("poll b: %v", err)
+	}
+	s1.Close()
+
+	s2, err := NewSQLiteStore(dbPath, cfg)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer s2.Close()
+	detail, err := s2.GetMessage(m.MessageID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if strings.Join(detail.Recipients, ",") != "b,c" || len(detail.Deliveries) != 2 || detail.Deliveries[0].State != StateCompleted || detail.Deliveries[0].DeliveredAt == nil || detail.Deliveries[1].AgentID != "c" || detail.Deliveries[1].State != StatePending {
+		t.Fatalf("expected recipients and deliveries after reopen, got %#v", detail)
+	}
+	if child, _ := s2.GetMessageForTest(detail.Deliveries[0].MessageID); child.ParentID != m.MessageID || child.To != "b" {
+		t.Fatalf("expected b's copy to keep its parent, got %#v", child)
+	}
+}
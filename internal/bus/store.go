@@ -7,11 +7,14 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"reflect"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
 )
 
 type Config struct {
@@ -25,8 +28,28 @@ type Config struct {
 	PushMaxAttempts        int
 	PushBaseBackoff        time.Duration
 	MaxInboxEventsPerAgent int
+	// MaxPendingPerAgent caps the requests held for an agent at its
+	// max_in_flight; sends beyond it fail with rate_limited.
+	MaxPendingPerAgent int
+	// BackpressureRetryAfter is the Retry-After given when a send is
+	// refused because an agent's pending queue or inbox is full.
+	BackpressureRetryAfter time.Duration
 	MaxObserveEvents       int
-	Clock                  func() time.Time
+	MaxBatchMessages       int
+	// ConversationIdleTimeout closes an active conversation once it has had
+	// no messages for this long and none of its requests are still open.
+	// Zero disables idle closing.
+	ConversationIdleTimeout time.Duration
+	// VerifyAttachments, when set, checks a message's attachments before it
+	// is sent. It returns them with Verification filled in, or an error to
+	// reject the message. It is called without the store lock held.
+	VerifyAttachments func([]Attachment) ([]Attachment, error)
+	// BlobUploaders, when set, lists the agents that uploaded the bus blob
+	// with the given digest. A message or result may then attach a bus blob
+	// only if its sender uploaded it or can already read it. It is called
+	// with the store lock held.
+	BlobUploaders func(sha string) []string
+	Clock         func() time.Time
 }
 
 type idempotencyEntry struct {
@@ -50,14 +73,47 @@ type Store struct {
 	conversationMessages map[string][]string
 	inboxes              map[string][]InboxEvent
 	inboxBase            map[string]int
-	observeEvents        []ObserveEvent
-	idempotency          map[string]idempotencyEntry
+	// inboxRead is the cursor each agent last polled from; events before it
+	// have been read and may be trimmed.
+	inboxRead map[string]int
+	// inboxServed is where each pull agent's last poll ended; events past
+	// it have not been handed out and are kept in priority order.
+	inboxServed   map[string]int
+	observeEvents []ObserveEvent
+	idempotency   map[string]idempotencyEntry
+	stateHistory  map[string][]StateTransition
+	replies       map[string][]string
+	// children maps a multicast message to its recipients' copies.
+	children map[string][]string
+	// conversationHistory holds each conversation's progress, status and
+	// update events for the transcript export.
+	conversationHistory map[string][]ConversationEvent
+	// blobRefs maps a blob digest to the messages whose attachments or
+	// result attachments reference it.
+	blobRefs map[string]map[string]struct{}
+	// schemaCache holds compiled capability schemas keyed by their source.
+	schemaCache map[string]*jsonschema.Schema
+	// routeTurns rotates capability-addressed sends among equally matching
+	// agents, keyed by the capability query.
+	routeTurns map[string]int
+	search     *searchIndex
+
+	// changedMessages and changedConversations collect IDs whose state
+	// moved since the last takeChangesLocked; only backends that save rows
+	// incrementally enable tracking.
+	trackChanges         bool
+	changedMessages      map[string]struct{}
+	changedConversations map[string]struct{}
 
 	humanAllowlist map[string]struct{}
 	httpClient     *http.Client
 	logger         *log.Logger
 	pushFailures   int64
 	pushSuccesses  int64
+	// pushQueues holds the callbacks each push agent has not been sent
+	// yet; pushBusy marks the agents with a goroutine draining theirs.
+	pushQueues map[string][]pushItem
+	pushBusy   map[string]bool
 }
 
 func NewStore(cfg Config) *Store {
@@ -91,9 +147,18 @@ func NewStore(cfg Config) *Store {
 	if cfg.MaxInboxEventsPerAgent <= 0 {
 		cfg.MaxInboxEventsPerAgent = 10000
 	}
+	if cfg.MaxPendingPerAgent <= 0 {
+		cfg.MaxPendingPerAgent = 1000
+	}
+	if cfg.BackpressureRetryAfter <= 0 {
+		cfg.BackpressureRetryAfter = 5 * time.Second
+	}
 	if cfg.MaxObserveEvents <= 0 {
 		cfg.MaxObserveEvents = 50000
 	}
+	if cfg.MaxBatchMessages <= 0 {
+		cfg.MaxBatchMessages = 100
+	}
 	if cfg.Clock == nil {
 		cfg.Clock = time.Now
 	}
@@ -114,9 +179,23 @@ func NewStore(cfg Config) *Store {
 		conversationMessages: map[string][]string{},
 		inboxes:              map[string][]InboxEvent{},
 		inboxBase:            map[string]int{},
+		inboxRead:            map[string]int{},
+		inboxServed:          map[string]int{},
 		observeEvents:        []ObserveEvent{},
 		idempotency:          map[string]idempotencyEntry{},
+		stateHistory:         map[string][]StateTransition{},
+		conversationHistory:  map[string][]ConversationEvent{},
+		replies:              map[string][]string{},
+		children:             map[string][]string{},
+		blobRefs:             map[string]map[string]struct{}{},
+		schemaCache:          map[string]*jsonschema.Schema{},
+		routeTurns:           map[string]int{},
+		search:               newSearchIndex(),
+		changedMessages:      map[string]struct{}{},
+		changedConversations: map[string]struct{}{},
 		humanAllowlist:       allowlist,
+		pushQueues:           map[string][]pushItem{},
+		pushBusy:             map[string]bool{},
 		httpClient: &http.Client{
 			Timeout: 10 * time.Second,
 		},
@@ -132,25 +211,105 @@ func dedupeKey(from, to, requestID string) string {
 	return from + "\x1f" + to + "\x1f" + requestID
 }
 
+// messageIDLess orders message IDs by the sequence number they were issued
+// with. IDs outgrow their zero padding, so shorter IDs come first.
+func messageIDLess(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+func sortMessageIDs(ids []string) {
+	sort.Slice(ids, func(i, j int) bool { return messageIDLess(ids[i], ids[j]) })
+}
+
 func isTerminal(state MessageState) bool {
 	switch state {
-	case StateCompleted, StateRejected, StateError:
+	case StateCompleted, StateRejected, StateError, StateCancelled:
 		return true
 	default:
 		return false
 	}
 }
 
+func inboxEventFor(m *Message) InboxEvent {
+	return InboxEvent{
+		MessageID:      m.MessageID,
+		ParentID:       m.ParentID,
+		Type:           m.Type,
+		From:           m.From,
+		ConversationID: m.ConversationID,
+		Capability:     m.Capability,
+		Priority:       m.Priority,
+		Body:           m.Body,
+		Meta:           m.Meta,
+		Attachments:    append([]Attachment{}, m.Attachments...),
+		TraceParent:    m.TraceParent,
+		CreatedAt:      m.CreatedAt,
+	}
+}
+
+func pushPayloadFor(m *Message) map[string]any {
+	payload := map[string]any{
+		"message_id":      m.MessageID,
+		"type":            m.Type,
+		"from":            m.From,
+		"conversation_id": m.ConversationID,
+		"body":            m.Body,
+		"meta":            m.Meta,
+		"attachments":     m.Attachments,
+		"created_at":      m.CreatedAt,
+	}
+	if m.ParentID != "" {
+		payload["parent_id"] = m.ParentID
+	}
+	if m.Priority != "" {
+		payload["priority"] = m.Priority
+	}
+	if m.TraceParent != "" {
+		payload["trace_parent"] = m.TraceParent
+	}
+	return payload
+}
+
 func (s *Store) appendInboxLocked(agentID string, evt InboxEvent) {
-	s.inboxes[agentID] = append(s.inboxes[agentID], evt)
+	events := append(s.inboxes[agentID], evt)
+	if a, ok := s.agents[agentID]; ok && a.Mode != AgentModePush {
+		// No poll has returned the events past the served cursor, so a new
+		// event can move ahead of lower-priority ones there without
+		// invalidating any cursor the agent holds.
+		served := s.inboxServedLocked(agentID)
+		i := len(events) - 1
+		for i > served && priorityRank[events[i-1].Priority] < priorityRank[evt.Priority] {
+			events[i] = events[i-1]
+			i--
+		}
+		events[i] = evt
+	}
+	s.inboxes[agentID] = events
 	max := s.cfg.MaxInboxEventsPerAgent
 	if max > 0 && len(s.inboxes[agentID]) > max {
 		drop := len(s.inboxes[agentID]) - max
-		s.inboxes[agentID] = append([]InboxEvent{}, s.inboxes[agentID][drop:]...)
-		s.inboxBase[agentID] += drop
+		// Pull agents only lose events they have polled past; sends are
+		// refused instead once the unread ones fill the inbox.
+		if a, ok := s.agents[agentID]; !ok || a.Mode != AgentModePush {
+			drop = min(drop, s.inboxRead[agentID]-s.inboxBase[agentID])
+		}
+		if drop > 0 {
+			s.inboxes[agentID] = append([]InboxEvent{}, s.inboxes[agentID][drop:]...)
+			s.inboxBase[agentID] += drop
+		}
 	}
 }
 
+// inboxServedLocked returns the index in agentID's inbox of the first event
+// no poll has returned.
+func (s *Store) inboxServedLocked(agentID string) int {
+	served := max(s.inboxServed[agentID], s.inboxRead[agentID]) - s.inboxBase[agentID]
+	return min(max(served, 0), len(s.inboxes[agentID]))
+}
+
 func (s *Store) trimObserveLocked() {
 	max := s.cfg.MaxObserveEvents
 	if max > 0 && len(s.observeEvents) > max {
@@ -159,20 +318,82 @@ func (s *Store) trimObserveLocked() {
 	}
 }
 
-func (s *Store) sendPushCallback(url string, payload map[string]any) {
+// pushItem is a callback waiting to be sent to a push agent.
+type pushItem struct {
+	url       string
+	payload   map[string]any
+	rank      int
+	messageID string
+}
+
+// pushLocked queues payload for agentID's callback at url. Each agent's
+// callbacks are sent one at a time, highest priority first and in queue
+// order within a priority, so a backlog drains urgent work first.
+func (s *Store) pushLocked(agentID, url string, payload map[string]any) {
+	priority, _ := payload["priority"].(MessagePriority)
+	messageID, _ := payload["message_id"].(string)
+	s.pushQueues[agentID] = append(s.pushQueues[agentID], pushItem{url: url, payload: payload, rank: priorityRank[priority], messageID: messageID})
+	if !s.pushBusy[agentID] {
+		s.pushBusy[agentID] = true
+		go s.drainPushes(agentID)
+	}
+}
+
+// drainPushes sends agentID's queued callbacks until its queue is empty,
+// settling each multicast copy by the outcome of its callback.
+func (s *Store) drainPushes(agentID string) {
+	for {
+		s.mu.Lock()
+		queue := s.pushQueues[agentID]
+		if len(queue) == 0 {
+			delete(s.pushQueues, agentID)
+			delete(s.pushBusy, agentID)
+			s.mu.Unlock()
+			return
+		}
+		next := 0
+		for i, item := range queue {
+			if item.rank > queue[next].rank {
+				next = i
+			}
+		}
+		item := queue[next]
+		s.pushQueues[agentID] = append(queue[:next:next], queue[next+1:]...)
+		s.mu.Unlock()
+
+		delivered := s.sendPushCallback(item.url, item.payload)
+		s.mu.Lock()
+		s.settleCopyLocked(item.messageID, delivered, pushOutcome(delivered), s.now())
+		s.mu.Unlock()
+	}
+}
+
+func pushOutcome(delivered bool) string {
+	if delivered {
+		return "push callback delivered"
+	}
+	return "push callback failed"
+}
+
+// sendPushCallback posts payload to url, retrying with backoff, and reports
+// whether an attempt succeeded.
+func (s *Store) sendPushCallback(url string, payload map[string]any) bool {
 	blob, err := json.Marshal(payload)
 	if err != nil {
 		s.logger.Printf("push delivery marshal failed: %v", err)
-		return
+		return false
 	}
 	backoff := s.cfg.PushBaseBackoff
 	for attempt := 1; attempt <= s.cfg.PushMaxAttempts; attempt++ {
 		req, reqErr := http.NewRequest(http.MethodPost, url, bytes.NewReader(blob))
 		if reqErr != nil {
 			s.logger.Printf("push delivery request build failed attempt=%d url=%s err=%v", attempt, url, reqErr)
-			return
+			return false
 		}
 		req.Header.Set("Content-Type", "application/json")
+		if traceParent, _ := payload["trace_parent"].(string); traceParent != "" {
+			req.Header.Set("traceparent", traceParent)
+		}
 		resp, doErr := s.httpClient.Do(req)
 		if doErr == nil && resp != nil && resp.StatusCode >= 200 && resp.StatusCode < 300 {
 			s.logger.Printf("push delivery success attempt=%d url=%s status=%d", attempt, url, resp.StatusCode)
@@ -180,7 +401,7 @@ func (s *Store) sendPushCallback(url string, payload map[string]any) {
 			s.mu.Lock()
 			s.pushSuccesses++
 			s.mu.Unlock()
-			return
+			return true
 		}
 		status := 0
 		if resp != nil {
@@ -198,6 +419,7 @@ func (s *Store) sendPushCallback(url string, payload map[string]any) {
 	s.mu.Lock()
 	s.pushFailures++
 	s.mu.Unlock()
+	return false
 }
 
 func (s *Store) publishLocked(eventType EventType, data any, conversationID string, agentIDs []string, at time.Time) {
@@ -226,12 +448,14 @@ func (s *Store) ensureConversationLocked(input CreateConversationInput, now time
 		ConversationID: id,
 		Title:          strings.TrimSpace(input.Title),
 		Participants:   append([]string{}, input.Participants...),
-		Status:         "active",
+		Status:         ConversationStatusActive,
 		CreatedAt:      now,
 		LastMessageAt:  now,
 		Meta:           input.Meta,
+		Tags:           normalizeTags(input.Tags),
 	}
 	s.conversations[id] = c
+	s.indexConversationLocked(c)
 	return c
 }
 
@@ -255,45 +479,25 @@ func (s *Store) sweepLocked(now time.Time) {
 		}
 	}
 
+	s.deliverDueLocked(now)
+
 	for _, m := range s.messages {
+		if m.ParentID != "" && m.State == StatePending {
+			s.sweepCopyLocked(m, now)
+			continue
+		}
 		if m.Type != MessageTypeRequest || isTerminal(m.State) {
 			continue
 		}
 		if !m.TTLExpiresAt.IsZero() && now.After(m.TTLExpiresAt) {
-			from := m.State
-			m.State = StateError
-			s.publishLocked(
-				ObserveStateChange,
-				map[string]any{
-					"message_id": m.MessageID,
-					"from_state": from,
-					"to_state":   StateError,
-					"at":         now,
-					"error":      "ttl timeout",
-				},
-				m.ConversationID,
-				[]string{m.From, m.To},
-				now,
-			)
+			m.Result = &Result{Body: "ttl timeout", ErrorCode: CodeTimeout}
+			s.transitionLocked(m, StateTransition{To: StateError, Reason: "ttl timeout"}, now)
 			continue
 		}
 
 		if m.State == StateWaitingAck && !m.DeliveredAt.IsZero() && now.Sub(m.DeliveredAt) > s.cfg.AckTimeout {
-			from := m.State
-			m.State = StateError
-			s.publishLocked(
-				ObserveStateChange,
-				map[string]any{
-					"message_id": m.MessageID,
-					"from_state": from,
-					"to_state":   StateError,
-					"at":         now,
-					"error":      "ack timeout",
-				},
-				m.ConversationID,
-				[]string{m.From, m.To},
-				now,
-			)
+			m.Result = &Result{Body: "ack timeout", ErrorCode: CodeTimeout}
+			s.transitionLocked(m, StateTransition{To: StateError, Reason: "ack timeout"}, now)
 			continue
 		}
 
@@ -301,40 +505,35 @@ func (s *Store) sweepLocked(now time.Time) {
 			target, ok := s.agents[m.To]
 			if ok && target.Status == AgentStatusActive {
 				m.QueuedForAgent = false
-				m.State = StateWaitingAck
+				if s.mustHoldLocked(target) {
+					m.HeldForCapacity = true
+					s.transitionLocked(m, StateTransition{To: StatePending, Reason: "target agent re-registered; held for capacity"}, now)
+					continue
+				}
 				m.DeliveredAt = now
-				s.appendInboxLocked(m.To, InboxEvent{
-					MessageID:      m.MessageID,
-					Type:           m.Type,
-					From:           m.From,
-					ConversationID: m.ConversationID,
-					Body:           m.Body,
-					Meta:           m.Meta,
-					Attachments:    append([]Attachment{}, m.Attachments...),
-					CreatedAt:      m.CreatedAt,
-				})
+				s.transitionLocked(m, StateTransition{To: StateWaitingAck, Reason: "target agent re-registered"}, now)
+				s.appendInboxLocked(m.To, inboxEventFor(m))
 				continue
 			}
 			if !m.GraceUntil.IsZero() && now.After(m.GraceUntil) {
-				from := m.State
 				m.QueuedForAgent = false
-				m.State = StateError
-				s.publishLocked(
-					ObserveStateChange,
-					map[string]any{
-						"message_id": m.MessageID,
-						"from_state": from,
-						"to_state":   StateError,
-						"at":         now,
-						"error":      "target agent did not re-register in grace period",
-					},
-					m.ConversationID,
-					[]string{m.From, m.To},
-					now,
-				)
+				m.Result = &Result{Body: "target agent did not re-register in grace period", ErrorCode: CodeTimeout}
+				s.transitionLocked(m, StateTransition{To: StateError, Reason: "target agent did not re-register in grace period"}, now)
 			}
 		}
 	}
+
+	if s.cfg.ConversationIdleTimeout > 0 {
+		for _, c := range s.conversations {
+			if c.Status != ConversationStatusActive || now.Sub(c.LastMessageAt) <= s.cfg.ConversationIdleTimeout {
+				continue
+			}
+			if s.hasOpenRequestsLocked(c.ConversationID) {
+				continue
+			}
+			s.setConversationStatusLocked(c, ConversationStatusClosed, "", "idle timeout", now)
+		}
+	}
 }
 
 func (s *Store) RegisterAgent(input RegisterAgentInput) (*Agent, error) {
@@ -357,6 +556,19 @@ func (s *Store) RegisterAgent(input RegisterAgentInput) (*Agent, error) {
 	if ttl <= 0 {
 		ttl = int(s.cfg.DefaultRegistrationTTL.Seconds())
 	}
+	for i, c := range input.Capabilities {
+		if err := validateCapability(c); err != nil {
+			return nil, newError(CodeValidation, fmt.Sprintf("capabilities[%d]: %v", i, err), false, 0)
+		}
+	}
+	schemas, err := normalizeSchemas(input.Schemas, input.Capabilities)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := normalizeMetadata(input.Metadata)
+	if err != nil {
+		return nil, err
+	}
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -372,15 +584,23 @@ func (s *Store) RegisterAgent(input RegisterAgentInput) (*Agent, error) {
 		RegisteredAt: now,
 		ExpiresAt:    now.Add(time.Duration(ttl) * time.Second),
 		TTLSeconds:   ttl,
+		Schemas:      schemas,
+		Metadata:     metadata,
 	}
 	if existing, ok := s.agents[agentID]; ok {
 		agent.RegisteredAt = existing.RegisteredAt
+		// Re-registering replaces the declaration, not the last health
+		// report.
+		agent.Health = existing.Health
 	}
 	s.agents[agentID] = agent
 	if _, ok := s.inboxes[agentID]; !ok {
 		s.inboxes[agentID] = []InboxEvent{}
 		s.inboxBase[agentID] = 0
 	}
+	// A returning agent, or one with a higher max_in_flight, takes held
+	// requests now.
+	s.releaseHeldLocked(agentID, now)
 
 	s.publishLocked(
 		ObserveAgentRegistered,
@@ -398,9 +618,100 @@ func (s *Store) RegisterAgent(input RegisterAgentInput) (*Agent, error) {
 	return &cp, nil
 }
 
-func (s *Store) ListAgents(capability string) []Agent {
+// DeregisterAgent marks an agent as gone. Requests it had not yet accepted
+// are rerouted to another healthy agent offering their capability, or fail
+// with unavailable; requests it is executing stay with it so it can finish
+// them.
+func (s *Store) DeregisterAgent(input DeregisterAgentInput) (*DeregisterResult, error) {
 	now := s.now()
-	capability = strings.TrimSpace(capability)
+	agentID := strings.TrimSpace(input.AgentID)
+	reason := strings.TrimSpace(input.Reason)
+	if agentID == "" {
+		return nil, newError(CodeValidation, "agent_id is required", false, 0)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweepLocked(now)
+
+	agent, ok := s.agents[agentID]
+	if !ok {
+		return nil, newError(CodeNotFound, "agent not registered", false, 0)
+	}
+	agent.Status = AgentStatusDeregistered
+	agent.ExpiresAt = now
+
+	out := &DeregisterResult{Rerouted: []ReroutedMessage{}, Failed: []string{}}
+	ids := make([]string, 0)
+	for id, m := range s.messages {
+		if m.To == agentID && m.Type == MessageTypeRequest && (m.State == StatePending || m.State == StateWaitingAck) {
+			ids = append(ids, id)
+		}
+	}
+	sortMessageIDs(ids)
+	for _, id := range ids {
+		m := s.messages[id]
+		var next *Agent
+		if m.Capability != "" {
+			if q, err := parseCapabilityQuery(m.Capability); err == nil {
+				next, _ = s.routeLocked(q)
+			}
+		}
+		if next == nil {
+			m.QueuedForAgent = false
+			m.Result = &Result{Body: "target agent deregistered", ErrorCode: CodeUnavailable}
+			s.transitionLocked(m, StateTransition{To: StateError, Reason: "target agent deregistered"}, now)
+			out.Failed = append(out.Failed, id)
+			continue
+		}
+		reason := "rerouted from deregistered agent " + agentID
+		hold := s.mustHoldLocked(next)
+		m.To = next.AgentID
+		m.QueuedForAgent = false
+		m.GraceUntil = time.Time{}
+		if hold {
+			m.DeliveredAt = time.Time{}
+			m.HeldForCapacity = true
+			s.transitionLocked(m, StateTransition{To: StatePending, Reason: reason + "; held for capacity"}, now)
+		} else {
+			s.deliverLocked(m, next, reason, now)
+		}
+		s.indexMessageLocked(m)
+		out.Rerouted = append(out.Rerouted, ReroutedMessage{MessageID: id, To: next.AgentID})
+	}
+
+	s.publishLocked(
+		ObserveAgentDeregistered,
+		map[string]any{
+			"agent_id": agentID,
+			"reason":   reason,
+			"rerouted": out.Rerouted,
+			"failed":   out.Failed,
+			"at":       now,
+		},
+		"",
+		[]string{agentID},
+		now,
+	)
+
+	out.Agent = *agent
+	return out, nil
+}
+
+func (s *Store) ListAgents(filter ListAgentsFilter) ([]Agent, string, error) {
+	now := s.now()
+	var capability *capabilityQuery
+	if raw := strings.TrimSpace(filter.Capability); raw != "" {
+		q, err := parseCapabilityQuery(raw)
+		if err != nil {
+			return nil, "", err
+		}
+		capability = &q
+	}
+	page, err := newPageRequest(filter.Sort, filter.Order, filter.Cursor, filter.Limit, SortAgentID, SortRegisteredAt, SortExpiresAt)
+	if err != nil {
+		return nil, "", err
+	}
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -408,26 +719,33 @@ func (s *Store) ListAgents(capability string) []Agent {
 
 	out := []Agent{}
 	for _, a := range s.agents {
-		if a.Status != AgentStatusActive {
+		if a.Status != AgentStatusActive && !filter.IncludeExpired {
 			continue
 		}
-		if capability != "" {
-			found := false
-			for _, c := range a.Capabilities {
-				if c == capability {
-					found = true
-					break
-				}
-			}
-			if !found {
+		if !inTimeRange(a.RegisteredAt, filter.RegisteredAfter, filter.RegisteredBefore) {
+			continue
+		}
+		if capability != nil {
+			if _, _, ok := capability.best(a.Capabilities); !ok {
 				continue
 			}
 		}
+		if !labelsMatch(a.Metadata.Labels, filter.Labels) {
+			continue
+		}
 		cp := *a
 		out = append(out, cp)
 	}
-	sort.Slice(out, func(i, j int) bool { return out[i].AgentID < out[j].AgentID })
-	return out
+	out, next := paginate(out, func(a Agent) pageKey {
+		switch page.sort {
+		case SortRegisteredAt:
+			return timeKey(a.RegisteredAt, a.AgentID)
+		case SortExpiresAt:
+			return timeKey(a.ExpiresAt, a.AgentID)
+		}
+		return pageKey{ID: a.AgentID}
+	}, page)
+	return out, next, nil
 }
 
 func (s *Store) CreateConversation(input CreateConversationInput) (*Conversation, error) {
@@ -435,15 +753,279 @@ func (s *Store) CreateConversation(input CreateConversationInput) (*Conversation
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	s.sweepLocked(now)
+	if existing, ok := s.conversations[strings.TrimSpace(input.ConversationID)]; ok {
+		// Creating is idempotent, but only for the same fields; a create that
+		// would change an existing conversation must go through
+		// UpdateConversation instead of being silently dropped.
+		if field := conversationCreateConflict(existing, input); field != "" {
+			return nil, newError(CodeRejected, "conversation already exists with a different "+field+"; update it instead", false, 0)
+		}
+	}
 	c := s.ensureConversationLocked(input, now)
 	cp := *c
 	return &cp, nil
 }
 
-func (s *Store) ListConversations(filter ListConversationsFilter) []Conversation {
+// conversationCreateConflict names the first field supplied in input that
+// differs from the existing conversation, or returns "".
+func conversationCreateConflict(c *Conversation, input CreateConversationInput) string {
+	if title := strings.TrimSpace(input.Title); title != "" && title != c.Title {
+		return "title"
+	}
+	if input.Participants != nil && !reflect.DeepEqual(input.Participants, c.Participants) {
+		return "participants"
+	}
+	if input.Meta != nil && !reflect.DeepEqual(input.Meta, c.Meta) {
+		return "meta"
+	}
+	if input.Tags != nil && !reflect.DeepEqual(normalizeTags(input.Tags), c.Tags) {
+		return "tags"
+	}
+	return ""
+}
+
+// UpdateConversation changes the title, meta or tags of an existing
+// conversation. Archived conversations are read-only until reopened.
+func (s *Store) UpdateConversation(input UpdateConversationInput) (*Conversation, error) {
+	now := s.now()
+	id := strings.TrimSpace(input.ConversationID)
+	if id == "" {
+		return nil, newError(CodeValidation, "conversation_id is required", false, 0)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweepLocked(now)
+
+	c, ok := s.conversations[id]
+	if !ok {
+		return nil, newError(CodeNotFound, "conversation not found", false, 0)
+	}
+	if c.Status == ConversationStatusArchived {
+		return nil, newError(CodeRejected, "conversation is archived; reopen it before updating", false, 0)
+	}
+
+	changed := []string{}
+	if input.Title != nil {
+		if title := strings.TrimSpace(*input.Title); title != c.Title {
+			c.Title = title
+			changed = append(changed, "title")
+		}
+	}
+	if input.Meta != nil {
+		var meta any = mergeMetaPatch(c.Meta, input.Meta)
+		if len(meta.(map[string]any)) == 0 {
+			meta = nil
+		}
+		if !reflect.DeepEqual(meta, c.Meta) {
+			c.Meta = meta
+			changed = append(changed, "meta")
+		}
+	}
+	if input.Tags != nil || len(input.AddTags) > 0 || len(input.RemoveTags) > 0 {
+		tags := c.Tags
+		if input.Tags != nil {
+			tags = input.Tags
+		}
+		tags = append(append([]string{}, tags...), input.AddTags...)
+		remove := map[string]bool{}
+		for _, tag := range input.RemoveTags {
+			remove[strings.TrimSpace(tag)] = true
+		}
+		kept := tags[:0]
+		for _, tag := range tags {
+			if !remove[strings.TrimSpace(tag)] {
+				kept = append(kept, tag)
+			}
+		}
+		if tags = normalizeTags(kept); !reflect.DeepEqual(tags, c.Tags) {
+			c.Tags = tags
+			changed = append(changed, "tags")
+		}
+	}
+
+	if len(changed) > 0 {
+		if s.trackChanges {
+			s.changedConversations[c.ConversationID] = struct{}{}
+		}
+		s.indexConversationLocked(c)
+		data := map[string]any{
+			"conversation_id": c.ConversationID,
+			"changed":         changed,
+			"at":              now,
+		}
+		actor := strings.TrimSpace(input.Actor)
+		if actor != "" {
+			data["actor"] = actor
+		}
+		s.publishLocked(ObserveConversationUpdated, data, c.ConversationID, c.Participants, now)
+		s.recordConversationEventLocked(c.ConversationID, ConversationEvent{Type: ObserveConversationUpdated, At: now, Actor: actor, Changed: changed})
+	}
+	cp := *c
+	return &cp, nil
+}
+
+// mergeMetaPatch applies patch to target as a JSON merge patch. It builds new
+// maps rather than editing target, since callers may still hold the old meta.
+func mergeMetaPatch(target any, patch map[string]any) map[string]any {
+	base, _ := target.(map[string]any)
+	out := make(map[string]any, len(base)+len(patch))
+	for k, v := range base {
+		out[k] = v
+	}
+	for k, v := range patch {
+		switch v := v.(type) {
+		case nil:
+			delete(out, k)
+		case map[string]any:
+			out[k] = mergeMetaPatch(out[k], v)
+		default:
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// normalizeTags trims, dedupes and sorts tags, dropping empty ones. It
+// returns nil for an empty set.
+func normalizeTags(tags []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// conversationMatches reports whether c has every tag in tags and, when
+// metaKey is set, that meta key (with value metaValue if that is set too).
+func conversationMatches(c *Conversation, tags []string, metaKey, metaValue string) bool {
+	for _, tag := range tags {
+		if tag = strings.TrimSpace(tag); tag == "" {
+			continue
+		}
+		i := sort.SearchStrings(c.Tags, tag)
+		if i == len(c.Tags) || c.Tags[i] != tag {
+			return false
+		}
+	}
+	if metaKey == "" {
+		return true
+	}
+	meta, _ := c.Meta.(map[string]any)
+	v, ok := meta[metaKey]
+	if !ok {
+		return false
+	}
+	if metaValue == "" {
+		return true
+	}
+	if str, isString := v.(string); isString {
+		return str == metaValue
+	}
+	blob, err := json.Marshal(v)
+	return err == nil && string(blob) == metaValue
+}
+
+// SetConversationStatus moves a conversation along its lifecycle: active to
+// closed, closed to archived, and closed or archived back to active. Setting
+// the current status again is a no-op.
+func (s *Store) SetConversationStatus(input ConversationStatusInput) (*Conversation, error) {
+	now := s.now()
+	id := strings.TrimSpace(input.ConversationID)
+	to := strings.TrimSpace(input.Status)
+	if id == "" {
+		return nil, newError(CodeValidation, "conversation_id is required", false, 0)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweepLocked(now)
+
+	c, ok := s.conversations[id]
+	if !ok {
+		return nil, newError(CodeNotFound, "conversation not found", false, 0)
+	}
+	if c.Status != to {
+		switch to {
+		case ConversationStatusActive:
+		case ConversationStatusClosed:
+			if c.Status != ConversationStatusActive {
+				return nil, newError(CodeRejected, "only active conversations can be closed", false, 0)
+			}
+		case ConversationStatusArchived:
+			if c.Status != ConversationStatusClosed {
+				return nil, newError(CodeRejected, "conversation must be closed before it is archived", false, 0)
+			}
+		default:
+			return nil, newError(CodeValidation, "status must be active, closed, or archived", false, 0)
+		}
+		s.setConversationStatusLocked(c, to, strings.TrimSpace(input.Actor), strings.TrimSpace(input.Reason), now)
+	}
+	cp := *c
+	return &cp, nil
+}
+
+func (s *Store) setConversationStatusLocked(c *Conversation, to, actor, reason string, now time.Time) {
+	from := c.Status
+	c.Status = to
+	c.StatusChangedAt = &now
+	c.StatusReason = reason
+	if s.trackChanges {
+		s.changedConversations[c.ConversationID] = struct{}{}
+	}
+	data := map[string]any{
+		"conversation_id": c.ConversationID,
+		"from_status":     from,
+		"to_status":       to,
+		"at":              now,
+	}
+	if actor != "" {
+		data["actor"] = actor
+	}
+	if reason != "" {
+		data["reason"] = reason
+	}
+	s.publishLocked(ObserveConversationStatus, data, c.ConversationID, c.Participants, now)
+	s.recordConversationEventLocked(c.ConversationID, ConversationEvent{
+		Type: ObserveConversationStatus, At: now, Actor: actor, FromStatus: from, ToStatus: to, Reason: reason,
+	})
+}
+
+// checkConversationOpenLocked rejects sends into an existing conversation
+// that is closed or archived. Unknown IDs are fine; the send creates them.
+func (s *Store) checkConversationOpenLocked(conversationID string) error {
+	c, ok := s.conversations[strings.TrimSpace(conversationID)]
+	if !ok || c.Status == ConversationStatusActive {
+		return nil
+	}
+	return newError(CodeRejected, "conversation is "+c.Status+"; reopen it before sending", false, 0)
+}
+
+func (s *Store) hasOpenRequestsLocked(conversationID string) bool {
+	for _, id := range s.conversationMessages[conversationID] {
+		if m, ok := s.messages[id]; ok && (m.State == StateScheduled || (m.Type == MessageTypeRequest && !isTerminal(m.State))) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) ListConversations(filter ListConversationsFilter) ([]Conversation, string, error) {
 	now := s.now()
 	participant := strings.TrimSpace(filter.Participant)
 	status := strings.TrimSpace(filter.Status)
+	metaKey := strings.TrimSpace(filter.MetaKey)
+	page, err := newPageRequest(filter.Sort, filter.Order, filter.Cursor, filter.Limit, SortCreatedAt, SortLastMessageAt)
+	if err != nil {
+		return nil, "", err
+	}
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -466,186 +1048,576 @@ func (s *Store) ListConversations(filter ListConversationsFilter) []Conversation
 				continue
 			}
 		}
-		cp := *c
-		out = append(out, cp)
+		if !conversationMatches(c, filter.Tags, metaKey, filter.MetaValue) {
+			continue
+		}
+		if !inTimeRange(c.CreatedAt, filter.CreatedAfter, filter.CreatedBefore) ||
+			!inTimeRange(c.LastMessageAt, filter.LastMessageAfter, filter.LastMessageBefore) {
+			continue
+		}
+		cp := *c
+		out = append(out, cp)
+	}
+	out, next := paginate(out, func(c Conversation) pageKey {
+		if page.sort == SortLastMessageAt {
+			return timeKey(c.LastMessageAt, c.ConversationID)
+		}
+		return timeKey(c.CreatedAt, c.ConversationID)
+	}, page)
+	return out, next, nil
+}
+
+// sendPlan is a SendMessage input that has passed validation against the
+// current store state. Applying it cannot fail, which is what lets batches
+// validate every item before mutating anything.
+type sendPlan struct {
+	to         string
+	from       string
+	requestID  string
+	body       string
+	msgType    MessageType
+	ttl        int
+	input      SendMessageInput
+	target     *Agent
+	key        string
+	duplicate  *Message
+	queued     bool
+	graceUntil time.Time
+	inReplyTo  *Message
+	capability string
+	priority   MessagePriority
+	deliverAt  time.Time
+	recipients []*Agent
+}
+
+// planSendLocked validates input against the store and the sends already in
+// planned.
+func (s *Store) planSendLocked(input SendMessageInput, planned *sendLoad, now time.Time) (*sendPlan, error) {
+	p := &sendPlan{
+		to:        strings.TrimSpace(input.To),
+		from:      strings.TrimSpace(input.From),
+		requestID: strings.TrimSpace(input.RequestID),
+		body:      strings.TrimSpace(input.Body),
+		msgType:   input.Type,
+		ttl:       input.TTLSeconds,
+		input:     input,
+	}
+	if p.from == "" {
+		return nil, newError(CodeValidation, "from is required", false, 0)
+	}
+	if p.requestID == "" {
+		return nil, newError(CodeValidation, "request_id is required", false, 0)
+	}
+	if p.body == "" {
+		return nil, newError(CodeValidation, "body is required", false, 0)
+	}
+	if p.msgType == "" {
+		p.msgType = MessageTypeRequest
+	}
+	if p.msgType != MessageTypeRequest && p.msgType != MessageTypeResponse && p.msgType != MessageTypeInform {
+		return nil, newError(CodeValidation, "type must be request, response, or inform", false, 0)
+	}
+	p.priority = MessagePriority(strings.TrimSpace(string(input.Priority)))
+	if p.priority == "" {
+		p.priority = PriorityNormal
+	}
+	if _, ok := priorityRank[p.priority]; !ok {
+		return nil, newError(CodeValidation, "priority must be low, normal, high, or urgent", false, 0)
+	}
+	if input.DelaySeconds < 0 {
+		return nil, newError(CodeValidation, "delay_seconds must be >= 0", false, 0)
+	}
+	if input.DelaySeconds > 0 && !input.DeliverAt.IsZero() {
+		return nil, newError(CodeValidation, "deliver_at and delay_seconds cannot both be set", false, 0)
+	}
+	switch {
+	case input.DelaySeconds > 0:
+		p.deliverAt = now.Add(time.Duration(input.DelaySeconds) * time.Second)
+	case input.DeliverAt.After(now):
+		p.deliverAt = input.DeliverAt.UTC()
+	}
+	if !p.deliverAt.IsZero() && input.CompleteRequest {
+		return nil, newError(CodeValidation, "complete_request cannot be used with a scheduled delivery", false, 0)
+	}
+	if len(input.Recipients) > 0 {
+		return s.planMulticastLocked(p, planned, now)
+	}
+	var capability *capabilityQuery
+	if raw := strings.TrimSpace(input.Capability); raw != "" && p.msgType == MessageTypeRequest {
+		q, err := parseCapabilityQuery(raw)
+		if err != nil {
+			return nil, err
+		}
+		capability = &q
+	}
+	if p.to == "" && capability == nil {
+		return nil, newError(CodeValidation, "to is required unless a request names a capability", false, 0)
+	}
+	if p.ttl <= 0 {
+		p.ttl = int(s.cfg.DefaultMessageTTL.Seconds())
+	}
+
+	sender, ok := s.agents[p.from]
+	if !ok || sender.Status != AgentStatusActive {
+		return nil, newError(CodeUnauthorized, "sender is not registered/active", false, 0)
+	}
+	if err := s.checkBlobAccessLocked(p.from, input.Attachments); err != nil {
+		return nil, err
+	}
+
+	var target *Agent
+	if p.to == "" {
+		// A retried capability-addressed send must find the original even
+		// if routing would now pick another agent, so it dedupes on the
+		// capability query rather than the target.
+		p.key = dedupeKey(p.from, "@"+capability.raw, p.requestID)
+		if existing := s.idempotentMessageLocked(p.key, now); existing != nil {
+			p.duplicate = existing
+			return p, nil
+		}
+		routed, err := s.routeLocked(*capability)
+		if err != nil {
+			return nil, err
+		}
+		target, p.to = routed, routed.AgentID
+	} else {
+		target, ok = s.agents[p.to]
+		if !ok {
+			return nil, newError(CodeNotFound, "target agent not registered", false, 0)
+		}
+		p.key = dedupeKey(p.from, p.to, p.requestID)
+		if existing := s.idempotentMessageLocked(p.key, now); existing != nil {
+			p.duplicate = existing
+			return p, nil
+		}
+	}
+	p.target = target
+
+	if id := strings.TrimSpace(input.InReplyTo); id != "" {
+		orig, ok := s.messages[id]
+		if !ok {
+			return nil, newError(CodeValidation, "in_reply_to references an unknown message", false, 0)
+		}
+		if orig.Type != MessageTypeRequest || orig.To != p.from {
+			return nil, newError(CodeValidation, "in_reply_to must reference a request sent to the sender", false, 0)
+		}
+		p.inReplyTo = orig
+	} else if input.CompleteRequest {
+		return nil, newError(CodeValidation, "complete_request requires in_reply_to", false, 0)
+	}
+
+	if p.msgType == MessageTypeRequest {
+		// Requests record the capability of the target they are for, as
+		// the target declared it.
+		if capability != nil {
+			resolved, _, ok := capability.best(target.Capabilities)
+			if !ok {
+				return nil, newError(CodeValidation, "target agent does not offer capability "+capability.raw, false, 0)
+			}
+			p.capability = resolved
+		}
+		p.capability = schemaCapability(target, p.capability)
+	} else if p.inReplyTo != nil {
+		p.capability = p.inReplyTo.Capability
+	}
+	switch {
+	case p.msgType == MessageTypeRequest:
+		if err := s.validateSchemaLocked(target, p.capability, schemaRequest, p.body, input.Meta); err != nil {
+			return nil, err
+		}
+	case p.msgType == MessageTypeResponse && p.inReplyTo != nil:
+		if err := s.validateSchemaLocked(sender, p.inReplyTo.Capability, schemaResponse, p.body, input.Meta); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.checkConversationOpenLocked(input.ConversationID); err != nil {
+		return nil, err
+	}
+
+	if target.Status == AgentStatusDeregistered {
+		return nil, newError(CodeNotFound, "target agent deregistered", false, 0)
+	}
+	if !p.deliverAt.IsZero() {
+		// Whether the target can take it is decided at delivery.
+		return p, nil
+	}
+	if target.Status == AgentStatusExpired {
+		graceUntil := target.ExpiresAt.Add(s.cfg.GracePeriod)
+		if now.After(graceUntil) {
+			return nil, newError(CodeNotFound, "target agent expired beyond grace period", false, 0)
+		}
+		p.queued = true
+		p.graceUntil = graceUntil
+		return p, nil
+	}
+	if err := s.backpressureLocked(target, p.msgType == MessageTypeRequest, p.key, planned); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (s *Store) idempotentMessageLocked(key string, now time.Time) *Message {
+	dedup, ok := s.idempotency[key]
+	if !ok || now.Sub(dedup.CreatedAt) > s.cfg.IdempotencyWindow {
+		return nil
+	}
+	return s.messages[dedup.MessageID]
+}
+
+func (s *Store) applySendLocked(p *sendPlan, now time.Time) (*Message, bool) {
+	if p.duplicate == nil {
+		// An earlier item in the same batch may have used this request_id.
+		p.duplicate = s.idempotentMessageLocked(p.key, now)
+	}
+	if p.duplicate != nil {
+		cp := *p.duplicate
+		return &cp, true
+	}
+	if p.recipients != nil {
+		return s.applyMulticastLocked(p, now), false
+	}
+
+	conv := s.ensureConversationLocked(CreateConversationInput{
+		ConversationID: p.input.ConversationID,
+		Participants:   []string{p.from, p.to},
+	}, now)
+
+	s.nextMessageID++
+	mid := fmt.Sprintf("m-%06d", s.nextMessageID)
+	m := &Message{
+		MessageID:      mid,
+		Type:           p.msgType,
+		From:           p.from,
+		To:             p.to,
+		ConversationID: conv.ConversationID,
+		RequestID:      p.requestID,
+		InReplyTo:      strings.TrimSpace(p.input.InReplyTo),
+		Capability:     p.capability,
+		Priority:       p.priority,
+		Body:           p.body,
+		Meta:           p.input.Meta,
+		Attachments:    append([]Attachment{}, p.input.Attachments...),
+		State:          StatePending,
+		TraceParent:    strings.TrimSpace(p.input.TraceParent),
+		CreatedAt:      now,
+		TTLExpiresAt:   now.Add(time.Duration(p.ttl) * time.Second),
+	}
+
+	if p.msgType != MessageTypeRequest {
+		m.State = StateCompleted
+	}
+
+	target := p.target
+	pushCallbackURL := ""
+	pushPayload := map[string]any(nil)
+
+	if !p.deliverAt.IsZero() {
+		deliverAt := p.deliverAt
+		m.State = StateScheduled
+		m.DeliverAt = &deliverAt
+		m.TTLExpiresAt = deliverAt.Add(time.Duration(p.ttl) * time.Second)
+	} else if p.queued {
+		m.QueuedForAgent = true
+		m.GraceUntil = p.graceUntil
+	} else if p.msgType == MessageTypeRequest && s.mustHoldLocked(target) {
+		m.HeldForCapacity = true
+	} else if p.msgType == MessageTypeRequest {
+		m.State = StateWaitingAck
+		m.DeliveredAt = now
+		if target.Mode == AgentModePush && strings.TrimSpace(target.CallbackURL) != "" {
+			pushCallbackURL = strings.TrimSpace(target.CallbackURL)
+			pushPayload = pushPayloadFor(m)
+		}
+		s.appendInboxLocked(p.to, inboxEventFor(m))
+	} else {
+		if target.Mode == AgentModePush && strings.TrimSpace(target.CallbackURL) != "" {
+			pushCallbackURL = strings.TrimSpace(target.CallbackURL)
+			pushPayload = pushPayloadFor(m)
+		}
+		s.appendInboxLocked(p.to, inboxEventFor(m))
+	}
+
+	s.messages[mid] = m
+	s.indexReplyLocked(m)
+	s.indexMessageLocked(m)
+	s.indexBlobsLocked(m)
+	s.conversationMessages[conv.ConversationID] = append(s.conversationMessages[conv.ConversationID], mid)
+	conv.MessageCount = len(s.conversationMessages[conv.ConversationID])
+	conv.LastMessageAt = now
+	if conv.Status == "" {
+		conv.Status = ConversationStatusActive
+	}
+	s.idempotency[p.key] = idempotencyEntry{MessageID: mid, CreatedAt: now}
+
+	observed := map[string]any{
+		"message_id":      m.MessageID,
+		"type":            m.Type,
+		"from":            m.From,
+		"to":              m.To,
+		"conversation_id": m.ConversationID,
+		"body":            m.Body,
+		"created_at":      m.CreatedAt,
+	}
+	if m.DeliverAt != nil {
+		observed["deliver_at"] = *m.DeliverAt
+	}
+	s.publishLocked(ObserveMessage, observed, m.ConversationID, []string{m.From, m.To}, now)
+
+	if orig := p.inReplyTo; orig != nil && p.input.CompleteRequest && !isTerminal(orig.State) {
+		orig.Result = &Result{Body: m.Body, Meta: m.Meta, Attachments: append([]Attachment{}, m.Attachments...)}
+		s.transitionLocked(orig, StateTransition{To: StateCompleted, Actor: m.From, Reason: "completed by reply " + m.MessageID, Body: m.Body, Meta: m.Meta}, now)
+	}
+
+	if pushCallbackURL != "" && pushPayload != nil {
+		s.pushLocked(p.to, pushCallbackURL, pushPayload)
+	}
+
+	cp := *m
+	return &cp, false
+}
+
+func (s *Store) SendMessage(input SendMessageInput) (*Message, bool, error) {
+	m, dup, err := s.sendMessage(input)
+	if err != nil {
+		return nil, false, err
+	}
+	return s.awaitResult(m, input.Wait), dup, nil
+}
+
+// sendMessage is SendMessage without the wait, so the persisting backends can
+// save the new message before blocking on its result.
+func (s *Store) sendMessage(input SendMessageInput) (*Message, bool, error) {
+	atts, err := s.verifyAttachments(input.Attachments)
+	if err != nil {
+		return nil, false, err
+	}
+	input.Attachments = atts
+
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweepLocked(now)
+
+	plan, err := s.planSendLocked(input, newSendLoad(), now)
+	if err != nil {
+		return nil, false, err
+	}
+	m, dup := s.applySendLocked(plan, now)
+	return m, dup, nil
+}
+
+// awaitResult polls a sent request until it reaches a terminal state or wait
+// (capped at InboxWaitMax) elapses, and returns its latest copy.
+func (s *Store) awaitResult(m *Message, wait time.Duration) *Message {
+	m, _ = s.await(m, wait, false)
+	return m
+}
+
+// await polls a sent request until it reaches a terminal state or, with
+// withReply, a response naming it in in_reply_to arrives. wait is capped at
+// InboxWaitMax. It returns the latest copy of the request and the reply, if
+// any.
+func (s *Store) await(m *Message, wait time.Duration, withReply bool) (*Message, *Message) {
+	if wait <= 0 || m.Type != MessageTypeRequest {
+		return m, nil
+	}
+	if wait > s.cfg.InboxWaitMax {
+		wait = s.cfg.InboxWaitMax
+	}
+	deadline := s.now().Add(wait)
+	for {
+		now := s.now()
+		s.mu.Lock()
+		s.sweepLocked(now)
+		cur, ok := s.messages[m.MessageID]
+		if ok {
+			cp := *cur
+			m = &cp
+		}
+		var reply *Message
+		if withReply {
+			reply = s.replyToLocked(m.MessageID)
+		}
+		s.mu.Unlock()
+
+		if !ok || reply != nil || isTerminal(m.State) || s.now().After(deadline) {
+			return m, reply
+		}
+		time.Sleep(100 * time.Millisecond)
 	}
-	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
-	return out
 }
 
-func (s *Store) SendMessage(input SendMessageInput) (*Message, bool, error) {
-	now := s.now()
-	to := strings.TrimSpace(input.To)
-	from := strings.TrimSpace(input.From)
-	requestID := strings.TrimSpace(input.RequestID)
-	body := strings.TrimSpace(input.Body)
-	if to == "" {
-		return nil, false, newError(CodeValidation, "to is required", false, 0)
-	}
-	if from == "" {
-		return nil, false, newError(CodeValidation, "from is required", false, 0)
-	}
-	if requestID == "" {
-		return nil, false, newError(CodeValidation, "request_id is required", false, 0)
+// replyToLocked returns a copy of the earliest response whose in_reply_to is
+// messageID, or nil.
+func (s *Store) replyToLocked(messageID string) *Message {
+	for _, id := range s.replies[messageID] {
+		if m, ok := s.messages[id]; ok && m.Type == MessageTypeResponse {
+			cp := *m
+			return &cp
+		}
 	}
-	if body == "" {
-		return nil, false, newError(CodeValidation, "body is required", false, 0)
+	return nil
+}
+
+// indexReplyLocked records m against the request it answers.
+func (s *Store) indexReplyLocked(m *Message) {
+	if m.InReplyTo != "" {
+		s.replies[m.InReplyTo] = append(s.replies[m.InReplyTo], m.MessageID)
 	}
-	msgType := input.Type
-	if msgType == "" {
-		msgType = MessageTypeRequest
+}
+
+// reindexRepliesLocked rebuilds the reply index after messages are loaded
+// from a backend, in message ID (send) order.
+func (s *Store) reindexRepliesLocked() {
+	s.replies = map[string][]string{}
+	ids := make([]string, 0, len(s.messages))
+	for id, m := range s.messages {
+		if m.InReplyTo != "" {
+			ids = append(ids, id)
+		}
 	}
-	if msgType != MessageTypeRequest && msgType != MessageTypeResponse && msgType != MessageTypeInform {
-		return nil, false, newError(CodeValidation, "type must be request, response, or inform", false, 0)
+	sortMessageIDs(ids)
+	for _, id := range ids {
+		s.indexReplyLocked(s.messages[id])
 	}
+}
 
-	ttl := input.TTLSeconds
-	if ttl <= 0 {
-		ttl = int(s.cfg.DefaultMessageTTL.Seconds())
+// withRepliesLocked returns a copy of m listing the messages sent in reply
+// to it.
+func (s *Store) withRepliesLocked(m *Message) Message {
+	cp := *m
+	if ids := s.replies[m.MessageID]; len(ids) > 0 {
+		cp.Replies = append([]string{}, ids...)
 	}
+	return cp
+}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.sweepLocked(now)
-
-	sender, ok := s.agents[from]
-	if !ok || sender.Status != AgentStatusActive {
-		return nil, false, newError(CodeUnauthorized, "sender is not registered/active", false, 0)
+// Call sends a request and waits up to input.Wait (capped at InboxWaitMax)
+// for it to finish or be answered. Retrying with the same request_id waits on
+// the original request.
+func (s *Store) Call(input SendMessageInput) (*CallResult, error) {
+	m, dup, err := s.sendCall(input)
+	if err != nil {
+		return nil, err
 	}
+	return s.awaitCall(m, dup, input.Wait), nil
+}
 
-	target, ok := s.agents[to]
-	if !ok {
-		return nil, false, newError(CodeNotFound, "target agent not registered", false, 0)
+func (s *Store) sendCall(input SendMessageInput) (*Message, bool, error) {
+	if input.Type == "" {
+		input.Type = MessageTypeRequest
 	}
-
-	key := dedupeKey(from, to, requestID)
-	if dedup, ok := s.idempotency[key]; ok && now.Sub(dedup.CreatedAt) <= s.cfg.IdempotencyWindow {
-		if existing, ok := s.messages[dedup.MessageID]; ok {
-			cp := *existing
-			return &cp, true, nil
-		}
+	if input.Type != MessageTypeRequest {
+		return nil, false, newError(CodeValidation, "calls must send a request", false, 0)
 	}
+	return s.sendMessage(input)
+}
 
-	conv := s.ensureConversationLocked(CreateConversationInput{
-		ConversationID: input.ConversationID,
-		Participants:   []string{from, to},
-	}, now)
+func (s *Store) awaitCall(m *Message, dup bool, wait time.Duration) *CallResult {
+	if wait <= 0 {
+		wait = s.cfg.InboxWaitMax
+	}
+	req, reply := s.await(m, wait, true)
+	return &CallResult{
+		Request:   req,
+		Reply:     reply,
+		Duplicate: dup,
+		TimedOut:  reply == nil && !isTerminal(req.State),
+	}
+}
 
-	s.nextMessageID++
-	mid := fmt.Sprintf("m-%06d", s.nextMessageID)
-	m := &Message{
-		MessageID:      mid,
-		Type:           msgType,
-		From:           from,
-		To:             to,
-		ConversationID: conv.ConversationID,
-		RequestID:      requestID,
-		InReplyTo:      strings.TrimSpace(input.InReplyTo),
-		Body:           body,
-		Meta:           input.Meta,
-		Attachments:    append([]Attachment{}, input.Attachments...),
-		State:          StatePending,
-		CreatedAt:      now,
-		TTLExpiresAt:   now.Add(time.Duration(ttl) * time.Second),
+func (s *Store) SendMessages(input SendMessagesInput) ([]SendMessageResult, error) {
+	if len(input.Messages) == 0 {
+		return nil, newError(CodeValidation, "messages is required", false, 0)
+	}
+	if len(input.Messages) > s.cfg.MaxBatchMessages {
+		return nil, newError(CodeValidation, fmt.Sprintf("at most %d messages per batch", s.cfg.MaxBatchMessages), false, 0)
 	}
 
-	if msgType != MessageTypeRequest {
-		m.State = StateCompleted
+	items := append([]SendMessageInput{}, input.Messages...)
+	verifyErrs := make([]error, len(items))
+	for i := range items {
+		items[i].Attachments, verifyErrs[i] = s.verifyAttachments(items[i].Attachments)
 	}
 
-	pushCallbackURL := ""
-	pushPayload := map[string]any(nil)
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweepLocked(now)
 
-	if target.Status == AgentStatusExpired {
-		graceUntil := target.ExpiresAt.Add(s.cfg.GracePeriod)
-		if now.After(graceUntil) {
-			return nil, false, newError(CodeNotFound, "target agent expired beyond grace period", false, 0)
+	results := make([]SendMessageResult, len(items))
+	plans := make([]*sendPlan, len(items))
+	planned := newSendLoad()
+	var firstErr error
+	for i, item := range items {
+		err := verifyErrs[i]
+		var plan *sendPlan
+		if err == nil {
+			plan, err = s.planSendLocked(item, planned, now)
 		}
-		m.QueuedForAgent = true
-		m.GraceUntil = graceUntil
-	} else if msgType == MessageTypeRequest {
-		m.State = StateWaitingAck
-		m.DeliveredAt = now
-		if target.Mode == AgentModePush && strings.TrimSpace(target.CallbackURL) != "" {
-			pushCallbackURL = strings.TrimSpace(target.CallbackURL)
-			pushPayload = map[string]any{
-				"message_id":      m.MessageID,
-				"type":            m.Type,
-				"from":            m.From,
-				"conversation_id": m.ConversationID,
-				"body":            m.Body,
-				"meta":            m.Meta,
-				"attachments":     m.Attachments,
-				"created_at":      m.CreatedAt,
+		if err != nil {
+			results[i].Err = err
+			if firstErr == nil {
+				firstErr = batchItemError(i, err)
 			}
+			continue
 		}
-		s.appendInboxLocked(to, InboxEvent{
-			MessageID:      m.MessageID,
-			Type:           m.Type,
-			From:           m.From,
-			ConversationID: m.ConversationID,
-			Body:           m.Body,
-			Meta:           m.Meta,
-			Attachments:    append([]Attachment{}, m.Attachments...),
-			CreatedAt:      m.CreatedAt,
-		})
-	} else {
-		if target.Mode == AgentModePush && strings.TrimSpace(target.CallbackURL) != "" {
-			pushCallbackURL = strings.TrimSpace(target.CallbackURL)
-			pushPayload = map[string]any{
-				"message_id":      m.MessageID,
-				"type":            m.Type,
-				"from":            m.From,
-				"conversation_id": m.ConversationID,
-				"body":            m.Body,
-				"meta":            m.Meta,
-				"attachments":     m.Attachments,
-				"created_at":      m.CreatedAt,
-			}
+		plans[i] = plan
+	}
+	if input.Atomic && firstErr != nil {
+		return results, firstErr
+	}
+	for i, plan := range plans {
+		if plan == nil {
+			continue
 		}
-		s.appendInboxLocked(to, InboxEvent{
-			MessageID:      m.MessageID,
-			Type:           m.Type,
-			From:           m.From,
-			ConversationID: m.ConversationID,
-			Body:           m.Body,
-			Meta:           m.Meta,
-			Attachments:    append([]Attachment{}, m.Attachments...),
-			CreatedAt:      m.CreatedAt,
-		})
+		results[i].Message, results[i].Duplicate = s.applySendLocked(plan, now)
 	}
+	return results, nil
+}
 
-	s.messages[mid] = m
-	s.conversationMessages[conv.ConversationID] = append(s.conversationMessages[conv.ConversationID], mid)
-	conv.MessageCount = len(s.conversationMessages[conv.ConversationID])
-	conv.LastMessageAt = now
-	if conv.Status == "" {
-		conv.Status = "active"
+func stripVerification(atts []Attachment) []Attachment {
+	if len(atts) == 0 {
+		return atts
 	}
-	s.idempotency[key] = idempotencyEntry{MessageID: mid, CreatedAt: now}
-
-	s.publishLocked(
-		ObserveMessage,
-		map[string]any{
-			"message_id":      m.MessageID,
-			"type":            m.Type,
-			"from":            m.From,
-			"to":              m.To,
-			"conversation_id": m.ConversationID,
-			"body":            m.Body,
-			"created_at":      m.CreatedAt,
-		},
-		m.ConversationID,
-		[]string{m.From, m.To},
-		now,
-	)
+	out := make([]Attachment, len(atts))
+	for i, a := range atts {
+		a.Verification = nil
+		out[i] = a
+	}
+	return out
+}
 
-	if pushCallbackURL != "" && pushPayload != nil {
-		go s.sendPushCallback(pushCallbackURL, pushPayload)
+// verifyAttachments drops sender-supplied verification results and, when
+// Config.VerifyAttachments is set, runs it. Errors that are not already bus
+// errors are reported as validation failures.
+func (s *Store) verifyAttachments(atts []Attachment) ([]Attachment, error) {
+	if len(atts) == 0 {
+		return atts, nil
+	}
+	out := stripVerification(atts)
+	if s.cfg.VerifyAttachments == nil {
+		return out, nil
+	}
+	verified, err := s.cfg.VerifyAttachments(out)
+	if err != nil {
+		if _, ok := err.(*Error); ok {
+			return nil, err
+		}
+		return nil, newError(CodeValidation, err.Error(), false, 0)
 	}
+	return verified, nil
+}
 
-	cp := *m
-	return &cp, false, nil
+func batchItemError(index int, err error) error {
+	be, ok := err.(*Error)
+	if !ok {
+		return err
+	}
+	cp := *be
+	cp.Message = fmt.Sprintf("messages[%d]: %s", index, be.Message)
+	return &cp
 }
 
 func (s *Store) PollInbox(input PollInboxInput) ([]InboxEvent, int, error) {
@@ -684,10 +1656,19 @@ func (s *Store) PollInbox(input PollInboxInput) ([]InboxEvent, int, error) {
 		if cursor > end {
 			cursor = end
 		}
+		if cursor > s.inboxRead[agentID] {
+			s.inboxRead[agentID] = cursor
+		}
 		if cursor < end {
 			start := cursor - base
 			out := append([]InboxEvent{}, events[start:]...)
 			next := end
+			s.inboxServed[agentID] = max(s.inboxServed[agentID], next)
+			for _, evt := range out {
+				if evt.ParentID != "" {
+					s.settleCopyLocked(evt.MessageID, true, "polled", now)
+				}
+			}
 			s.mu.Unlock()
 			return out, next, nil
 		}
@@ -729,6 +1710,9 @@ func (s *Store) Ack(input AckInput) error {
 	if isTerminal(m.State) {
 		return nil
 	}
+	if m.State == StateScheduled {
+		return newError(CodeRejected, "message has not been delivered yet", false, 0)
+	}
 
 	s.publishLocked(
 		ObserveAck,
@@ -743,24 +1727,11 @@ func (s *Store) Ack(input AckInput) error {
 		now,
 	)
 
-	from := m.State
+	to := StateExecuting
 	if status == "rejected" {
-		m.State = StateRejected
-	} else {
-		m.State = StateExecuting
+		to = StateRejected
 	}
-	s.publishLocked(
-		ObserveStateChange,
-		map[string]any{
-			"message_id": m.MessageID,
-			"from_state": from,
-			"to_state":   m.State,
-			"at":         now,
-		},
-		m.ConversationID,
-		[]string{m.From, m.To},
-		now,
-	)
+	s.transitionLocked(m, StateTransition{To: to, Actor: agentID, Reason: strings.TrimSpace(input.Reason)}, now)
 	return nil
 }
 
@@ -779,6 +1750,8 @@ func (s *Store) PostEvent(input EventInput) error {
 	if typeRaw != "progress" && typeRaw != "final" && typeRaw != "error" {
 		return newError(CodeValidation, "type must be progress, final, or error", false, 0)
 	}
+	// Result attachments are not verified, so no verification is recorded.
+	input.Attachments = stripVerification(input.Attachments)
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -797,6 +1770,12 @@ func (s *Store) PostEvent(input EventInput) error {
 	if isTerminal(m.State) {
 		return nil
 	}
+	if m.State == StateScheduled {
+		return newError(CodeRejected, "message has not been delivered yet", false, 0)
+	}
+	if err := s.checkBlobAccessLocked(actor, input.Attachments); err != nil {
+		return err
+	}
 
 	switch typeRaw {
 	case "progress":
@@ -807,20 +1786,7 @@ func (s *Store) PostEvent(input EventInput) error {
 			}
 		}
 		if m.State == StateWaitingAck {
-			from := m.State
-			m.State = StateExecuting
-			s.publishLocked(
-				ObserveStateChange,
-				map[string]any{
-					"message_id": m.MessageID,
-					"from_state": from,
-					"to_state":   m.State,
-					"at":         now,
-				},
-				m.ConversationID,
-				[]string{m.From, m.To},
-				now,
-			)
+			s.transitionLocked(m, StateTransition{To: StateExecuting, Actor: actor}, now)
 		}
 		m.LastProgressAt = now
 		s.publishLocked(
@@ -835,40 +1801,22 @@ func (s *Store) PostEvent(input EventInput) error {
 			[]string{m.From, m.To},
 			now,
 		)
+		s.recordConversationEventLocked(m.ConversationID, ConversationEvent{
+			Type: ObserveProgress, At: now, Actor: actor, MessageID: m.MessageID, Body: body, Meta: input.Meta,
+		})
 	case "final":
-		from := m.State
-		m.State = StateCompleted
-		s.publishLocked(
-			ObserveStateChange,
-			map[string]any{
-				"message_id": m.MessageID,
-				"from_state": from,
-				"to_state":   m.State,
-				"at":         now,
-				"body":       body,
-				"meta":       input.Meta,
-			},
-			m.ConversationID,
-			[]string{m.From, m.To},
-			now,
-		)
+		if err := s.validateSchemaLocked(s.agents[m.To], m.Capability, schemaResponse, body, input.Meta); err != nil {
+			return err
+		}
+		m.Result = &Result{Body: body, Meta: input.Meta, Attachments: append([]Attachment{}, input.Attachments...)}
+		s.transitionLocked(m, StateTransition{To: StateCompleted, Actor: actor, Body: body, Meta: input.Meta}, now)
 	case "error":
-		from := m.State
-		m.State = StateError
-		s.publishLocked(
-			ObserveStateChange,
-			map[string]any{
-				"message_id": m.MessageID,
-				"from_state": from,
-				"to_state":   m.State,
-				"at":         now,
-				"body":       body,
-				"meta":       input.Meta,
-			},
-			m.ConversationID,
-			[]string{m.From, m.To},
-			now,
-		)
+		errorCode := strings.TrimSpace(input.ErrorCode)
+		if errorCode == "" {
+			errorCode = CodeInternal
+		}
+		m.Result = &Result{Body: body, Meta: input.Meta, Attachments: append([]Attachment{}, input.Attachments...), ErrorCode: errorCode}
+		s.transitionLocked(m, StateTransition{To: StateError, Actor: actor, Body: body, Meta: input.Meta}, now)
 	}
 
 	return nil
@@ -892,6 +1840,9 @@ func (s *Store) Inject(input InjectInput) (*Message, error) {
 	defer s.mu.Unlock()
 	s.sweepLocked(now)
 
+	if err := s.checkConversationOpenLocked(input.ConversationID); err != nil {
+		return nil, err
+	}
 	conv := s.ensureConversationLocked(CreateConversationInput{ConversationID: input.ConversationID}, now)
 
 	s.nextMessageID++
@@ -906,6 +1857,7 @@ func (s *Store) Inject(input InjectInput) (*Message, error) {
 		Body:           body,
 		Meta:           map[string]any{"identity": identity},
 		State:          StateCompleted,
+		TraceParent:    strings.TrimSpace(input.TraceParent),
 		CreatedAt:      now,
 		TTLExpiresAt:   now.Add(s.cfg.DefaultMessageTTL),
 	}
@@ -918,6 +1870,9 @@ func (s *Store) Inject(input InjectInput) (*Message, error) {
 		if !ok {
 			return nil, newError(CodeNotFound, "target agent not registered", false, 0)
 		}
+		if target.Status == AgentStatusDeregistered {
+			return nil, newError(CodeNotFound, "target agent deregistered", false, 0)
+		}
 		if target.Status == AgentStatusExpired {
 			graceUntil := target.ExpiresAt.Add(s.cfg.GracePeriod)
 			if now.After(graceUntil) {
@@ -926,32 +1881,19 @@ func (s *Store) Inject(input InjectInput) (*Message, error) {
 			m.QueuedForAgent = true
 			m.GraceUntil = graceUntil
 			m.State = StatePending
+		} else if s.inboxFullLocked(target, 0) {
+			return nil, newError(CodeUnavailable, fmt.Sprintf("agent %s has %d unread inbox events", to, s.cfg.MaxInboxEventsPerAgent), true, s.cfg.BackpressureRetryAfter)
 		} else {
 			if target.Mode == AgentModePush && strings.TrimSpace(target.CallbackURL) != "" {
 				pushCallbackURL = strings.TrimSpace(target.CallbackURL)
-				pushPayload = map[string]any{
-					"message_id":      m.MessageID,
-					"type":            m.Type,
-					"from":            m.From,
-					"conversation_id": m.ConversationID,
-					"body":            m.Body,
-					"meta":            m.Meta,
-					"created_at":      m.CreatedAt,
-				}
+				pushPayload = pushPayloadFor(m)
 			}
-			s.appendInboxLocked(to, InboxEvent{
-				MessageID:      m.MessageID,
-				Type:           m.Type,
-				From:           m.From,
-				ConversationID: m.ConversationID,
-				Body:           m.Body,
-				Meta:           m.Meta,
-				CreatedAt:      m.CreatedAt,
-			})
+			s.appendInboxLocked(to, inboxEventFor(m))
 		}
 	}
 
 	s.messages[mid] = m
+	s.indexMessageLocked(m)
 	s.conversationMessages[conv.ConversationID] = append(s.conversationMessages[conv.ConversationID], mid)
 	conv.MessageCount = len(s.conversationMessages[conv.ConversationID])
 	conv.LastMessageAt = now
@@ -987,7 +1929,7 @@ func (s *Store) Inject(input InjectInput) (*Message, error) {
 	)
 
 	if pushCallbackURL != "" && pushPayload != nil {
-		go s.sendPushCallback(pushCallbackURL, pushPayload)
+		s.pushLocked(m.To, pushCallbackURL, pushPayload)
 	}
 
 	cp := *m
@@ -1031,8 +1973,7 @@ func (s *Store) ListConversationMessages(input ListConversationMessagesInput) (s
 	out := make([]Message, 0, end-cursor)
 	for _, id := range ids[cursor:end] {
 		if m, ok := s.messages[id]; ok {
-			cp := *m
-			out = append(out, cp)
+			out = append(out, s.withRepliesLocked(m))
 		}
 	}
 	return conv.ConversationID, out, end, nil
@@ -1087,6 +2028,171 @@ func (s *Store) ObserveSince(afterID int64, filter ObserveFilter, wait time.Dura
 	}
 }
 
+func (s *Store) GetMessage(messageID string) (*MessageDetail, error) {
+	now := s.now()
+	messageID = strings.TrimSpace(messageID)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweepLocked(now)
+
+	m, ok := s.messages[messageID]
+	if !ok {
+		return nil, newError(CodeNotFound, "message not found", false, 0)
+	}
+	return &MessageDetail{
+		Message:         s.withRepliesLocked(m),
+		DeliveredAt:     optionalTime(m.DeliveredAt),
+		LastProgressAt:  optionalTime(m.LastProgressAt),
+		TTLExpiresAt:    m.TTLExpiresAt,
+		GraceUntil:      optionalTime(m.GraceUntil),
+		QueuedForAgent:  m.QueuedForAgent,
+		HeldForCapacity: m.HeldForCapacity,
+		StateHistory:    append([]StateTransition{}, s.stateHistory[messageID]...),
+		Deliveries:      s.deliveriesLocked(m),
+	}, nil
+}
+
+// transitionLocked moves m to t.To, appends the change to the message's
+// history and publishes it as a state_change observe event. The caller fills
+// in To and whichever of Actor, Reason, Body and Meta apply.
+func (s *Store) transitionLocked(m *Message, t StateTransition, now time.Time) {
+	t.From = m.State
+	t.At = now
+	m.State = t.To
+	if t.To != StatePending {
+		m.HeldForCapacity = false
+	}
+	s.stateHistory[m.MessageID] = append(s.stateHistory[m.MessageID], t)
+	if m.Result != nil {
+		s.indexBlobsLocked(m)
+	}
+	if s.trackChanges {
+		s.changedMessages[m.MessageID] = struct{}{}
+	}
+
+	data := map[string]any{
+		"message_id": m.MessageID,
+		"from_state": t.From,
+		"to_state":   t.To,
+		"at":         now,
+	}
+	if t.Actor != "" {
+		data["actor"] = t.Actor
+	}
+	if t.Reason != "" {
+		data["error"] = t.Reason
+	}
+	if t.Body != "" {
+		data["body"] = t.Body
+		data["meta"] = t.Meta
+	}
+	s.publishLocked(ObserveStateChange, data, m.ConversationID, []string{m.From, m.To}, now)
+
+	// A finished request frees a slot under its agent's max_in_flight.
+	if m.Type == MessageTypeRequest && isTerminal(t.To) && !isTerminal(t.From) {
+		s.releaseHeldLocked(m.To, now)
+	}
+}
+
+// storeChanges is what takeChangesLocked hands to an incremental backend.
+type storeChanges struct {
+	messages      []Message
+	history       map[string][]StateTransition
+	conversations []Conversation
+	// conversationHistory holds each changed conversation's full history.
+	conversationHistory map[string][]ConversationEvent
+}
+
+// takeChangesLocked returns copies of the messages and conversations whose
+// state moved since the last call, with each message's full transition
+// history and each conversation's full event history, and resets the change
+// sets.
+func (s *Store) takeChangesLocked() storeChanges {
+	out := storeChanges{history: map[string][]StateTransition{}, conversationHistory: map[string][]ConversationEvent{}}
+	for id := range s.changedMessages {
+		if m, ok := s.messages[id]; ok {
+			out.messages = append(out.messages, *m)
+			out.history[id] = append([]StateTransition{}, s.stateHistory[id]...)
+		}
+		delete(s.changedMessages, id)
+	}
+	for id := range s.changedConversations {
+		if c, ok := s.conversations[id]; ok {
+			out.conversations = append(out.conversations, *c)
+			out.conversationHistory[id] = append([]ConversationEvent{}, s.conversationHistory[id]...)
+		}
+		delete(s.changedConversations, id)
+	}
+	return out
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func (s *Store) GetAgent(agentID string) (*Agent, error) {
+	now := s.now()
+	agentID = strings.TrimSpace(agentID)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweepLocked(now)
+
+	a, ok := s.agents[agentID]
+	if !ok {
+		return nil, newError(CodeNotFound, "agent not found", false, 0)
+	}
+	cp := *a
+	return &cp, nil
+}
+
+func (s *Store) GetConversation(conversationID string) (*Conversation, error) {
+	now := s.now()
+	conversationID = strings.TrimSpace(conversationID)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweepLocked(now)
+
+	c, ok := s.conversations[conversationID]
+	if !ok {
+		return nil, newError(CodeNotFound, "conversation not found", false, 0)
+	}
+	cp := *c
+	return &cp, nil
+}
+
+// ConversationHistory returns the conversation's progress, status and update
+// events, oldest first.
+func (s *Store) ConversationHistory(conversationID string) ([]ConversationEvent, error) {
+	now := s.now()
+	conversationID = strings.TrimSpace(conversationID)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweepLocked(now)
+
+	if _, ok := s.conversations[conversationID]; !ok {
+		return nil, newError(CodeNotFound, "conversation not found", false, 0)
+	}
+	return append([]ConversationEvent{}, s.conversationHistory[conversationID]...), nil
+}
+
+// recordConversationEventLocked appends e to the conversation's history.
+func (s *Store) recordConversationEventLocked(conversationID string, e ConversationEvent) {
+	if conversationID == "" {
+		return
+	}
+	s.conversationHistory[conversationID] = append(s.conversationHistory[conversationID], e)
+	if s.trackChanges {
+		s.changedConversations[conversationID] = struct{}{}
+	}
+}
+
 func (s *Store) Health() map[string]any {
 	now := s.now()
 	s.mu.Lock()
@@ -1117,27 +2223,83 @@ func (s *Store) SystemStatus() map[string]any {
 	s.sweepLocked(now)
 	active := 0
 	expired := 0
+	deregistered := 0
 	for _, a := range s.agents {
-		if a.Status == AgentStatusActive {
+		switch a.Status {
+		case AgentStatusActive:
 			active++
-		} else {
+		case AgentStatusDeregistered:
+			deregistered++
+		default:
 			expired++
 		}
 	}
+	// Queue depth: requests held on the bus for agents at max_in_flight,
+	// and requests delivered but not yet handed over, by a poll or a push
+	// callback.
+	held := map[MessagePriority]int{PriorityUrgent: 0, PriorityHigh: 0, PriorityNormal: 0, PriorityLow: 0}
+	queued := map[MessagePriority]int{PriorityUrgent: 0, PriorityHigh: 0, PriorityNormal: 0, PriorityLow: 0}
+	count := func(depth map[MessagePriority]int, p MessagePriority) {
+		if p == "" {
+			p = PriorityNormal
+		}
+		depth[p]++
+	}
+	scheduled := 0
+	for _, m := range s.messages {
+		if m.State == StateScheduled {
+			scheduled++
+		}
+		if m.HeldForCapacity && m.State == StatePending {
+			count(held, m.Priority)
+		}
+	}
+	for agentID, events := range s.inboxes {
+		if a, ok := s.agents[agentID]; !ok || a.Mode == AgentModePush {
+			continue
+		}
+		for _, evt := range events[s.inboxServedLocked(agentID):] {
+			if evt.Type == MessageTypeRequest {
+				count(queued, evt.Priority)
+			}
+		}
+	}
+	for _, queue := range s.pushQueues {
+		for _, item := range queue {
+			if item.payload["type"] == MessageTypeRequest {
+				priority, _ := item.payload["priority"].(MessagePriority)
+				count(queued, priority)
+			}
+		}
+	}
 	return map[string]any{
 		"ok": true,
 		"system": map[string]any{
-			"agents_active":  active,
-			"agents_expired": expired,
-			"conversations":  len(s.conversations),
-			"messages":       len(s.messages),
-			"observe_events": len(s.observeEvents),
-			"push_successes": s.pushSuccesses,
-			"push_failures":  s.pushFailures,
+			"agents_active":       active,
+			"agents_expired":      expired,
+			"agents_deregistered": deregistered,
+			"conversations":       len(s.conversations),
+			"messages":            len(s.messages),
+			"observe_events":      len(s.observeEvents),
+			"push_successes":      s.pushSuccesses,
+			"push_failures":       s.pushFailures,
+			"held_requests":       held,
+			"queued_requests":     queued,
+			"scheduled_messages":  scheduled,
 		},
 	}
 }
 
+// MessageTraceParent returns the W3C traceparent recorded when the message was sent.
+func (s *Store) MessageTraceParent(messageID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m, ok := s.messages[messageID]; ok {
+		return m.TraceParent
+	}
+	return ""
+}
+
 func (s *Store) GetMessageForTest(messageID string) (Message, bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
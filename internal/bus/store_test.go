@@ -1,9 +1,13 @@
 package bus
 
 import (
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -278,16 +282,37 @@ func TestInboxCursorAfterTrim(t *testing.T) {
 	})
 	registerPair(t, s, 60, 60)
 
-	for i := 1; i <= 5; i++ {
+	send := func(i int) error {
 		_, _, err := s.SendMessage(SendMessageInput{
-			To: "b", From: "a", RequestID: "rid-trim-" + strconv.Itoa(i), Type: MessageTypeRequest, Body: "msg",
+			To: "b", From: "a", RequestID: "rid-trim-" + strconv.Itoa(i), Type: MessageTypeInform, Body: "msg",
 		})
-		if err != nil {
+		return err
+	}
+	for i := 1; i <= 3; i++ {
+		if err := send(i); err != nil {
 			t.Fatalf("send %d: %v", i, err)
 		}
 	}
+	// Unread events are never dropped; the sender is told to back off.
+	err := send(4)
+	if be, ok := err.(*Error); !ok || be.Code != CodeUnavailable || !be.Transient || be.RetryAfter != 5 {
+		t.Fatalf("expected transient unavailable with full inbox, got %v", err)
+	}
 
 	events, next, err := s.PollInbox(PollInboxInput{AgentID: "b", Cursor: 0, Wait: 0})
+	if err != nil || len(events) != 3 || next != 3 {
+		t.Fatalf("expected 3 events and cursor 3, got %d events cursor %d err=%v", len(events), next, err)
+	}
+	if _, _, err := s.PollInbox(PollInboxInput{AgentID: "b", Cursor: next}); err != nil {
+		t.Fatalf("poll past read events: %v", err)
+	}
+	for i := 4; i <= 5; i++ {
+		if err := send(i); err != nil {
+			t.Fatalf("send %d after read: %v", i, err)
+		}
+	}
+
+	events, next, err = s.PollInbox(PollInboxInput{AgentID: "b", Cursor: 0, Wait: 0})
 	if err != nil {
 		t.Fatalf("poll inbox: %v", err)
 	}
@@ -324,3 +349,1832 @@ func TestAckTimeoutTransitionsToErrorOnSweep(t *testing.T) {
 		t.Fatalf("expected error state, got %s", stored.State)
 	}
 }
+
+func TestSendMessagesAtomicRejectsWholeBatch(t *testing.T) {
+	s, _ := newTestStore(t)
+	registerPair(t, s, 60, 60)
+
+	results, err := s.SendMessages(SendMessagesInput{
+		Atomic: true,
+		Messages: []SendMessageInput{
+			{To: "b", From: "a", RequestID: "rid-batch-1", Body: "one"},
+			{To: "missing", From: "a", RequestID: "rid-batch-2", Body: "two"},
+		},
+	})
+	if err == nil {
+		t.Fatalf("expected atomic batch with an invalid item to fail")
+	}
+	if be, ok := err.(*Error); !ok || be.Code != CodeNotFound || be.Message != "messages[1]: target agent not registered" {
+		t.Fatalf("unexpected batch error: %#v", err)
+	}
+	if results[0].Err != nil || results[1].Err == nil {
+		t.Fatalf("expected only item 1 to carry an error: %#v", results)
+	}
+	if status := s.SystemStatus()["system"].(map[string]any); status["messages"] != 0 || status["conversations"] != 0 {
+		t.Fatalf("expected nothing applied, got %v", status)
+	}
+}
+
+func TestSendMessagesPartialAndInBatchDuplicates(t *testing.T) {
+	s, _ := newTestStore(t)
+	registerPair(t, s, 60, 60)
+
+	results, err := s.SendMessages(SendMessagesInput{
+		Messages: []SendMessageInput{
+			{To: "b", From: "a", RequestID: "rid-batch-1", Body: "one"},
+			{To: "b", From: "a", RequestID: "rid-batch-1", Body: "one again"},
+			{To: "b", From: "a", RequestID: "rid-batch-3", Body: ""},
+			{To: "b", From: "a", RequestID: "rid-batch-4", Body: "four"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("send batch: %v", err)
+	}
+	if results[0].Message == nil || results[0].Duplicate {
+		t.Fatalf("expected first item sent: %#v", results[0])
+	}
+	if !results[1].Duplicate || results[1].Message.MessageID != results[0].Message.MessageID {
+		t.Fatalf("expected second item to dedupe against the first: %#v", results[1])
+	}
+	if results[2].Err == nil || results[2].Message != nil {
+		t.Fatalf("expected third item to fail validation: %#v", results[2])
+	}
+	if results[3].Message == nil {
+		t.Fatalf("expected fourth item sent: %#v", results[3])
+	}
+	events, _, err := s.PollInbox(PollInboxInput{AgentID: "b"})
+	if err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 inbox events, got %d", len(events))
+	}
+}
+
+func TestSendMessagesEnforcesMaxBatch(t *testing.T) {
+	s, _ := newTestStore(t)
+	registerPair(t, s, 60, 60)
+
+	batch := make([]SendMessageInput, 101)
+	for i := range batch {
+		batch[i] = SendMessageInput{To: "b", From: "a", RequestID: "rid-" + strconv.Itoa(i), Body: "x"}
+	}
+	if _, err := s.SendMessages(SendMessagesInput{Messages: batch}); err == nil {
+		t.Fatalf("expected oversized batch to be rejected")
+	}
+	if _, err := s.SendMessages(SendMessagesInput{}); err == nil {
+		t.Fatalf("expected empty batch to be rejected")
+	}
+}
+
+func TestGetMessageIncludesDeliveryFields(t *testing.T) {
+	s, now := newTestStore(t)
+	registerPair(t, s, 60, 60)
+
+	msg, _, err := s.SendMessage(SendMessageInput{To: "b", From: "a", RequestID: "rid-get", Body: "hello"})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	*now = now.Add(11 * time.Second)
+
+	detail, err := s.GetMessage(msg.MessageID)
+	if err != nil {
+		t.Fatalf("get message: %v", err)
+	}
+	if detail.DeliveredAt == nil || detail.TTLExpiresAt.IsZero() || detail.State != StateError {
+		t.Fatalf("unexpected detail: %#v", detail)
+	}
+	if len(detail.StateHistory) != 1 || detail.StateHistory[0].Reason != "ack timeout" {
+		t.Fatalf("expected ack timeout transition, got %#v", detail.StateHistory)
+	}
+
+	if _, err := s.GetMessage("m-missing"); err == nil {
+		t.Fatalf("expected not found for unknown message")
+	}
+	if _, err := s.GetAgent("zzz"); err == nil {
+		t.Fatalf("expected not found for unknown agent")
+	}
+	conv, err := s.GetConversation(msg.ConversationID)
+	if err != nil || conv.MessageCount != 1 {
+		t.Fatalf("unexpected conversation: %#v err=%v", conv, err)
+	}
+}
+
+func TestStateHistoryRecordsActorsAndOutlivesObserveTrim(t *testing.T) {
+	now := time.Date(2026, 2, 17, 0, 0, 0, 0, time.UTC)
+	s := NewStore(Config{
+		MaxObserveEvents: 2,
+		Clock:            func() time.Time { return now },
+	})
+	registerPair(t, s, 60, 60)
+
+	msg, _, err := s.SendMessage(SendMessageInput{To: "b", From: "a", RequestID: "rid-history", Body: "work"})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	now = now.Add(time.Second)
+	if err := s.Ack(AckInput{AgentID: "b", MessageID: msg.MessageID, Status: "accepted"}); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	now = now.Add(time.Second)
+	meta := map[string]any{"score": 0.9}
+	if err := s.PostEvent(EventInput{ActorAgentID: "b", MessageID: msg.MessageID, Type: "final", Body: "done", Meta: meta}); err != nil {
+		t.Fatalf("final: %v", err)
+	}
+	// Push the state_change events out of the observe buffer.
+	for i := 0; i < 3; i++ {
+		if _, err := s.CreateConversation(CreateConversationInput{Title: "filler"}); err != nil {
+			t.Fatalf("create conversation: %v", err)
+		}
+	}
+
+	detail, err := s.GetMessage(msg.MessageID)
+	if err != nil {
+		t.Fatalf("get message: %v", err)
+	}
+	h := detail.StateHistory
+	if len(h) != 2 {
+		t.Fatalf("expected ack and final transitions, got %#v", h)
+	}
+	if h[0].From != StateWaitingAck || h[0].To != StateExecuting || h[0].Actor != "b" || !h[0].At.Equal(now.Add(-time.Second)) {
+		t.Fatalf("unexpected ack transition: %#v", h[0])
+	}
+	if h[1].To != StateCompleted || h[1].Actor != "b" || h[1].Body != "done" || h[1].Meta == nil {
+		t.Fatalf("unexpected final transition: %#v", h[1])
+	}
+
+	rejected, _, err := s.SendMessage(SendMessageInput{To: "b", From: "a", RequestID: "rid-reject", Body: "work"})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if err := s.Ack(AckInput{AgentID: "b", MessageID: rejected.MessageID, Status: "rejected", Reason: "out of scope"}); err != nil {
+		t.Fatalf("reject: %v", err)
+	}
+	detail, _ = s.GetMessage(rejected.MessageID)
+	if h := detail.StateHistory; len(h) != 1 || h[0].To != StateRejected || h[0].Reason != "out of scope" {
+		t.Fatalf("expected rejection reason in history, got %#v", h)
+	}
+}
+
+func TestConversationHistoryOutlivesObserveTrim(t *testing.T) {
+	now := time.Date(2026, 2, 17, 0, 0, 0, 0, time.UTC)
+	s := NewStore(Config{
+		MaxObserveEvents: 2,
+		Clock:            func() time.Time { return now },
+	})
+	registerPair(t, s, 60, 60)
+
+	msg, _, err := s.SendMessage(SendMessageInput{To: "b", From: "a", ConversationID: "case-1", RequestID: "rid-history", Body: "work"})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if err := s.PostEvent(EventInput{ActorAgentID: "b", MessageID: msg.MessageID, Type: "progress", Body: "halfway"}); err != nil {
+		t.Fatalf("progress: %v", err)
+	}
+	title := "Case 1"
+	if _, err := s.UpdateConversation(UpdateConversationInput{ConversationID: "case-1", Title: &title, Actor: "a"}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if _, err := s.SetConversationStatus(ConversationStatusInput{ConversationID: "case-1", Status: ConversationStatusClosed, Actor: "a", Reason: "done"}); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	// Push the conversation's events out of the observe buffer.
+	for i := 0; i < 3; i++ {
+		if _, err := s.CreateConversation(CreateConversationInput{Title: "filler"}); err != nil {
+			t.Fatalf("create conversation: %v", err)
+		}
+	}
+
+	h, err := s.ConversationHistory("case-1")
+	if err != nil {
+		t.Fatalf("history: %v", err)
+	}
+	if len(h) != 3 {
+		t.Fatalf("expected progress, update and status events, got %#v", h)
+	}
+	if h[0].Type != ObserveProgress || h[0].MessageID != msg.MessageID || h[0].Actor != "b" || h[0].Body != "halfway" {
+		t.Fatalf("unexpected progress event: %#v", h[0])
+	}
+	if h[1].Type != ObserveConversationUpdated || h[1].Actor != "a" || len(h[1].Changed) != 1 || h[1].Changed[0] != "title" {
+		t.Fatalf("unexpected update event: %#v", h[1])
+	}
+	if h[2].Type != ObserveConversationStatus || h[2].FromStatus != ConversationStatusActive || h[2].ToStatus != ConversationStatusClosed || h[2].Reason != "done" {
+		t.Fatalf("unexpected status event: %#v", h[2])
+	}
+	if _, err := s.ConversationHistory("missing"); err == nil || err.(*Error).Code != CodeNotFound {
+		t.Fatalf("expected not_found for unknown conversation, got %v", err)
+	}
+}
+
+func TestPostEventStoresResult(t *testing.T) {
+	s, now := newTestStore(t)
+	registerPair(t, s, 60, 60)
+
+	ok, _, err := s.SendMessage(SendMessageInput{To: "b", From: "a", RequestID: "rid-ok", Body: "work"})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	att := []Attachment{{URL: "https://files.example/out.json", Name: "out.json"}}
+	if err := s.PostEvent(EventInput{ActorAgentID: "b", MessageID: ok.MessageID, Type: "final", Body: "done", Meta: map[string]any{"n": 1}, Attachments: att}); err != nil {
+		t.Fatalf("final: %v", err)
+	}
+	failed, _, err := s.SendMessage(SendMessageInput{To: "b", From: "a", RequestID: "rid-fail", Body: "work"})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if err := s.PostEvent(EventInput{ActorAgentID: "b", MessageID: failed.MessageID, Type: "error", Body: "boom"}); err != nil {
+		t.Fatalf("error event: %v", err)
+	}
+	stale, _, err := s.SendMessage(SendMessageInput{To: "b", From: "a", RequestID: "rid-stale", Body: "work"})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	*now = now.Add(11 * time.Second)
+
+	_, msgs, _, err := s.ListConversationMessages(ListConversationMessagesInput{ConversationID: ok.ConversationID})
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(msgs) != 1 || msgs[0].Result == nil || msgs[0].Result.Body != "done" || len(msgs[0].Result.Attachments) != 1 || msgs[0].Result.ErrorCode != "" {
+		t.Fatalf("expected final result in listing, got %#v", msgs)
+	}
+	got, _ := s.GetMessage(failed.MessageID)
+	if got.Result == nil || got.Result.Body != "boom" || got.Result.ErrorCode != CodeInternal {
+		t.Fatalf("expected error result with default code, got %#v", got.Result)
+	}
+	got, _ = s.GetMessage(stale.MessageID)
+	if got.Result == nil || got.Result.ErrorCode != CodeTimeout {
+		t.Fatalf("expected timeout result, got %#v", got.Result)
+	}
+}
+
+func TestSendMessageWaitReturnsResult(t *testing.T) {
+	s := NewStore(Config{InboxWaitMax: 5 * time.Second})
+	registerPair(t, s, 60, 60)
+
+	go func() {
+		for {
+			events, _, _ := s.PollInbox(PollInboxInput{AgentID: "b", Wait: time.Second})
+			if len(events) > 0 {
+				_ = s.PostEvent(EventInput{ActorAgentID: "b", MessageID: events[0].MessageID, Type: "final", Body: "answer"})
+				return
+			}
+		}
+	}()
+
+	msg, _, err := s.SendMessage(SendMessageInput{To: "b", From: "a", RequestID: "rid-wait", Body: "question", Wait: 3 * time.Second})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if msg.State != StateCompleted || msg.Result == nil || msg.Result.Body != "answer" {
+		t.Fatalf("expected completed message with result, got %#v", msg)
+	}
+}
+
+func TestCallReturnsFinalResult(t *testing.T) {
+	s := NewStore(Config{InboxWaitMax: 5 * time.Second})
+	registerPair(t, s, 60, 60)
+
+	go func() {
+		for {
+			events, _, _ := s.PollInbox(PollInboxInput{AgentID: "b", Wait: time.Second})
+			if len(events) > 0 {
+				_ = s.PostEvent(EventInput{ActorAgentID: "b", MessageID: events[0].MessageID, Type: "final", Body: "answer"})
+				return
+			}
+		}
+	}()
+
+	out, err := s.Call(SendMessageInput{To: "b", From: "a", RequestID: "rid-call", Body: "question", Wait: 3 * time.Second})
+	if err != nil {
+		t.Fatalf("call: %v", err)
+	}
+	if out.TimedOut || out.Reply != nil || out.Request.State != StateCompleted || out.Request.Result.Body != "answer" {
+		t.Fatalf("unexpected call result: %#v", out)
+	}
+
+	if _, err := s.Call(SendMessageInput{To: "b", From: "a", RequestID: "rid-inform", Type: MessageTypeInform, Body: "fyi"}); err == nil {
+		t.Fatalf("expected calls to reject non-request types")
+	}
+}
+
+func TestInReplyToValidatedIndexedAndCompletes(t *testing.T) {
+	s, _ := newTestStore(t)
+	registerPair(t, s, 60, 60)
+	if _, err := s.RegisterAgent(RegisterAgentInput{AgentID: "c", Mode: AgentModePull, TTLSeconds: 60}); err != nil {
+		t.Fatalf("register c: %v", err)
+	}
+
+	req, _, err := s.SendMessage(SendMessageInput{To: "b", From: "a", RequestID: "rid-q", Body: "question"})
+	if err != nil {
+		t.Fatalf("send request: %v", err)
+	}
+	cases := []SendMessageInput{
+		{To: "a", From: "b", RequestID: "r1", Type: MessageTypeResponse, Body: "x", InReplyTo: "m-missing"},
+		{To: "a", From: "c", RequestID: "r2", Type: MessageTypeResponse, Body: "x", InReplyTo: req.MessageID},
+		{To: "a", From: "b", RequestID: "r3", Type: MessageTypeResponse, Body: "x", CompleteRequest: true},
+	}
+	for i, in := range cases {
+		if _, _, err := s.SendMessage(in); err == nil || err.(*Error).Code != CodeValidation {
+			t.Fatalf("case %d: expected validation error, got %v", i, err)
+		}
+	}
+
+	progress, _, err := s.SendMessage(SendMessageInput{To: "a", From: "b", ConversationID: req.ConversationID, RequestID: "r-inform", Type: MessageTypeInform, Body: "working", InReplyTo: req.MessageID})
+	if err != nil {
+		t.Fatalf("send inform reply: %v", err)
+	}
+	if got, _ := s.GetMessageForTest(req.MessageID); got.State != StateWaitingAck {
+		t.Fatalf("expected a plain reply to leave the request open, got %s", got.State)
+	}
+	reply, _, err := s.SendMessage(SendMessageInput{
+		To: "a", From: "b", ConversationID: req.ConversationID, RequestID: "r-answer", Type: MessageTypeResponse,
+		Body: "answer", InReplyTo: req.MessageID, CompleteRequest: true,
+	})
+	if err != nil {
+		t.Fatalf("send completing reply: %v", err)
+	}
+
+	detail, err := s.GetMessage(req.MessageID)
+	if err != nil {
+		t.Fatalf("get request: %v", err)
+	}
+	if detail.State != StateCompleted || detail.Result == nil || detail.Result.Body != "answer" {
+		t.Fatalf("expected request completed by reply, got %#v", detail.Message)
+	}
+	if last := detail.StateHistory[len(detail.StateHistory)-1]; last.Actor != "b" {
+		t.Fatalf("expected completion attributed to b, got %#v", last)
+	}
+	_, msgs, _, err := s.ListConversationMessages(ListConversationMessagesInput{ConversationID: req.ConversationID})
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(msgs) != 3 || len(msgs[0].Replies) != 2 || msgs[0].Replies[0] != progress.MessageID || msgs[0].Replies[1] != reply.MessageID {
+		t.Fatalf("expected reply chain on request, got %#v", msgs)
+	}
+	if got := s.replyToLocked(req.MessageID); got == nil || got.MessageID != reply.MessageID {
+		t.Fatalf("expected indexed response %s, got %#v", reply.MessageID, got)
+	}
+}
+
+func TestReindexRepliesOrdersPastIDPadding(t *testing.T) {
+	s, _ := newTestStore(t)
+	registerPair(t, s, 60, 60)
+
+	s.nextMessageID = 999997
+	req, _, err := s.SendMessage(SendMessageInput{To: "b", From: "a", RequestID: "rid-q", Body: "question"})
+	if err != nil {
+		t.Fatalf("send request: %v", err)
+	}
+	var want []string
+	for _, rid := range []string{"r1", "r2", "r3"} {
+		reply, _, err := s.SendMessage(SendMessageInput{To: "a", From: "b", RequestID: rid, Type: MessageTypeInform, Body: "update", InReplyTo: req.MessageID})
+		if err != nil {
+			t.Fatalf("send reply %s: %v", rid, err)
+		}
+		want = append(want, reply.MessageID)
+	}
+	if want[2] != "m-1000001" {
+		t.Fatalf("expected replies to cross m-999999, got %v", want)
+	}
+
+	s.mu.Lock()
+	s.reindexRepliesLocked()
+	got := s.replies[req.MessageID]
+	s.mu.Unlock()
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("replies after reindex=%v want %v", got, want)
+	}
+}
+
+func TestConversationLifecycle(t *testing.T) {
+	s, now := newTestStore(t)
+	s.cfg.ConversationIdleTimeout = 24 * time.Hour
+	registerPair(t, s, 60, 60)
+
+	m, _, err := s.SendMessage(SendMessageInput{To: "b", From: "a", RequestID: "rid-open", Type: MessageTypeInform, Body: "hello"})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	convID := m.ConversationID
+	if _, err := s.SetConversationStatus(ConversationStatusInput{ConversationID: convID, Status: ConversationStatusArchived}); err == nil || err.(*Error).Code != CodeRejected {
+		t.Fatalf("expected archive of active conversation to be rejected, got %v", err)
+	}
+	c, err := s.SetConversationStatus(ConversationStatusInput{ConversationID: convID, Status: ConversationStatusClosed, Actor: "a", Reason: "done"})
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if c.Status != ConversationStatusClosed || c.StatusReason != "done" || c.StatusChangedAt == nil {
+		t.Fatalf("unexpected closed conversation: %#v", c)
+	}
+	if _, _, err := s.SendMessage(SendMessageInput{To: "b", From: "a", ConversationID: convID, RequestID: "rid-closed", Type: MessageTypeInform, Body: "more"}); err == nil || err.(*Error).Code != CodeRejected {
+		t.Fatalf("expected send to closed conversation to be rejected, got %v", err)
+	}
+	if _, err := s.Inject(InjectInput{Identity: "human", ConversationID: convID, To: "b", Body: "hi"}); err == nil || err.(*Error).Code != CodeRejected {
+		t.Fatalf("expected inject into closed conversation to be rejected, got %v", err)
+	}
+	if _, err := s.SetConversationStatus(ConversationStatusInput{ConversationID: convID, Status: ConversationStatusArchived}); err != nil {
+		t.Fatalf("archive: %v", err)
+	}
+	if _, err := s.SetConversationStatus(ConversationStatusInput{ConversationID: convID, Status: ConversationStatusActive}); err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if _, _, err := s.SendMessage(SendMessageInput{To: "b", From: "a", ConversationID: convID, RequestID: "rid-reopened", Type: MessageTypeRequest, Body: "again"}); err != nil {
+		t.Fatalf("send after reopen: %v", err)
+	}
+
+	*now = now.Add(25 * time.Hour)
+	c, err = s.GetConversation(convID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if c.Status != ConversationStatusClosed || c.StatusReason != "idle timeout" {
+		t.Fatalf("expected idle conversation to be closed, got %#v", c)
+	}
+	events, _ := s.ObserveSince(0, ObserveFilter{ConversationID: convID}, 0)
+	var changes int
+	for _, ev := range events {
+		if ev.Type == ObserveConversationStatus {
+			changes++
+		}
+	}
+	if changes != 4 {
+		t.Fatalf("expected 4 conversation_status events, got %d", changes)
+	}
+}
+
+func TestConversationIdleCloseOffByDefault(t *testing.T) {
+	s, now := newTestStore(t)
+	registerPair(t, s, 60, 60)
+
+	m, _, err := s.SendMessage(SendMessageInput{To: "b", From: "a", RequestID: "rid-quiet", Type: MessageTypeInform, Body: "hello"})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	*now = now.Add(30 * 24 * time.Hour)
+	if c, _ := s.GetConversation(m.ConversationID); c.Status != ConversationStatusActive {
+		t.Fatalf("expected conversation to stay active without an idle timeout, got %#v", c)
+	}
+}
+
+func TestUpdateConversationMergesMetaAndTags(t *testing.T) {
+	s, _ := newTestStore(t)
+
+	if _, err := s.CreateConversation(CreateConversationInput{
+		ConversationID: "case-1",
+		Title:          "Disclosure",
+		Meta:           map[string]any{"case_id": "TT-1", "inventor": map[string]any{"name": "Ada", "dept": "EE"}},
+		Tags:           []string{"intake"},
+	}); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := s.CreateConversation(CreateConversationInput{ConversationID: "case-1", Title: "Disclosure"}); err != nil {
+		t.Fatalf("expected identical create to be idempotent: %v", err)
+	}
+	if _, err := s.CreateConversation(CreateConversationInput{ConversationID: "case-1", Title: "Renamed"}); err == nil || err.(*Error).Code != CodeRejected {
+		t.Fatalf("expected create with a new title to be rejected, got %v", err)
+	}
+	if _, err := s.CreateConversation(CreateConversationInput{ConversationID: "case-2", Meta: map[string]any{"case_id": "TT-2"}, Tags: []string{"triage"}}); err != nil {
+		t.Fatalf("create case-2: %v", err)
+	}
+
+	title := "Disclosure 2026-014"
+	c, err := s.UpdateConversation(UpdateConversationInput{
+		ConversationID: "case-1",
+		Title:          &title,
+		Meta:           map[string]any{"disclosure": "2026-014", "inventor": map[string]any{"dept": nil}},
+		AddTags:        []string{"review", " intake "},
+		RemoveTags:     []string{"missing"},
+	})
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	meta := c.Meta.(map[string]any)
+	inventor := meta["inventor"].(map[string]any)
+	if c.Title != title || meta["case_id"] != "TT-1" || meta["disclosure"] != "2026-014" || inventor["name"] != "Ada" || len(inventor) != 1 {
+		t.Fatalf("unexpected merged conversation: %#v", c)
+	}
+	if len(c.Tags) != 2 || c.Tags[0] != "intake" || c.Tags[1] != "review" {
+		t.Fatalf("unexpected tags: %v", c.Tags)
+	}
+	if c, err = s.UpdateConversation(UpdateConversationInput{ConversationID: "case-1", Tags: []string{"licensed"}, RemoveTags: []string{"licensed"}}); err != nil || c.Tags != nil {
+		t.Fatalf("expected tags cleared, got %v (%v)", c, err)
+	}
+	if _, err := s.UpdateConversation(UpdateConversationInput{ConversationID: "case-1", AddTags: []string{"review"}}); err != nil {
+		t.Fatalf("re-tag: %v", err)
+	}
+
+	cases := []struct {
+		filter ListConversationsFilter
+		want   string
+	}{
+		{ListConversationsFilter{Tags: []string{"review"}}, "case-1"},
+		{ListConversationsFilter{MetaKey: "disclosure"}, "case-1"},
+		{ListConversationsFilter{MetaKey: "case_id", MetaValue: "TT-2"}, "case-2"},
+		{ListConversationsFilter{Tags: []string{"triage", "review"}}, ""},
+	}
+	for i, tc := range cases {
+		got, _, _ := s.ListConversations(tc.filter)
+		if tc.want == "" {
+			if len(got) != 0 {
+				t.Fatalf("case %d: expected no match, got %#v", i, got)
+			}
+			continue
+		}
+		if len(got) != 1 || got[0].ConversationID != tc.want {
+			t.Fatalf("case %d: expected %s, got %#v", i, tc.want, got)
+		}
+	}
+
+	events, _ := s.ObserveSince(0, ObserveFilter{ConversationID: "case-1"}, 0)
+	var updates int
+	for _, ev := range events {
+		if ev.Type == ObserveConversationUpdated {
+			updates++
+		}
+	}
+	if updates != 3 {
+		t.Fatalf("expected 3 conversation_updated events, got %d", updates)
+	}
+
+	if _, err := s.SetConversationStatus(ConversationStatusInput{ConversationID: "case-1", Status: ConversationStatusClosed}); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if _, err := s.SetConversationStatus(ConversationStatusInput{ConversationID: "case-1", Status: ConversationStatusArchived}); err != nil {
+		t.Fatalf("archive: %v", err)
+	}
+	if _, err := s.UpdateConversation(UpdateConversationInput{ConversationID: "case-1", AddTags: []string{"late"}}); err == nil || err.(*Error).Code != CodeRejected {
+		t.Fatalf("expected update of archived conversation to be rejected, got %v", err)
+	}
+}
+
+func TestListingsPageSortAndFilter(t *testing.T) {
+	s, now := newTestStore(t)
+	start := *now
+	ttls := map[string]int{"c": 300, "a": 600, "b": 600}
+	for i, id := range []string{"c", "a", "b"} {
+		*now = start.Add(time.Duration(i) * time.Minute)
+		if _, err := s.RegisterAgent(RegisterAgentInput{AgentID: id, Mode: AgentModePull, TTLSeconds: ttls[id]}); err != nil {
+			t.Fatalf("register %s: %v", id, err)
+		}
+		if _, err := s.CreateConversation(CreateConversationInput{ConversationID: "conv-" + id}); err != nil {
+			t.Fatalf("create conv-%s: %v", id, err)
+		}
+	}
+
+	var ids []string
+	cursor := ""
+	for {
+		page, next, err := s.ListAgents(ListAgentsFilter{Limit: 2, Cursor: cursor})
+		if err != nil {
+			t.Fatalf("list agents: %v", err)
+		}
+		for _, a := range page {
+			ids = append(ids, a.AgentID)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if len(ids) != 3 || ids[0] != "a" || ids[1] != "b" || ids[2] != "c" {
+		t.Fatalf("expected agents paged in id order, got %v", ids)
+	}
+	agents, _, err := s.ListAgents(ListAgentsFilter{Sort: SortRegisteredAt, Order: OrderDesc, RegisteredAfter: start})
+	if err != nil {
+		t.Fatalf("list by registration: %v", err)
+	}
+	if len(agents) != 2 || agents[0].AgentID != "b" || agents[1].AgentID != "a" {
+		t.Fatalf("expected a and b newest first, got %#v", agents)
+	}
+
+	// Sending moves conv-c to the end of the last_message_at order.
+	*now = start.Add(10 * time.Minute)
+	if _, _, err := s.SendMessage(SendMessageInput{To: "a", From: "b", ConversationID: "conv-c", RequestID: "rid-1", Type: MessageTypeInform, Body: "hi"}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	convs, next, err := s.ListConversations(ListConversationsFilter{Sort: SortLastMessageAt, Limit: 2})
+	if err != nil || len(convs) != 2 || next == "" || convs[0].ConversationID != "conv-a" {
+		t.Fatalf("unexpected first page %#v next=%q err=%v", convs, next, err)
+	}
+	convs, next, _ = s.ListConversations(ListConversationsFilter{Sort: SortLastMessageAt, Limit: 2, Cursor: next})
+	if len(convs) != 1 || next != "" || convs[0].ConversationID != "conv-c" {
+		t.Fatalf("unexpected last page %#v next=%q", convs, next)
+	}
+	convs, _, _ = s.ListConversations(ListConversationsFilter{CreatedBefore: start.Add(time.Minute), LastMessageAfter: start.Add(5 * time.Minute)})
+	if len(convs) != 1 || convs[0].ConversationID != "conv-c" {
+		t.Fatalf("expected only conv-c in time range, got %#v", convs)
+	}
+	if _, _, err := s.ListConversations(ListConversationsFilter{Order: OrderDesc, Cursor: cursor}); err == nil || err.(*Error).Code != CodeValidation {
+		t.Fatalf("expected cursor from another listing to be rejected, got %v", err)
+	}
+	if _, _, err := s.ListAgents(ListAgentsFilter{Sort: "name"}); err == nil || err.(*Error).Code != CodeValidation {
+		t.Fatalf("expected unknown sort to be rejected, got %v", err)
+	}
+
+	if agents, _, _ = s.ListAgents(ListAgentsFilter{}); len(agents) != 2 {
+		t.Fatalf("expected c to have expired, got %#v", agents)
+	}
+	if agents, _, _ = s.ListAgents(ListAgentsFilter{IncludeExpired: true}); len(agents) != 3 {
+		t.Fatalf("expected include_expired to list all three agents, got %#v", agents)
+	}
+}
+
+func TestListingsDefaultPageSize(t *testing.T) {
+	s, _ := newTestStore(t)
+	for i := 0; i < listLimitDefault+1; i++ {
+		if _, err := s.RegisterAgent(RegisterAgentInput{AgentID: "agent-" + strconv.Itoa(100+i), Mode: AgentModePull}); err != nil {
+			t.Fatalf("register %d: %v", i, err)
+		}
+	}
+	agents, next, err := s.ListAgents(ListAgentsFilter{})
+	if err != nil || len(agents) != listLimitDefault || next == "" {
+		t.Fatalf("expected a default page of %d with a cursor, got %d next=%q err=%v", listLimitDefault, len(agents), next, err)
+	}
+	agents, next, _ = s.ListAgents(ListAgentsFilter{Cursor: next})
+	if len(agents) != 1 || next != "" {
+		t.Fatalf("expected the last agent on the second page, got %d next=%q", len(agents), next)
+	}
+}
+
+func TestSearchFindsMessagesAndConversations(t *testing.T) {
+	s, now := newTestStore(t)
+	registerPair(t, s, 600, 600)
+	if _, err := s.CreateConversation(CreateConversationInput{
+		ConversationID: "case-7",
+		Title:          "Nano-coating disclosure",
+		Meta:           map[string]any{"inventor": "Grace Hopper", "docket": 1407},
+	}); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	start := *now
+	*now = start.Add(time.Minute)
+	if _, _, err := s.SendMessage(SendMessageInput{To: "b", From: "a", ConversationID: "case-7", RequestID: "r1", Body: "Please review the nano-coating claims against prior art."}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	*now = start.Add(2 * time.Minute)
+	if _, _, err := s.SendMessage(SendMessageInput{To: "a", From: "b", ConversationID: "case-7", RequestID: "r2", Type: MessageTypeInform, Body: "Coating looks novel."}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	hits, err := s.Search(SearchInput{Query: "nano coating"})
+	if err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if len(hits) != 2 || hits[0].Kind == hits[1].Kind {
+		t.Fatalf("expected the request and the conversation, got %#v", hits)
+	}
+	for _, h := range hits {
+		if h.Title != "Nano-coating disclosure" || !strings.Contains(h.Snippet, "**coating**") {
+			t.Fatalf("unexpected hit %#v", h)
+		}
+	}
+
+	cases := []struct {
+		input SearchInput
+		want  int
+	}{
+		{SearchInput{Query: "coat*"}, 3},
+		{SearchInput{Query: "hopper"}, 1},
+		{SearchInput{Query: "1407", Kind: SearchKindConversation}, 1},
+		{SearchInput{Query: "coat*", Type: MessageTypeInform}, 1},
+		{SearchInput{Query: "coat*", AgentID: "a", Kind: SearchKindMessage}, 2},
+		{SearchInput{Query: "coat*", After: start.Add(90 * time.Second)}, 1},
+		{SearchInput{Query: "coat*", ConversationID: "c-other"}, 0},
+		{SearchInput{Query: "coat*", Limit: 1}, 1},
+	}
+	for i, tc := range cases {
+		got, err := s.Search(tc.input)
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		if len(got) != tc.want {
+			t.Fatalf("case %d: expected %d hits, got %#v", i, tc.want, got)
+		}
+	}
+
+	title := "Photonic lattice"
+	if _, err := s.UpdateConversation(UpdateConversationInput{ConversationID: "case-7", Title: &title}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if got, _ := s.Search(SearchInput{Query: "lattice"}); len(got) != 1 || got[0].Kind != SearchKindConversation {
+		t.Fatalf("expected retitled conversation to be found, got %#v", got)
+	}
+	if _, err := s.Search(SearchInput{Query: " ** "}); err == nil || err.(*Error).Code != CodeValidation {
+		t.Fatalf("expected empty query to be rejected, got %v", err)
+	}
+}
+
+func TestSearchAgentFilterMatchesMulticastRecipients(t *testing.T) {
+	s, _ := newTestStore(t)
+	registerPair(t, s, 600, 600)
+	if _, err := s.RegisterAgent(RegisterAgentInput{AgentID: "c", Mode: AgentModePull, Capabilities: []string{"z"}, TTLSeconds: 600}); err != nil {
+		t.Fatalf("register c: %v", err)
+	}
+	if _, _, err := s.SendMessage(SendMessageInput{From: "a", Recipients: []string{"b", "c"}, RequestID: "r1", Type: MessageTypeInform, Body: "Docket closed."}); err != nil {
+		t.Fatalf("multicast: %v", err)
+	}
+	for _, agent := range []string{"a", "b", "c"} {
+		if got, err := s.Search(SearchInput{Query: "docket", AgentID: agent}); err != nil || len(got) != 1 {
+			t.Fatalf("expected %s to find the multicast, got %#v (%v)", agent, got, err)
+		}
+	}
+}
+
+func TestBlobReferences(t *testing.T) {
+	s, _ := newTestStore(t)
+	registerPair(t, s, 60, 60)
+	if _, err := s.RegisterAgent(RegisterAgentInput{AgentID: "c", Mode: AgentModePull, Capabilities: []string{"z"}, TTLSeconds: 60}); err != nil {
+		t.Fatalf("register c: %v", err)
+	}
+
+	input, output := strings.Repeat("1", 64), strings.Repeat("2", 64)
+	req, _, err := s.SendMessage(SendMessageInput{
+		To: "b", From: "a", ConversationID: "conv-blob", RequestID: "rid-blob", Type: MessageTypeRequest, Body: "review",
+		Attachments: []Attachment{
+			{URL: BlobPathPrefix + input},
+			{URL: "https://bus.example.com" + BlobPathPrefix + input + "?download=1"},
+			{URL: "file:///tmp/disclosure.pdf"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if err := s.Ack(AckInput{AgentID: "b", MessageID: req.MessageID, Status: "accepted"}); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	if got := s.ReferencedBlobs(); len(got) != 1 || got[input] != 1 {
+		t.Fatalf("referenced blobs before result=%v", got)
+	}
+	if err := s.PostEvent(EventInput{ActorAgentID: "b", MessageID: req.MessageID, Type: "final", Body: "done",
+		Attachments: []Attachment{{URL: BlobPathPrefix + output}}}); err != nil {
+		t.Fatalf("final: %v", err)
+	}
+	if _, _, err := s.SendMessage(SendMessageInput{
+		To: "c", From: "b", ConversationID: "conv-blob", RequestID: "rid-fwd", Type: MessageTypeInform, Body: "fyi",
+		Attachments: []Attachment{{URL: BlobPathPrefix + output}},
+	}); err != nil {
+		t.Fatalf("forward: %v", err)
+	}
+
+	refs := s.BlobReferences(output)
+	if refs.RefCount != 2 || strings.Join(refs.ConversationIDs, ",") != "conv-blob" || strings.Join(refs.Members, ",") != "a,b,c" {
+		t.Fatalf("unexpected references: %#v", refs)
+	}
+	if refs := s.BlobReferences(strings.Repeat("3", 64)); refs.RefCount != 0 || len(refs.Members) != 0 {
+		t.Fatalf("expected no references for unknown blob: %#v", refs)
+	}
+	if _, ok := BlobSHA(BlobPathPrefix + strings.Repeat("A", 64)); ok {
+		t.Fatalf("expected uppercase digest to be rejected")
+	}
+}
+
+func TestAttachingBlobRequiresAccess(t *testing.T) {
+	s, _ := newTestStore(t)
+	registerPair(t, s, 60, 60)
+	if _, err := s.RegisterAgent(RegisterAgentInput{AgentID: "c", Mode: AgentModePull, TTLSeconds: 60}); err != nil {
+		t.Fatalf("register c: %v", err)
+	}
+	sha := strings.Repeat("1", 64)
+	s.cfg.BlobUploaders = func(digest string) []string {
+		if digest == sha {
+			return []string{"a"}
+		}
+		return nil
+	}
+	blob := []Attachment{{URL: BlobPathPrefix + sha}}
+
+	if _, _, err := s.SendMessage(SendMessageInput{To: "a", From: "c", RequestID: "rid-steal", Type: MessageTypeInform, Body: "mine", Attachments: blob}); err == nil || err.(*Error).Code != CodeUnauthorized {
+		t.Fatalf("expected attaching an unreadable blob to be rejected, got %v", err)
+	}
+	if _, _, err := s.SendMessage(SendMessageInput{From: "c", Recipients: []string{"a", "b"}, RequestID: "rid-steal-all", Type: MessageTypeInform, Body: "mine", Attachments: blob}); err == nil || err.(*Error).Code != CodeUnauthorized {
+		t.Fatalf("expected multicast with an unreadable blob to be rejected, got %v", err)
+	}
+	req, _, err := s.SendMessage(SendMessageInput{To: "c", From: "a", RequestID: "rid-own", Type: MessageTypeRequest, Body: "review", Attachments: blob})
+	if err != nil {
+		t.Fatalf("uploader send: %v", err)
+	}
+	// c now reads the blob through the request and may return it.
+	if err := s.PostEvent(EventInput{ActorAgentID: "c", MessageID: req.MessageID, Type: "final", Body: "done", Attachments: blob}); err != nil {
+		t.Fatalf("final with readable blob: %v", err)
+	}
+	other, _, err := s.SendMessage(SendMessageInput{To: "b", From: "a", RequestID: "rid-other", Type: MessageTypeRequest, Body: "review"})
+	if err != nil {
+		t.Fatalf("send other: %v", err)
+	}
+	if err := s.PostEvent(EventInput{ActorAgentID: "b", MessageID: other.MessageID, Type: "final", Body: "done", Attachments: []Attachment{{URL: BlobPathPrefix + strings.Repeat("2", 64)}}}); err == nil || err.(*Error).Code != CodeUnauthorized {
+		t.Fatalf("expected result with an unreadable blob to be rejected, got %v", err)
+	}
+}
+
+func TestOutsiderSendDoesNotGrantBlobAccess(t *testing.T) {
+	s, _ := newTestStore(t)
+	registerPair(t, s, 60, 60)
+	for _, id := range []string{"c", "outsider"} {
+		if _, err := s.RegisterAgent(RegisterAgentInput{AgentID: id, Mode: AgentModePull, TTLSeconds: 60}); err != nil {
+			t.Fatalf("register %s: %v", id, err)
+		}
+	}
+	sha := strings.Repeat("3", 64)
+	s.cfg.BlobUploaders = func(digest string) []string { return []string{"a"} }
+	blob := []Attachment{{URL: BlobPathPrefix + sha}}
+
+	req, _, err := s.SendMessage(SendMessageInput{To: "b", From: "a", ConversationID: "case-7", RequestID: "rid-disclosure", Body: "review", Attachments: blob})
+	if err != nil {
+		t.Fatalf("send with blob: %v", err)
+	}
+	if _, _, err := s.SendMessage(SendMessageInput{From: "a", Recipients: []string{"c"}, ConversationID: "case-7", RequestID: "rid-fyi", Type: MessageTypeInform, Body: "fyi", Attachments: blob}); err != nil {
+		t.Fatalf("multicast with blob: %v", err)
+	}
+	// The outsider learns the conversation ID and digest from the open
+	// listings and posts into the conversation.
+	if _, _, err := s.SendMessage(SendMessageInput{To: "a", From: "outsider", ConversationID: req.ConversationID, RequestID: "rid-hello", Type: MessageTypeInform, Body: "hello"}); err != nil {
+		t.Fatalf("outsider send: %v", err)
+	}
+
+	refs := s.BlobReferences(sha)
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(refs.Members, want) {
+		t.Fatalf("expected members %v, got %v", want, refs.Members)
+	}
+	if _, _, err := s.SendMessage(SendMessageInput{To: "a", From: "outsider", ConversationID: req.ConversationID, RequestID: "rid-steal", Type: MessageTypeInform, Body: "mine", Attachments: blob}); err == nil || err.(*Error).Code != CodeUnauthorized {
+		t.Fatalf("expected the outsider to be refused the blob, got %v", err)
+	}
+}
+
+func TestSendRunsAttachmentVerifier(t *testing.T) {
+	s, now := newTestStore(t)
+	registerPair(t, s, 60, 60)
+
+	forged := &AttachmentVerification{Status: AttachmentVerified}
+	if _, _, err := s.SendMessage(SendMessageInput{
+		To: "b", From: "a", RequestID: "rid-plain", Type: MessageTypeInform, Body: "fyi",
+		Attachments: []Attachment{{URL: "https://example.com/a.pdf", Verification: forged}},
+	}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	events, _, _ := s.PollInbox(PollInboxInput{AgentID: "b"})
+	if len(events) != 1 || events[0].Attachments[0].Verification != nil {
+		t.Fatalf("expected sender-supplied verification to be dropped: %#v", events)
+	}
+
+	s.cfg.VerifyAttachments = func(atts []Attachment) ([]Attachment, error) {
+		for i := range atts {
+			if strings.HasSuffix(atts[i].URL, ".exe") {
+				return nil, errors.New("attachments[" + strconv.Itoa(i) + "]: content type not allowed")
+			}
+			atts[i].Verification = &AttachmentVerification{Status: AttachmentVerified, CheckedAt: *now}
+		}
+		return atts, nil
+	}
+	m, _, err := s.SendMessage(SendMessageInput{
+		To: "b", From: "a", RequestID: "rid-checked", Type: MessageTypeInform, Body: "fyi",
+		Attachments: []Attachment{{URL: "https://example.com/a.pdf", Verification: &AttachmentVerification{Status: AttachmentVerifyFailed}}},
+	})
+	if err != nil {
+		t.Fatalf("send verified: %v", err)
+	}
+	if v := m.Attachments[0].Verification; v == nil || v.Status != AttachmentVerified || !v.CheckedAt.Equal(*now) {
+		t.Fatalf("expected verifier result on message, got %#v", v)
+	}
+
+	_, _, err = s.SendMessage(SendMessageInput{
+		To: "b", From: "a", RequestID: "rid-exe", Type: MessageTypeInform, Body: "run me",
+		Attachments: []Attachment{{URL: "https://example.com/a.exe"}},
+	})
+	if be, ok := err.(*Error); !ok || be.Code != CodeValidation || be.Message != "attachments[0]: content type not allowed" {
+		t.Fatalf("expected validation error from verifier, got %v", err)
+	}
+
+	results, err := s.SendMessages(SendMessagesInput{Messages: []SendMessageInput{
+		{To: "b", From: "a", RequestID: "rid-batch-ok", Type: MessageTypeInform, Body: "ok", Attachments: []Attachment{{URL: "https://example.com/b.pdf"}}},
+		{To: "b", From: "a", RequestID: "rid-batch-exe", Type: MessageTypeInform, Body: "bad", Attachments: []Attachment{{URL: "https://example.com/b.exe"}}},
+	}})
+	if err != nil {
+		t.Fatalf("batch: %v", err)
+	}
+	if results[0].Err != nil || results[0].Message.Attachments[0].Verification == nil || results[1].Err == nil {
+		t.Fatalf("unexpected batch results: %#v", results)
+	}
+}
+
+func TestCapabilitySchemas(t *testing.T) {
+	s, _ := newTestStore(t)
+	registerPair(t, s, 60, 60)
+
+	request := json.RawMessage(`{"type":"object","required":["body"],"properties":{"body":{"type":"object","required":["claims"],"properties":{"claims":{"type":"array","minItems":1}}}}}`)
+	response := json.RawMessage(`{"properties":{"body":{"type":"object","required":["verdict"]}}}`)
+	bad := []RegisterAgentInput{
+		{AgentID: "screen", Capabilities: []string{"screen"}, Schemas: map[string]CapabilitySchema{"review": {Request: request}}},
+		{AgentID: "screen", Capabilities: []string{"screen"}, Schemas: map[string]CapabilitySchema{"screen": {Request: json.RawMessage(`{"type":"nope"}`)}}},
+	}
+	for i, in := range bad {
+		if _, err := s.RegisterAgent(in); err == nil || err.(*Error).Code != CodeValidation {
+			t.Fatalf("case %d: expected validation error, got %v", i, err)
+		}
+	}
+	if _, err := s.RegisterAgent(RegisterAgentInput{
+		AgentID: "screen", Mode: AgentModePull, TTLSeconds: 60, Capabilities: []string{"screen"},
+		Schemas: map[string]CapabilitySchema{"screen": {Request: request, Response: response}},
+	}); err != nil {
+		t.Fatalf("register screen: %v", err)
+	}
+
+	_, _, err := s.SendMessage(SendMessageInput{To: "screen", From: "a", RequestID: "rid-bad", Body: `{"claims":[]}`})
+	if be, ok := err.(*Error); !ok || be.Code != CodeValidation || be.Field != "/body/claims" {
+		t.Fatalf("expected validation error at /body/claims, got %#v", err)
+	}
+	_, _, err = s.SendMessage(SendMessageInput{To: "screen", From: "a", RequestID: "rid-text", Body: "plain text"})
+	if be, ok := err.(*Error); !ok || be.Field != "/body" {
+		t.Fatalf("expected validation error at /body, got %#v", err)
+	}
+	_, _, err = s.SendMessage(SendMessageInput{To: "screen", From: "a", RequestID: "rid-cap", Body: `{"claims":[1]}`, Capability: "review"})
+	if err == nil || err.(*Error).Code != CodeValidation {
+		t.Fatalf("expected unknown capability to be rejected, got %v", err)
+	}
+
+	req, _, err := s.SendMessage(SendMessageInput{To: "screen", From: "a", RequestID: "rid-ok", Body: `{"claims":["a method"]}`})
+	if err != nil {
+		t.Fatalf("send valid request: %v", err)
+	}
+	if req.Capability != "screen" {
+		t.Fatalf("expected implicit capability, got %q", req.Capability)
+	}
+	_, _, err = s.SendMessage(SendMessageInput{To: "a", From: "screen", RequestID: "rid-reply", Type: MessageTypeResponse, Body: `{}`, InReplyTo: req.MessageID})
+	if be, ok := err.(*Error); !ok || be.Field != "/body/verdict" {
+		t.Fatalf("expected response validated at /body/verdict, got %#v", err)
+	}
+	err = s.PostEvent(EventInput{ActorAgentID: "screen", MessageID: req.MessageID, Type: "final", Body: `{"score":1}`})
+	if be, ok := err.(*Error); !ok || be.Field != "/body/verdict" {
+		t.Fatalf("expected final validated at /body/verdict, got %#v", err)
+	}
+	if err := s.PostEvent(EventInput{ActorAgentID: "screen", MessageID: req.MessageID, Type: "final", Body: `{"verdict":"eligible"}`}); err != nil {
+		t.Fatalf("valid final: %v", err)
+	}
+
+	// Agents without schemas accept anything.
+	if _, _, err := s.SendMessage(SendMessageInput{To: "b", From: "a", RequestID: "rid-free", Body: "anything"}); err != nil {
+		t.Fatalf("send to schemaless agent: %v", err)
+	}
+}
+
+func TestCapabilityQueryRanges(t *testing.T) {
+	cases := []struct {
+		query, capability string
+		want              bool
+	}{
+		{"patent-screen", "patent-screen", true},
+		{"patent-screen", "patent-screen@2.1", true},
+		{"patent-screen@^2", "patent-screen", false},
+		{"patent-screen@^2", "patent-screen@2.1", true},
+		{"patent-screen@^2", "patent-screen@3", false},
+		{"patent-screen@^0.2", "patent-screen@0.2.9", true},
+		{"patent-screen@^0.2", "patent-screen@0.3", false},
+		{"patent-screen@~2.1", "patent-screen@2.1.4", true},
+		{"patent-screen@~2.1", "patent-screen@2.2", false},
+		{"patent-screen@2.1", "patent-screen@2.1.7", true},
+		{"patent-screen@2.x", "patent-screen@2.9", true},
+		{"patent-screen@>=2.1 <3", "patent-screen@2.0.9", false},
+		{"patent-screen@>=2.1 <3", "patent-screen@2.5", true},
+		{"patent-screen@>2", "patent-screen@2.9", false},
+		{"patent-screen@<=2.1", "patent-screen@2.1.9", true},
+		{"patent-screen@*", "patent-screen@1", true},
+		{"prior-art@^2", "patent-screen@2", false},
+	}
+	for _, tc := range cases {
+		q, err := parseCapabilityQuery(tc.query)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.query, err)
+		}
+		if _, got := q.match(tc.capability); got != tc.want {
+			t.Errorf("%s matching %s: got %v want %v", tc.query, tc.capability, got, tc.want)
+		}
+	}
+	for _, bad := range []string{"@2", "patent-screen@", "patent-screen@^2.a", "patent-screen@1.2.3.4"} {
+		if _, err := parseCapabilityQuery(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestCapabilityAddressedSend(t *testing.T) {
+	s, _ := newTestStore(t)
+	for _, reg := range []RegisterAgentInput{
+		{AgentID: "a", Mode: AgentModePull, TTLSeconds: 60},
+		{AgentID: "blue", Mode: AgentModePull, TTLSeconds: 60, Capabilities: []string{"patent-screen@2.0"}},
+		{AgentID: "green-1", Mode: AgentModePull, TTLSeconds: 60, Capabilities: []string{"patent-screen@2.1"}},
+		{AgentID: "green-2", Mode: AgentModePull, TTLSeconds: 60, Capabilities: []string{"patent-screen@2.1", "prior-art"}},
+		{AgentID: "next", Mode: AgentModePull, TTLSeconds: 60, Capabilities: []string{"patent-screen@3.0"}},
+	} {
+		if _, err := s.RegisterAgent(reg); err != nil {
+			t.Fatalf("register %s: %v", reg.AgentID, err)
+		}
+	}
+	if _, err := s.RegisterAgent(RegisterAgentInput{AgentID: "bad", Capabilities: []string{"patent-screen@two"}}); err == nil || err.(*Error).Code != CodeValidation {
+		t.Fatalf("expected invalid capability version to be rejected, got %v", err)
+	}
+
+	agents, _, err := s.ListAgents(ListAgentsFilter{Capability: "patent-screen@^2"})
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(agents) != 3 || agents[0].AgentID != "blue" || agents[2].AgentID != "green-2" {
+		t.Fatalf("expected the 2.x agents, got %#v", agents)
+	}
+	if _, _, err := s.ListAgents(ListAgentsFilter{Capability: "patent-screen@^"}); err == nil || err.(*Error).Code != CodeValidation {
+		t.Fatalf("expected invalid range to be rejected, got %v", err)
+	}
+
+	var routed []string
+	for i := 0; i < 3; i++ {
+		m, _, err := s.SendMessage(SendMessageInput{From: "a", RequestID: "rid-" + strconv.Itoa(i), Body: "screen this", Capability: "patent-screen@^2"})
+		if err != nil {
+			t.Fatalf("send %d: %v", i, err)
+		}
+		if m.Capability != "patent-screen@2.1" {
+			t.Fatalf("expected resolved capability on message, got %q", m.Capability)
+		}
+		routed = append(routed, m.To)
+	}
+	if strings.Join(routed, ",") != "green-1,green-2,green-1" {
+		t.Fatalf("expected the highest version to take turns, got %v", routed)
+	}
+	dup, duplicate, err := s.SendMessage(SendMessageInput{From: "a", RequestID: "rid-1", Body: "screen this", Capability: "patent-screen@^2"})
+	if err != nil || !duplicate || dup.To != "green-2" {
+		t.Fatalf("expected retry to dedupe to the original target, got %#v dup=%v err=%v", dup, duplicate, err)
+	}
+
+	pinned, _, err := s.SendMessage(SendMessageInput{From: "a", RequestID: "rid-pinned", Body: "screen this", Capability: "patent-screen@~2.0"})
+	if err != nil || pinned.To != "blue" {
+		t.Fatalf("expected ~2.0 to reach blue, got %#v err=%v", pinned, err)
+	}
+	if _, _, err := s.SendMessage(SendMessageInput{From: "a", RequestID: "rid-none", Body: "x", Capability: "patent-screen@^4"}); err == nil || err.(*Error).Code != CodeNotFound {
+		t.Fatalf("expected no agent for ^4, got %v", err)
+	}
+	if _, _, err := s.SendMessage(SendMessageInput{From: "a", RequestID: "rid-inform", Type: MessageTypeInform, Body: "x", Capability: "prior-art"}); err == nil || err.(*Error).Code != CodeValidation {
+		t.Fatalf("expected inform without to to be rejected, got %v", err)
+	}
+	direct, _, err := s.SendMessage(SendMessageInput{To: "next", From: "a", RequestID: "rid-direct", Body: "x", Capability: "patent-screen@>=3"})
+	if err != nil || direct.Capability != "patent-screen@3.0" {
+		t.Fatalf("expected directed send to resolve the target's capability, got %#v err=%v", direct, err)
+	}
+}
+
+func TestHeartbeatRecordsHealthAndSteersRouting(t *testing.T) {
+	s, now := newTestStore(t)
+	for _, reg := range []RegisterAgentInput{
+		{AgentID: "a", Mode: AgentModePull, TTLSeconds: 60},
+		{AgentID: "s1", Mode: AgentModePull, TTLSeconds: 60, Capabilities: []string{"patent-screen@2"}, Metadata: AgentMetadata{Version: "2.0.1", MaxInFlight: 3, Labels: map[string]string{"team": "patent", "tier": "gpu"}}},
+		{AgentID: "s2", Mode: AgentModePull, TTLSeconds: 60, Capabilities: []string{"patent-screen@2"}, Metadata: AgentMetadata{Labels: map[string]string{"team": "patent"}}},
+	} {
+		if _, err := s.RegisterAgent(reg); err != nil {
+			t.Fatalf("register %s: %v", reg.AgentID, err)
+		}
+	}
+	if _, err := s.RegisterAgent(RegisterAgentInput{AgentID: "bad", Metadata: AgentMetadata{Labels: map[string]string{"a=b": "c"}}}); err == nil || err.(*Error).Code != CodeValidation {
+		t.Fatalf("expected invalid label key to be rejected, got %v", err)
+	}
+
+	agents, _, err := s.ListAgents(ListAgentsFilter{Labels: []string{"team=patent", "tier"}})
+	if err != nil {
+		t.Fatalf("list by label: %v", err)
+	}
+	if len(agents) != 1 || agents[0].AgentID != "s1" || agents[0].Metadata.Version != "2.0.1" {
+		t.Fatalf("expected label filter to select s1, got %#v", agents)
+	}
+
+	*now = now.Add(50 * time.Second)
+	agent, err := s.Heartbeat(HeartbeatInput{AgentID: "s1", InFlight: 2, Checks: []HealthCheck{
+		{Name: "llm_key", Status: HealthUnhealthy, Detail: "401 from provider"},
+		{Name: "disk", Status: HealthOK},
+	}})
+	if err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+	if agent.Health == nil || agent.Health.Status != HealthUnhealthy || agent.Health.InFlight != 2 || !agent.ExpiresAt.Equal(now.Add(60*time.Second)) {
+		t.Fatalf("unexpected agent after heartbeat: %#v", agent)
+	}
+	if _, err := s.Heartbeat(HeartbeatInput{AgentID: "s1", Checks: []HealthCheck{{Name: "llm_key", Status: "broken"}}}); err == nil {
+		t.Fatalf("expected invalid check status to be rejected")
+	}
+
+	for i := 0; i < 2; i++ {
+		m, _, err := s.SendMessage(SendMessageInput{From: "a", RequestID: "rid-" + strconv.Itoa(i), Body: "screen", Capability: "patent-screen"})
+		if err != nil || m.To != "s2" {
+			t.Fatalf("expected routing to skip unhealthy s1, got %#v err=%v", m, err)
+		}
+	}
+	if _, err := s.Heartbeat(HeartbeatInput{AgentID: "s2", Status: HealthUnhealthy}); err != nil {
+		t.Fatalf("heartbeat s2: %v", err)
+	}
+	if _, _, err := s.SendMessage(SendMessageInput{From: "a", RequestID: "rid-none", Body: "screen", Capability: "patent-screen"}); err == nil || err.(*Error).Code != CodeUnavailable {
+		t.Fatalf("expected unavailable with every agent unhealthy, got %v", err)
+	}
+	events, _ := s.ObserveSince(0, ObserveFilter{AgentID: "s1"}, 0)
+	found := false
+	for _, ev := range events {
+		if ev.Type == ObserveAgentHealth {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected an agent_health observe event")
+	}
+
+	// Re-registering keeps the last report; an expired agent must register.
+	if _, err := s.RegisterAgent(RegisterAgentInput{AgentID: "s1", Mode: AgentModePull, TTLSeconds: 60, Capabilities: []string{"patent-screen@2"}}); err != nil {
+		t.Fatalf("re-register: %v", err)
+	}
+	if got, _ := s.GetAgent("s1"); got.Health == nil || got.Health.Status != HealthUnhealthy {
+		t.Fatalf("expected health to survive re-registration, got %#v", got.Health)
+	}
+	*now = now.Add(2 * time.Minute)
+	if _, err := s.Heartbeat(HeartbeatInput{AgentID: "s1"}); err == nil || err.(*Error).Code != CodeNotFound {
+		t.Fatalf("expected expired agent heartbeat to fail, got %v", err)
+	}
+}
+
+func TestDeregisterAgentReroutesUnacceptedRequests(t *testing.T) {
+	s, _ := newTestStore(t)
+	for _, reg := range []RegisterAgentInput{
+		{AgentID: "a", Mode: AgentModePull, TTLSeconds: 60},
+		{AgentID: "w1", Mode: AgentModePull, TTLSeconds: 60, Capabilities: []string{"translate@1", "summarize"}},
+		{AgentID: "w2", Mode: AgentModePull, TTLSeconds: 60, Capabilities: []string{"translate@1.2"}},
+	} {
+		if _, err := s.RegisterAgent(reg); err != nil {
+			t.Fatalf("register %s: %v", reg.AgentID, err)
+		}
+	}
+	send := func(rid, capability string) *Message {
+		m, _, err := s.SendMessage(SendMessageInput{From: "a", To: "w1", RequestID: rid, Body: "work", Capability: capability})
+		if err != nil {
+			t.Fatalf("send %s: %v", rid, err)
+		}
+		return m
+	}
+	moved := send("r1", "translate")
+	failed := send("r2", "summarize")
+	kept := send("r3", "translate")
+	if err := s.Ack(AckInput{AgentID: "w1", MessageID: kept.MessageID, Status: "accepted"}); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+
+	res, err := s.DeregisterAgent(DeregisterAgentInput{AgentID: "w1", Reason: "shutdown"})
+	if err != nil {
+		t.Fatalf("deregister: %v", err)
+	}
+	if res.Agent.Status != AgentStatusDeregistered || len(res.Rerouted) != 1 || res.Rerouted[0] != (ReroutedMessage{MessageID: moved.MessageID, To: "w2"}) || len(res.Failed) != 1 || res.Failed[0] != failed.MessageID {
+		t.Fatalf("unexpected deregister result: %#v", res)
+	}
+	if m, _ := s.GetMessageForTest(moved.MessageID); m.To != "w2" || m.State != StateWaitingAck {
+		t.Fatalf("expected request rerouted to w2, got %#v", m)
+	}
+	if m, _ := s.GetMessageForTest(failed.MessageID); m.State != StateError || m.Result == nil || m.Result.ErrorCode != CodeUnavailable {
+		t.Fatalf("expected request to fail unavailable, got %#v", m)
+	}
+	if m, _ := s.GetMessageForTest(kept.MessageID); m.To != "w1" || m.State != StateExecuting {
+		t.Fatalf("expected executing request to stay with w1, got %#v", m)
+	}
+	if err := s.PostEvent(EventInput{ActorAgentID: "w1", MessageID: kept.MessageID, Type: "final", Body: "done"}); err != nil {
+		t.Fatalf("expected deregistered agent to finish its request: %v", err)
+	}
+	events, _, err := s.PollInbox(PollInboxInput{AgentID: "w2"})
+	if err != nil || len(events) != 1 || events[0].MessageID != moved.MessageID {
+		t.Fatalf("expected rerouted request in w2's inbox, got %#v err=%v", events, err)
+	}
+
+	if _, _, err := s.SendMessage(SendMessageInput{From: "a", To: "w1", RequestID: "r4", Body: "work"}); err == nil || err.(*Error).Code != CodeNotFound {
+		t.Fatalf("expected send to deregistered agent to fail, got %v", err)
+	}
+	if agents, _, err := s.ListAgents(ListAgentsFilter{}); err != nil || len(agents) != 2 {
+		t.Fatalf("expected deregistered agent to be hidden, got %#v (%v)", agents, err)
+	}
+	obs, _ := s.ObserveSince(0, ObserveFilter{AgentID: "w1"}, 0)
+	found := false
+	for _, ev := range obs {
+		if ev.Type == ObserveAgentDeregistered {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected an agent_deregistered observe event")
+	}
+	if system := s.SystemStatus()["system"].(map[string]any); system["agents_active"] != 2 || system["agents_deregistered"] != 1 || system["agents_expired"] != 0 {
+		t.Fatalf("expected deregistration counted apart from expiry, got %#v", system)
+	}
+
+	if _, err := s.RegisterAgent(RegisterAgentInput{AgentID: "w1", Mode: AgentModePull, TTLSeconds: 60, Capabilities: []string{"summarize"}}); err != nil {
+		t.Fatalf("re-register: %v", err)
+	}
+	if _, _, err := s.SendMessage(SendMessageInput{From: "a", To: "w1", RequestID: "r5", Body: "work"}); err != nil {
+		t.Fatalf("expected re-registered agent to receive sends: %v", err)
+	}
+}
+
+func TestMaxInFlightHoldsRequestsOnBus(t *testing.T) {
+	s, now := newTestStore(t)
+	s.cfg.MaxPendingPerAgent = 2
+	if _, err := s.RegisterAgent(RegisterAgentInput{AgentID: "a", Mode: AgentModePull, TTLSeconds: 600}); err != nil {
+		t.Fatalf("register a: %v", err)
+	}
+	if _, err := s.RegisterAgent(RegisterAgentInput{AgentID: "b", Mode: AgentModePull, TTLSeconds: 600, Metadata: AgentMetadata{MaxInFlight: 2}}); err != nil {
+		t.Fatalf("register b: %v", err)
+	}
+	var ids []string
+	for i := 1; i <= 4; i++ {
+		m, _, err := s.SendMessage(SendMessageInput{To: "b", From: "a", RequestID: "rid-" + strconv.Itoa(i), Body: "screen"})
+		if err != nil {
+			t.Fatalf("send %d: %v", i, err)
+		}
+		ids = append(ids, m.MessageID)
+	}
+	_, _, err := s.SendMessage(SendMessageInput{To: "b", From: "a", RequestID: "rid-5", Body: "screen"})
+	if be, ok := err.(*Error); !ok || be.Code != CodeRateLimited || !be.Transient || be.RetryAfter != 5 {
+		t.Fatalf("expected rate_limited once the pending queue is full, got %v", err)
+	}
+	// Responses and informs are not limited by max_in_flight.
+	if _, _, err := s.SendMessage(SendMessageInput{To: "b", From: "a", RequestID: "rid-inform", Type: MessageTypeInform, Body: "fyi"}); err != nil {
+		t.Fatalf("send inform: %v", err)
+	}
+
+	states := func() []MessageState {
+		var out []MessageState
+		for _, id := range ids {
+			m, _ := s.GetMessageForTest(id)
+			out = append(out, m.State)
+		}
+		return out
+	}
+	if got := states(); !reflect.DeepEqual(got, []MessageState{StateWaitingAck, StateWaitingAck, StatePending, StatePending}) {
+		t.Fatalf("expected two delivered and two held, got %v", got)
+	}
+	if detail, _ := s.GetMessage(ids[2]); !detail.HeldForCapacity {
+		t.Fatalf("expected held_for_capacity on a held request")
+	}
+	events, _, _ := s.PollInbox(PollInboxInput{AgentID: "b"})
+	if len(events) != 3 {
+		t.Fatalf("expected only delivered messages in the inbox, got %d", len(events))
+	}
+
+	// Accepted requests still count; finishing one releases the oldest held.
+	if err := s.Ack(AckInput{AgentID: "b", MessageID: ids[0], Status: "accepted"}); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	if got := states(); got[2] != StatePending {
+		t.Fatalf("expected accepted request to keep its slot, got %v", got)
+	}
+	*now = now.Add(time.Second)
+	if err := s.PostEvent(EventInput{ActorAgentID: "b", MessageID: ids[0], Type: "final", Body: "done"}); err != nil {
+		t.Fatalf("final: %v", err)
+	}
+	if got := states(); !reflect.DeepEqual(got, []MessageState{StateCompleted, StateWaitingAck, StateWaitingAck, StatePending}) {
+		t.Fatalf("expected the oldest held request released, got %v", got)
+	}
+	if detail, _ := s.GetMessage(ids[2]); detail.HeldForCapacity || detail.DeliveredAt == nil {
+		t.Fatalf("expected released request delivered, got %#v", detail)
+	}
+
+	// Raising the limit on re-registration releases the rest.
+	if _, err := s.RegisterAgent(RegisterAgentInput{AgentID: "b", Mode: AgentModePull, TTLSeconds: 600, Metadata: AgentMetadata{MaxInFlight: 5}}); err != nil {
+		t.Fatalf("re-register b: %v", err)
+	}
+	if got := states(); got[3] != StateWaitingAck {
+		t.Fatalf("expected re-registration to release held requests, got %v", got)
+	}
+}
+
+func TestSendMessagesCountsBatchAgainstCapacity(t *testing.T) {
+	s, _ := newTestStore(t)
+	s.cfg.MaxPendingPerAgent = 2
+	s.cfg.MaxInboxEventsPerAgent = 3
+	for _, in := range []RegisterAgentInput{
+		{AgentID: "a", Mode: AgentModePull, TTLSeconds: 600},
+		{AgentID: "b", Mode: AgentModePull, TTLSeconds: 600, Metadata: AgentMetadata{MaxInFlight: 1}},
+		{AgentID: "c", Mode: AgentModePull, TTLSeconds: 600},
+	} {
+		if _, err := s.RegisterAgent(in); err != nil {
+			t.Fatalf("register %s: %v", in.AgentID, err)
+		}
+	}
+	batch := func(to string, n int, msgType MessageType) []SendMessageInput {
+		out := make([]SendMessageInput, n)
+		for i := range out {
+			out[i] = SendMessageInput{To: to, From: "a", RequestID: to + "-" + strconv.Itoa(i), Type: msgType, Body: "screen"}
+		}
+		return out
+	}
+
+	_, err := s.SendMessages(SendMessagesInput{Atomic: true, Messages: batch("b", 10, MessageTypeRequest)})
+	if be, ok := err.(*Error); !ok || be.Code != CodeRateLimited || be.Message != "messages[3]: agent b has 2 requests waiting for capacity" {
+		t.Fatalf("expected atomic batch to fail at the first item over the pending limit, got %v", err)
+	}
+	if status := s.SystemStatus()["system"].(map[string]any); status["messages"] != 0 {
+		t.Fatalf("expected nothing applied, got %v", status)
+	}
+
+	results, err := s.SendMessages(SendMessagesInput{Messages: batch("b", 10, MessageTypeRequest)})
+	if err != nil {
+		t.Fatalf("send batch: %v", err)
+	}
+	var states []MessageState
+	for i, r := range results {
+		switch {
+		case i < 3 && r.Message != nil:
+			states = append(states, r.Message.State)
+		case i >= 3 && r.Err != nil && r.Err.(*Error).Code == CodeRateLimited:
+		default:
+			t.Fatalf("unexpected result %d: %#v", i, r)
+		}
+	}
+	if !reflect.DeepEqual(states, []MessageState{StateWaitingAck, StatePending, StatePending}) {
+		t.Fatalf("expected one delivered and two held, got %v", states)
+	}
+
+	// Informs to a pull agent stop at its unread inbox limit.
+	results, err = s.SendMessages(SendMessagesInput{Messages: batch("c", 5, MessageTypeInform)})
+	if err != nil {
+		t.Fatalf("send inform batch: %v", err)
+	}
+	for i, r := range results {
+		if sent := r.Err == nil; sent != (i < 3) {
+			t.Fatalf("expected only the first 3 informs sent, item %d: %#v", i, r)
+		}
+	}
+	if be := results[3].Err.(*Error); be.Code != CodeUnavailable {
+		t.Fatalf("expected unavailable once the inbox is full, got %v", be)
+	}
+	if events, _, _ := s.PollInbox(PollInboxInput{AgentID: "c"}); len(events) != 3 {
+		t.Fatalf("expected 3 inbox events, got %d", len(events))
+	}
+}
+
+func TestHeldRequestsReleaseByPriority(t *testing.T) {
+	s, _ := newTestStore(t)
+	if _, err := s.RegisterAgent(RegisterAgentInput{AgentID: "a", Mode: AgentModePull, TTLSeconds: 600}); err != nil {
+		t.Fatalf("register a: %v", err)
+	}
+	if _, err := s.RegisterAgent(RegisterAgentInput{AgentID: "b", Mode: AgentModePull, TTLSeconds: 600, Metadata: AgentMetadata{MaxInFlight: 1}}); err != nil {
+		t.Fatalf("register b: %v", err)
+	}
+	if _, _, err := s.SendMessage(SendMessageInput{To: "b", From: "a", RequestID: "rid-bad", Body: "x", Priority: "asap"}); err == nil || err.(*Error).Code != CodeValidation {
+		t.Fatalf("expected invalid priority to be rejected, got %v", err)
+	}
+
+	ids := map[string]string{}
+	for _, send := range []struct {
+		rid      string
+		priority MessagePriority
+	}{
+		{"first", ""}, {"routine", PriorityNormal}, {"backfill", PriorityLow}, {"rush", PriorityUrgent}, {"review", PriorityHigh},
+	} {
+		m, _, err := s.SendMessage(SendMessageInput{To: "b", From: "a", RequestID: send.rid, Body: "screen", Priority: send.priority})
+		if err != nil {
+			t.Fatalf("send %s: %v", send.rid, err)
+		}
+		ids[send.rid] = m.MessageID
+	}
+	if m, _ := s.GetMessageForTest(ids["first"]); m.Priority != PriorityNormal {
+		t.Fatalf("expected default priority normal, got %q", m.Priority)
+	}
+	system := s.SystemStatus()["system"].(map[string]any)
+	held := system["held_requests"].(map[MessagePriority]int)
+	if held[PriorityUrgent] != 1 || held[PriorityHigh] != 1 || held[PriorityNormal] != 1 || held[PriorityLow] != 1 {
+		t.Fatalf("unexpected held queue depth: %v", held)
+	}
+	if events, _, _ := s.PollInbox(PollInboxInput{AgentID: "b"}); len(events) != 1 || events[0].MessageID != ids["first"] {
+		t.Fatalf("expected only the first request delivered, got %#v", events)
+	}
+
+	var order []string
+	current := "first"
+	for i := 0; i < 4; i++ {
+		if err := s.PostEvent(EventInput{ActorAgentID: "b", MessageID: ids[current], Type: "final", Body: "done"}); err != nil {
+			t.Fatalf("final %s: %v", current, err)
+		}
+		events, _, _ := s.PollInbox(PollInboxInput{AgentID: "b", Cursor: i + 1})
+		if len(events) != 1 {
+			t.Fatalf("expected one released request, got %#v", events)
+		}
+		for rid, id := range ids {
+			if id == events[0].MessageID {
+				current = rid
+			}
+		}
+		order = append(order, current)
+	}
+	if want := []string{"rush", "review", "routine", "backfill"}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("expected release order %v, got %v", want, order)
+	}
+}
+
+func TestInboxDeliversByPriorityWithoutMaxInFlight(t *testing.T) {
+	s, _ := newTestStore(t)
+	registerPair(t, s, 600, 600)
+	send := func(rid string, priority MessagePriority) {
+		t.Helper()
+		if _, _, err := s.SendMessage(SendMessageInput{To: "b", From: "a", RequestID: rid, Body: "screen", Priority: priority}); err != nil {
+			t.Fatalf("send %s: %v", rid, err)
+		}
+	}
+	poll := func(cursor int) ([]string, int) {
+		t.Helper()
+		events, next, err := s.PollInbox(PollInboxInput{AgentID: "b", Cursor: cursor})
+		if err != nil {
+			t.Fatalf("poll: %v", err)
+		}
+		var rids []string
+		for _, evt := range events {
+			m, _ := s.GetMessageForTest(evt.MessageID)
+			rids = append(rids, m.RequestID)
+		}
+		return rids, next
+	}
+
+	send("routine-1", PriorityNormal)
+	send("backfill", PriorityLow)
+	send("routine-2", "")
+	send("rush", PriorityUrgent)
+	send("review", PriorityHigh)
+	queued := s.SystemStatus()["system"].(map[string]any)["queued_requests"].(map[MessagePriority]int)
+	if queued[PriorityUrgent] != 1 || queued[PriorityHigh] != 1 || queued[PriorityNormal] != 2 || queued[PriorityLow] != 1 {
+		t.Fatalf("unexpected queued depth: %v", queued)
+	}
+	got, next := poll(0)
+	if want := []string{"rush", "review", "routine-1", "routine-2", "backfill"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected priority order %v, got %v", want, got)
+	}
+	if next != 5 {
+		t.Fatalf("expected next cursor 5, got %d", next)
+	}
+
+	// Events already returned keep their positions, so a re-poll from an
+	// earlier cursor sees the same events.
+	send("later", PriorityLow)
+	send("rush-2", PriorityUrgent)
+	if got, _ := poll(3); !reflect.DeepEqual(got, []string{"routine-2", "backfill", "rush-2", "later"}) {
+		t.Fatalf("expected returned events unmoved, got %v", got)
+	}
+	queued = s.SystemStatus()["system"].(map[string]any)["queued_requests"].(map[MessagePriority]int)
+	if queued[PriorityUrgent]+queued[PriorityHigh]+queued[PriorityNormal]+queued[PriorityLow] != 0 {
+		t.Fatalf("expected nothing queued after the poll, got %v", queued)
+	}
+}
+
+func TestPushCallbacksSentByPriority(t *testing.T) {
+	s, _ := newTestStore(t)
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var order []string
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			MessageID string `json:"message_id"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		mu.Lock()
+		order = append(order, payload.MessageID)
+		first := len(order) == 1
+		mu.Unlock()
+		if first {
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer callback.Close()
+
+	if _, err := s.RegisterAgent(RegisterAgentInput{AgentID: "a", Mode: AgentModePull, TTLSeconds: 60}); err != nil {
+		t.Fatalf("register a: %v", err)
+	}
+	if _, err := s.RegisterAgent(RegisterAgentInput{AgentID: "p", Mode: AgentModePush, CallbackURL: callback.URL, TTLSeconds: 60}); err != nil {
+		t.Fatalf("register p: %v", err)
+	}
+	ids := map[string]string{}
+	for _, send := range []struct {
+		rid      string
+		priority MessagePriority
+	}{
+		{"first", PriorityNormal}, {"routine", PriorityNormal}, {"backfill", PriorityLow}, {"rush", PriorityUrgent},
+	} {
+		m, _, err := s.SendMessage(SendMessageInput{To: "p", From: "a", RequestID: send.rid, Body: "screen", Priority: send.priority})
+		if err != nil {
+			t.Fatalf("send %s: %v", send.rid, err)
+		}
+		ids[m.MessageID] = send.rid
+		if send.rid == "first" {
+			// Wait until the first callback is in progress, so the rest
+			// queue behind it.
+			for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+				mu.Lock()
+				n := len(order)
+				mu.Unlock()
+				if n == 1 {
+					break
+				}
+			}
+		}
+	}
+	queued := s.SystemStatus()["system"].(map[string]any)["queued_requests"].(map[MessagePriority]int)
+	if queued[PriorityUrgent] != 1 || queued[PriorityNormal] != 1 || queued[PriorityLow] != 1 {
+		t.Fatalf("unexpected queued depth: %v", queued)
+	}
+	close(release)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		n := len(order)
+		mu.Unlock()
+		if n == 4 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	var got []string
+	for _, id := range order {
+		got = append(got, ids[id])
+	}
+	if want := []string{"first", "rush", "routine", "backfill"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected push order %v, got %v", want, got)
+	}
+}
+
+func TestScheduledDelivery(t *testing.T) {
+	s, now := newTestStore(t)
+	for _, id := range []string{"a", "b", "c"} {
+		if _, err := s.RegisterAgent(RegisterAgentInput{AgentID: id, Mode: AgentModePull, TTLSeconds: 600}); err != nil {
+			t.Fatalf("register %s: %v", id, err)
+		}
+	}
+	for _, in := range []SendMessageInput{
+		{DelaySeconds: -1},
+		{DelaySeconds: 10, DeliverAt: now.Add(time.Minute)},
+		{DelaySeconds: 10, Type: MessageTypeResponse, InReplyTo: "m-000001", CompleteRequest: true},
+	} {
+		in.To, in.From, in.RequestID, in.Body = "b", "a", "rid-bad", "x"
+		if _, _, err := s.SendMessage(in); err == nil || err.(*Error).Code != CodeValidation {
+			t.Fatalf("expected validation error for %+v, got %v", in, err)
+		}
+	}
+
+	req, _, err := s.SendMessage(SendMessageInput{To: "b", From: "a", RequestID: "rid-req", Body: "follow up", TTLSeconds: 10, DeliverAt: now.Add(30 * time.Second)})
+	if err != nil {
+		t.Fatalf("schedule request: %v", err)
+	}
+	inform, _, err := s.SendMessage(SendMessageInput{To: "b", From: "a", RequestID: "rid-inform", Type: MessageTypeInform, Body: "reminder", DelaySeconds: 60})
+	if err != nil {
+		t.Fatalf("schedule inform: %v", err)
+	}
+	orphan, _, err := s.SendMessage(SendMessageInput{To: "c", From: "a", RequestID: "rid-orphan", Body: "later", DelaySeconds: 20})
+	if err != nil {
+		t.Fatalf("schedule orphan: %v", err)
+	}
+	if req.State != StateScheduled || req.DeliverAt == nil || !req.DeliverAt.Equal(now.Add(30*time.Second)) {
+		t.Fatalf("expected scheduled request, got %#v", req)
+	}
+	if detail, _ := s.GetMessage(req.MessageID); !detail.TTLExpiresAt.Equal(now.Add(40 * time.Second)) {
+		t.Fatalf("expected ttl to count from delivery, got %v", detail.TTLExpiresAt)
+	}
+	if events, _, _ := s.PollInbox(PollInboxInput{AgentID: "b"}); len(events) != 0 {
+		t.Fatalf("expected nothing delivered yet, got %#v", events)
+	}
+	if err := s.Ack(AckInput{AgentID: "b", MessageID: req.MessageID, Status: "accepted"}); err == nil || err.(*Error).Code != CodeRejected {
+		t.Fatalf("expected ack before delivery to be rejected, got %v", err)
+	}
+
+	listed, err := s.ListScheduled("a")
+	if err != nil {
+		t.Fatalf("list scheduled: %v", err)
+	}
+	if len(listed) != 3 || listed[0].MessageID != orphan.MessageID || listed[1].MessageID != req.MessageID || listed[2].MessageID != inform.MessageID {
+		t.Fatalf("expected scheduled messages in due order, got %#v", listed)
+	}
+	if _, err := s.CancelScheduled(CancelScheduledInput{MessageID: inform.MessageID, AgentID: "b"}); err == nil || err.(*Error).Code != CodeUnauthorized {
+		t.Fatalf("expected only the sender to cancel, got %v", err)
+	}
+	cancelled, err := s.CancelScheduled(CancelScheduledInput{MessageID: inform.MessageID, AgentID: "a"})
+	if err != nil || cancelled.State != StateCancelled {
+		t.Fatalf("cancel: %#v %v", cancelled, err)
+	}
+	if _, err := s.CancelScheduled(CancelScheduledInput{MessageID: inform.MessageID, AgentID: "a"}); err == nil || err.(*Error).Code != CodeRejected {
+		t.Fatalf("expected second cancel to be rejected, got %v", err)
+	}
+
+	if _, err := s.DeregisterAgent(DeregisterAgentInput{AgentID: "c"}); err != nil {
+		t.Fatalf("deregister c: %v", err)
+	}
+	*now = now.Add(30 * time.Second)
+	if n := s.DeliverDue(); n != 2 {
+		t.Fatalf("expected two messages due, got %d", n)
+	}
+	if m, _ := s.GetMessageForTest(orphan.MessageID); m.State != StateError || m.Result.ErrorCode != CodeUnavailable {
+		t.Fatalf("expected message to a deregistered agent to fail, got %#v", m)
+	}
+	detail, _ := s.GetMessage(req.MessageID)
+	last := detail.StateHistory[len(detail.StateHistory)-1]
+	if detail.State != StateWaitingAck || last.From != StateScheduled || last.Reason != "scheduled delivery" {
+		t.Fatalf("expected request delivered on schedule, got %#v", detail)
+	}
+
+	*now = now.Add(time.Minute)
+	events, _, _ := s.PollInbox(PollInboxInput{AgentID: "b"})
+	if len(events) != 1 || events[0].MessageID != req.MessageID {
+		t.Fatalf("expected only the request in the inbox, got %#v", events)
+	}
+	if listed, _ := s.ListScheduled("a"); len(listed) != 0 {
+		t.Fatalf("expected nothing left scheduled, got %#v", listed)
+	}
+}
+
+func TestMulticastInform(t *testing.T) {
+	s, now := newTestStore(t)
+	for _, reg := range []RegisterAgentInput{
+		{AgentID: "a", Mode: AgentModePull, TTLSeconds: 600},
+		{AgentID: "b", Mode: AgentModePull, TTLSeconds: 600, Capabilities: []string{"patent-screen@2.0"}},
+		{AgentID: "c", Mode: AgentModePull, TTLSeconds: 600, Capabilities: []string{"patent-screen@2.1"}},
+		{AgentID: "d", Mode: AgentModePull, TTLSeconds: 600, Capabilities: []string{"prior-art"}},
+		{AgentID: "gone", Mode: AgentModePull, TTLSeconds: 600},
+	} {
+		if _, err := s.RegisterAgent(reg); err != nil {
+			t.Fatalf("register %s: %v", reg.AgentID, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		in   SendMessageInput
+		code string
+	}{
+		{SendMessageInput{To: "b", Type: MessageTypeInform, Recipients: []string{"c"}}, CodeValidation},
+		{SendMessageInput{Type: MessageTypeRequest, Recipients: []string{"c"}}, CodeValidation},
+		{SendMessageInput{Type: MessageTypeInform, Recipients: []string{"c"}, DelaySeconds: 10}, CodeValidation},
+		{SendMessageInput{Type: MessageTypeInform, Recipients: []string{"capability:patent-screen@two"}}, CodeValidation},
+		{SendMessageInput{Type: MessageTypeInform, Recipients: []string{"c", "nobody"}}, CodeNotFound},
+		{SendMessageInput{Type: MessageTypeInform, Recipients: []string{"conversation:c-missing"}}, CodeNotFound},
+		{SendMessageInput{Type: MessageTypeInform, Recipients: []string{"a"}}, CodeNotFound},
+	} {
+		tc.in.From, tc.in.RequestID, tc.in.Body = "a", "rid-bad", "x"
+		if _, _, err := s.SendMessage(tc.in); err == nil || err.(*Error).Code != tc.code {
+			t.Fatalf("expected %s for %+v, got %v", tc.code, tc.in, err)
+		}
+	}
+
+	req, _, err := s.SendMessage(SendMessageInput{To: "d", From: "a", ConversationID: "case-2026-124", RequestID: "rid-req", Body: "search prior art"})
+	if err != nil {
+		t.Fatalf("send request: %v", err)
+	}
+	if _, err := s.DeregisterAgent(DeregisterAgentInput{AgentID: "gone"}); err != nil {
+		t.Fatalf("deregister: %v", err)
+	}
+
+	in := SendMessageInput{
+		From:           "a",
+		ConversationID: req.ConversationID,
+		RequestID:      "rid-withdrawn",
+		Type:           MessageTypeInform,
+		Body:           "case 2026-124 withdrawn",
+		Recipients:     []string{"capability:patent-screen@^2", "conversation:" + req.ConversationID, "c", "gone"},
+	}
+	parent, dup, err := s.SendMessage(in)
+	if err != nil || dup {
+		t.Fatalf("multicast: %#v %v %v", parent, dup, err)
+	}
+	if parent.To != "" || parent.State != StateCompleted || strings.Join(parent.Recipients, ",") != "b,c,d,gone" {
+		t.Fatalf("expected completed parent naming each recipient once, got %#v", parent)
+	}
+	if again, dup, err := s.SendMessage(in); err != nil || !dup || again.MessageID != parent.MessageID {
+		t.Fatalf("expected retried multicast to dedupe, got %#v %v %v", again, dup, err)
+	}
+
+	detail, err := s.GetMessage(parent.MessageID)
+	if err != nil {
+		t.Fatalf("get parent: %v", err)
+	}
+	if len(detail.Deliveries) != 4 {
+		t.Fatalf("expected four deliveries, got %#v", detail.Deliveries)
+	}
+	for i, d := range detail.Deliveries {
+		if d.AgentID != parent.Recipients[i] {
+			t.Fatalf("expected deliveries in recipient order, got %#v", detail.Deliveries)
+		}
+		if d.AgentID == "gone" {
+			if d.State != StateError || d.Error != "target agent unavailable" || d.DeliveredAt != nil {
+				t.Fatalf("expected delivery to a deregistered agent to fail, got %#v", d)
+			}
+			continue
+		}
+		if d.State != StatePending || d.DeliveredAt != nil {
+			t.Fatalf("expected copy pending until polled, got %#v", d)
+		}
+		*now = now.Add(time.Second)
+		events, _, _ := s.PollInbox(PollInboxInput{AgentID: d.AgentID})
+		last := events[len(events)-1]
+		if last.MessageID != d.MessageID || last.ParentID != parent.MessageID || last.Body != in.Body {
+			t.Fatalf("expected %s's copy in its inbox, got %#v", d.AgentID, events)
+		}
+		if c, _ := s.GetMessageForTest(d.MessageID); c.State != StateCompleted || !c.DeliveredAt.Equal(*now) {
+			t.Fatalf("expected copy delivered when polled, got %#v", c)
+		}
+	}
+	if events, _, _ := s.PollInbox(PollInboxInput{AgentID: "a"}); len(events) != 0 {
+		t.Fatalf("expected the sender to get no copy, got %#v", events)
+	}
+
+	_, msgs, _, err := s.ListConversationMessages(ListConversationMessagesInput{ConversationID: req.ConversationID})
+	if err != nil {
+		t.Fatalf("list conversation: %v", err)
+	}
+	if len(msgs) != 2 || msgs[1].MessageID != parent.MessageID {
+		t.Fatalf("expected only the parent listed in the conversation, got %#v", msgs)
+	}
+	if hits, _ := s.Search(SearchInput{Query: "withdrawn"}); len(hits) != 1 || hits[0].MessageID != parent.MessageID {
+		t.Fatalf("expected search to find only the parent, got %#v", hits)
+	}
+
+	all, _, err := s.SendMessage(SendMessageInput{From: "b", RequestID: "rid-all", Type: MessageTypeInform, Body: "maintenance", Recipients: []string{"capability:*"}})
+	if err != nil {
+		t.Fatalf("multicast to all: %v", err)
+	}
+	if strings.Join(all.Recipients, ",") != "a,c,d" {
+		t.Fatalf("expected every other active agent, got %v", all.Recipients)
+	}
+}
+
+func TestMulticastCopiesSettleByDeliveryOutcome(t *testing.T) {
+	s, _ := newTestStore(t)
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }))
+	defer ok.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusInternalServerError) }))
+	defer failing.Close()
+	for _, reg := range []RegisterAgentInput{
+		{AgentID: "a", Mode: AgentModePull, TTLSeconds: 600},
+		{AgentID: "ok", Mode: AgentModePush, CallbackURL: ok.URL, TTLSeconds: 600},
+		{AgentID: "failing", Mode: AgentModePush, CallbackURL: failing.URL, TTLSeconds: 600},
+		{AgentID: "leaving", Mode: AgentModePull, TTLSeconds: 600},
+	} {
+		if _, err := s.RegisterAgent(reg); err != nil {
+			t.Fatalf("register %s: %v", reg.AgentID, err)
+		}
+	}
+	parent, _, err := s.SendMessage(SendMessageInput{From: "a", RequestID: "rid-fyi", Type: MessageTypeInform, Body: "fyi", Recipients: []string{"ok", "failing", "leaving"}})
+	if err != nil {
+		t.Fatalf("multicast: %v", err)
+	}
+	if _, err := s.DeregisterAgent(DeregisterAgentInput{AgentID: "leaving"}); err != nil {
+		t.Fatalf("deregister: %v", err)
+	}
+
+	var deliveries map[string]Delivery
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		detail, err := s.GetMessage(parent.MessageID)
+		if err != nil {
+			t.Fatalf("get parent: %v", err)
+		}
+		deliveries = map[string]Delivery{}
+		settled := true
+		for _, d := range detail.Deliveries {
+			deliveries[d.AgentID] = d
+			settled = settled && d.State != StatePending
+		}
+		if settled || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if d := deliveries["ok"]; d.State != StateCompleted || d.DeliveredAt == nil {
+		t.Fatalf("expected the pushed copy delivered, got %#v", d)
+	}
+	if d := deliveries["failing"]; d.State != StateError || d.Error != "push callback failed" || d.DeliveredAt != nil {
+		t.Fatalf("expected the failed push recorded on the copy, got %#v", d)
+	}
+	if d := deliveries["leaving"]; d.State != StateError || d.Error != "target agent unavailable" {
+		t.Fatalf("expected the copy for a deregistered agent to fail, got %#v", d)
+	}
+}
+
+func TestMulticastCopyExpiresWhenNeverPolled(t *testing.T) {
+	s, now := newTestStore(t)
+	registerPair(t, s, 3600, 3600)
+	parent, _, err := s.SendMessage(SendMessageInput{From: "a", RequestID: "rid-fyi", Type: MessageTypeInform, Body: "fyi", Recipients: []string{"b"}, TTLSeconds: 60})
+	if err != nil {
+		t.Fatalf("multicast: %v", err)
+	}
+	*now = now.Add(30 * time.Second)
+	if detail, _ := s.GetMessage(parent.MessageID); len(detail.Deliveries) != 1 || detail.Deliveries[0].State != StatePending {
+		t.Fatalf("expected the copy pending within its ttl, got %#v", detail.Deliveries)
+	}
+
+	*now = now.Add(31 * time.Second)
+	detail, err := s.GetMessage(parent.MessageID)
+	if err != nil {
+		t.Fatalf("get parent: %v", err)
+	}
+	if len(detail.Deliveries) != 1 || detail.Deliveries[0].State != StateError || detail.Deliveries[0].Error != "ttl timeout" {
+		t.Fatalf("expected the unpolled copy to time out, got %#v", detail.Deliveries)
+	}
+	if _, _, err := s.PollInbox(PollInboxInput{AgentID: "b"}); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if detail, _ := s.GetMessage(parent.MessageID); detail.Deliveries[0].State != StateError {
+		t.Fatalf("expected a late poll to leave the timed-out copy alone, got %#v", detail.Deliveries)
+	}
+}
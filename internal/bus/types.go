@@ -17,6 +17,19 @@ const (
 	MessageTypeInform   MessageType = "inform"
 )
 
+// MessagePriority orders the messages waiting for an agent, whether held on
+// the bus, not yet polled from its inbox, or queued for its push callback:
+// higher priorities are delivered first, equal ones in the order they were
+// sent. An empty priority is normal.
+type MessagePriority string
+
+const (
+	PriorityLow    MessagePriority = "low"
+	PriorityNormal MessagePriority = "normal"
+	PriorityHigh   MessagePriority = "high"
+	PriorityUrgent MessagePriority = "urgent"
+)
+
 type MessageState string
 
 const (
@@ -26,6 +39,10 @@ const (
 	StateCompleted  MessageState = "completed"
 	StateRejected   MessageState = "rejected"
 	StateError      MessageState = "error"
+	// StateScheduled is a message waiting on the bus for its deliver_at;
+	// StateCancelled is one its sender cancelled before then.
+	StateScheduled MessageState = "scheduled"
+	StateCancelled MessageState = "cancelled"
 )
 
 type AgentStatus string
@@ -33,6 +50,9 @@ type AgentStatus string
 const (
 	AgentStatusActive  AgentStatus = "active"
 	AgentStatusExpired AgentStatus = "expired"
+	// AgentStatusDeregistered marks an agent that left with DeregisterAgent.
+	// Like an expired agent it can come back by registering again.
+	AgentStatusDeregistered AgentStatus = "deregistered"
 )
 
 type EventType string
@@ -45,6 +65,16 @@ const (
 	ObserveAgentRegistered EventType = "agent_registered"
 	ObserveAgentExpired    EventType = "agent_expired"
 	ObserveHumanInjection  EventType = "human_injection"
+	// ObserveConversationStatus reports a conversation lifecycle change.
+	ObserveConversationStatus EventType = "conversation_status"
+	// ObserveConversationUpdated reports a title, meta or tag change.
+	ObserveConversationUpdated EventType = "conversation_updated"
+	// ObserveAgentHealth reports a change in an agent's self-reported
+	// health status.
+	ObserveAgentHealth EventType = "agent_health"
+	// ObserveAgentDeregistered reports an agent leaving and what happened to
+	// the requests it had not accepted.
+	ObserveAgentDeregistered EventType = "agent_deregistered"
 )
 
 type Attachment struct {
@@ -53,6 +83,26 @@ type Attachment struct {
 	ContentType string `json:"content_type,omitempty"`
 	Size        int64  `json:"size,omitempty"`
 	SHA256      string `json:"sha256,omitempty"`
+	// Verification is set by the bus when attachment verification is
+	// enabled; any value supplied by the sender is discarded.
+	Verification *AttachmentVerification `json:"verification,omitempty"`
+}
+
+const (
+	AttachmentVerified     = "verified"
+	AttachmentVerifyFailed = "failed"
+)
+
+// AttachmentVerification is the outcome of fetching an attachment when its
+// message was sent. Size, SHA256 and ContentType describe the content the bus
+// read, which may differ from what the sender declared.
+type AttachmentVerification struct {
+	Status      string    `json:"status"`
+	Reason      string    `json:"reason,omitempty"`
+	Size        int64     `json:"size,omitempty"`
+	SHA256      string    `json:"sha256,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	CheckedAt   time.Time `json:"checked_at"`
 }
 
 type Agent struct {
@@ -65,48 +115,196 @@ type Agent struct {
 	RegisteredAt time.Time   `json:"registered_at"`
 	ExpiresAt    time.Time   `json:"expires_at"`
 	TTLSeconds   int         `json:"-"`
+	// Schemas maps a capability to the JSON Schemas its messages must
+	// satisfy.
+	Schemas  map[string]CapabilitySchema `json:"schemas,omitempty"`
+	Metadata AgentMetadata               `json:"metadata"`
+	// Health is the agent's last heartbeat report, nil until it sends one.
+	Health *AgentHealth `json:"health,omitempty"`
 }
 
+// AgentMetadata describes the build behind an agent registration. The bus
+// stores it for operators and enforces MaxInFlight: once that many requests
+// are delivered to the agent and unfinished, further requests wait on the
+// bus. Zero means no limit.
+type AgentMetadata struct {
+	Version     string            `json:"version,omitempty"`
+	BuildSHA    string            `json:"build_sha,omitempty"`
+	MaxInFlight int               `json:"max_in_flight,omitempty"`
+	CostClass   string            `json:"cost_class,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+type HealthStatus string
+
+const (
+	HealthOK        HealthStatus = "ok"
+	HealthDegraded  HealthStatus = "degraded"
+	HealthUnhealthy HealthStatus = "unhealthy"
+)
+
+// HealthCheck is one named probe an agent ran, such as whether its LLM key
+// is accepted.
+type HealthCheck struct {
+	Name   string       `json:"name"`
+	Status HealthStatus `json:"status"`
+	Detail string       `json:"detail,omitempty"`
+}
+
+// AgentHealth is what an agent last reported in a heartbeat. Status is the
+// worst of the reported status and its checks.
+type AgentHealth struct {
+	Status     HealthStatus  `json:"status"`
+	InFlight   int           `json:"in_flight"`
+	Load       float64       `json:"load,omitempty"`
+	Checks     []HealthCheck `json:"checks,omitempty"`
+	ReportedAt time.Time     `json:"reported_at"`
+}
+
+const (
+	ConversationStatusActive   = "active"
+	ConversationStatusClosed   = "closed"
+	ConversationStatusArchived = "archived"
+)
+
 type Conversation struct {
-	ConversationID string    `json:"conversation_id"`
-	Title          string    `json:"title,omitempty"`
-	Participants   []string  `json:"participants,omitempty"`
-	Status         string    `json:"status"`
-	MessageCount   int       `json:"message_count"`
-	CreatedAt      time.Time `json:"created_at"`
-	LastMessageAt  time.Time `json:"last_message_at"`
-	Meta           any       `json:"meta,omitempty"`
+	ConversationID  string     `json:"conversation_id"`
+	Title           string     `json:"title,omitempty"`
+	Participants    []string   `json:"participants,omitempty"`
+	Status          string     `json:"status"`
+	StatusChangedAt *time.Time `json:"status_changed_at,omitempty"`
+	StatusReason    string     `json:"status_reason,omitempty"`
+	MessageCount    int        `json:"message_count"`
+	CreatedAt       time.Time  `json:"created_at"`
+	LastMessageAt   time.Time  `json:"last_message_at"`
+	Meta            any        `json:"meta,omitempty"`
+	Tags            []string   `json:"tags,omitempty"`
 }
 
 type Message struct {
-	MessageID      string       `json:"message_id"`
-	Type           MessageType  `json:"type"`
-	From           string       `json:"from"`
-	To             string       `json:"to,omitempty"`
-	ConversationID string       `json:"conversation_id,omitempty"`
-	RequestID      string       `json:"request_id"`
-	InReplyTo      string       `json:"in_reply_to,omitempty"`
-	Body           string       `json:"body"`
-	Meta           any          `json:"meta,omitempty"`
-	Attachments    []Attachment `json:"attachments,omitempty"`
-	State          MessageState `json:"state,omitempty"`
-	CreatedAt      time.Time    `json:"created_at"`
-	DeliveredAt    time.Time    `json:"-"`
-	LastProgressAt time.Time    `json:"-"`
-	TTLExpiresAt   time.Time    `json:"-"`
-	GraceUntil     time.Time    `json:"-"`
-	QueuedForAgent bool         `json:"-"`
+	MessageID      string      `json:"message_id"`
+	Type           MessageType `json:"type"`
+	From           string      `json:"from"`
+	To             string      `json:"to,omitempty"`
+	ConversationID string      `json:"conversation_id,omitempty"`
+	RequestID      string      `json:"request_id"`
+	InReplyTo      string      `json:"in_reply_to,omitempty"`
+	// ParentID is set on each recipient's copy of a multicast message and
+	// names the message the sender sent; Recipients is set on that message
+	// and lists who it was fanned out to.
+	ParentID    string          `json:"parent_id,omitempty"`
+	Recipients  []string        `json:"recipients,omitempty"`
+	Capability  string          `json:"capability,omitempty"`
+	Priority    MessagePriority `json:"priority,omitempty"`
+	Replies     []string        `json:"replies,omitempty"`
+	Body        string          `json:"body"`
+	Meta        any             `json:"meta,omitempty"`
+	Attachments []Attachment    `json:"attachments,omitempty"`
+	State       MessageState    `json:"state,omitempty"`
+	Result      *Result         `json:"result,omitempty"`
+	TraceParent string          `json:"trace_parent,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	// DeliverAt is when a scheduled message is (or was) due for delivery.
+	DeliverAt      *time.Time `json:"deliver_at,omitempty"`
+	DeliveredAt    time.Time  `json:"-"`
+	LastProgressAt time.Time  `json:"-"`
+	TTLExpiresAt   time.Time  `json:"-"`
+	GraceUntil     time.Time  `json:"-"`
+	QueuedForAgent bool       `json:"-"`
+	// HeldForCapacity marks a pending request the bus is holding until its
+	// target has room under max_in_flight.
+	HeldForCapacity bool `json:"-"`
+}
+
+// CallResult is the outcome of Call: the request as it stood when the wait
+// ended and the first response to it, if one arrived. TimedOut is set when
+// neither a terminal state nor a reply was seen in time.
+type CallResult struct {
+	Request   *Message
+	Reply     *Message
+	Duplicate bool
+	TimedOut  bool
+}
+
+// Result is the outcome of a request, taken from its final or error event.
+// ErrorCode is set for error outcomes: the code the agent reported, or
+// CodeTimeout when the bus gave up on the request.
+type Result struct {
+	Body        string       `json:"body"`
+	Meta        any          `json:"meta,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+	ErrorCode   string       `json:"error_code,omitempty"`
+}
+
+// MessageDetail is a Message together with the delivery bookkeeping that
+// listings omit, as returned by GetMessage.
+type MessageDetail struct {
+	Message
+	DeliveredAt     *time.Time        `json:"delivered_at,omitempty"`
+	LastProgressAt  *time.Time        `json:"last_progress_at,omitempty"`
+	TTLExpiresAt    time.Time         `json:"ttl_expires_at"`
+	GraceUntil      *time.Time        `json:"grace_until,omitempty"`
+	QueuedForAgent  bool              `json:"queued_for_agent"`
+	HeldForCapacity bool              `json:"held_for_capacity"`
+	StateHistory    []StateTransition `json:"state_history"`
+	// Deliveries tracks each recipient's copy of a multicast message.
+	Deliveries []Delivery `json:"deliveries,omitempty"`
+}
+
+// Delivery is one recipient's copy of a multicast message. State is pending
+// until the recipient polls the copy or its push callback succeeds; Error
+// explains a copy that could not be delivered.
+type Delivery struct {
+	AgentID     string       `json:"agent_id"`
+	MessageID   string       `json:"message_id"`
+	State       MessageState `json:"state"`
+	DeliveredAt *time.Time   `json:"delivered_at,omitempty"`
+	Error       string       `json:"error,omitempty"`
+}
+
+// StateTransition is one entry in a message's state history. Actor is the
+// agent that caused the change and is empty for bus-driven timeouts; Body and
+// Meta carry the payload of a final or error event.
+type StateTransition struct {
+	From   MessageState `json:"from"`
+	To     MessageState `json:"to"`
+	At     time.Time    `json:"at"`
+	Actor  string       `json:"actor,omitempty"`
+	Reason string       `json:"reason,omitempty"`
+	Body   string       `json:"body,omitempty"`
+	Meta   any          `json:"meta,omitempty"`
+}
+
+// ConversationEvent is one entry in a conversation's history: progress on
+// one of its requests (Type progress), a lifecycle change (conversation_status)
+// or a title, meta or tags update (conversation_updated). Unlike the observe
+// log, the history is never trimmed and is persisted with the conversation.
+type ConversationEvent struct {
+	Type       EventType `json:"type"`
+	At         time.Time `json:"at"`
+	Actor      string    `json:"actor,omitempty"`
+	MessageID  string    `json:"message_id,omitempty"`
+	FromStatus string    `json:"from_status,omitempty"`
+	ToStatus   string    `json:"to_status,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	Changed    []string  `json:"changed,omitempty"`
+	Body       string    `json:"body,omitempty"`
+	Meta       any       `json:"meta,omitempty"`
 }
 
 type InboxEvent struct {
-	MessageID      string       `json:"message_id"`
-	Type           MessageType  `json:"type"`
-	From           string       `json:"from"`
-	ConversationID string       `json:"conversation_id,omitempty"`
-	Body           string       `json:"body"`
-	Meta           any          `json:"meta,omitempty"`
-	Attachments    []Attachment `json:"attachments,omitempty"`
-	CreatedAt      time.Time    `json:"created_at"`
+	MessageID      string          `json:"message_id"`
+	ParentID       string          `json:"parent_id,omitempty"`
+	Type           MessageType     `json:"type"`
+	From           string          `json:"from"`
+	ConversationID string          `json:"conversation_id,omitempty"`
+	Capability     string          `json:"capability,omitempty"`
+	Priority       MessagePriority `json:"priority,omitempty"`
+	Body           string          `json:"body"`
+	Meta           any             `json:"meta,omitempty"`
+	Attachments    []Attachment    `json:"attachments,omitempty"`
+	TraceParent    string          `json:"trace_parent,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
 }
 
 type ObserveEvent struct {
@@ -125,6 +323,39 @@ type RegisterAgentInput struct {
 	Mode         AgentMode
 	CallbackURL  string
 	TTLSeconds   int
+	// Schemas is keyed by capability; every key must be in Capabilities.
+	Schemas  map[string]CapabilitySchema
+	Metadata AgentMetadata
+}
+
+type DeregisterAgentInput struct {
+	AgentID string
+	Reason  string
+}
+
+// ReroutedMessage is a request DeregisterAgent handed to another agent.
+type ReroutedMessage struct {
+	MessageID string `json:"message_id"`
+	To        string `json:"to"`
+}
+
+// DeregisterResult lists what happened to the requests a departing agent
+// had not accepted: those for a capability another healthy agent offers are
+// rerouted, the rest fail.
+type DeregisterResult struct {
+	Agent    Agent             `json:"agent"`
+	Rerouted []ReroutedMessage `json:"rerouted"`
+	Failed   []string          `json:"failed"`
+}
+
+// HeartbeatInput renews an agent's registration and records its health.
+// Status may be empty, in which case it is derived from Checks.
+type HeartbeatInput struct {
+	AgentID  string
+	Status   HealthStatus
+	InFlight int
+	Load     float64
+	Checks   []HealthCheck
 }
 
 type CreateConversationInput struct {
@@ -132,11 +363,47 @@ type CreateConversationInput struct {
 	Title          string
 	Participants   []string
 	Meta           any
+	Tags           []string
 }
 
+// ListAgentsFilter narrows and pages ListAgents. Expired agents are left out
+// unless IncludeExpired is set. Sort is agent_id (default), registered_at or
+// expires_at; Order is asc (default) or desc. Limit defaults to 50, capped
+// at 200; the returned cursor, passed back as Cursor with the same Sort and
+// Order, fetches the next page. Each
+// Labels entry is key=value, or a bare key matching any value; all must
+// hold.
+type ListAgentsFilter struct {
+	Capability       string
+	Labels           []string
+	IncludeExpired   bool
+	RegisteredAfter  time.Time
+	RegisteredBefore time.Time
+	Sort             string
+	Order            string
+	Cursor           string
+	Limit            int
+}
+
+// ListConversationsFilter narrows ListConversations. Every entry in Tags must
+// be present on a conversation; MetaKey matches conversations whose meta
+// object has that key, and MetaValue additionally requires its value. The
+// After/Before bounds are exclusive and ignored when zero. Sort is created_at
+// (default) or last_message_at; paging works as in ListAgentsFilter.
 type ListConversationsFilter struct {
-	Participant string
-	Status      string
+	Participant       string
+	Status            string
+	Tags              []string
+	MetaKey           string
+	MetaValue         string
+	CreatedAfter      time.Time
+	CreatedBefore     time.Time
+	LastMessageAfter  time.Time
+	LastMessageBefore time.Time
+	Sort              string
+	Order             string
+	Cursor            string
+	Limit             int
 }
 
 type SendMessageInput struct {
@@ -145,11 +412,56 @@ type SendMessageInput struct {
 	ConversationID string
 	RequestID      string
 	Type           MessageType
-	Body           string
-	Meta           any
-	Attachments    []Attachment
-	TTLSeconds     int
-	InReplyTo      string
+	// Capability names which of the target's capabilities a request is for.
+	// It selects the schema the request is validated against; when empty
+	// and the target has a single capability, that one is used.
+	Capability string
+	// Recipients multicasts an inform instead of sending it To one agent.
+	// Each entry is an agent ID, "capability:<query>" for every active agent
+	// offering a matching capability ("capability:*" for every active
+	// agent), or "conversation:<id>" for everyone taking part in that
+	// conversation. The sender is never a recipient.
+	Recipients []string
+	// Priority orders the message among those waiting for its target;
+	// empty is normal.
+	Priority    MessagePriority
+	Body        string
+	Meta        any
+	Attachments []Attachment
+	TTLSeconds  int
+	// DeliverAt or DelaySeconds, at most one of which may be set, schedules
+	// the message for later delivery; its TTL counts from then. A DeliverAt
+	// that has already passed delivers at once.
+	DeliverAt    time.Time
+	DelaySeconds int
+	InReplyTo    string
+	// CompleteRequest marks the in_reply_to request completed, with this
+	// message as its result, when the reply is sent.
+	CompleteRequest bool
+	TraceParent     string
+	// Wait, when positive, holds a request's SendMessage until it reaches a
+	// terminal state or the wait (capped at InboxWaitMax) elapses.
+	Wait time.Duration
+}
+
+type SendMessagesInput struct {
+	Messages []SendMessageInput
+	Atomic   bool
+}
+
+// SendMessageResult is the outcome of one item in a SendMessages batch;
+// exactly one of Message or Err is set.
+type SendMessageResult struct {
+	Message   *Message
+	Duplicate bool
+	Err       error
+}
+
+// CancelScheduledInput cancels a scheduled message on behalf of AgentID,
+// which must be its sender.
+type CancelScheduledInput struct {
+	MessageID string
+	AgentID   string
 }
 
 type PollInboxInput struct {
@@ -171,6 +483,63 @@ type EventInput struct {
 	Type         string
 	Body         string
 	Meta         any
+	Attachments  []Attachment
+	ErrorCode    string
+}
+
+// ConversationStatusInput requests a lifecycle change. Actor and Reason are
+// recorded in the conversation's history; Reason is also kept on the
+// conversation.
+type ConversationStatusInput struct {
+	ConversationID string
+	Status         string
+	Actor          string
+	Reason         string
+}
+
+// UpdateConversationInput changes a conversation after creation. Nil fields
+// are left alone. Meta is applied as a JSON merge patch (RFC 7386): keys are
+// merged recursively and a nil value deletes the key. Tags replaces the tag
+// set, while AddTags and RemoveTags adjust it.
+type UpdateConversationInput struct {
+	ConversationID string
+	Title          *string
+	Meta           map[string]any
+	Tags           []string
+	AddTags        []string
+	RemoveTags     []string
+	Actor          string
+}
+
+// SearchInput is a full-text query over message bodies and conversation
+// titles, with the scalar values of both kinds' meta. Every word of Query must
+// match; a trailing * makes a word a prefix. Kind limits results to messages
+// or conversations, and Type implies messages. AgentID matches a message's
+// sender or recipient or a conversation's participants. After and Before bound
+// the creation time, exclusive. Limit defaults to 20, capped at 100.
+type SearchInput struct {
+	Query          string
+	Kind           string
+	AgentID        string
+	ConversationID string
+	Type           MessageType
+	After          time.Time
+	Before         time.Time
+	Limit          int
+}
+
+// SearchHit is one search result, best first. Snippet is the matching
+// stretch of text with matched words wrapped in **.
+type SearchHit struct {
+	Kind           string      `json:"kind"`
+	MessageID      string      `json:"message_id,omitempty"`
+	ConversationID string      `json:"conversation_id"`
+	Title          string      `json:"title,omitempty"`
+	Type           MessageType `json:"type,omitempty"`
+	From           string      `json:"from,omitempty"`
+	To             string      `json:"to,omitempty"`
+	CreatedAt      time.Time   `json:"created_at"`
+	Snippet        string      `json:"snippet"`
 }
 
 type InjectInput struct {
@@ -178,6 +547,7 @@ type InjectInput struct {
 	ConversationID string
 	To             string
 	Body           string
+	TraceParent    string
 }
 
 type ListConversationMessagesInput struct {
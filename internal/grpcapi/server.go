@@ -0,0 +1,475 @@
+// Package grpcapi serves the bus API over gRPC (proto/bus/v1/bus.proto, with
+// generated code in pkg/buspb) for agents that prefer typed streaming over
+// HTTP+JSON. It shares the store and agent credentials with the HTTP server.
+package grpcapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/joelkehle/techtransfer-agency/internal/agentauth"
+	"github.com/joelkehle/techtransfer-agency/internal/bus"
+	"github.com/joelkehle/techtransfer-agency/internal/telemetry"
+	"github.com/joelkehle/techtransfer-agency/pkg/buspb"
+)
+
+const (
+	// SignatureMetadata carries the hex HMAC-SHA256 of the serialized request
+	// message, mirroring the X-Bus-Signature header of the HTTP API.
+	SignatureMetadata = "x-bus-signature"
+
+	pollWait = 5 * time.Second
+)
+
+type service struct {
+	buspb.UnimplementedBusServer
+
+	store bus.API
+	creds *agentauth.Registry
+}
+
+// NewServer returns a gRPC server exposing store. Secrets registered through
+// either transport are honoured by both when creds is shared.
+func NewServer(store bus.API, creds *agentauth.Registry, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append([]grpc.ServerOption{grpc.ChainUnaryInterceptor(unaryInterceptor)}, opts...)
+	srv := grpc.NewServer(opts...)
+	buspb.RegisterBusServer(srv, &service{store: store, creds: creds})
+	return srv
+}
+
+// unaryInterceptor runs each unary call in a server span that continues any
+// traceparent sent as metadata, and maps bus errors onto gRPC status codes.
+func unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, span := startServerSpan(ctx, info.FullMethod)
+	resp, err := handler(ctx, req)
+	endSpan(span, err)
+	if err != nil {
+		return nil, toStatus(ctx, err)
+	}
+	return resp, nil
+}
+
+func startServerSpan(ctx context.Context, fullMethod string) (context.Context, trace.Span) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if tp := md.Get("traceparent"); len(tp) > 0 {
+			ctx = telemetry.ExtractHTTP(ctx, propagation.HeaderCarrier{"Traceparent": tp, "Tracestate": md.Get("tracestate")})
+		}
+	}
+	return telemetry.Tracer().Start(ctx, strings.TrimPrefix(fullMethod, "/"),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("rpc.system", "grpc"),
+			attribute.String("rpc.method", fullMethod),
+		),
+	)
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// toStatus maps bus error codes onto gRPC status codes. Retry hints are sent
+// as a retry-after trailer, matching the HTTP Retry-After header.
+func toStatus(ctx context.Context, err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	var be *bus.Error
+	if !errors.As(err, &be) {
+		return status.Error(grpccodes.Internal, err.Error())
+	}
+	code := grpccodes.Internal
+	switch be.Code {
+	case bus.CodeValidation:
+		code = grpccodes.InvalidArgument
+	case bus.CodeUnauthorized:
+		code = grpccodes.Unauthenticated
+	case bus.CodeNotFound:
+		code = grpccodes.NotFound
+	case bus.CodeRejected:
+		code = grpccodes.FailedPrecondition
+	case bus.CodeRateLimited:
+		code = grpccodes.ResourceExhausted
+	case bus.CodeTimeout:
+		code = grpccodes.DeadlineExceeded
+	case bus.CodeUnavailable:
+		code = grpccodes.Unavailable
+	}
+	if be.RetryAfter > 0 {
+		_ = grpc.SetTrailer(ctx, metadata.Pairs("retry-after", strconv.Itoa(be.RetryAfter)))
+	}
+	return status.Error(code, be.Message)
+}
+
+// verify checks the x-bus-signature metadata against the deterministic
+// serialization of req, which is what the client signed.
+func (s *service) verify(ctx context.Context, agentID string, req proto.Message) error {
+	var signature string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(SignatureMetadata); len(v) > 0 {
+			signature = v[0]
+		}
+	}
+	raw, err := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+	if err != nil {
+		return status.Error(grpccodes.InvalidArgument, err.Error())
+	}
+	return s.creds.Verify(agentID, signature, raw)
+}
+
+func decodeMeta(raw string) (any, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	var v any
+	if err := json.Unmarshal([]byte(raw), &v); err != nil {
+		return nil, &bus.Error{Code: bus.CodeValidation, Message: "meta_json must be valid JSON", Status: 400}
+	}
+	return v, nil
+}
+
+func encodeJSON(v any) string {
+	if v == nil {
+		return ""
+	}
+	blob, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	return string(blob)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func (s *service) RegisterAgent(ctx context.Context, req *buspb.RegisterAgentRequest) (*buspb.RegisterAgentResponse, error) {
+	agentID := strings.TrimSpace(req.AgentId)
+	if !s.creds.IsAllowed(agentID) {
+		return nil, &bus.Error{Code: bus.CodeUnauthorized, Message: "agent_id not allowlisted", Status: 401}
+	}
+	if strings.TrimSpace(req.Secret) == "" {
+		return nil, &bus.Error{Code: bus.CodeValidation, Message: "secret is required", Status: 400}
+	}
+	agent, err := s.store.RegisterAgent(bus.RegisterAgentInput{
+		AgentID:      agentID,
+		Capabilities: req.Capabilities,
+		Description:  req.Description,
+		Mode:         bus.AgentMode(req.Mode),
+		CallbackURL:  req.CallbackUrl,
+		TTLSeconds:   int(req.Ttl),
+		Schemas:      toBusSchemas(req.Schemas),
+		Metadata:     toBusMetadata(req.Metadata),
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.creds.SetSecret(agent.AgentID, req.Secret)
+	return &buspb.RegisterAgentResponse{AgentId: agent.AgentID, ExpiresAt: formatTime(agent.ExpiresAt)}, nil
+}
+
+func toBusMetadata(in *buspb.AgentMetadata) bus.AgentMetadata {
+	if in == nil {
+		return bus.AgentMetadata{}
+	}
+	return bus.AgentMetadata{
+		Version:     in.Version,
+		BuildSHA:    in.BuildSha,
+		MaxInFlight: int(in.MaxInFlight),
+		CostClass:   in.CostClass,
+		Labels:      in.Labels,
+	}
+}
+
+func toBusSchemas(in map[string]*buspb.CapabilitySchema) map[string]bus.CapabilitySchema {
+	if len(in) == 0 {
+		return nil
+	}
+	out := make(map[string]bus.CapabilitySchema, len(in))
+	for capability, sc := range in {
+		var cs bus.CapabilitySchema
+		if raw := strings.TrimSpace(sc.GetRequestJson()); raw != "" {
+			cs.Request = json.RawMessage(raw)
+		}
+		if raw := strings.TrimSpace(sc.GetResponseJson()); raw != "" {
+			cs.Response = json.RawMessage(raw)
+		}
+		out[capability] = cs
+	}
+	return out
+}
+
+// Heartbeat renews an agent's registration and records the health it
+// reports.
+func (s *service) Heartbeat(ctx context.Context, req *buspb.HeartbeatRequest) (*buspb.HeartbeatResponse, error) {
+	if err := s.verify(ctx, req.AgentId, req); err != nil {
+		return nil, err
+	}
+	checks := make([]bus.HealthCheck, 0, len(req.Checks))
+	for _, c := range req.Checks {
+		checks = append(checks, bus.HealthCheck{Name: c.GetName(), Status: bus.HealthStatus(c.GetStatus()), Detail: c.GetDetail()})
+	}
+	agent, err := s.store.Heartbeat(bus.HeartbeatInput{
+		AgentID:  strings.TrimSpace(req.AgentId),
+		Status:   bus.HealthStatus(req.Status),
+		InFlight: int(req.InFlight),
+		Load:     req.Load,
+		Checks:   checks,
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := &buspb.HeartbeatResponse{AgentId: agent.AgentID, ExpiresAt: formatTime(agent.ExpiresAt)}
+	if agent.Health != nil {
+		out.HealthStatus = string(agent.Health.Status)
+	}
+	return out, nil
+}
+
+// Deregister takes an agent off the bus, rerouting or failing the requests
+// it has not accepted.
+func (s *service) Deregister(ctx context.Context, req *buspb.DeregisterRequest) (*buspb.DeregisterResponse, error) {
+	if err := s.verify(ctx, req.AgentId, req); err != nil {
+		return nil, err
+	}
+	res, err := s.store.DeregisterAgent(bus.DeregisterAgentInput{AgentID: strings.TrimSpace(req.AgentId), Reason: req.Reason})
+	if err != nil {
+		return nil, err
+	}
+	out := &buspb.DeregisterResponse{AgentId: res.Agent.AgentID, Failed: res.Failed}
+	for _, r := range res.Rerouted {
+		out.Rerouted = append(out.Rerouted, &buspb.ReroutedMessage{MessageId: r.MessageID, To: r.To})
+	}
+	return out, nil
+}
+
+func (s *service) SendMessage(ctx context.Context, req *buspb.SendMessageRequest) (*buspb.SendMessageResponse, error) {
+	if err := s.verify(ctx, req.From, req); err != nil {
+		return nil, err
+	}
+	meta, err := decodeMeta(req.MetaJson)
+	if err != nil {
+		return nil, err
+	}
+	var deliverAt time.Time
+	if v := strings.TrimSpace(req.DeliverAt); v != "" {
+		if deliverAt, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			return nil, &bus.Error{Code: bus.CodeValidation, Message: "deliver_at must be an RFC 3339 timestamp", Status: 400}
+		}
+	}
+	attachments := toBusAttachments(req.Attachments)
+	message, duplicate, err := s.store.SendMessage(bus.SendMessageInput{
+		To:              req.To,
+		Recipients:      req.Recipients,
+		From:            req.From,
+		ConversationID:  req.ConversationId,
+		RequestID:       req.RequestId,
+		Type:            bus.MessageType(req.Type),
+		Body:            req.Body,
+		Meta:            meta,
+		Attachments:     attachments,
+		TTLSeconds:      int(req.Ttl),
+		InReplyTo:       req.InReplyTo,
+		CompleteRequest: req.CompleteRequest,
+		Capability:      req.Capability,
+		Priority:        bus.MessagePriority(req.Priority),
+		DeliverAt:       deliverAt,
+		DelaySeconds:    int(req.DelaySeconds),
+		TraceParent:     telemetry.TraceParent(ctx),
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := &buspb.SendMessageResponse{
+		MessageId:  message.MessageID,
+		Duplicate:  duplicate,
+		To:         message.To,
+		State:      string(message.State),
+		Recipients: message.Recipients,
+	}
+	if message.DeliverAt != nil {
+		out.DeliverAt = formatTime(*message.DeliverAt)
+	}
+	return out, nil
+}
+
+func (s *service) ListScheduled(ctx context.Context, req *buspb.ListScheduledRequest) (*buspb.ListScheduledResponse, error) {
+	if err := s.verify(ctx, req.AgentId, req); err != nil {
+		return nil, err
+	}
+	messages, err := s.store.ListScheduled(req.AgentId)
+	if err != nil {
+		return nil, err
+	}
+	out := &buspb.ListScheduledResponse{}
+	for _, m := range messages {
+		scheduled := &buspb.ScheduledMessage{
+			MessageId:      m.MessageID,
+			Type:           string(m.Type),
+			To:             m.To,
+			ConversationId: m.ConversationID,
+			RequestId:      m.RequestID,
+			Body:           m.Body,
+			Priority:       string(m.Priority),
+			CreatedAt:      formatTime(m.CreatedAt),
+		}
+		if m.DeliverAt != nil {
+			scheduled.DeliverAt = formatTime(*m.DeliverAt)
+		}
+		out.Messages = append(out.Messages, scheduled)
+	}
+	return out, nil
+}
+
+func (s *service) CancelScheduled(ctx context.Context, req *buspb.CancelScheduledRequest) (*buspb.CancelScheduledResponse, error) {
+	if err := s.verify(ctx, req.AgentId, req); err != nil {
+		return nil, err
+	}
+	message, err := s.store.CancelScheduled(bus.CancelScheduledInput{MessageID: req.MessageId, AgentID: req.AgentId})
+	if err != nil {
+		return nil, err
+	}
+	return &buspb.CancelScheduledResponse{MessageId: message.MessageID, State: string(message.State)}, nil
+}
+
+func (s *service) Ack(ctx context.Context, req *buspb.AckRequest) (*buspb.AckResponse, error) {
+	if err := s.verify(ctx, req.AgentId, req); err != nil {
+		return nil, err
+	}
+	err := s.store.Ack(bus.AckInput{
+		AgentID:   req.AgentId,
+		MessageID: req.MessageId,
+		Status:    req.Status,
+		Reason:    req.Reason,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &buspb.AckResponse{}, nil
+}
+
+func (s *service) PostEvent(ctx context.Context, req *buspb.PostEventRequest) (*buspb.PostEventResponse, error) {
+	if err := s.verify(ctx, req.AgentId, req); err != nil {
+		return nil, err
+	}
+	meta, err := decodeMeta(req.MetaJson)
+	if err != nil {
+		return nil, err
+	}
+	err = s.store.PostEvent(bus.EventInput{
+		ActorAgentID: strings.TrimSpace(req.AgentId),
+		MessageID:    req.MessageId,
+		Type:         req.Type,
+		Body:         req.Body,
+		Meta:         meta,
+		Attachments:  toBusAttachments(req.Attachments),
+		ErrorCode:    req.ErrorCode,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &buspb.PostEventResponse{}, nil
+}
+
+func toBusAttachments(in []*buspb.Attachment) []bus.Attachment {
+	out := make([]bus.Attachment, 0, len(in))
+	for _, a := range in {
+		out = append(out, bus.Attachment{URL: a.GetUrl(), Name: a.GetName(), ContentType: a.GetContentType(), Size: a.GetSize(), SHA256: a.GetSha256()})
+	}
+	return out
+}
+
+func (s *service) Inject(ctx context.Context, req *buspb.InjectRequest) (*buspb.InjectResponse, error) {
+	message, err := s.store.Inject(bus.InjectInput{
+		Identity:       req.Identity,
+		ConversationID: req.ConversationId,
+		To:             req.To,
+		Body:           req.Body,
+		TraceParent:    telemetry.TraceParent(ctx),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &buspb.InjectResponse{MessageId: message.MessageID}, nil
+}
+
+// PollInbox streams inbox events until the client goes away. Each event
+// carries the cursor to resume from after it.
+func (s *service) PollInbox(req *buspb.PollInboxRequest, stream grpc.ServerStreamingServer[buspb.InboxEvent]) error {
+	ctx := stream.Context()
+	if err := s.verify(ctx, req.AgentId, req); err != nil {
+		return toStatus(ctx, err)
+	}
+	cursor := int(req.Cursor)
+	for ctx.Err() == nil {
+		events, next, err := s.store.PollInbox(bus.PollInboxInput{AgentID: req.AgentId, Cursor: cursor, Wait: pollWait})
+		if err != nil {
+			return toStatus(ctx, err)
+		}
+		for i, evt := range events {
+			out := &buspb.InboxEvent{
+				MessageId:      evt.MessageID,
+				Type:           string(evt.Type),
+				From:           evt.From,
+				ConversationId: evt.ConversationID,
+				Body:           evt.Body,
+				MetaJson:       encodeJSON(evt.Meta),
+				TraceParent:    evt.TraceParent,
+				CreatedAt:      formatTime(evt.CreatedAt),
+				Cursor:         int64(next - len(events) + i + 1),
+				Capability:     evt.Capability,
+				Priority:       string(evt.Priority),
+				ParentId:       evt.ParentID,
+			}
+			for _, a := range evt.Attachments {
+				out.Attachments = append(out.Attachments, &buspb.Attachment{Url: a.URL, Name: a.Name, ContentType: a.ContentType, Size: a.Size, Sha256: a.SHA256})
+			}
+			if err := stream.Send(out); err != nil {
+				return err
+			}
+		}
+		cursor = next
+	}
+	return nil
+}
+
+func (s *service) ObserveSince(req *buspb.ObserveRequest, stream grpc.ServerStreamingServer[buspb.ObserveEvent]) error {
+	ctx := stream.Context()
+	filter := bus.ObserveFilter{
+		ConversationID: strings.TrimSpace(req.ConversationId),
+		AgentID:        strings.TrimSpace(req.AgentId),
+	}
+	cursor := req.Cursor
+	for ctx.Err() == nil {
+		events, last := s.store.ObserveSince(cursor, filter, time.Second)
+		for _, evt := range events {
+			out := &buspb.ObserveEvent{Id: evt.ID, Type: string(evt.Type), At: formatTime(evt.At), DataJson: encodeJSON(evt.Data)}
+			if err := stream.Send(out); err != nil {
+				return err
+			}
+		}
+		cursor = last
+	}
+	return nil
+}
@@ -0,0 +1,294 @@
+package grpcapi
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/joelkehle/techtransfer-agency/internal/agentauth"
+	"github.com/joelkehle/techtransfer-agency/internal/bus"
+	"github.com/joelkehle/techtransfer-agency/pkg/buspb"
+)
+
+func sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newTestClient(t *testing.T, store bus.API) buspb.BusClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := NewServer(store, agentauth.NewRegistryFromEnv())
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return buspb.NewBusClient(conn)
+}
+
+func signedContext(t *testing.T, ctx context.Context, secret string, req proto.Message) context.Context {
+	t.Helper()
+	raw, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return metadata.AppendToOutgoingContext(ctx, SignatureMetadata, sign(secret, raw))
+}
+
+func TestGRPCSendPollAck(t *testing.T) {
+	store := bus.NewStore(bus.Config{InboxWaitMax: time.Second})
+	client := newTestClient(t, store)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, reg := range []*buspb.RegisterAgentRequest{
+		{AgentId: "a", Capabilities: []string{"orchestrator"}, Mode: "pull", Secret: "secret-a"},
+		{AgentId: "b", Capabilities: []string{"worker"}, Mode: "pull", Secret: "secret-b"},
+	} {
+		resp, err := client.RegisterAgent(ctx, reg)
+		if err != nil {
+			t.Fatalf("register %s: %v", reg.AgentId, err)
+		}
+		if resp.AgentId != reg.AgentId || resp.ExpiresAt == "" {
+			t.Fatalf("unexpected register response: %v", resp)
+		}
+	}
+
+	send := &buspb.SendMessageRequest{To: "b", From: "a", RequestId: "rid-grpc", Type: "request", Body: "do work", MetaJson: `{"k":"v"}`}
+	_, err := client.SendMessage(signedContext(t, ctx, "wrong", send), send)
+	if status.Code(err) != grpccodes.Unauthenticated {
+		t.Fatalf("expected unauthenticated for bad signature, got %v", err)
+	}
+	sent, err := client.SendMessage(signedContext(t, ctx, "secret-a", send), send)
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if sent.MessageId == "" || sent.Duplicate {
+		t.Fatalf("unexpected send response: %v", sent)
+	}
+
+	poll := &buspb.PollInboxRequest{AgentId: "b"}
+	stream, err := client.PollInbox(signedContext(t, ctx, "secret-b", poll), poll)
+	if err != nil {
+		t.Fatalf("open poll stream: %v", err)
+	}
+	evt, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("recv inbox event: %v", err)
+	}
+	if evt.MessageId != sent.MessageId || evt.Body != "do work" || evt.Cursor != 1 || evt.MetaJson != `{"k":"v"}` {
+		t.Fatalf("unexpected inbox event: %v", evt)
+	}
+
+	ack := &buspb.AckRequest{AgentId: "b", MessageId: sent.MessageId, Status: "accepted"}
+	if _, err := client.Ack(signedContext(t, ctx, "secret-b", ack), ack); err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	stored, _ := store.GetMessageForTest(sent.MessageId)
+	if stored.State != bus.StateExecuting {
+		t.Fatalf("expected executing after ack, got %s", stored.State)
+	}
+
+	missing := &buspb.PostEventRequest{AgentId: "b", MessageId: "m-missing", Type: "final", Body: "done"}
+	_, err = client.PostEvent(signedContext(t, ctx, "secret-b", missing), missing)
+	if status.Code(err) != grpccodes.NotFound {
+		t.Fatalf("expected not found for unknown message, got %v", err)
+	}
+}
+
+func TestGRPCHeartbeatDeregister(t *testing.T) {
+	store := bus.NewStore(bus.Config{})
+	client := newTestClient(t, store)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	reg := &buspb.RegisterAgentRequest{AgentId: "w", Capabilities: []string{"worker"}, Mode: "pull", Secret: "secret-w"}
+	if _, err := client.RegisterAgent(ctx, reg); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	hb := &buspb.HeartbeatRequest{AgentId: "w", Status: "degraded", InFlight: 2, Load: 0.5,
+		Checks: []*buspb.HealthCheck{{Name: "db", Status: "degraded", Detail: "slow"}}}
+	if _, err := client.Heartbeat(signedContext(t, ctx, "wrong", hb), hb); status.Code(err) != grpccodes.Unauthenticated {
+		t.Fatalf("expected unauthenticated heartbeat, got %v", err)
+	}
+	beat, err := client.Heartbeat(signedContext(t, ctx, "secret-w", hb), hb)
+	if err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+	if beat.AgentId != "w" || beat.ExpiresAt == "" || beat.HealthStatus != "degraded" {
+		t.Fatalf("unexpected heartbeat response: %v", beat)
+	}
+
+	dereg := &buspb.DeregisterRequest{AgentId: "w", Reason: "shutdown"}
+	if _, err := client.Deregister(signedContext(t, ctx, "wrong", dereg), dereg); status.Code(err) != grpccodes.Unauthenticated {
+		t.Fatalf("expected unauthenticated deregister, got %v", err)
+	}
+	if _, err := client.Deregister(signedContext(t, ctx, "secret-w", dereg), dereg); err != nil {
+		t.Fatalf("deregister: %v", err)
+	}
+	if _, err := client.Heartbeat(signedContext(t, ctx, "secret-w", hb), hb); status.Code(err) == grpccodes.OK {
+		t.Fatal("expected heartbeat after deregister to fail")
+	}
+}
+
+func TestGRPCSendOptionsAndScheduling(t *testing.T) {
+	store := bus.NewStore(bus.Config{InboxWaitMax: time.Second})
+	client := newTestClient(t, store)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, reg := range []*buspb.RegisterAgentRequest{
+		{AgentId: "a", Capabilities: []string{"orchestrator"}, Mode: "pull", Secret: "secret-a"},
+		{AgentId: "b", Capabilities: []string{"patent-screen@1.2.0"}, Mode: "pull", Secret: "secret-b"},
+		{AgentId: "c", Capabilities: []string{"reviewer"}, Mode: "pull", Secret: "secret-c"},
+	} {
+		if _, err := client.RegisterAgent(ctx, reg); err != nil {
+			t.Fatalf("register %s: %v", reg.AgentId, err)
+		}
+	}
+	send := func(req *buspb.SendMessageRequest) (*buspb.SendMessageResponse, error) {
+		return client.SendMessage(signedContext(t, ctx, "secret-a", req), req)
+	}
+
+	routed, err := send(&buspb.SendMessageRequest{From: "a", RequestId: "rid-routed", Body: "screen", Capability: "patent-screen@^1", Priority: "urgent"})
+	if err != nil {
+		t.Fatalf("capability send: %v", err)
+	}
+	if routed.To != "b" || routed.State != string(bus.StateWaitingAck) {
+		t.Fatalf("expected request routed to b, got %v", routed)
+	}
+	multicast, err := send(&buspb.SendMessageRequest{From: "a", RequestId: "rid-fyi", Type: "inform", Body: "fyi", Recipients: []string{"b", "c"}})
+	if err != nil {
+		t.Fatalf("multicast send: %v", err)
+	}
+	if len(multicast.Recipients) != 2 {
+		t.Fatalf("expected two recipients, got %v", multicast)
+	}
+	if _, err := send(&buspb.SendMessageRequest{To: "b", From: "a", RequestId: "rid-bad", Body: "x", DeliverAt: "tomorrow"}); status.Code(err) != grpccodes.InvalidArgument {
+		t.Fatalf("expected invalid argument for a bad deliver_at, got %v", err)
+	}
+	later, err := send(&buspb.SendMessageRequest{To: "b", From: "a", RequestId: "rid-later", Body: "later", DelaySeconds: 60})
+	if err != nil {
+		t.Fatalf("scheduled send: %v", err)
+	}
+	if later.State != string(bus.StateScheduled) || later.DeliverAt == "" {
+		t.Fatalf("expected scheduled send, got %v", later)
+	}
+
+	poll := &buspb.PollInboxRequest{AgentId: "b"}
+	stream, err := client.PollInbox(signedContext(t, ctx, "secret-b", poll), poll)
+	if err != nil {
+		t.Fatalf("open poll stream: %v", err)
+	}
+	first, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("recv: %v", err)
+	}
+	if first.MessageId != routed.MessageId || first.Priority != "urgent" || first.Capability != "patent-screen@1.2.0" {
+		t.Fatalf("unexpected routed inbox event: %v", first)
+	}
+	copyEvt, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("recv: %v", err)
+	}
+	if copyEvt.ParentId != multicast.MessageId {
+		t.Fatalf("expected multicast copy, got %v", copyEvt)
+	}
+
+	list := &buspb.ListScheduledRequest{AgentId: "a"}
+	if _, err := client.ListScheduled(signedContext(t, ctx, "secret-b", list), list); status.Code(err) != grpccodes.Unauthenticated {
+		t.Fatalf("expected unauthenticated list, got %v", err)
+	}
+	listed, err := client.ListScheduled(signedContext(t, ctx, "secret-a", list), list)
+	if err != nil {
+		t.Fatalf("list scheduled: %v", err)
+	}
+	if len(listed.Messages) != 1 || listed.Messages[0].MessageId != later.MessageId || listed.Messages[0].DeliverAt != later.DeliverAt {
+		t.Fatalf("unexpected scheduled listing: %v", listed)
+	}
+	cancelReq := &buspb.CancelScheduledRequest{AgentId: "a", MessageId: later.MessageId}
+	cancelled, err := client.CancelScheduled(signedContext(t, ctx, "secret-a", cancelReq), cancelReq)
+	if err != nil {
+		t.Fatalf("cancel scheduled: %v", err)
+	}
+	if cancelled.State != string(bus.StateCancelled) {
+		t.Fatalf("expected cancelled, got %v", cancelled)
+	}
+	if _, err := client.CancelScheduled(signedContext(t, ctx, "secret-a", cancelReq), cancelReq); status.Code(err) != grpccodes.FailedPrecondition {
+		t.Fatalf("expected failed precondition cancelling twice, got %v", err)
+	}
+}
+
+func TestGRPCRegisterMetadataAndSchemas(t *testing.T) {
+	store := bus.NewStore(bus.Config{})
+	client := newTestClient(t, store)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for _, reg := range []*buspb.RegisterAgentRequest{
+		{AgentId: "a", Capabilities: []string{"orchestrator"}, Mode: "pull", Secret: "secret-a"},
+		{
+			AgentId: "b", Capabilities: []string{"patent-screen"}, Mode: "pull", Secret: "secret-b",
+			Metadata: &buspb.AgentMetadata{Version: "1.4.0", MaxInFlight: 1, Labels: map[string]string{"team": "ip"}},
+			Schemas: map[string]*buspb.CapabilitySchema{
+				"patent-screen": {RequestJson: `{"type":"object","required":["body"],"properties":{"body":{"type":"string","minLength":3}}}`},
+			},
+		},
+	} {
+		if _, err := client.RegisterAgent(ctx, reg); err != nil {
+			t.Fatalf("register %s: %v", reg.AgentId, err)
+		}
+	}
+	agents, _, err := store.ListAgents(bus.ListAgentsFilter{})
+	if err != nil {
+		t.Fatalf("list agents: %v", err)
+	}
+	for _, a := range agents {
+		if a.AgentID == "b" && (a.Metadata.Version != "1.4.0" || a.Metadata.Labels["team"] != "ip") {
+			t.Fatalf("expected metadata recorded, got %#v", a.Metadata)
+		}
+	}
+
+	send := func(rid, body string) (*buspb.SendMessageResponse, error) {
+		req := &buspb.SendMessageRequest{To: "b", From: "a", RequestId: rid, Type: "request", Body: body}
+		return client.SendMessage(signedContext(t, ctx, "secret-a", req), req)
+	}
+	if _, err := send("rid-short", "ab"); status.Code(err) != grpccodes.InvalidArgument {
+		t.Fatalf("expected the request schema to reject a short body, got %v", err)
+	}
+	first, err := send("rid-1", "screen one")
+	if err != nil {
+		t.Fatalf("send 1: %v", err)
+	}
+	second, err := send("rid-2", "screen two")
+	if err != nil {
+		t.Fatalf("send 2: %v", err)
+	}
+	if first.State != string(bus.StateWaitingAck) || second.State != string(bus.StatePending) {
+		t.Fatalf("expected max_in_flight 1 to hold the second request, got %s and %s", first.State, second.State)
+	}
+	if m, _ := store.GetMessageForTest(second.MessageId); !m.HeldForCapacity {
+		t.Fatalf("expected the second request held for capacity, got %#v", m)
+	}
+}
@@ -0,0 +1,120 @@
+package httpapi
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/joelkehle/techtransfer-agency/internal/agentauth"
+	"github.com/joelkehle/techtransfer-agency/internal/blobstore"
+	"github.com/joelkehle/techtransfer-agency/internal/bus"
+)
+
+func blobStoreUnavailable() error {
+	return &bus.Error{Code: bus.CodeUnavailable, Message: "blob store not configured", Status: 503}
+}
+
+// handleUploadBlob streams the request body into the blob store. The
+// signature covers the raw body and is checked once it has been read, so
+// unauthorized content is staged but never committed.
+func (s *Server) handleUploadBlob(w http.ResponseWriter, r *http.Request) {
+	if !methodOnly(w, r, http.MethodPost) {
+		return
+	}
+	if s.blobs == nil {
+		writeBusError(w, blobStoreUnavailable())
+		return
+	}
+	agentID := strings.TrimSpace(r.URL.Query().Get("agent_id"))
+	mac, err := s.creds.MAC(agentID)
+	if err != nil {
+		writeBusError(w, err)
+		return
+	}
+
+	_, span := startBusSpan(r.Context(), "UploadBlob")
+	var body io.Reader = http.NoBody
+	if r.Body != nil {
+		body = r.Body
+	}
+	upload, err := s.blobs.Stage(io.TeeReader(body, mac))
+	if err != nil {
+		if errors.Is(err, blobstore.ErrTooLarge) {
+			err = &bus.Error{Code: bus.CodeValidation, Message: "blob exceeds " + strconv.FormatInt(s.blobs.MaxSize, 10) + " bytes", Status: http.StatusRequestEntityTooLarge}
+		}
+		endBusSpan(span, err)
+		writeBusError(w, err)
+		return
+	}
+	if err := agentauth.VerifySum(r.Header.Get("X-Bus-Signature"), mac.Sum(nil)); err != nil {
+		upload.Abort()
+		endBusSpan(span, err)
+		writeBusError(w, err)
+		return
+	}
+	contentType := strings.TrimSpace(r.Header.Get("Content-Type"))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	blob, err := upload.Commit(contentType, agentID)
+	endBusSpan(span, err)
+	if err != nil {
+		writeBusError(w, err)
+		return
+	}
+	writeJSON(w, 200, map[string]any{
+		"ok": true,
+		"attachment": bus.Attachment{
+			URL:         bus.BlobPathPrefix + blob.SHA256,
+			Name:        strings.TrimSpace(r.URL.Query().Get("name")),
+			ContentType: blob.ContentType,
+			Size:        blob.Size,
+			SHA256:      blob.SHA256,
+		},
+	})
+}
+
+// handleGetBlob serves a blob to its uploaders and to members of any
+// conversation with a message referencing it. The signature covers the blob
+// path as well as the query, so a captured signature opens only the blob it
+// was made for. Range and conditional requests are handled by
+// http.ServeContent.
+func (s *Server) handleGetBlob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if s.blobs == nil {
+		writeBusError(w, blobStoreUnavailable())
+		return
+	}
+	agentID := strings.TrimSpace(r.URL.Query().Get("agent_id"))
+	sha := r.PathValue("sha256")
+	if err := s.verifySignature(agentID, r.Header.Get("X-Bus-Signature"), []byte(bus.BlobPathPrefix+sha+"?"+r.URL.RawQuery)); err != nil {
+		writeBusError(w, err)
+		return
+	}
+
+	f, blob, err := s.blobs.Open(sha)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		writeBusError(w, &bus.Error{Code: bus.CodeNotFound, Message: "blob not found", Status: 404})
+		return
+	}
+	if err != nil {
+		writeBusError(w, err)
+		return
+	}
+	defer f.Close()
+	if !slices.Contains(blob.Uploaders, agentID) && !slices.Contains(s.store.BlobReferences(sha).Members, agentID) {
+		writeBusError(w, &bus.Error{Code: bus.CodeUnauthorized, Message: "agent did not upload, send or receive this blob", Status: 401})
+		return
+	}
+
+	w.Header().Set("Content-Type", blob.ContentType)
+	w.Header().Set("ETag", `"`+blob.SHA256+`"`)
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	http.ServeContent(w, r, "", blob.CreatedAt, f)
+}
@@ -11,12 +11,16 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/joelkehle/techtransfer-agency/internal/agentauth"
+	"github.com/joelkehle/techtransfer-agency/internal/attachverify"
+	"github.com/joelkehle/techtransfer-agency/internal/blobstore"
 	"github.com/joelkehle/techtransfer-agency/internal/bus"
 )
 
@@ -117,9 +121,7 @@ func runContractAllEndpoints(t *testing.T, h http.Handler) {
 		ts.CloseClientConnections()
 		ts.Close()
 	}()
-	c := &http.Client{
-		Transport: &http.Transport{DisableKeepAlives: true},
-	}
+	c := newContractClient(t)
 
 	regA := map[string]any{"agent_id": "a", "capabilities": []string{"orchestrator"}, "mode": "pull", "ttl": 60, "secret": "secret-a"}
 	regB := map[string]any{"agent_id": "b", "capabilities": []string{"worker"}, "mode": "pull", "ttl": 60, "secret": "secret-b"}
@@ -239,6 +241,266 @@ func TestContractAllEndpointsPersistentBackend(t *testing.T) {
 	runContractAllEndpoints(t, newContractServerPersistent(t))
 }
 
+// contractRequest registers agents a and b and has a send b the request
+// "do work" in conv-1, which b accepts. It returns the request's message ID.
+func contractRequest(t *testing.T, c *http.Client, baseURL string) string {
+	t.Helper()
+	for _, id := range []string{"a", "b"} {
+		mustStatus(t, doJSON(t, c, http.MethodPost, baseURL+"/v1/agents/register", map[string]any{
+			"agent_id": id, "capabilities": []string{"worker"}, "mode": "pull", "ttl": 60, "secret": "secret-" + id,
+		}, nil), http.StatusOK)
+	}
+	sendReq := map[string]any{"to": "b", "from": "a", "conversation_id": "conv-1", "request_id": "rid-1", "type": "request", "body": "do work"}
+	sendBlob, _ := json.Marshal(sendReq)
+	var sent struct {
+		MessageID string `json:"message_id"`
+	}
+	if err := json.Unmarshal(mustStatus(t, doJSON(t, c, http.MethodPost, baseURL+"/v1/messages", sendReq, map[string]string{"X-Bus-Signature": signPayload("secret-a", sendBlob)}), http.StatusOK), &sent); err != nil {
+		t.Fatalf("decode send response: %v", err)
+	}
+	ackReq := map[string]any{"agent_id": "b", "message_id": sent.MessageID, "status": "accepted"}
+	ackBlob, _ := json.Marshal(ackReq)
+	mustStatus(t, doJSON(t, c, http.MethodPost, baseURL+"/v1/acks", ackReq, map[string]string{"X-Bus-Signature": signPayload("secret-b", ackBlob)}), http.StatusOK)
+	return sent.MessageID
+}
+
+// postContractEvent posts evt signed by agent b.
+func postContractEvent(t *testing.T, c *http.Client, baseURL string, evt map[string]any) {
+	t.Helper()
+	blob, _ := json.Marshal(evt)
+	mustStatus(t, doJSON(t, c, http.MethodPost, baseURL+"/v1/events", evt, map[string]string{"X-Agent-ID": "b", "X-Bus-Signature": signPayload("secret-b", blob)}), http.StatusOK)
+}
+
+func TestContractLookupEndpoints(t *testing.T) {
+	ts := httptest.NewServer(newContractServer())
+	defer func() {
+		ts.CloseClientConnections()
+		ts.Close()
+	}()
+	c := newContractClient(t)
+	messageID := contractRequest(t, c, ts.URL)
+	postContractEvent(t, c, ts.URL, map[string]any{"message_id": messageID, "type": "final", "body": "done"})
+
+	blob := mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+"/v1/messages/"+messageID, nil, nil), http.StatusOK)
+	var detail struct {
+		Message struct {
+			State        string     `json:"state"`
+			DeliveredAt  *time.Time `json:"delivered_at"`
+			StateHistory []struct {
+				From  string `json:"from"`
+				To    string `json:"to"`
+				Actor string `json:"actor"`
+			} `json:"state_history"`
+		} `json:"message"`
+	}
+	if err := json.Unmarshal(blob, &detail); err != nil {
+		t.Fatalf("decode message detail: %v", err)
+	}
+	if detail.Message.State != "completed" || detail.Message.DeliveredAt == nil {
+		t.Fatalf("unexpected message detail: %s", blob)
+	}
+	if n := len(detail.Message.StateHistory); n < 2 || detail.Message.StateHistory[n-1].To != "completed" || detail.Message.StateHistory[n-1].Actor != "b" {
+		t.Fatalf("expected state history ending in completed by b: %s", blob)
+	}
+	mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+"/v1/messages/m-missing", nil, nil), http.StatusNotFound)
+
+	if blob := mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+"/v1/agents/b", nil, nil), http.StatusOK); !bytes.Contains(blob, []byte(`"agent_id":"b"`)) {
+		t.Fatalf("expected agent b: %s", blob)
+	}
+	if blob := mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+"/v1/conversations/conv-1", nil, nil), http.StatusOK); !bytes.Contains(blob, []byte(`"message_count":1`)) {
+		t.Fatalf("expected conv-1 with one message: %s", blob)
+	}
+}
+
+func TestContractRequestResult(t *testing.T) {
+	ts := httptest.NewServer(newContractServer())
+	defer func() {
+		ts.CloseClientConnections()
+		ts.Close()
+	}()
+	c := newContractClient(t)
+	messageID := contractRequest(t, c, ts.URL)
+	postContractEvent(t, c, ts.URL, map[string]any{
+		"message_id":  messageID,
+		"type":        "final",
+		"body":        "done",
+		"attachments": []map[string]any{{"url": "https://files.example/report.pdf", "name": "report.pdf"}},
+	})
+
+	blob := mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+"/v1/messages/"+messageID, nil, nil), http.StatusOK)
+	var detail struct {
+		Message struct {
+			Result *struct {
+				Body        string           `json:"body"`
+				Attachments []map[string]any `json:"attachments"`
+			} `json:"result"`
+		} `json:"message"`
+	}
+	if err := json.Unmarshal(blob, &detail); err != nil {
+		t.Fatalf("decode message detail: %v", err)
+	}
+	if detail.Message.Result == nil || detail.Message.Result.Body != "done" || len(detail.Message.Result.Attachments) != 1 {
+		t.Fatalf("expected the final event as the result: %s", blob)
+	}
+}
+
+func TestContractConversationLifecycle(t *testing.T) {
+	ts := httptest.NewServer(newContractServer())
+	defer func() {
+		ts.CloseClientConnections()
+		ts.Close()
+	}()
+	c := newContractClient(t)
+	contractRequest(t, c, ts.URL)
+	injectReq := map[string]any{"identity": "joel", "conversation_id": "conv-1", "to": "b", "body": "human note"}
+
+	blob := mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/conversations/conv-1/close", map[string]any{"actor": "joel", "reason": "wrapped up"}, nil), http.StatusOK)
+	if !bytes.Contains(blob, []byte(`"status":"closed"`)) || !bytes.Contains(blob, []byte(`"status_reason":"wrapped up"`)) {
+		t.Fatalf("expected closed conversation: %s", blob)
+	}
+	mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/inject", injectReq, nil), http.StatusConflict)
+	mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/conversations/conv-1/archive", nil, nil), http.StatusOK)
+	mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/conversations/conv-1/close", nil, nil), http.StatusConflict)
+	mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/conversations/conv-1/reopen", nil, nil), http.StatusOK)
+	mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/inject", injectReq, nil), http.StatusOK)
+}
+
+func TestContractConversationPatchAndFilters(t *testing.T) {
+	ts := httptest.NewServer(newContractServer())
+	defer func() {
+		ts.CloseClientConnections()
+		ts.Close()
+	}()
+	c := newContractClient(t)
+	contractRequest(t, c, ts.URL)
+
+	blob := mustStatus(t, doJSON(t, c, http.MethodPatch, ts.URL+"/v1/conversations/conv-1", map[string]any{"title": "Case TT-1", "meta": map[string]any{"case_id": "TT-1"}, "add_tags": []string{"intake"}}, nil), http.StatusOK)
+	if !bytes.Contains(blob, []byte(`"tags":["intake"]`)) || !bytes.Contains(blob, []byte(`"case_id":"TT-1"`)) {
+		t.Fatalf("expected patched conversation: %s", blob)
+	}
+	blob = mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+"/v1/conversations?tag=intake&meta_key=case_id&meta_value=TT-1", nil, nil), http.StatusOK)
+	if !bytes.Contains(blob, []byte(`"conversation_id":"conv-1"`)) {
+		t.Fatalf("expected conv-1 in filtered listing: %s", blob)
+	}
+	mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/conversations", map[string]any{"conversation_id": "conv-1", "title": "Other"}, nil), http.StatusConflict)
+}
+
+func TestContractAgentPaging(t *testing.T) {
+	ts := httptest.NewServer(newContractServer())
+	defer func() {
+		ts.CloseClientConnections()
+		ts.Close()
+	}()
+	c := newContractClient(t)
+	contractRequest(t, c, ts.URL)
+
+	var page struct {
+		Agents     []map[string]any `json:"agents"`
+		NextCursor string           `json:"next_cursor"`
+	}
+	if err := json.Unmarshal(mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+"/v1/agents?limit=1&order=desc", nil, nil), http.StatusOK), &page); err != nil {
+		t.Fatalf("decode agents page: %v", err)
+	}
+	if len(page.Agents) != 1 || page.Agents[0]["agent_id"] != "b" || page.NextCursor == "" {
+		t.Fatalf("unexpected first agents page: %#v", page)
+	}
+	blob := mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+"/v1/agents?limit=1&order=desc&cursor="+page.NextCursor, nil, nil), http.StatusOK)
+	if !bytes.Contains(blob, []byte(`"agent_id":"a"`)) || bytes.Contains(blob, []byte(`next_cursor`)) {
+		t.Fatalf("unexpected last agents page: %s", blob)
+	}
+	mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+"/v1/agents?registered_after=yesterday", nil, nil), http.StatusBadRequest)
+}
+
+func TestContractSearch(t *testing.T) {
+	ts := httptest.NewServer(newContractServer())
+	defer func() {
+		ts.CloseClientConnections()
+		ts.Close()
+	}()
+	c := newContractClient(t)
+	messageID := contractRequest(t, c, ts.URL)
+
+	blob := mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+"/v1/search?q=work&kind=message&agent=a", nil, nil), http.StatusOK)
+	if !bytes.Contains(blob, []byte(messageID)) || !bytes.Contains(blob, []byte(`**work**`)) {
+		t.Fatalf("expected search to find the message: %s", blob)
+	}
+	mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+"/v1/search?q=", nil, nil), http.StatusBadRequest)
+}
+
+func TestContractConversationExport(t *testing.T) {
+	ts := httptest.NewServer(newContractServer())
+	defer func() {
+		ts.CloseClientConnections()
+		ts.Close()
+	}()
+	c := newContractClient(t)
+	messageID := contractRequest(t, c, ts.URL)
+	postContractEvent(t, c, ts.URL, map[string]any{"message_id": messageID, "type": "final", "body": "done"})
+	mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/inject", map[string]any{"identity": "joel", "conversation_id": "conv-1", "to": "b", "body": "human note"}, nil), http.StatusOK)
+	mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/conversations/conv-1/close", nil, nil), http.StatusOK)
+	mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/conversations/conv-1/reopen", nil, nil), http.StatusOK)
+	mustStatus(t, doJSON(t, c, http.MethodPatch, ts.URL+"/v1/conversations/conv-1", map[string]any{"title": "Case TT-1"}, nil), http.StatusOK)
+
+	exportResp := doJSON(t, c, http.MethodGet, ts.URL+"/v1/conversations/conv-1/export?format=jsonl", nil, nil)
+	digest := exportResp.Header.Get("X-Transcript-Digest")
+	checkTranscriptChain(t, mustStatus(t, exportResp, http.StatusOK), digest)
+	blob := mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+"/v1/conversations/conv-1/export", nil, nil), http.StatusOK)
+	if !bytes.Contains(blob, []byte("do work")) || !bytes.Contains(blob, []byte(digest)) {
+		t.Fatalf("expected markdown transcript with message and digest: %s", blob)
+	}
+	blob = mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+"/v1/conversations/conv-1/export?format=html", nil, nil), http.StatusOK)
+	if !bytes.Contains(blob, []byte("do work")) || !bytes.Contains(blob, []byte("human note")) {
+		t.Fatalf("expected html transcript with message and injection: %s", blob)
+	}
+	mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+"/v1/conversations/conv-1/export?format=pdf", nil, nil), http.StatusBadRequest)
+	mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+"/v1/conversations/conv-missing/export", nil, nil), http.StatusNotFound)
+}
+
+// checkTranscriptChain recomputes every entry hash of a JSONL export and
+// checks the prev_hash links and the header digest.
+func checkTranscriptChain(t *testing.T, blob []byte, digest string) {
+	t.Helper()
+	lines := strings.Split(strings.TrimSpace(string(blob)), "\n")
+	var header struct {
+		EntryCount int    `json:"entry_count"`
+		Digest     string `json:"digest"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &header); err != nil {
+		t.Fatalf("decode transcript header: %v", err)
+	}
+	if header.EntryCount != len(lines)-1 || header.Digest != digest {
+		t.Fatalf("unexpected transcript header %s (digest header %q, %d entries)", lines[0], digest, len(lines)-1)
+	}
+	kinds := map[string]int{}
+	prev := ""
+	for _, line := range lines[1:] {
+		var e transcriptEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("decode transcript entry: %v", err)
+		}
+		if e.PrevHash != prev {
+			t.Fatalf("entry %d prev_hash=%q want %q", e.Seq, e.PrevHash, prev)
+		}
+		hash := e.Hash
+		e.Hash = ""
+		raw, _ := json.Marshal(e)
+		sum := sha256.Sum256(raw)
+		if hex.EncodeToString(sum[:]) != hash {
+			t.Fatalf("entry %d hash does not match its content: %s", e.Seq, line)
+		}
+		kinds[e.Kind]++
+		prev = hash
+	}
+	if prev != digest {
+		t.Fatalf("digest=%q want last hash %q", digest, prev)
+	}
+	for _, kind := range []string{"message", "human_injection", "state_change", "conversation_status", "conversation_updated"} {
+		if kinds[kind] == 0 {
+			t.Fatalf("expected %s entries in transcript, got %v", kind, kinds)
+		}
+	}
+}
+
 type sseEvent struct {
 	ID   string
 	Type string
@@ -298,9 +560,7 @@ func readNextSSEEvent(t *testing.T, r io.Reader, timeout time.Duration) sseEvent
 func TestObserveSSECursorResume(t *testing.T) {
 	ts := httptest.NewServer(newContractServer())
 	t.Cleanup(func() { ts.CloseClientConnections() })
-	c := &http.Client{
-		Transport: &http.Transport{DisableKeepAlives: true},
-	}
+	c := newContractClient(t)
 
 	mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/agents/register", map[string]any{"agent_id": "a", "mode": "pull", "capabilities": []string{"x"}, "secret": "secret-a"}, nil), 200)
 	mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/agents/register", map[string]any{"agent_id": "b", "mode": "pull", "capabilities": []string{"y"}, "secret": "secret-b"}, nil), 200)
@@ -375,9 +635,7 @@ func TestObserveSSECursorResume(t *testing.T) {
 func TestContractPushModeCallbackDelivery(t *testing.T) {
 	ts := httptest.NewServer(newContractServer())
 	t.Cleanup(func() { ts.CloseClientConnections() })
-	c := &http.Client{
-		Transport: &http.Transport{DisableKeepAlives: true},
-	}
+	c := newContractClient(t)
 
 	var callbackCount int32
 	callbackDone := make(chan map[string]any, 1)
@@ -441,9 +699,7 @@ func TestContractRegisterHonorsAgentAllowlist(t *testing.T) {
 		ts.CloseClientConnections()
 		ts.Close()
 	}()
-	c := &http.Client{
-		Transport: &http.Transport{DisableKeepAlives: true},
-	}
+	c := newContractClient(t)
 
 	bodyDenied := mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/agents/register", map[string]any{
 		"agent_id": "gamma", "capabilities": []string{"worker"}, "mode": "pull", "ttl": 60, "secret": "secret-gamma",
@@ -469,9 +725,7 @@ func TestContractInjectHonorsHumanAllowlist(t *testing.T) {
 		ts.CloseClientConnections()
 		ts.Close()
 	}()
-	c := &http.Client{
-		Transport: &http.Transport{DisableKeepAlives: true},
-	}
+	c := newContractClient(t)
 
 	regA := map[string]any{"agent_id": "a", "capabilities": []string{"orchestrator"}, "mode": "pull", "ttl": 60, "secret": "secret-a"}
 	regB := map[string]any{"agent_id": "b", "capabilities": []string{"worker"}, "mode": "pull", "ttl": 60, "secret": "secret-b"}
@@ -510,6 +764,13 @@ func TestBusConfigSurfaceDocumented(t *testing.T) {
 		"STATE_FILE",
 		"AGENT_ALLOWLIST",
 		"HUMAN_ALLOWLIST",
+		"CONVERSATION_IDLE_TIMEOUT",
+		"BLOB_DIR",
+		"BLOB_MAX_BYTES",
+		"ATTACHMENT_VERIFY",
+		"ATTACHMENT_CONTENT_TYPES",
+		"ATTACHMENT_MAX_BYTES",
+		"ATTACHMENT_ALLOWED_CIDRS",
 		"--db",
 		"GracePeriod = 30s",
 		"ProgressMinInterval = 2s",
@@ -528,3 +789,582 @@ func TestBusConfigSurfaceDocumented(t *testing.T) {
 		}
 	}
 }
+
+func TestContractBlobs(t *testing.T) {
+	blobs, err := blobstore.New(t.TempDir())
+	if err != nil {
+		t.Fatalf("new blob store: %v", err)
+	}
+	blobs.MaxSize = 64
+	verifier := &attachverify.Verifier{Mode: attachverify.ModeFlag, Blobs: blobs, Client: http.DefaultClient, Now: time.Now}
+	store := bus.NewStore(bus.Config{InboxWaitMax: time.Second, VerifyAttachments: verifier.Verify, BlobUploaders: func(sha string) []string {
+		b, _ := blobs.Stat(sha)
+		return b.Uploaders
+	}})
+	ts := httptest.NewServer(NewServerWithBlobs(store, agentauth.NewRegistryFromEnv(), blobs))
+	defer func() {
+		ts.CloseClientConnections()
+		ts.Close()
+	}()
+	c := newContractClient(t)
+
+	for _, id := range []string{"a", "b", "c"} {
+		mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/agents/register", map[string]any{
+			"agent_id": id, "capabilities": []string{"worker"}, "mode": "pull", "secret": "secret-" + id,
+		}, nil), http.StatusOK)
+	}
+
+	content := []byte("prior art: none found")
+	sum := sha256.Sum256(content)
+	upload := func(agentID, secret string, content []byte) *http.Response {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/v1/blobs?agent_id="+agentID+"&name=report.txt", bytes.NewReader(content))
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("X-Bus-Signature", signPayload(secret, content))
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatalf("upload: %v", err)
+		}
+		return resp
+	}
+	download := func(agentID string, headers map[string]string) *http.Response {
+		path := "/v1/blobs/" + hex.EncodeToString(sum[:]) + "?agent_id=" + agentID
+		h := map[string]string{"X-Bus-Signature": signPayload("secret-"+agentID, []byte(path))}
+		for k, v := range headers {
+			h[k] = v
+		}
+		return doJSON(t, c, http.MethodGet, ts.URL+path, nil, h)
+	}
+
+	var uploaded struct {
+		Attachment bus.Attachment `json:"attachment"`
+	}
+	if err := json.Unmarshal(mustStatus(t, upload("a", "secret-a", content), http.StatusOK), &uploaded); err != nil {
+		t.Fatalf("decode upload: %v", err)
+	}
+	want := bus.Attachment{URL: "/v1/blobs/" + hex.EncodeToString(sum[:]), Name: "report.txt", ContentType: "text/plain", Size: int64(len(content)), SHA256: hex.EncodeToString(sum[:])}
+	if uploaded.Attachment != want {
+		t.Fatalf("attachment=%+v want %+v", uploaded.Attachment, want)
+	}
+	mustStatus(t, upload("a", "secret-b", content), http.StatusUnauthorized)
+	mustStatus(t, upload("a", "secret-a", bytes.Repeat([]byte("x"), 65)), http.StatusRequestEntityTooLarge)
+
+	if got := mustStatus(t, download("a", nil), http.StatusOK); !bytes.Equal(got, content) {
+		t.Fatalf("uploader download=%q", got)
+	}
+	mustStatus(t, download("b", nil), http.StatusUnauthorized)
+
+	sendReq := map[string]any{
+		"to": "b", "from": "a", "request_id": "rid-blob", "type": "request", "body": "see report",
+		"attachments": []bus.Attachment{uploaded.Attachment},
+	}
+	sendBlob, _ := json.Marshal(sendReq)
+	var sent struct {
+		MessageID string `json:"message_id"`
+	}
+	if err := json.Unmarshal(mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/messages", sendReq, map[string]string{"X-Bus-Signature": signPayload("secret-a", sendBlob)}), http.StatusOK), &sent); err != nil {
+		t.Fatalf("decode send: %v", err)
+	}
+	blobDetail := mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+"/v1/messages/"+sent.MessageID, nil, nil), http.StatusOK)
+	if !bytes.Contains(blobDetail, []byte(`"verification":{"status":"verified"`)) {
+		t.Fatalf("expected verified attachment on message: %s", blobDetail)
+	}
+	var blobMessage struct {
+		Message struct {
+			ConversationID string `json:"conversation_id"`
+		} `json:"message"`
+	}
+	if err := json.Unmarshal(blobDetail, &blobMessage); err != nil || blobMessage.Message.ConversationID == "" {
+		t.Fatalf("decode message detail: %v %s", err, blobDetail)
+	}
+	badReq := map[string]any{
+		"to": "b", "from": "a", "request_id": "rid-blob-bad", "type": "inform", "body": "wrong size",
+		"attachments": []map[string]any{{"url": want.URL, "size": 3}},
+	}
+	badBlob, _ := json.Marshal(badReq)
+	if err := json.Unmarshal(mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/messages", badReq, map[string]string{"X-Bus-Signature": signPayload("secret-a", badBlob)}), http.StatusOK), &sent); err != nil {
+		t.Fatalf("decode send: %v", err)
+	}
+	blobDetail = mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+"/v1/messages/"+sent.MessageID, nil, nil), http.StatusOK)
+	if !bytes.Contains(blobDetail, []byte(`"status":"failed","reason":"size mismatch: declared 3, got 21"`)) {
+		t.Fatalf("expected flagged attachment on message: %s", blobDetail)
+	}
+	if refs := store.ReferencedBlobs(); refs[want.SHA256] != 2 {
+		t.Fatalf("referenced blobs=%v", refs)
+	}
+
+	resp := download("b", map[string]string{"Range": "bytes=0-8"})
+	if got := mustStatus(t, resp, http.StatusPartialContent); string(got) != "prior art" || resp.Header.Get("Content-Range") != "bytes 0-8/21" {
+		t.Fatalf("range download=%q content-range=%q", got, resp.Header.Get("Content-Range"))
+	}
+	mustStatus(t, download("b", map[string]string{"If-None-Match": `"` + want.SHA256 + `"`}), http.StatusNotModified)
+	mustStatus(t, download("c", nil), http.StatusUnauthorized)
+
+	// c has seen the digest but may not attach it to gain access; b, a
+	// recipient, may pass it on.
+	stealReq := map[string]any{
+		"to": "a", "from": "c", "request_id": "rid-steal", "type": "inform", "body": "mine now",
+		"attachments": []bus.Attachment{uploaded.Attachment},
+	}
+	stealBlob, _ := json.Marshal(stealReq)
+	mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/messages", stealReq, map[string]string{"X-Bus-Signature": signPayload("secret-c", stealBlob)}), http.StatusUnauthorized)
+	mustStatus(t, download("c", nil), http.StatusUnauthorized)
+	// Posting into the conversation that carries the blob does not make c
+	// a member either.
+	joinReq := map[string]any{
+		"to": "a", "from": "c", "conversation_id": blobMessage.Message.ConversationID, "request_id": "rid-join", "type": "inform", "body": "hello",
+	}
+	joinBlob, _ := json.Marshal(joinReq)
+	mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/messages", joinReq, map[string]string{"X-Bus-Signature": signPayload("secret-c", joinBlob)}), http.StatusOK)
+	mustStatus(t, download("c", nil), http.StatusUnauthorized)
+	forwardReq := map[string]any{
+		"to": "c", "from": "b", "request_id": "rid-forward", "type": "inform", "body": "fyi",
+		"attachments": []bus.Attachment{uploaded.Attachment},
+	}
+	forwardBlob, _ := json.Marshal(forwardReq)
+	mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/messages", forwardReq, map[string]string{"X-Bus-Signature": signPayload("secret-b", forwardBlob)}), http.StatusOK)
+	mustStatus(t, download("c", nil), http.StatusOK)
+
+	// A signature is bound to the blob it was made for.
+	other := "/v1/blobs/" + strings.Repeat("0", 64) + "?agent_id=a"
+	mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+other, nil, map[string]string{"X-Bus-Signature": signPayload("secret-a", []byte("/v1/blobs/"+want.SHA256+"?agent_id=a"))}), http.StatusUnauthorized)
+	mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+other, nil, map[string]string{"X-Bus-Signature": signPayload("secret-a", []byte(other))}), http.StatusNotFound)
+}
+
+func TestContractBatchSend(t *testing.T) {
+	ts := httptest.NewServer(newContractServer())
+	defer func() {
+		ts.CloseClientConnections()
+		ts.Close()
+	}()
+	c := newContractClient(t)
+
+	for _, id := range []string{"a", "b", "c"} {
+		mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/agents/register", map[string]any{
+			"agent_id": id, "capabilities": []string{"worker"}, "mode": "pull", "secret": "secret-" + id,
+		}, nil), http.StatusOK)
+	}
+
+	signed := func(body map[string]any) map[string]string {
+		blob, _ := json.Marshal(body)
+		return map[string]string{"X-Bus-Signature": signPayload("secret-a", blob)}
+	}
+
+	atomicReq := map[string]any{
+		"from": "a", "atomic": true,
+		"messages": []map[string]any{
+			{"to": "b", "request_id": "rid-fan-1", "type": "request", "body": "disclosure"},
+			{"to": "nobody", "request_id": "rid-fan-2", "type": "request", "body": "disclosure"},
+		},
+	}
+	blob := mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/messages:batch", atomicReq, signed(atomicReq)), http.StatusNotFound)
+	if !bytes.Contains(blob, []byte("messages[1]")) {
+		t.Fatalf("expected failing item index in error: %s", blob)
+	}
+
+	fanOut := map[string]any{
+		"from": "a", "atomic": true,
+		"messages": []map[string]any{
+			{"to": "b", "request_id": "rid-fan-1", "type": "request", "body": "disclosure"},
+			{"to": "c", "request_id": "rid-fan-1", "type": "request", "body": "disclosure"},
+		},
+	}
+	blob = mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/messages:batch", fanOut, signed(fanOut)), http.StatusOK)
+	var resp struct {
+		Results []struct {
+			OK        bool   `json:"ok"`
+			MessageID string `json:"message_id"`
+			Duplicate bool   `json:"duplicate"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(blob, &resp); err != nil {
+		t.Fatalf("decode batch response: %v", err)
+	}
+	if len(resp.Results) != 2 || !resp.Results[0].OK || !resp.Results[1].OK || resp.Results[0].MessageID == resp.Results[1].MessageID {
+		t.Fatalf("unexpected batch results: %s", blob)
+	}
+
+	mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/messages:batch", fanOut, map[string]string{"X-Bus-Signature": "bad"}), http.StatusUnauthorized)
+}
+
+func TestContractCallWaitsForReply(t *testing.T) {
+	store := bus.NewStore(bus.Config{InboxWaitMax: 3 * time.Second})
+	ts := httptest.NewServer(NewServer(store))
+	defer ts.Close()
+	c := newContractClient(t)
+
+	for _, reg := range []map[string]any{
+		{"agent_id": "a", "mode": "pull", "ttl": 60, "secret": "secret-a"},
+		{"agent_id": "b", "mode": "pull", "ttl": 60, "secret": "secret-b"},
+	} {
+		mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/agents/register", reg, nil), 200)
+	}
+
+	// b answers with a response message rather than a final event.
+	go func() {
+		for {
+			events, _, _ := store.PollInbox(bus.PollInboxInput{AgentID: "b", Wait: time.Second})
+			if len(events) == 0 {
+				continue
+			}
+			_, _, _ = store.SendMessage(bus.SendMessageInput{
+				To: "a", From: "b", ConversationID: events[0].ConversationID, RequestID: "resp-" + events[0].MessageID,
+				Type: bus.MessageTypeResponse, Body: "pong", InReplyTo: events[0].MessageID,
+			})
+			return
+		}
+	}()
+
+	callReq := map[string]any{"to": "b", "from": "a", "request_id": "rid-call", "body": "ping", "timeout": 2}
+	callBlob, _ := json.Marshal(callReq)
+	blob := mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/call", callReq, map[string]string{"X-Bus-Signature": signPayload("secret-a", callBlob)}), 200)
+	var resp struct {
+		MessageID string `json:"message_id"`
+		TimedOut  bool   `json:"timed_out"`
+		Reply     *struct {
+			Body      string `json:"body"`
+			InReplyTo string `json:"in_reply_to"`
+		} `json:"reply"`
+	}
+	if err := json.Unmarshal(blob, &resp); err != nil {
+		t.Fatalf("decode call response: %v", err)
+	}
+	if resp.TimedOut || resp.Reply == nil || resp.Reply.Body != "pong" || resp.Reply.InReplyTo != resp.MessageID {
+		t.Fatalf("unexpected call response: %s", blob)
+	}
+
+	// Nobody answers this one, so the call times out with the request still open.
+	slowReq := map[string]any{"to": "b", "from": "a", "request_id": "rid-slow", "body": "ping", "timeout": 1}
+	slowBlob, _ := json.Marshal(slowReq)
+	blob = mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/call", slowReq, map[string]string{"X-Bus-Signature": signPayload("secret-a", slowBlob)}), 200)
+	if !bytes.Contains(blob, []byte(`"timed_out":true`)) || !bytes.Contains(blob, []byte(`"state":"waiting"`)) {
+		t.Fatalf("expected timed out call, got %s", blob)
+	}
+}
+
+func TestContractCapabilitySchemas(t *testing.T) {
+	ts := httptest.NewServer(newContractServer())
+	defer func() {
+		ts.CloseClientConnections()
+		ts.Close()
+	}()
+	c := newContractClient(t)
+
+	schemas := map[string]any{
+		"screen": map[string]any{
+			"request": map[string]any{
+				"properties": map[string]any{
+					"body": map[string]any{"type": "object", "required": []string{"claims"}},
+				},
+			},
+		},
+	}
+	mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/agents/register", map[string]any{
+		"agent_id": "screen", "capabilities": []string{"screen", "review"}, "schemas": map[string]any{"triage": schemas["screen"]}, "mode": "pull", "secret": "secret-screen",
+	}, nil), http.StatusBadRequest)
+	for _, reg := range []map[string]any{
+		{"agent_id": "a", "mode": "pull", "secret": "secret-a"},
+		{"agent_id": "screen", "capabilities": []string{"screen", "review"}, "schemas": schemas, "mode": "pull", "secret": "secret-screen"},
+	} {
+		mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/agents/register", reg, nil), http.StatusOK)
+	}
+
+	blob := mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+"/v1/agents/screen", nil, nil), http.StatusOK)
+	if !bytes.Contains(blob, []byte(`"schemas":{"screen":{"request":`)) {
+		t.Fatalf("expected schemas on agent: %s", blob)
+	}
+
+	send := func(req map[string]any, want int) []byte {
+		t.Helper()
+		raw, _ := json.Marshal(req)
+		return mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/messages", req, map[string]string{"X-Bus-Signature": signPayload("secret-a", raw)}), want)
+	}
+	blob = send(map[string]any{"to": "screen", "from": "a", "request_id": "rid-bad", "type": "request", "capability": "screen", "body": `{"title":"x"}`}, http.StatusBadRequest)
+	if !bytes.Contains(blob, []byte(`"field":"/body/claims"`)) {
+		t.Fatalf("expected field pointer in error: %s", blob)
+	}
+	send(map[string]any{"to": "screen", "from": "a", "request_id": "rid-ok", "type": "request", "capability": "screen", "body": `{"claims":["a method"]}`}, http.StatusOK)
+	send(map[string]any{"to": "screen", "from": "a", "request_id": "rid-review", "type": "request", "capability": "review", "body": "free text"}, http.StatusOK)
+}
+
+func TestContractCapabilityRouting(t *testing.T) {
+	ts := httptest.NewServer(newContractServer())
+	defer func() {
+		ts.CloseClientConnections()
+		ts.Close()
+	}()
+	c := newContractClient(t)
+
+	for _, reg := range []map[string]any{
+		{"agent_id": "a", "mode": "pull", "secret": "secret-a"},
+		{"agent_id": "blue", "capabilities": []string{"patent-screen@2.0"}, "mode": "pull", "secret": "secret-blue"},
+		{"agent_id": "green", "capabilities": []string{"patent-screen@2.1"}, "mode": "pull", "secret": "secret-green"},
+	} {
+		mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/agents/register", reg, nil), http.StatusOK)
+	}
+	mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/agents/register", map[string]any{
+		"agent_id": "broken", "capabilities": []string{"patent-screen@latest"}, "mode": "pull", "secret": "secret-broken",
+	}, nil), http.StatusBadRequest)
+
+	blob := mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+"/v1/agents?capability="+url.QueryEscape("patent-screen@~2.0"), nil, nil), http.StatusOK)
+	if !bytes.Contains(blob, []byte(`"agent_id":"blue"`)) || bytes.Contains(blob, []byte(`"agent_id":"green"`)) {
+		t.Fatalf("expected only blue for ~2.0: %s", blob)
+	}
+	mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+"/v1/agents?capability="+url.QueryEscape("patent-screen@>>2"), nil, nil), http.StatusBadRequest)
+
+	req := map[string]any{"from": "a", "request_id": "rid-routed", "type": "request", "capability": "patent-screen@^2", "body": "disclosure"}
+	raw, _ := json.Marshal(req)
+	blob = mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/messages", req, map[string]string{"X-Bus-Signature": signPayload("secret-a", raw)}), http.StatusOK)
+	if !bytes.Contains(blob, []byte(`"to":"green"`)) {
+		t.Fatalf("expected routing to the newest version: %s", blob)
+	}
+}
+
+func TestContractHeartbeat(t *testing.T) {
+	ts := httptest.NewServer(newContractServer())
+	defer func() {
+		ts.CloseClientConnections()
+		ts.Close()
+	}()
+	c := newContractClient(t)
+
+	mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/agents/register", map[string]any{
+		"agent_id": "screen", "capabilities": []string{"patent-screen@2.1"}, "mode": "pull", "secret": "secret-screen",
+		"metadata": map[string]any{"version": "2.1.0", "build_sha": "abc123", "max_in_flight": 3, "cost_class": "high", "labels": map[string]string{"team": "patent"}},
+	}, nil), http.StatusOK)
+
+	beat := map[string]any{"in_flight": 1, "load": 0.33, "checks": []map[string]any{{"name": "llm_key", "status": "degraded", "detail": "rate limited"}}}
+	raw, _ := json.Marshal(beat)
+	mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/agents/screen/heartbeat", beat, map[string]string{"X-Bus-Signature": "bad"}), http.StatusUnauthorized)
+	blob := mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/agents/screen/heartbeat", beat, map[string]string{"X-Bus-Signature": signPayload("secret-screen", raw)}), http.StatusOK)
+	if !bytes.Contains(blob, []byte(`"status":"degraded"`)) {
+		t.Fatalf("expected degraded health: %s", blob)
+	}
+
+	blob = mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+"/v1/agents?label=team%3Dpatent", nil, nil), http.StatusOK)
+	if !bytes.Contains(blob, []byte(`"build_sha":"abc123"`)) || !bytes.Contains(blob, []byte(`"in_flight":1`)) {
+		t.Fatalf("expected metadata and health in listing: %s", blob)
+	}
+	blob = mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+"/v1/agents?label=team%3Dprior-art", nil, nil), http.StatusOK)
+	if bytes.Contains(blob, []byte(`"agent_id":"screen"`)) {
+		t.Fatalf("expected label filter to exclude screen: %s", blob)
+	}
+}
+
+func TestContractDeregisterAgent(t *testing.T) {
+	ts := httptest.NewServer(newContractServer())
+	defer func() {
+		ts.CloseClientConnections()
+		ts.Close()
+	}()
+	c := newContractClient(t)
+
+	for _, id := range []string{"sender", "screen-a", "screen-b"} {
+		mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/agents/register", map[string]any{
+			"agent_id": id, "capabilities": []string{"patent-screen@2"}, "mode": "pull", "secret": "secret-" + id,
+		}, nil), http.StatusOK)
+	}
+	send := map[string]any{"to": "screen-a", "from": "sender", "request_id": "r1", "type": "request", "body": "screen this", "capability": "patent-screen"}
+	raw, _ := json.Marshal(send)
+	blob := mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/messages", send, map[string]string{"X-Bus-Signature": signPayload("secret-sender", raw)}), http.StatusOK)
+	var sent struct {
+		MessageID string `json:"message_id"`
+	}
+	_ = json.Unmarshal(blob, &sent)
+
+	query := "reason=shutdown"
+	mustStatus(t, doJSON(t, c, http.MethodDelete, ts.URL+"/v1/agents/screen-a?"+query, nil, map[string]string{"X-Bus-Signature": signPayload("secret-screen-b", []byte(query))}), http.StatusUnauthorized)
+	blob = mustStatus(t, doJSON(t, c, http.MethodDelete, ts.URL+"/v1/agents/screen-a?"+query, nil, map[string]string{"X-Bus-Signature": signPayload("secret-screen-a", []byte(query))}), http.StatusOK)
+	var out struct {
+		Rerouted []struct {
+			MessageID string `json:"message_id"`
+			To        string `json:"to"`
+		} `json:"rerouted"`
+		Failed []string `json:"failed"`
+	}
+	if err := json.Unmarshal(blob, &out); err != nil || len(out.Rerouted) != 1 || out.Rerouted[0].MessageID != sent.MessageID || out.Rerouted[0].To != "screen-b" || len(out.Failed) != 0 {
+		t.Fatalf("unexpected deregister response: %s", blob)
+	}
+
+	blob = mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+"/v1/agents/screen-a", nil, nil), http.StatusOK)
+	if !bytes.Contains(blob, []byte(`"status":"deregistered"`)) {
+		t.Fatalf("expected deregistered status: %s", blob)
+	}
+	send["request_id"] = "r2"
+	raw, _ = json.Marshal(send)
+	mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/messages", send, map[string]string{"X-Bus-Signature": signPayload("secret-sender", raw)}), http.StatusNotFound)
+}
+
+func TestContractMaxInFlightBackpressure(t *testing.T) {
+	now := time.Date(2026, 2, 17, 0, 0, 0, 0, time.UTC)
+	store := bus.NewStore(bus.Config{
+		MaxPendingPerAgent:     1,
+		BackpressureRetryAfter: 7 * time.Second,
+		Clock:                  func() time.Time { return now },
+	})
+	ts := httptest.NewServer(NewServer(store))
+	defer func() {
+		ts.CloseClientConnections()
+		ts.Close()
+	}()
+	c := newContractClient(t)
+
+	mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/agents/register", map[string]any{
+		"agent_id": "a", "mode": "pull", "secret": "secret-a",
+	}, nil), http.StatusOK)
+	mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/agents/register", map[string]any{
+		"agent_id": "screen", "mode": "pull", "secret": "secret-screen", "metadata": map[string]any{"max_in_flight": 1},
+	}, nil), http.StatusOK)
+
+	send := func(rid string) *http.Response {
+		req := map[string]any{"to": "screen", "from": "a", "request_id": rid, "type": "request", "body": "screen this", "priority": "urgent"}
+		raw, _ := json.Marshal(req)
+		return doJSON(t, c, http.MethodPost, ts.URL+"/v1/messages", req, map[string]string{"X-Bus-Signature": signPayload("secret-a", raw)})
+	}
+	mustStatus(t, send("r1"), http.StatusOK)
+	blob := mustStatus(t, send("r2"), http.StatusOK)
+	var held struct {
+		MessageID string `json:"message_id"`
+		State     string `json:"state"`
+	}
+	if err := json.Unmarshal(blob, &held); err != nil || held.State != "pending" {
+		t.Fatalf("expected second request held as pending: %s", blob)
+	}
+	blob = mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+"/v1/messages/"+held.MessageID, nil, nil), http.StatusOK)
+	if !bytes.Contains(blob, []byte(`"held_for_capacity":true`)) || !bytes.Contains(blob, []byte(`"priority":"urgent"`)) {
+		t.Fatalf("expected held urgent request: %s", blob)
+	}
+	blob = mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+"/v1/system/status", nil, nil), http.StatusOK)
+	if !bytes.Contains(blob, []byte(`"held_requests":{"high":0,"low":0,"normal":0,"urgent":1}`)) {
+		t.Fatalf("expected held queue depth by priority: %s", blob)
+	}
+
+	resp := send("r3")
+	if resp.Header.Get("Retry-After") != "7" {
+		t.Fatalf("expected Retry-After 7, got %q", resp.Header.Get("Retry-After"))
+	}
+	blob = mustStatus(t, resp, http.StatusTooManyRequests)
+	if !bytes.Contains(blob, []byte(`"code":"rate_limited"`)) || !bytes.Contains(blob, []byte(`"retry_after":7`)) {
+		t.Fatalf("expected rate_limited with retry_after: %s", blob)
+	}
+}
+
+func TestContractScheduledMessages(t *testing.T) {
+	now := time.Date(2026, 2, 17, 0, 0, 0, 0, time.UTC)
+	store := bus.NewStore(bus.Config{Clock: func() time.Time { return now }})
+	ts := httptest.NewServer(NewServer(store))
+	defer func() {
+		ts.CloseClientConnections()
+		ts.Close()
+	}()
+	c := newContractClient(t)
+
+	for _, id := range []string{"a", "b"} {
+		mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/agents/register", map[string]any{
+			"agent_id": id, "mode": "pull", "secret": "secret-" + id,
+		}, nil), http.StatusOK)
+	}
+	send := func(req map[string]any) []byte {
+		raw, _ := json.Marshal(req)
+		return mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/messages", req, map[string]string{"X-Bus-Signature": signPayload("secret-a", raw)}), http.StatusOK)
+	}
+	var first, second struct {
+		MessageID string `json:"message_id"`
+		State     string `json:"state"`
+		DeliverAt string `json:"deliver_at"`
+	}
+	blob := send(map[string]any{"to": "b", "from": "a", "request_id": "r1", "type": "request", "body": "follow up", "delay_seconds": 60})
+	if err := json.Unmarshal(blob, &first); err != nil || first.State != "scheduled" || first.DeliverAt != "2026-02-17T00:01:00Z" {
+		t.Fatalf("expected scheduled request: %s", blob)
+	}
+	blob = send(map[string]any{"to": "b", "from": "a", "request_id": "r2", "type": "inform", "body": "reminder", "deliver_at": "2026-02-17T00:00:30Z"})
+	if err := json.Unmarshal(blob, &second); err != nil || second.State != "scheduled" {
+		t.Fatalf("expected scheduled inform: %s", blob)
+	}
+
+	query := "agent_id=a"
+	mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+"/v1/messages/scheduled?"+query, nil, map[string]string{"X-Bus-Signature": signPayload("secret-b", []byte(query))}), http.StatusUnauthorized)
+	blob = mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+"/v1/messages/scheduled?"+query, nil, map[string]string{"X-Bus-Signature": signPayload("secret-a", []byte(query))}), http.StatusOK)
+	var listed struct {
+		Messages []struct {
+			MessageID string `json:"message_id"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(blob, &listed); err != nil || len(listed.Messages) != 2 || listed.Messages[0].MessageID != second.MessageID {
+		t.Fatalf("expected both messages, earliest first: %s", blob)
+	}
+
+	cancel := map[string]any{"agent_id": "a", "message_id": second.MessageID}
+	raw, _ := json.Marshal(cancel)
+	// A signed cancel for one message cannot be replayed against another.
+	mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/messages/"+first.MessageID+"/cancel", cancel, map[string]string{"X-Bus-Signature": signPayload("secret-a", raw)}), http.StatusBadRequest)
+	blob = mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/messages/"+second.MessageID+"/cancel", cancel, map[string]string{"X-Bus-Signature": signPayload("secret-a", raw)}), http.StatusOK)
+	if !bytes.Contains(blob, []byte(`"state":"cancelled"`)) {
+		t.Fatalf("expected cancelled message: %s", blob)
+	}
+	mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/messages/"+second.MessageID+"/cancel", cancel, map[string]string{"X-Bus-Signature": signPayload("secret-a", raw)}), http.StatusConflict)
+	blob = mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+"/v1/system/status", nil, nil), http.StatusOK)
+	if !bytes.Contains(blob, []byte(`"scheduled_messages":1`)) {
+		t.Fatalf("expected one scheduled message in status: %s", blob)
+	}
+
+	now = now.Add(time.Minute)
+	store.DeliverDue()
+	blob = mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+"/v1/messages/"+first.MessageID, nil, nil), http.StatusOK)
+	if !bytes.Contains(blob, []byte(`"state":"waiting"`)) || !bytes.Contains(blob, []byte(`"deliver_at":"2026-02-17T00:01:00Z"`)) {
+		t.Fatalf("expected request delivered on schedule: %s", blob)
+	}
+}
+
+func TestContractMulticastInform(t *testing.T) {
+	ts := httptest.NewServer(NewServer(bus.NewStore(bus.Config{})))
+	defer func() {
+		ts.CloseClientConnections()
+		ts.Close()
+	}()
+	c := newContractClient(t)
+
+	for _, id := range []string{"a", "b", "c"} {
+		mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/agents/register", map[string]any{
+			"agent_id": id, "mode": "pull", "secret": "secret-" + id, "capabilities": []string{"ip-review"},
+		}, nil), http.StatusOK)
+	}
+	send := func(req map[string]any, want int) []byte {
+		raw, _ := json.Marshal(req)
+		return mustStatus(t, doJSON(t, c, http.MethodPost, ts.URL+"/v1/messages", req, map[string]string{"X-Bus-Signature": signPayload("secret-a", raw)}), want)
+	}
+	send(map[string]any{"from": "a", "request_id": "r0", "type": "inform", "body": "x", "recipients": []string{"b", "nobody"}}, http.StatusNotFound)
+	send(map[string]any{"from": "a", "request_id": "r0", "type": "request", "body": "x", "recipients": []string{"b"}}, http.StatusBadRequest)
+
+	blob := send(map[string]any{"from": "a", "request_id": "r1", "type": "inform", "body": "case 2026-124 withdrawn", "recipients": []string{"capability:ip-review"}}, http.StatusOK)
+	var sent struct {
+		MessageID  string   `json:"message_id"`
+		State      string   `json:"state"`
+		Recipients []string `json:"recipients"`
+	}
+	if err := json.Unmarshal(blob, &sent); err != nil || sent.State != "completed" || strings.Join(sent.Recipients, ",") != "b,c" {
+		t.Fatalf("expected multicast to b and c: %s", blob)
+	}
+
+	query := "agent_id=c&cursor=0&wait=0"
+	blob = mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+"/v1/inbox?"+query, nil, map[string]string{"X-Bus-Signature": signPayload("secret-c", []byte(query))}), http.StatusOK)
+	if !bytes.Contains(blob, []byte(`"parent_id":"`+sent.MessageID+`"`)) {
+		t.Fatalf("expected c's copy to name its parent: %s", blob)
+	}
+	blob = mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+"/v1/messages/"+sent.MessageID, nil, nil), http.StatusOK)
+	var detail struct {
+		Message struct {
+			ConversationID string `json:"conversation_id"`
+			Deliveries     []struct {
+				AgentID string `json:"agent_id"`
+				State   string `json:"state"`
+			} `json:"deliveries"`
+		} `json:"message"`
+	}
+	if err := json.Unmarshal(blob, &detail); err != nil || len(detail.Message.Deliveries) != 2 || detail.Message.Deliveries[1].AgentID != "c" || detail.Message.Deliveries[1].State != "completed" {
+		t.Fatalf("expected a delivery per recipient: %s", blob)
+	}
+	blob = mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+"/v1/conversations/"+detail.Message.ConversationID+"/export", nil, nil), http.StatusOK)
+	if !bytes.Contains(blob, []byte("inform "+sent.MessageID+": a → b, c")) {
+		t.Fatalf("expected the transcript to name the multicast recipients: %s", blob)
+	}
+}
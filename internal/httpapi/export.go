@@ -0,0 +1,449 @@
+package httpapi
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/joelkehle/techtransfer-agency/internal/bus"
+)
+
+// Transcript entry kinds.
+const (
+	entryMessage            = "message"
+	entryHumanInjection     = "human_injection"
+	entryStateChange        = "state_change"
+	entryProgress           = "progress"
+	entryConversationStatus = "conversation_status"
+	entryConversationUpdate = "conversation_updated"
+)
+
+// transcriptEntry is one line of a conversation's audit trail. Entries are
+// hash-chained: Hash is the hex SHA-256 of the entry's JSON encoding with
+// Hash left empty, and PrevHash is the previous entry's Hash.
+type transcriptEntry struct {
+	Seq         int              `json:"seq"`
+	At          time.Time        `json:"at"`
+	Kind        string           `json:"kind"`
+	MessageID   string           `json:"message_id,omitempty"`
+	Type        bus.MessageType  `json:"type,omitempty"`
+	From        string           `json:"from,omitempty"`
+	To          string           `json:"to,omitempty"`
+	Recipients  []string         `json:"recipients,omitempty"`
+	InReplyTo   string           `json:"in_reply_to,omitempty"`
+	Identity    string           `json:"identity,omitempty"`
+	Actor       string           `json:"actor,omitempty"`
+	FromState   string           `json:"from_state,omitempty"`
+	ToState     string           `json:"to_state,omitempty"`
+	Reason      string           `json:"reason,omitempty"`
+	Changed     []string         `json:"changed,omitempty"`
+	Body        string           `json:"body,omitempty"`
+	Meta        any              `json:"meta,omitempty"`
+	Attachments []bus.Attachment `json:"attachments,omitempty"`
+	ErrorCode   string           `json:"error_code,omitempty"`
+	PrevHash    string           `json:"prev_hash"`
+	Hash        string           `json:"hash,omitempty"`
+}
+
+// transcript is a conversation with its chronological, hash-chained entries.
+// Digest is the last entry's hash and so covers the whole chain.
+type transcript struct {
+	Conversation *bus.Conversation `json:"conversation"`
+	ExportedAt   time.Time         `json:"exported_at"`
+	EntryCount   int               `json:"entry_count"`
+	Digest       string            `json:"digest"`
+	Entries      []transcriptEntry `json:"-"`
+}
+
+// buildTranscript assembles the audit trail for a conversation from its
+// messages, their state histories and the conversation's own history of
+// progress, status and update events.
+func buildTranscript(store bus.API, conversationID string, now time.Time) (*transcript, error) {
+	conv, err := store.GetConversation(conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []transcriptEntry
+	messages := map[string]bus.Message{}
+	cursor := 0
+	for {
+		_, page, next, err := store.ListConversationMessages(bus.ListConversationMessagesInput{
+			ConversationID: conv.ConversationID,
+			Cursor:         cursor,
+			Limit:          200,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range page {
+			messages[m.MessageID] = m
+			entries = append(entries, messageEntry(m))
+			detail, err := store.GetMessage(m.MessageID)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, transitionEntries(detail)...)
+		}
+		if len(page) == 0 || next <= cursor {
+			break
+		}
+		cursor = next
+	}
+
+	history, err := store.ConversationHistory(conv.ConversationID)
+	if err != nil {
+		return nil, err
+	}
+	for _, evt := range history {
+		switch evt.Type {
+		case bus.ObserveProgress:
+			entry := transcriptEntry{At: evt.At, Kind: entryProgress, MessageID: evt.MessageID, Body: evt.Body, Meta: evt.Meta}
+			if m, ok := messages[evt.MessageID]; ok {
+				entry.From, entry.To = m.From, m.To
+			}
+			entries = append(entries, entry)
+		case bus.ObserveConversationStatus:
+			entries = append(entries, transcriptEntry{
+				At:        evt.At,
+				Kind:      entryConversationStatus,
+				Actor:     evt.Actor,
+				FromState: evt.FromStatus,
+				ToState:   evt.ToStatus,
+				Reason:    evt.Reason,
+			})
+		case bus.ObserveConversationUpdated:
+			entries = append(entries, transcriptEntry{
+				At:      evt.At,
+				Kind:    entryConversationUpdate,
+				Actor:   evt.Actor,
+				Changed: evt.Changed,
+			})
+		}
+	}
+
+	// Stable, so a message stays ahead of transitions stamped with the same
+	// instant.
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].At.Before(entries[j].At) })
+	prev := ""
+	for i := range entries {
+		entries[i].Seq = i + 1
+		entries[i].PrevHash = prev
+		entries[i].Hash = ""
+		blob, err := json.Marshal(entries[i])
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(blob)
+		entries[i].Hash = hex.EncodeToString(sum[:])
+		prev = entries[i].Hash
+	}
+	return &transcript{
+		Conversation: conv,
+		ExportedAt:   now,
+		EntryCount:   len(entries),
+		Digest:       prev,
+		Entries:      entries,
+	}, nil
+}
+
+func messageEntry(m bus.Message) transcriptEntry {
+	entry := transcriptEntry{
+		At:          m.CreatedAt,
+		Kind:        entryMessage,
+		MessageID:   m.MessageID,
+		Type:        m.Type,
+		From:        m.From,
+		To:          m.To,
+		Recipients:  m.Recipients,
+		InReplyTo:   m.InReplyTo,
+		Body:        m.Body,
+		Meta:        m.Meta,
+		Attachments: m.Attachments,
+	}
+	if identity, ok := strings.CutPrefix(m.From, "human:"); ok {
+		entry.Kind = entryHumanInjection
+		entry.Identity = identity
+	}
+	return entry
+}
+
+// transitionEntries renders a message's state history. The terminal
+// transition also carries the result's attachments and error code.
+func transitionEntries(detail *bus.MessageDetail) []transcriptEntry {
+	out := make([]transcriptEntry, 0, len(detail.StateHistory))
+	for _, tr := range detail.StateHistory {
+		out = append(out, transcriptEntry{
+			At:        tr.At,
+			Kind:      entryStateChange,
+			MessageID: detail.MessageID,
+			Actor:     tr.Actor,
+			FromState: string(tr.From),
+			ToState:   string(tr.To),
+			Reason:    tr.Reason,
+			Body:      tr.Body,
+			Meta:      tr.Meta,
+		})
+	}
+	if n := len(out); n > 0 && detail.Result != nil && out[n-1].ToState == string(detail.State) {
+		out[n-1].Attachments = detail.Result.Attachments
+		out[n-1].ErrorCode = detail.Result.ErrorCode
+	}
+	return out
+}
+
+// headline is the one-line summary of an entry used by the Markdown and HTML
+// renderings.
+func (e transcriptEntry) headline() string {
+	switch e.Kind {
+	case entryMessage:
+		to := e.To
+		if to == "" {
+			to = strings.Join(e.Recipients, ", ")
+		}
+		return fmt.Sprintf("%s %s: %s → %s", e.Type, e.MessageID, e.From, to)
+	case entryHumanInjection:
+		return fmt.Sprintf("human injection %s: %s → %s", e.MessageID, e.Identity, e.To)
+	case entryStateChange:
+		line := fmt.Sprintf("%s: %s → %s", e.MessageID, e.FromState, e.ToState)
+		if e.Actor != "" {
+			line += " by " + e.Actor
+		}
+		return line
+	case entryProgress:
+		return fmt.Sprintf("progress on %s", e.MessageID)
+	case entryConversationStatus:
+		line := fmt.Sprintf("conversation %s → %s", e.FromState, e.ToState)
+		if e.Actor != "" {
+			line += " by " + e.Actor
+		}
+		return line
+	case entryConversationUpdate:
+		line := "conversation updated: " + strings.Join(e.Changed, ", ")
+		if e.Actor != "" {
+			line += " by " + e.Actor
+		}
+		return line
+	}
+	return e.Kind
+}
+
+// details lists the entry's secondary fields as label/value pairs.
+func (e transcriptEntry) details() [][2]string {
+	var out [][2]string
+	if e.InReplyTo != "" {
+		out = append(out, [2]string{"In reply to", e.InReplyTo})
+	}
+	if e.Reason != "" {
+		out = append(out, [2]string{"Reason", e.Reason})
+	}
+	if e.ErrorCode != "" {
+		out = append(out, [2]string{"Error code", e.ErrorCode})
+	}
+	if e.Meta != nil {
+		blob, _ := json.Marshal(e.Meta)
+		out = append(out, [2]string{"Meta", string(blob)})
+	}
+	for _, a := range e.Attachments {
+		out = append(out, [2]string{"Attachment", attachmentLine(a)})
+	}
+	return out
+}
+
+func attachmentLine(a bus.Attachment) string {
+	parts := []string{a.URL}
+	if a.Name != "" {
+		parts[0] = a.Name + " <" + a.URL + ">"
+	}
+	if a.ContentType != "" {
+		parts = append(parts, a.ContentType)
+	}
+	if a.Size > 0 {
+		parts = append(parts, fmt.Sprintf("%d bytes", a.Size))
+	}
+	if a.SHA256 != "" {
+		parts = append(parts, "sha256 "+a.SHA256)
+	}
+	return strings.Join(parts, " · ")
+}
+
+func (t *transcript) title() string {
+	if t.Conversation.Title != "" {
+		return t.Conversation.Title
+	}
+	return t.Conversation.ConversationID
+}
+
+func (t *transcript) writeJSONL(w *bytes.Buffer) error {
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(t); err != nil {
+		return err
+	}
+	for _, e := range t.Entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *transcript) writeMarkdown(w *bytes.Buffer) {
+	c := t.Conversation
+	fmt.Fprintf(w, "# Conversation transcript: %s\n\n", t.title())
+	fmt.Fprintf(w, "- Conversation: `%s`\n", c.ConversationID)
+	fmt.Fprintf(w, "- Status: %s\n", c.Status)
+	if len(c.Participants) > 0 {
+		fmt.Fprintf(w, "- Participants: %s\n", strings.Join(c.Participants, ", "))
+	}
+	if len(c.Tags) > 0 {
+		fmt.Fprintf(w, "- Tags: %s\n", strings.Join(c.Tags, ", "))
+	}
+	if c.Meta != nil {
+		blob, _ := json.Marshal(c.Meta)
+		fmt.Fprintf(w, "- Meta: `%s`\n", blob)
+	}
+	fmt.Fprintf(w, "- Created: %s\n", c.CreatedAt.Format(time.RFC3339Nano))
+	fmt.Fprintf(w, "- Exported: %s\n", t.ExportedAt.Format(time.RFC3339Nano))
+	fmt.Fprintf(w, "- Entries: %d\n", t.EntryCount)
+	fmt.Fprintf(w, "- Digest (SHA-256 chain): `%s`\n", t.Digest)
+	w.WriteString("\n## Timeline\n")
+	for _, e := range t.Entries {
+		fmt.Fprintf(w, "\n### %d. %s · %s\n\n", e.Seq, e.At.Format(time.RFC3339Nano), e.headline())
+		if e.Body != "" {
+			for _, line := range strings.Split(e.Body, "\n") {
+				fmt.Fprintf(w, "> %s\n", line)
+			}
+			w.WriteString("\n")
+		}
+		for _, d := range e.details() {
+			fmt.Fprintf(w, "- %s: %s\n", d[0], d[1])
+		}
+		fmt.Fprintf(w, "- Hash: `%s`\n", e.Hash)
+	}
+}
+
+var transcriptHTML = template.Must(template.New("transcript").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Conversation transcript: {{.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 60rem; margin: 2rem auto; line-height: 1.4; }
+.entry { border-top: 1px solid #ccc; padding: 0.5rem 0; }
+.body { white-space: pre-wrap; background: #f6f6f6; padding: 0.5rem; }
+code { font-size: 0.85em; }
+</style>
+</head>
+<body>
+<h1>Conversation transcript: {{.Title}}</h1>
+<ul>
+<li>Conversation: <code>{{.Conversation.ConversationID}}</code></li>
+<li>Status: {{.Conversation.Status}}</li>
+{{- with .Conversation.Participants}}
+<li>Participants: {{range $i, $p := .}}{{if $i}}, {{end}}{{$p}}{{end}}</li>
+{{- end}}
+{{- with .Conversation.Tags}}
+<li>Tags: {{range $i, $t := .}}{{if $i}}, {{end}}{{$t}}{{end}}</li>
+{{- end}}
+{{- with .Meta}}
+<li>Meta: <code>{{.}}</code></li>
+{{- end}}
+<li>Created: {{.Conversation.CreatedAt.Format "2006-01-02T15:04:05.999999999Z07:00"}}</li>
+<li>Exported: {{.ExportedAt.Format "2006-01-02T15:04:05.999999999Z07:00"}}</li>
+<li>Entries: {{.EntryCount}}</li>
+<li>Digest (SHA-256 chain): <code>{{.Digest}}</code></li>
+</ul>
+<h2>Timeline</h2>
+{{- range .Entries}}
+<div class="entry" id="entry-{{.Seq}}">
+<h3>{{.Seq}}. {{.At.Format "2006-01-02T15:04:05.999999999Z07:00"}} · {{.Headline}}</h3>
+{{- if .Body}}
+<div class="body">{{.Body}}</div>
+{{- end}}
+<ul>
+{{- range .Details}}
+<li>{{index . 0}}: {{index . 1}}</li>
+{{- end}}
+<li>Hash: <code>{{.Hash}}</code></li>
+</ul>
+</div>
+{{- end}}
+</body>
+</html>
+`))
+
+// htmlEntry exposes an entry's derived text to the HTML template.
+type htmlEntry struct {
+	transcriptEntry
+	Headline string
+	Details  [][2]string
+}
+
+func (t *transcript) writeHTML(w *bytes.Buffer) error {
+	view := struct {
+		*transcript
+		Title   string
+		Meta    string
+		Entries []htmlEntry
+	}{transcript: t, Title: t.title()}
+	if t.Conversation.Meta != nil {
+		blob, _ := json.Marshal(t.Conversation.Meta)
+		view.Meta = string(blob)
+	}
+	for _, e := range t.Entries {
+		view.Entries = append(view.Entries, htmlEntry{transcriptEntry: e, Headline: e.headline(), Details: e.details()})
+	}
+	return transcriptHTML.Execute(w, view)
+}
+
+func (s *Server) handleConversationExport(w http.ResponseWriter, r *http.Request) {
+	if !methodOnly(w, r, http.MethodGet) {
+		return
+	}
+	format := strings.TrimSpace(r.URL.Query().Get("format"))
+	if format == "" {
+		format = "md"
+	}
+	contentType, ok := map[string]string{
+		"md":    "text/markdown; charset=utf-8",
+		"jsonl": "application/x-ndjson",
+		"html":  "text/html; charset=utf-8",
+	}[format]
+	if !ok {
+		writeBusError(w, &bus.Error{Code: bus.CodeValidation, Message: "format must be md, jsonl, or html", Status: 400})
+		return
+	}
+
+	_, span := startBusSpan(r.Context(), "ExportConversation")
+	t, err := buildTranscript(s.store, r.PathValue("conversation_id"), time.Now().UTC())
+	endBusSpan(span, err)
+	if err != nil {
+		writeBusError(w, err)
+		return
+	}
+	var buf bytes.Buffer
+	switch format {
+	case "md":
+		t.writeMarkdown(&buf)
+	case "jsonl":
+		err = t.writeJSONL(&buf)
+	case "html":
+		err = t.writeHTML(&buf)
+	}
+	if err != nil {
+		writeBusError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", t.Conversation.ConversationID+"."+format))
+	w.Header().Set("X-Transcript-Digest", t.Digest)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(buf.Bytes())
+}
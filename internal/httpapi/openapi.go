@@ -0,0 +1,21 @@
+package httpapi
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPISpec documents every route in routes(). contract tests validate
+// their traffic against it, so it must be updated with any API change.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if !methodOnly(w, r, http.MethodGet) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(openAPISpec)
+}
@@ -0,0 +1,357 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+const openAPIResource = "openapi.json"
+
+// openAPIDoc validates HTTP traffic against the embedded OpenAPI document.
+type openAPIDoc struct {
+	root     map[string]any
+	compiler *jsonschema.Compiler
+
+	mu      sync.Mutex
+	schemas map[string]*jsonschema.Schema
+}
+
+var (
+	openAPIOnce   sync.Once
+	openAPILoaded *openAPIDoc
+	openAPIErr    error
+)
+
+func loadOpenAPI(t *testing.T) *openAPIDoc {
+	t.Helper()
+	openAPIOnce.Do(func() {
+		doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(openAPISpec))
+		if err != nil {
+			openAPIErr = err
+			return
+		}
+		c := jsonschema.NewCompiler()
+		if err := c.AddResource(openAPIResource, doc); err != nil {
+			openAPIErr = err
+			return
+		}
+		openAPILoaded = &openAPIDoc{root: doc.(map[string]any), compiler: c, schemas: map[string]*jsonschema.Schema{}}
+	})
+	if openAPIErr != nil {
+		t.Fatalf("load openapi.json: %v", openAPIErr)
+	}
+	return openAPILoaded
+}
+
+func escapePointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+func (d *openAPIDoc) lookup(pointer string) (map[string]any, bool) {
+	var cur any = d.root
+	for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		obj, ok := cur.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = obj[token]; !ok {
+			return nil, false
+		}
+	}
+	obj, ok := cur.(map[string]any)
+	return obj, ok
+}
+
+// deref follows a local $ref on a response or parameter object, returning the
+// resolved object and its pointer.
+func (d *openAPIDoc) deref(pointer string) (map[string]any, string, bool) {
+	obj, ok := d.lookup(pointer)
+	if !ok {
+		return nil, "", false
+	}
+	if ref, ok := obj["$ref"].(string); ok && strings.HasPrefix(ref, "#/") {
+		return d.deref(strings.TrimPrefix(ref, "#"))
+	}
+	return obj, pointer, true
+}
+
+func (d *openAPIDoc) validate(pointer string, blob []byte) error {
+	d.mu.Lock()
+	sch, ok := d.schemas[pointer]
+	if !ok {
+		var err error
+		sch, err = d.compiler.Compile(openAPIResource + "#" + pointer)
+		if err != nil {
+			d.mu.Unlock()
+			return fmt.Errorf("compile %s: %w", pointer, err)
+		}
+		d.schemas[pointer] = sch
+	}
+	d.mu.Unlock()
+	inst, err := jsonschema.UnmarshalJSON(bytes.NewReader(blob))
+	if err != nil {
+		return fmt.Errorf("decode body: %w", err)
+	}
+	return sch.Validate(inst)
+}
+
+// operation returns the pointer of the operation matching method and path.
+// As in ServeMux, a literal path wins over a template that also matches it.
+func (d *openAPIDoc) operation(method, path string) (string, bool) {
+	paths, _ := d.root["paths"].(map[string]any)
+	best, found := "", false
+	for template := range paths {
+		if !regexp.MustCompile(templateRegexp(template)).MatchString(path) {
+			continue
+		}
+		pointer := "/paths/" + escapePointer(template) + "/" + strings.ToLower(method)
+		if _, ok := d.lookup(pointer); !ok {
+			continue
+		}
+		if !strings.Contains(template, "{") {
+			return pointer, true
+		}
+		best, found = pointer, true
+	}
+	return best, found
+}
+
+func templateRegexp(template string) string {
+	parts := strings.Split(template, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			parts[i] = `[^/]+`
+		} else {
+			parts[i] = regexp.QuoteMeta(part)
+		}
+	}
+	return "^" + strings.Join(parts, "/") + "$"
+}
+
+func (d *openAPIDoc) checkRequest(op string, req *http.Request, body []byte) error {
+	params, _ := d.lookup(op)
+	list, _ := params["parameters"].([]any)
+	for i := range list {
+		p, _, ok := d.deref(op + "/parameters/" + strconv.Itoa(i))
+		if !ok || p["required"] != true {
+			continue
+		}
+		name, _ := p["name"].(string)
+		switch p["in"] {
+		case "query":
+			if !req.URL.Query().Has(name) {
+				return fmt.Errorf("missing required query parameter %s", name)
+			}
+		case "header":
+			if req.Header.Get(name) == "" {
+				return fmt.Errorf("missing required header %s", name)
+			}
+		}
+	}
+	if len(body) == 0 {
+		if rb, ok := d.lookup(op + "/requestBody"); ok && rb["required"] == true {
+			return fmt.Errorf("missing required request body")
+		}
+		return nil
+	}
+	mediaType := strings.TrimSpace(strings.Split(req.Header.Get("Content-Type"), ";")[0])
+	if mediaType != "application/json" {
+		// Raw uploads are checked for a documented media type only.
+		content, _ := d.lookup(op + "/requestBody/content")
+		if !documentsMediaType(content, mediaType) {
+			return fmt.Errorf("request content type %q is not documented", mediaType)
+		}
+		return nil
+	}
+	pointer := op + "/requestBody/content/application~1json/schema"
+	if _, ok := d.lookup(pointer); !ok {
+		return fmt.Errorf("operation documents no JSON request body")
+	}
+	return d.validate(pointer, body)
+}
+
+// documentsMediaType reports whether an OpenAPI content map lists mediaType,
+// directly or through */*.
+func documentsMediaType(content map[string]any, mediaType string) bool {
+	if _, ok := content[mediaType]; ok {
+		return true
+	}
+	_, ok := content["*/*"]
+	return ok
+}
+
+func (d *openAPIDoc) responsePointer(op string, status int) (string, error) {
+	if _, pointer, ok := d.deref(op + "/responses/" + strconv.Itoa(status)); ok {
+		return pointer, nil
+	}
+	if status >= 400 {
+		if _, pointer, ok := d.deref(op + "/responses/default"); ok {
+			return pointer, nil
+		}
+	}
+	return "", fmt.Errorf("status %d is not documented", status)
+}
+
+// specCheckingTransport validates each request and response it carries
+// against the OpenAPI document, failing the test on any mismatch.
+type specCheckingTransport struct {
+	t    *testing.T
+	doc  *openAPIDoc
+	next http.RoundTripper
+}
+
+func newContractClient(t *testing.T) *http.Client {
+	return &http.Client{Transport: &specCheckingTransport{
+		t:    t,
+		doc:  loadOpenAPI(t),
+		next: &http.Transport{DisableKeepAlives: true},
+	}}
+}
+
+func (s *specCheckingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	label := req.Method + " " + req.URL.Path
+	op, ok := s.doc.operation(req.Method, req.URL.Path)
+	if !ok {
+		s.t.Errorf("openapi: %s is not documented", label)
+		return s.next.RoundTrip(req)
+	}
+
+	var reqBody []byte
+	if req.Body != nil {
+		reqBody, _ = io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(reqBody))
+	}
+	if err := s.doc.checkRequest(op, req, reqBody); err != nil {
+		s.t.Errorf("openapi: request %s: %v", label, err)
+	}
+
+	resp, err := s.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	pointer, err := s.doc.responsePointer(op, resp.StatusCode)
+	if err != nil {
+		s.t.Errorf("openapi: response %s: %v", label, err)
+		return resp, nil
+	}
+	mediaType := strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0])
+	content, _ := s.doc.lookup(pointer + "/content")
+	if mediaType != "" && content != nil {
+		if !documentsMediaType(content, mediaType) {
+			s.t.Errorf("openapi: response %s: content type %q is not documented", label, mediaType)
+		}
+	}
+	if mediaType != "application/json" {
+		// Streams such as SSE are left for the caller to read.
+		return resp, nil
+	}
+	blob, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(blob))
+	if err != nil {
+		return resp, err
+	}
+	if err := s.doc.validate(pointer+"/content/application~1json/schema", blob); err != nil {
+		s.t.Errorf("openapi: response %s %d: %v\nbody: %s", label, resp.StatusCode, err, blob)
+	}
+	return resp, nil
+}
+
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	doc := loadOpenAPI(t)
+	paths, _ := doc.root["paths"].(map[string]any)
+	s := &Server{}
+	documented := map[string]bool{}
+	for _, rt := range s.routes() {
+		documented[rt.pattern] = true
+		if _, ok := paths[rt.pattern]; !ok {
+			t.Errorf("route %s is missing from openapi.json", rt.pattern)
+		}
+	}
+	var extra []string
+	for path := range paths {
+		if !documented[path] {
+			extra = append(extra, path)
+		}
+	}
+	sort.Strings(extra)
+	if len(extra) > 0 {
+		t.Errorf("openapi.json documents unregistered paths: %v", extra)
+	}
+
+	// Every schema must compile so broken refs fail here rather than only
+	// when an endpoint happens to be exercised.
+	for path, item := range paths {
+		for method, raw := range item.(map[string]any) {
+			op := "/paths/" + escapePointer(path) + "/" + method
+			pointers := []string{op + "/requestBody/content/application~1json/schema"}
+			for status := range raw.(map[string]any)["responses"].(map[string]any) {
+				if _, pointer, ok := doc.deref(op + "/responses/" + status); ok {
+					pointers = append(pointers, pointer+"/content/application~1json/schema")
+				}
+			}
+			for _, pointer := range pointers {
+				if _, ok := doc.lookup(pointer); !ok {
+					continue
+				}
+				if err := doc.validate(pointer, []byte("null")); err != nil && strings.HasPrefix(err.Error(), "compile") {
+					t.Errorf("%s: %v", pointer, err)
+				}
+			}
+		}
+	}
+}
+
+func TestOpenAPIServed(t *testing.T) {
+	ts := httptest.NewServer(newServerForTest())
+	defer ts.Close()
+	c := newContractClient(t)
+
+	body := mustStatus(t, doJSON(t, c, http.MethodGet, ts.URL+"/v1/openapi.json", nil, nil), http.StatusOK)
+	var served map[string]any
+	if err := json.Unmarshal(body, &served); err != nil {
+		t.Fatalf("decode served spec: %v", err)
+	}
+	if !strings.HasPrefix(fmt.Sprint(served["openapi"]), "3.") {
+		t.Fatalf("expected an OpenAPI 3 document, got openapi=%v", served["openapi"])
+	}
+}
+
+func TestSpecCheckingTransportRejectsDrift(t *testing.T) {
+	doc := loadOpenAPI(t)
+	op, ok := doc.operation(http.MethodPost, "/v1/messages")
+	if !ok {
+		t.Fatalf("expected POST /v1/messages to be documented")
+	}
+	pointer, err := doc.responsePointer(op, http.StatusOK)
+	if err != nil {
+		t.Fatalf("response pointer: %v", err)
+	}
+	schema := pointer + "/content/application~1json/schema"
+	if err := doc.validate(schema, []byte(`{"ok":true,"message_id":"m-000001","duplicate":false,"state":"waiting"}`)); err != nil {
+		t.Fatalf("expected documented shape to validate: %v", err)
+	}
+	if err := doc.validate(schema, []byte(`{"ok":true,"message_id":"m-000001","duplicate":false,"state":"waiting","surprise":1}`)); err == nil {
+		t.Fatalf("expected undocumented response field to fail validation")
+	}
+	if _, err := doc.responsePointer(op, http.StatusTeapot); err != nil {
+		t.Fatalf("expected error statuses to fall back to default: %v", err)
+	}
+	if _, ok := doc.operation(http.MethodDelete, "/v1/messages"); ok {
+		t.Fatalf("expected undocumented method to be unmatched")
+	}
+}
@@ -2,61 +2,94 @@ package httpapi
 
 import (
 	"bufio"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
+	"net/url"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
+	"github.com/joelkehle/techtransfer-agency/internal/agentauth"
+	"github.com/joelkehle/techtransfer-agency/internal/blobstore"
 	"github.com/joelkehle/techtransfer-agency/internal/bus"
+	"github.com/joelkehle/techtransfer-agency/internal/telemetry"
 )
 
 type Server struct {
 	store bus.API
-
-	mu            sync.RWMutex
-	agentSecrets  map[string]string
-	agentAllowset map[string]struct{}
+	creds *agentauth.Registry
+	blobs *blobstore.Store
 }
 
 func NewServer(store bus.API) http.Handler {
-	allowset := map[string]struct{}{}
-	for _, raw := range strings.Split(os.Getenv("AGENT_ALLOWLIST"), ",") {
-		v := strings.TrimSpace(raw)
-		if v != "" {
-			allowset[v] = struct{}{}
-		}
-	}
+	return NewServerWithCredentials(store, agentauth.NewRegistryFromEnv())
+}
+
+// NewServerWithCredentials builds the HTTP API around a credential registry
+// that other transports (such as gRPC) can share.
+func NewServerWithCredentials(store bus.API, creds *agentauth.Registry) http.Handler {
+	return NewServerWithBlobs(store, creds, nil)
+}
 
+// NewServerWithBlobs is NewServerWithCredentials with /v1/blobs backed by
+// blobs. Without a blob store those endpoints answer 503.
+func NewServerWithBlobs(store bus.API, creds *agentauth.Registry, blobs *blobstore.Store) http.Handler {
 	s := &Server{
-		store:         store,
-		agentSecrets:  map[string]string{},
-		agentAllowset: allowset,
+		store: store,
+		creds: creds,
+		blobs: blobs,
 	}
 	mux := http.NewServeMux()
-	mux.HandleFunc("/v1/agents/register", s.handleRegisterAgent)
-	mux.HandleFunc("/v1/agents", s.handleListAgents)
-	mux.HandleFunc("/v1/conversations", s.handleConversations)
-	mux.HandleFunc("/v1/conversations/", s.handleConversationMessages)
-	mux.HandleFunc("/v1/messages", s.handleMessages)
-	mux.HandleFunc("/v1/inbox", s.handleInbox)
-	mux.HandleFunc("/v1/acks", s.handleAcks)
-	mux.HandleFunc("/v1/events", s.handleEvents)
-	mux.HandleFunc("/v1/observe", s.handleObserve)
-	mux.HandleFunc("/v1/inject", s.handleInject)
-	mux.HandleFunc("/v1/health", s.handleHealth)
-	mux.HandleFunc("/v1/system/status", s.handleSystemStatus)
+	for _, rt := range s.routes() {
+		mux.HandleFunc(rt.pattern, traced(rt.pattern, rt.handler))
+	}
 	return mux
 }
 
+// route binds a ServeMux pattern to its handler. The pattern is also the
+// templated path used for span names and in the OpenAPI document.
+type route struct {
+	pattern string
+	handler http.HandlerFunc
+}
+
+func (s *Server) routes() []route {
+	return []route{
+		{"/v1/agents/register", s.handleRegisterAgent},
+		{"/v1/agents", s.handleListAgents},
+		{"/v1/agents/{agent_id}", s.handleAgent},
+		{"/v1/agents/{agent_id}/heartbeat", s.handleHeartbeat},
+		{"/v1/conversations", s.handleConversations},
+		{"/v1/conversations/{conversation_id}", s.handleConversation},
+		{"/v1/conversations/{conversation_id}/messages", s.handleConversationMessages},
+		{"/v1/conversations/{conversation_id}/export", s.handleConversationExport},
+		{"/v1/conversations/{conversation_id}/close", s.handleConversationStatus(bus.ConversationStatusClosed)},
+		{"/v1/conversations/{conversation_id}/archive", s.handleConversationStatus(bus.ConversationStatusArchived)},
+		{"/v1/conversations/{conversation_id}/reopen", s.handleConversationStatus(bus.ConversationStatusActive)},
+		{"/v1/messages", s.handleMessages},
+		{"/v1/messages:batch", s.handleMessagesBatch},
+		{"/v1/messages/scheduled", s.handleListScheduled},
+		{"/v1/messages/{message_id}", s.handleGetMessage},
+		{"/v1/messages/{message_id}/cancel", s.handleCancelScheduled},
+		{"/v1/call", s.handleCall},
+		{"/v1/inbox", s.handleInbox},
+		{"/v1/acks", s.handleAcks},
+		{"/v1/events", s.handleEvents},
+		{"/v1/search", s.handleSearch},
+		{"/v1/blobs", s.handleUploadBlob},
+		{"/v1/blobs/{sha256}", s.handleGetBlob},
+		{"/v1/observe", s.handleObserve},
+		{"/v1/ws", s.handleWebSocket},
+		{"/v1/inject", s.handleInject},
+		{"/v1/health", s.handleHealth},
+		{"/v1/system/status", s.handleSystemStatus},
+		{"/v1/openapi.json", handleOpenAPI},
+	}
+}
+
 func writeJSON(w http.ResponseWriter, status int, payload any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -64,31 +97,45 @@ func writeJSON(w http.ResponseWriter, status int, payload any) {
 }
 
 func writeBusError(w http.ResponseWriter, err error) {
+	var be *bus.Error
+	if errors.As(err, &be) && be.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(be.RetryAfter))
+	}
+	status, payload := busErrorResponse(err)
+	writeJSON(w, status, payload)
+}
+
+// busErrorResponse returns the status and error envelope for err.
+func busErrorResponse(err error) (int, map[string]any) {
+	status := 500
 	var be *bus.Error
 	if errors.As(err, &be) {
-		payload := map[string]any{
-			"ok": false,
-			"error": map[string]any{
-				"code":      be.Code,
-				"message":   be.Message,
-				"transient": be.Transient,
-			},
-		}
-		if be.RetryAfter > 0 {
-			payload["error"].(map[string]any)["retry_after"] = be.RetryAfter
-			w.Header().Set("Retry-After", strconv.Itoa(be.RetryAfter))
-		}
-		writeJSON(w, be.Status, payload)
-		return
+		status = be.Status
 	}
-	writeJSON(w, 500, map[string]any{
-		"ok": false,
-		"error": map[string]any{
+	return status, map[string]any{"ok": false, "error": busErrorPayload(err)}
+}
+
+func busErrorPayload(err error) map[string]any {
+	var be *bus.Error
+	if !errors.As(err, &be) {
+		return map[string]any{
 			"code":      bus.CodeInternal,
 			"message":   err.Error(),
 			"transient": true,
-		},
-	})
+		}
+	}
+	payload := map[string]any{
+		"code":      be.Code,
+		"message":   be.Message,
+		"transient": be.Transient,
+	}
+	if be.RetryAfter > 0 {
+		payload["retry_after"] = be.RetryAfter
+	}
+	if be.Field != "" {
+		payload["field"] = be.Field
+	}
+	return payload
 }
 
 func readBody(r *http.Request) ([]byte, error) {
@@ -120,6 +167,33 @@ func parseInt(value string, def int) int {
 	return v
 }
 
+// parseTimeParams reads the named RFC 3339 query parameters into their
+// targets, leaving absent ones zero. Unlike the lenient integer parsing, a
+// malformed time is a validation error: dropping a range bound would widen
+// the listing without the caller noticing.
+func parseTimeParams(query url.Values, targets map[string]*time.Time) error {
+	for name, dst := range targets {
+		value := strings.TrimSpace(query.Get(name))
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return &bus.Error{Code: bus.CodeValidation, Message: name + " must be an RFC 3339 timestamp", Status: 400}
+		}
+		*dst = t
+	}
+	return nil
+}
+
+// listResponse adds next_cursor to a listing payload when there is a next page.
+func listResponse(payload map[string]any, next string) map[string]any {
+	if next != "" {
+		payload["next_cursor"] = next
+	}
+	return payload
+}
+
 func parseWaitSeconds(value string) time.Duration {
 	if strings.TrimSpace(value) == "" {
 		return 0
@@ -143,52 +217,15 @@ func methodOnly(w http.ResponseWriter, r *http.Request, method string) bool {
 }
 
 func (s *Server) verifySignature(agentID, signature string, payload []byte) error {
-	agentID = strings.TrimSpace(agentID)
-	if agentID == "" {
-		return &bus.Error{Code: bus.CodeUnauthorized, Message: "agent id required", Status: 401}
-	}
-	s.mu.RLock()
-	secret, ok := s.agentSecrets[agentID]
-	s.mu.RUnlock()
-	if !ok || strings.TrimSpace(secret) == "" {
-		return &bus.Error{Code: bus.CodeUnauthorized, Message: "agent secret not registered", Status: 401}
-	}
-	if strings.TrimSpace(signature) == "" {
-		return &bus.Error{Code: bus.CodeUnauthorized, Message: "X-Bus-Signature required", Status: 401}
-	}
-
-	sig := strings.TrimSpace(signature)
-	if strings.HasPrefix(strings.ToLower(sig), "sha256=") {
-		sig = sig[len("sha256="):]
-	}
-	provided, decErr := hex.DecodeString(strings.ToLower(sig))
-	if decErr != nil {
-		return &bus.Error{Code: bus.CodeUnauthorized, Message: "invalid signature encoding", Status: 401}
-	}
-
-	mac := hmac.New(sha256.New, []byte(secret))
-	_, _ = mac.Write(payload)
-	expected := mac.Sum(nil)
-	if !hmac.Equal(expected, provided) {
-		return &bus.Error{Code: bus.CodeUnauthorized, Message: "invalid signature", Status: 401}
-	}
-	return nil
+	return s.creds.Verify(agentID, signature, payload)
 }
 
 func (s *Server) setAgentSecret(agentID, secret string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.agentSecrets[agentID] = secret
+	s.creds.SetSecret(agentID, secret)
 }
 
 func (s *Server) isAgentAllowed(agentID string) bool {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	if len(s.agentAllowset) == 0 {
-		return true
-	}
-	_, ok := s.agentAllowset[agentID]
-	return ok
+	return s.creds.IsAllowed(agentID)
 }
 
 func (s *Server) handleRegisterAgent(w http.ResponseWriter, r *http.Request) {
@@ -201,13 +238,15 @@ func (s *Server) handleRegisterAgent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var req struct {
-		AgentID      string   `json:"agent_id"`
-		Capabilities []string `json:"capabilities"`
-		Description  string   `json:"description"`
-		Mode         string   `json:"mode"`
-		CallbackURL  string   `json:"callback_url"`
-		TTL          int      `json:"ttl"`
-		Secret       string   `json:"secret"`
+		AgentID      string                          `json:"agent_id"`
+		Capabilities []string                        `json:"capabilities"`
+		Schemas      map[string]bus.CapabilitySchema `json:"schemas"`
+		Metadata     bus.AgentMetadata               `json:"metadata"`
+		Description  string                          `json:"description"`
+		Mode         string                          `json:"mode"`
+		CallbackURL  string                          `json:"callback_url"`
+		TTL          int                             `json:"ttl"`
+		Secret       string                          `json:"secret"`
 	}
 	if err := decodeJSONBytes(blob, &req); err != nil {
 		writeBusError(w, bus.NewValidationJSONError(err))
@@ -223,14 +262,18 @@ func (s *Server) handleRegisterAgent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	_, span := startBusSpan(r.Context(), "RegisterAgent")
 	agent, err := s.store.RegisterAgent(bus.RegisterAgentInput{
 		AgentID:      req.AgentID,
 		Capabilities: req.Capabilities,
+		Schemas:      req.Schemas,
+		Metadata:     req.Metadata,
 		Description:  req.Description,
 		Mode:         bus.AgentMode(req.Mode),
 		CallbackURL:  req.CallbackURL,
 		TTLSeconds:   req.TTL,
 	})
+	endBusSpan(span, err)
 	if err != nil {
 		writeBusError(w, err)
 		return
@@ -248,8 +291,128 @@ func (s *Server) handleListAgents(w http.ResponseWriter, r *http.Request) {
 	if !methodOnly(w, r, http.MethodGet) {
 		return
 	}
-	agents := s.store.ListAgents(strings.TrimSpace(r.URL.Query().Get("capability")))
-	writeJSON(w, 200, map[string]any{"agents": agents})
+	query := r.URL.Query()
+	filter := bus.ListAgentsFilter{
+		Capability:     strings.TrimSpace(query.Get("capability")),
+		Labels:         query["label"],
+		IncludeExpired: query.Get("include_expired") == "true",
+		Sort:           query.Get("sort"),
+		Order:          query.Get("order"),
+		Cursor:         query.Get("cursor"),
+		Limit:          parseInt(query.Get("limit"), 0),
+	}
+	if err := parseTimeParams(query, map[string]*time.Time{
+		"registered_after":  &filter.RegisteredAfter,
+		"registered_before": &filter.RegisteredBefore,
+	}); err != nil {
+		writeBusError(w, err)
+		return
+	}
+	_, span := startBusSpan(r.Context(), "ListAgents")
+	agents, next, err := s.store.ListAgents(filter)
+	endBusSpan(span, err)
+	if err != nil {
+		writeBusError(w, err)
+		return
+	}
+	writeJSON(w, 200, listResponse(map[string]any{"agents": agents}, next))
+}
+
+func (s *Server) handleAgent(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.handleGetAgent(w, r)
+	case http.MethodDelete:
+		s.handleDeregisterAgent(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleGetAgent(w http.ResponseWriter, r *http.Request) {
+	_, span := startBusSpan(r.Context(), "GetAgent")
+	agent, err := s.store.GetAgent(r.PathValue("agent_id"))
+	endBusSpan(span, err)
+	if err != nil {
+		writeBusError(w, err)
+		return
+	}
+	writeJSON(w, 200, map[string]any{"agent": agent})
+}
+
+// handleDeregisterAgent takes an agent off the bus. The signature covers the
+// raw query string, which may carry a reason.
+func (s *Server) handleDeregisterAgent(w http.ResponseWriter, r *http.Request) {
+	agentID := strings.TrimSpace(r.PathValue("agent_id"))
+	if err := s.verifySignature(agentID, r.Header.Get("X-Bus-Signature"), []byte(r.URL.RawQuery)); err != nil {
+		writeBusError(w, err)
+		return
+	}
+
+	_, span := startBusSpan(r.Context(), "DeregisterAgent")
+	out, err := s.store.DeregisterAgent(bus.DeregisterAgentInput{
+		AgentID: agentID,
+		Reason:  r.URL.Query().Get("reason"),
+	})
+	endBusSpan(span, err)
+	if err != nil {
+		writeBusError(w, err)
+		return
+	}
+	writeJSON(w, 200, map[string]any{
+		"ok":       true,
+		"agent_id": out.Agent.AgentID,
+		"rerouted": out.Rerouted,
+		"failed":   out.Failed,
+	})
+}
+
+// handleHeartbeat renews an agent's registration and records the load and
+// health checks it reports.
+func (s *Server) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	if !methodOnly(w, r, http.MethodPost) {
+		return
+	}
+	blob, err := readBody(r)
+	if err != nil {
+		writeBusError(w, bus.NewValidationJSONError(err))
+		return
+	}
+	var req struct {
+		Status   string            `json:"status"`
+		InFlight int               `json:"in_flight"`
+		Load     float64           `json:"load"`
+		Checks   []bus.HealthCheck `json:"checks"`
+	}
+	if err := decodeJSONBytes(blob, &req); err != nil {
+		writeBusError(w, bus.NewValidationJSONError(err))
+		return
+	}
+	agentID := strings.TrimSpace(r.PathValue("agent_id"))
+	if err := s.verifySignature(agentID, r.Header.Get("X-Bus-Signature"), blob); err != nil {
+		writeBusError(w, err)
+		return
+	}
+
+	_, span := startBusSpan(r.Context(), "Heartbeat")
+	agent, err := s.store.Heartbeat(bus.HeartbeatInput{
+		AgentID:  agentID,
+		Status:   bus.HealthStatus(req.Status),
+		InFlight: req.InFlight,
+		Load:     req.Load,
+		Checks:   req.Checks,
+	})
+	endBusSpan(span, err)
+	if err != nil {
+		writeBusError(w, err)
+		return
+	}
+	writeJSON(w, 200, map[string]any{
+		"ok":         true,
+		"agent_id":   agent.AgentID,
+		"expires_at": agent.ExpiresAt,
+		"health":     agent.Health,
+	})
 }
 
 func (s *Server) handleConversations(w http.ResponseWriter, r *http.Request) {
@@ -265,45 +428,164 @@ func (s *Server) handleConversations(w http.ResponseWriter, r *http.Request) {
 			Title          string   `json:"title"`
 			Participants   []string `json:"participants"`
 			Meta           any      `json:"meta"`
+			Tags           []string `json:"tags"`
 		}
 		if err := decodeJSONBytes(blob, &req); err != nil {
 			writeBusError(w, bus.NewValidationJSONError(err))
 			return
 		}
+		_, span := startBusSpan(r.Context(), "CreateConversation")
 		c, err := s.store.CreateConversation(bus.CreateConversationInput{
 			ConversationID: req.ConversationID,
 			Title:          req.Title,
 			Participants:   req.Participants,
 			Meta:           req.Meta,
+			Tags:           req.Tags,
 		})
+		endBusSpan(span, err)
 		if err != nil {
 			writeBusError(w, err)
 			return
 		}
 		writeJSON(w, 200, map[string]any{"ok": true, "conversation_id": c.ConversationID})
 	case http.MethodGet:
+		query := r.URL.Query()
 		filter := bus.ListConversationsFilter{
-			Participant: strings.TrimSpace(r.URL.Query().Get("participant")),
-			Status:      strings.TrimSpace(r.URL.Query().Get("status")),
+			Participant: strings.TrimSpace(query.Get("participant")),
+			Status:      strings.TrimSpace(query.Get("status")),
+			Tags:        query["tag"],
+			MetaKey:     strings.TrimSpace(query.Get("meta_key")),
+			MetaValue:   query.Get("meta_value"),
+			Sort:        query.Get("sort"),
+			Order:       query.Get("order"),
+			Cursor:      query.Get("cursor"),
+			Limit:       parseInt(query.Get("limit"), 0),
 		}
-		conversations := s.store.ListConversations(filter)
-		writeJSON(w, 200, map[string]any{"conversations": conversations})
+		if filter.MetaValue != "" && filter.MetaKey == "" {
+			writeBusError(w, &bus.Error{Code: bus.CodeValidation, Message: "meta_value requires meta_key", Status: 400})
+			return
+		}
+		if err := parseTimeParams(query, map[string]*time.Time{
+			"created_after":       &filter.CreatedAfter,
+			"created_before":      &filter.CreatedBefore,
+			"last_message_after":  &filter.LastMessageAfter,
+			"last_message_before": &filter.LastMessageBefore,
+		}); err != nil {
+			writeBusError(w, err)
+			return
+		}
+		_, span := startBusSpan(r.Context(), "ListConversations")
+		conversations, next, err := s.store.ListConversations(filter)
+		endBusSpan(span, err)
+		if err != nil {
+			writeBusError(w, err)
+			return
+		}
+		writeJSON(w, 200, listResponse(map[string]any{"conversations": conversations}, next))
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
 
-func (s *Server) handleConversationMessages(w http.ResponseWriter, r *http.Request) {
-	if !methodOnly(w, r, http.MethodGet) {
+// handleConversationStatus returns the handler for one lifecycle action,
+// moving the conversation to status.
+func (s *Server) handleConversationStatus(status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !methodOnly(w, r, http.MethodPost) {
+			return
+		}
+		blob, err := readBody(r)
+		if err != nil {
+			writeBusError(w, bus.NewValidationJSONError(err))
+			return
+		}
+		var req struct {
+			Actor  string `json:"actor"`
+			Reason string `json:"reason"`
+		}
+		if err := decodeJSONBytes(blob, &req); err != nil {
+			writeBusError(w, bus.NewValidationJSONError(err))
+			return
+		}
+		_, span := startBusSpan(r.Context(), "SetConversationStatus")
+		conversation, err := s.store.SetConversationStatus(bus.ConversationStatusInput{
+			ConversationID: r.PathValue("conversation_id"),
+			Status:         status,
+			Actor:          req.Actor,
+			Reason:         req.Reason,
+		})
+		endBusSpan(span, err)
+		if err != nil {
+			writeBusError(w, err)
+			return
+		}
+		writeJSON(w, 200, map[string]any{"ok": true, "conversation": conversation})
+	}
+}
+
+func (s *Server) handleConversation(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.handleGetConversation(w, r)
+	case http.MethodPatch:
+		s.handleUpdateConversation(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleGetConversation(w http.ResponseWriter, r *http.Request) {
+	_, span := startBusSpan(r.Context(), "GetConversation")
+	conversation, err := s.store.GetConversation(r.PathValue("conversation_id"))
+	endBusSpan(span, err)
+	if err != nil {
+		writeBusError(w, err)
 		return
 	}
-	path := strings.TrimPrefix(r.URL.Path, "/v1/conversations/")
-	if !strings.HasSuffix(path, "/messages") {
-		w.WriteHeader(http.StatusNotFound)
+	writeJSON(w, 200, map[string]any{"conversation": conversation})
+}
+
+func (s *Server) handleUpdateConversation(w http.ResponseWriter, r *http.Request) {
+	blob, err := readBody(r)
+	if err != nil {
+		writeBusError(w, bus.NewValidationJSONError(err))
 		return
 	}
-	conversationID := strings.TrimSuffix(path, "/messages")
-	conversationID = strings.TrimSuffix(conversationID, "/")
+	var req struct {
+		Title      *string        `json:"title"`
+		Meta       map[string]any `json:"meta"`
+		Tags       []string       `json:"tags"`
+		AddTags    []string       `json:"add_tags"`
+		RemoveTags []string       `json:"remove_tags"`
+		Actor      string         `json:"actor"`
+	}
+	if err := decodeJSONBytes(blob, &req); err != nil {
+		writeBusError(w, bus.NewValidationJSONError(err))
+		return
+	}
+	_, span := startBusSpan(r.Context(), "UpdateConversation")
+	conversation, err := s.store.UpdateConversation(bus.UpdateConversationInput{
+		ConversationID: r.PathValue("conversation_id"),
+		Title:          req.Title,
+		Meta:           req.Meta,
+		Tags:           req.Tags,
+		AddTags:        req.AddTags,
+		RemoveTags:     req.RemoveTags,
+		Actor:          req.Actor,
+	})
+	endBusSpan(span, err)
+	if err != nil {
+		writeBusError(w, err)
+		return
+	}
+	writeJSON(w, 200, map[string]any{"ok": true, "conversation": conversation})
+}
+
+func (s *Server) handleConversationMessages(w http.ResponseWriter, r *http.Request) {
+	if !methodOnly(w, r, http.MethodGet) {
+		return
+	}
+	conversationID := strings.TrimSpace(r.PathValue("conversation_id"))
 	if conversationID == "" {
 		w.WriteHeader(http.StatusNotFound)
 		return
@@ -311,11 +593,13 @@ func (s *Server) handleConversationMessages(w http.ResponseWriter, r *http.Reque
 
 	cursor := parseInt(r.URL.Query().Get("cursor"), 0)
 	limit := parseInt(r.URL.Query().Get("limit"), 50)
+	_, span := startBusSpan(r.Context(), "ListConversationMessages")
 	cid, messages, next, err := s.store.ListConversationMessages(bus.ListConversationMessagesInput{
 		ConversationID: conversationID,
 		Cursor:         cursor,
 		Limit:          limit,
 	})
+	endBusSpan(span, err)
 	if err != nil {
 		writeBusError(w, err)
 		return
@@ -327,7 +611,126 @@ func (s *Server) handleConversationMessages(w http.ResponseWriter, r *http.Reque
 	})
 }
 
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if !methodOnly(w, r, http.MethodGet) {
+		return
+	}
+	query := r.URL.Query()
+	input := bus.SearchInput{
+		Query:          query.Get("q"),
+		Kind:           query.Get("kind"),
+		AgentID:        query.Get("agent"),
+		ConversationID: query.Get("conversation_id"),
+		Type:           bus.MessageType(strings.TrimSpace(query.Get("type"))),
+		Limit:          parseInt(query.Get("limit"), 0),
+	}
+	if err := parseTimeParams(query, map[string]*time.Time{
+		"after":  &input.After,
+		"before": &input.Before,
+	}); err != nil {
+		writeBusError(w, err)
+		return
+	}
+	_, span := startBusSpan(r.Context(), "Search")
+	results, err := s.store.Search(input)
+	endBusSpan(span, err)
+	if err != nil {
+		writeBusError(w, err)
+		return
+	}
+	writeJSON(w, 200, map[string]any{"results": results})
+}
+
 func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
+	if !methodOnly(w, r, http.MethodPost) {
+		return
+	}
+	blob, err := readBody(r)
+	if err != nil {
+		writeBusError(w, bus.NewValidationJSONError(err))
+		return
+	}
+	var req struct {
+		To              string           `json:"to"`
+		Recipients      []string         `json:"recipients"`
+		From            string           `json:"from"`
+		ConversationID  string           `json:"conversation_id"`
+		RequestID       string           `json:"request_id"`
+		Type            string           `json:"type"`
+		Body            string           `json:"body"`
+		Meta            any              `json:"meta"`
+		Attachments     []bus.Attachment `json:"attachments"`
+		TTL             int              `json:"ttl"`
+		InReplyTo       string           `json:"in_reply_to"`
+		CompleteRequest bool             `json:"complete_request"`
+		Capability      string           `json:"capability"`
+		Priority        string           `json:"priority"`
+		DeliverAt       time.Time        `json:"deliver_at"`
+		DelaySeconds    int              `json:"delay_seconds"`
+		Wait            int              `json:"wait"`
+	}
+	if err := decodeJSONBytes(blob, &req); err != nil {
+		writeBusError(w, bus.NewValidationJSONError(err))
+		return
+	}
+	if req.Wait < 0 {
+		writeBusError(w, &bus.Error{Code: bus.CodeValidation, Message: "wait must be >= 0", Status: 400})
+		return
+	}
+	if err := s.verifySignature(req.From, r.Header.Get("X-Bus-Signature"), blob); err != nil {
+		writeBusError(w, err)
+		return
+	}
+
+	ctx, span := startBusSpan(r.Context(), "SendMessage", s.messageLinks(strings.TrimSpace(req.InReplyTo))...)
+	message, duplicate, err := s.store.SendMessage(bus.SendMessageInput{
+		To:              req.To,
+		Recipients:      req.Recipients,
+		From:            req.From,
+		ConversationID:  req.ConversationID,
+		RequestID:       req.RequestID,
+		Type:            bus.MessageType(req.Type),
+		Body:            req.Body,
+		Meta:            req.Meta,
+		Attachments:     req.Attachments,
+		TTLSeconds:      req.TTL,
+		InReplyTo:       req.InReplyTo,
+		CompleteRequest: req.CompleteRequest,
+		Capability:      req.Capability,
+		Priority:        bus.MessagePriority(req.Priority),
+		DeliverAt:       req.DeliverAt,
+		DelaySeconds:    req.DelaySeconds,
+		TraceParent:     telemetry.TraceParent(ctx),
+		Wait:            time.Duration(req.Wait) * time.Second,
+	})
+	endBusSpan(span, err)
+	if err != nil {
+		writeBusError(w, err)
+		return
+	}
+
+	resp := map[string]any{
+		"ok":         true,
+		"message_id": message.MessageID,
+		"to":         message.To,
+		"duplicate":  duplicate,
+		"state":      message.State,
+	}
+	if len(message.Recipients) > 0 {
+		resp["recipients"] = message.Recipients
+	}
+	if message.DeliverAt != nil {
+		resp["deliver_at"] = message.DeliverAt
+	}
+	if message.Result != nil {
+		resp["result"] = message.Result
+	}
+	writeJSON(w, 200, resp)
+}
+
+// handleCall sends a request and holds the response until the request
+// finishes, a reply to it arrives, or the timeout elapses.
+func (s *Server) handleCall(w http.ResponseWriter, r *http.Request) {
 	if !methodOnly(w, r, http.MethodPost) {
 		return
 	}
@@ -341,44 +744,233 @@ func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
 		From           string           `json:"from"`
 		ConversationID string           `json:"conversation_id"`
 		RequestID      string           `json:"request_id"`
-		Type           string           `json:"type"`
 		Body           string           `json:"body"`
 		Meta           any              `json:"meta"`
 		Attachments    []bus.Attachment `json:"attachments"`
 		TTL            int              `json:"ttl"`
-		InReplyTo      string           `json:"in_reply_to"`
+		Capability     string           `json:"capability"`
+		Priority       string           `json:"priority"`
+		Timeout        int              `json:"timeout"`
 	}
 	if err := decodeJSONBytes(blob, &req); err != nil {
 		writeBusError(w, bus.NewValidationJSONError(err))
 		return
 	}
+	if req.Timeout < 0 {
+		writeBusError(w, &bus.Error{Code: bus.CodeValidation, Message: "timeout must be >= 0", Status: 400})
+		return
+	}
 	if err := s.verifySignature(req.From, r.Header.Get("X-Bus-Signature"), blob); err != nil {
 		writeBusError(w, err)
 		return
 	}
 
-	message, duplicate, err := s.store.SendMessage(bus.SendMessageInput{
+	ctx, span := startBusSpan(r.Context(), "Call")
+	out, err := s.store.Call(bus.SendMessageInput{
 		To:             req.To,
 		From:           req.From,
 		ConversationID: req.ConversationID,
 		RequestID:      req.RequestID,
-		Type:           bus.MessageType(req.Type),
+		Type:           bus.MessageTypeRequest,
 		Body:           req.Body,
 		Meta:           req.Meta,
 		Attachments:    req.Attachments,
 		TTLSeconds:     req.TTL,
-		InReplyTo:      req.InReplyTo,
+		Capability:     req.Capability,
+		Priority:       bus.MessagePriority(req.Priority),
+		TraceParent:    telemetry.TraceParent(ctx),
+		Wait:           time.Duration(req.Timeout) * time.Second,
 	})
+	endBusSpan(span, err)
 	if err != nil {
 		writeBusError(w, err)
 		return
 	}
 
-	writeJSON(w, 200, map[string]any{
+	resp := map[string]any{
 		"ok":         true,
-		"message_id": message.MessageID,
-		"duplicate":  duplicate,
+		"message_id": out.Request.MessageID,
+		"to":         out.Request.To,
+		"duplicate":  out.Duplicate,
+		"state":      out.Request.State,
+		"timed_out":  out.TimedOut,
+	}
+	if out.Request.Result != nil {
+		resp["result"] = out.Request.Result
+	}
+	if out.Reply != nil {
+		resp["reply"] = out.Reply
+	}
+	writeJSON(w, 200, resp)
+}
+
+func (s *Server) handleGetMessage(w http.ResponseWriter, r *http.Request) {
+	if !methodOnly(w, r, http.MethodGet) {
+		return
+	}
+	_, span := startBusSpan(r.Context(), "GetMessage")
+	message, err := s.store.GetMessage(r.PathValue("message_id"))
+	endBusSpan(span, err)
+	if err != nil {
+		writeBusError(w, err)
+		return
+	}
+	writeJSON(w, 200, map[string]any{"message": message})
+}
+
+// handleListScheduled lists the sender's messages still waiting for their
+// delivery time. The signature covers the raw query string.
+func (s *Server) handleListScheduled(w http.ResponseWriter, r *http.Request) {
+	if !methodOnly(w, r, http.MethodGet) {
+		return
+	}
+	agentID := strings.TrimSpace(r.URL.Query().Get("agent_id"))
+	if err := s.verifySignature(agentID, r.Header.Get("X-Bus-Signature"), []byte(r.URL.RawQuery)); err != nil {
+		writeBusError(w, err)
+		return
+	}
+
+	_, span := startBusSpan(r.Context(), "ListScheduled")
+	messages, err := s.store.ListScheduled(agentID)
+	endBusSpan(span, err)
+	if err != nil {
+		writeBusError(w, err)
+		return
+	}
+	writeJSON(w, 200, map[string]any{"messages": messages})
+}
+
+// handleCancelScheduled cancels a scheduled message on behalf of its sender.
+// The signed body names the message, so a captured signature cannot cancel
+// any other.
+func (s *Server) handleCancelScheduled(w http.ResponseWriter, r *http.Request) {
+	if !methodOnly(w, r, http.MethodPost) {
+		return
+	}
+	blob, err := readBody(r)
+	if err != nil {
+		writeBusError(w, bus.NewValidationJSONError(err))
+		return
+	}
+	var req struct {
+		AgentID   string `json:"agent_id"`
+		MessageID string `json:"message_id"`
+	}
+	if err := decodeJSONBytes(blob, &req); err != nil {
+		writeBusError(w, bus.NewValidationJSONError(err))
+		return
+	}
+	if err := s.verifySignature(req.AgentID, r.Header.Get("X-Bus-Signature"), blob); err != nil {
+		writeBusError(w, err)
+		return
+	}
+	if req.MessageID != r.PathValue("message_id") {
+		writeBusError(w, &bus.Error{Code: bus.CodeValidation, Message: "message_id must match the path", Status: 400})
+		return
+	}
+
+	_, span := startBusSpan(r.Context(), "CancelScheduled")
+	message, err := s.store.CancelScheduled(bus.CancelScheduledInput{
+		MessageID: req.MessageID,
+		AgentID:   req.AgentID,
 	})
+	endBusSpan(span, err)
+	if err != nil {
+		writeBusError(w, err)
+		return
+	}
+	writeJSON(w, 200, map[string]any{"ok": true, "message": message})
+}
+
+func (s *Server) handleMessagesBatch(w http.ResponseWriter, r *http.Request) {
+	if !methodOnly(w, r, http.MethodPost) {
+		return
+	}
+	blob, err := readBody(r)
+	if err != nil {
+		writeBusError(w, bus.NewValidationJSONError(err))
+		return
+	}
+	var req struct {
+		From     string `json:"from"`
+		Atomic   bool   `json:"atomic"`
+		Messages []struct {
+			To              string           `json:"to"`
+			Recipients      []string         `json:"recipients"`
+			ConversationID  string           `json:"conversation_id"`
+			RequestID       string           `json:"request_id"`
+			Type            string           `json:"type"`
+			Body            string           `json:"body"`
+			Meta            any              `json:"meta"`
+			Attachments     []bus.Attachment `json:"attachments"`
+			TTL             int              `json:"ttl"`
+			InReplyTo       string           `json:"in_reply_to"`
+			CompleteRequest bool             `json:"complete_request"`
+			Capability      string           `json:"capability"`
+			Priority        string           `json:"priority"`
+			DeliverAt       time.Time        `json:"deliver_at"`
+			DelaySeconds    int              `json:"delay_seconds"`
+		} `json:"messages"`
+	}
+	if err := decodeJSONBytes(blob, &req); err != nil {
+		writeBusError(w, bus.NewValidationJSONError(err))
+		return
+	}
+	// One signature from the sender covers every message in the batch.
+	if err := s.verifySignature(req.From, r.Header.Get("X-Bus-Signature"), blob); err != nil {
+		writeBusError(w, err)
+		return
+	}
+
+	ctx, span := startBusSpan(r.Context(), "SendMessages")
+	input := bus.SendMessagesInput{Atomic: req.Atomic}
+	for _, m := range req.Messages {
+		input.Messages = append(input.Messages, bus.SendMessageInput{
+			To:              m.To,
+			Recipients:      m.Recipients,
+			From:            req.From,
+			ConversationID:  m.ConversationID,
+			RequestID:       m.RequestID,
+			Type:            bus.MessageType(m.Type),
+			Body:            m.Body,
+			Meta:            m.Meta,
+			Attachments:     m.Attachments,
+			TTLSeconds:      m.TTL,
+			InReplyTo:       m.InReplyTo,
+			CompleteRequest: m.CompleteRequest,
+			Capability:      m.Capability,
+			Priority:        bus.MessagePriority(m.Priority),
+			DeliverAt:       m.DeliverAt,
+			DelaySeconds:    m.DelaySeconds,
+			TraceParent:     telemetry.TraceParent(ctx),
+		})
+	}
+	results, err := s.store.SendMessages(input)
+	endBusSpan(span, err)
+
+	items := make([]map[string]any, 0, len(results))
+	for i, res := range results {
+		item := map[string]any{"index": i, "ok": res.Err == nil}
+		if res.Err != nil {
+			item["error"] = busErrorPayload(res.Err)
+		} else if res.Message != nil {
+			item["message_id"] = res.Message.MessageID
+			item["to"] = res.Message.To
+			item["duplicate"] = res.Duplicate
+		}
+		items = append(items, item)
+	}
+	if err != nil {
+		if len(items) == 0 {
+			writeBusError(w, err)
+			return
+		}
+		status, payload := busErrorResponse(err)
+		payload["results"] = items
+		writeJSON(w, status, payload)
+		return
+	}
+	writeJSON(w, 200, map[string]any{"ok": true, "results": items})
 }
 
 func (s *Server) handleInbox(w http.ResponseWriter, r *http.Request) {
@@ -395,11 +987,13 @@ func (s *Server) handleInbox(w http.ResponseWriter, r *http.Request) {
 	cursor := parseInt(r.URL.Query().Get("cursor"), 0)
 	wait := parseWaitSeconds(r.URL.Query().Get("wait"))
 
+	_, span := startBusSpan(r.Context(), "PollInbox")
 	events, next, err := s.store.PollInbox(bus.PollInboxInput{
 		AgentID: agentID,
 		Cursor:  cursor,
 		Wait:    wait,
 	})
+	endBusSpan(span, err)
 	if err != nil {
 		writeBusError(w, err)
 		return
@@ -433,12 +1027,15 @@ func (s *Server) handleAcks(w http.ResponseWriter, r *http.Request) {
 		writeBusError(w, err)
 		return
 	}
-	if err := s.store.Ack(bus.AckInput{
+	_, span := startBusSpan(r.Context(), "Ack", s.messageLinks(strings.TrimSpace(req.MessageID))...)
+	err = s.store.Ack(bus.AckInput{
 		AgentID:   req.AgentID,
 		MessageID: req.MessageID,
 		Status:    req.Status,
 		Reason:    req.Reason,
-	}); err != nil {
+	})
+	endBusSpan(span, err)
+	if err != nil {
 		writeBusError(w, err)
 		return
 	}
@@ -455,10 +1052,12 @@ func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var req struct {
-		MessageID string `json:"message_id"`
-		Type      string `json:"type"`
-		Body      string `json:"body"`
-		Meta      any    `json:"meta"`
+		MessageID   string           `json:"message_id"`
+		Type        string           `json:"type"`
+		Body        string           `json:"body"`
+		Meta        any              `json:"meta"`
+		Attachments []bus.Attachment `json:"attachments"`
+		ErrorCode   string           `json:"error_code"`
 	}
 	if err := decodeJSONBytes(blob, &req); err != nil {
 		writeBusError(w, bus.NewValidationJSONError(err))
@@ -469,13 +1068,18 @@ func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
 		writeBusError(w, err)
 		return
 	}
-	if err := s.store.PostEvent(bus.EventInput{
+	_, span := startBusSpan(r.Context(), "PostEvent", s.messageLinks(strings.TrimSpace(req.MessageID))...)
+	err = s.store.PostEvent(bus.EventInput{
 		ActorAgentID: actor,
 		MessageID:    req.MessageID,
 		Type:         req.Type,
 		Body:         req.Body,
 		Meta:         req.Meta,
-	}); err != nil {
+		Attachments:  req.Attachments,
+		ErrorCode:    req.ErrorCode,
+	})
+	endBusSpan(span, err)
+	if err != nil {
 		writeBusError(w, err)
 		return
 	}
@@ -528,7 +1132,9 @@ func (s *Server) handleObserve(w http.ResponseWriter, r *http.Request) {
 		default:
 		}
 
+		_, span := startBusSpan(ctx, "ObserveSince")
 		events, last := s.store.ObserveSince(cursor, filter, 1*time.Second)
+		endBusSpan(span, nil)
 		if len(events) == 0 {
 			if _, err := bw.WriteString(": keep-alive\n\n"); err != nil {
 				return
@@ -588,12 +1194,15 @@ func (s *Server) handleInject(w http.ResponseWriter, r *http.Request) {
 		writeBusError(w, bus.NewValidationJSONError(err))
 		return
 	}
+	ctx, span := startBusSpan(r.Context(), "Inject")
 	message, err := s.store.Inject(bus.InjectInput{
 		Identity:       req.Identity,
 		ConversationID: req.ConversationID,
 		To:             req.To,
 		Body:           req.Body,
+		TraceParent:    telemetry.TraceParent(ctx),
 	})
+	endBusSpan(span, err)
 	if err != nil {
 		writeBusError(w, err)
 		return
@@ -605,12 +1214,18 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	if !methodOnly(w, r, http.MethodGet) {
 		return
 	}
-	writeJSON(w, 200, s.store.Health())
+	_, span := startBusSpan(r.Context(), "Health")
+	health := s.store.Health()
+	endBusSpan(span, nil)
+	writeJSON(w, 200, health)
 }
 
 func (s *Server) handleSystemStatus(w http.ResponseWriter, r *http.Request) {
 	if !methodOnly(w, r, http.MethodGet) {
 		return
 	}
-	writeJSON(w, 200, s.store.SystemStatus())
+	_, span := startBusSpan(r.Context(), "SystemStatus")
+	status := s.store.SystemStatus()
+	endBusSpan(span, nil)
+	writeJSON(w, 200, status)
 }
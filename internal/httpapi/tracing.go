@@ -0,0 +1,99 @@
+package httpapi
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/joelkehle/techtransfer-agency/internal/telemetry"
+)
+
+// traceParentLookup is implemented by stores that remember the trace context
+// each message was sent under, so acks and events can link back to it.
+type traceParentLookup interface {
+	MessageTraceParent(messageID string) string
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	r.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// traced wraps a handler in a server span, continuing any W3C trace context
+// supplied by the caller.
+func traced(route string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := telemetry.ExtractHTTP(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := telemetry.Tracer().Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", route),
+			),
+		)
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h(rec, r.WithContext(ctx))
+		span.SetAttributes(attribute.Int("http.response.status_code", rec.status))
+		if rec.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	}
+}
+
+func startBusSpan(ctx context.Context, op string, links ...trace.Link) (context.Context, trace.Span) {
+	return telemetry.Tracer().Start(ctx, "bus."+op, trace.WithLinks(links...))
+}
+
+func endBusSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// messageLinks returns a link to the trace the given message was sent under.
+func (s *Server) messageLinks(messageID string) []trace.Link {
+	lookup, ok := s.store.(traceParentLookup)
+	if !ok || messageID == "" {
+		return nil
+	}
+	link, ok := telemetry.LinkFromTraceParent(lookup.MessageTraceParent(messageID))
+	if !ok {
+		return nil
+	}
+	return []trace.Link{link}
+}
@@ -0,0 +1,93 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTraceContextPropagatesThroughInbox(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	h := newServerForTest()
+	mustRegisterAgent(t, h, "a", "secret-a")
+	mustRegisterAgent(t, h, "b", "secret-b")
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	sendBody := map[string]any{
+		"to": "b", "from": "a", "request_id": "rid-trace", "type": "request", "body": "do",
+	}
+	blob, _ := json.Marshal(sendBody)
+	rr := postJSON(t, h, "/v1/messages", sendBody, map[string]string{
+		"X-Bus-Signature": sign("secret-a", blob),
+		"traceparent":     "00-" + traceID + "-00f067aa0ba902b7-01",
+	})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("send status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	var sendResp struct {
+		MessageID string `json:"message_id"`
+	}
+	_ = json.Unmarshal(rr.Body.Bytes(), &sendResp)
+
+	q := url.Values{}
+	q.Set("agent_id", "b")
+	q.Set("cursor", "0")
+	q.Set("wait", "0")
+	raw := q.Encode()
+	rrInbox := getWithHeaders(t, h, "/v1/inbox?"+raw, map[string]string{"X-Bus-Signature": sign("secret-b", []byte(raw))})
+	var inbox struct {
+		Events []struct {
+			MessageID   string `json:"message_id"`
+			TraceParent string `json:"trace_parent"`
+		} `json:"events"`
+	}
+	if err := json.Unmarshal(rrInbox.Body.Bytes(), &inbox); err != nil {
+		t.Fatalf("decode inbox: %v", err)
+	}
+	if len(inbox.Events) != 1 {
+		t.Fatalf("expected 1 inbox event, got %d", len(inbox.Events))
+	}
+	if !strings.Contains(inbox.Events[0].TraceParent, traceID) {
+		t.Fatalf("expected inbox trace_parent in trace %s, got %q", traceID, inbox.Events[0].TraceParent)
+	}
+
+	// The receiver acks without propagating context; the ack span must link back.
+	ackBody := map[string]any{"agent_id": "b", "message_id": sendResp.MessageID, "status": "accepted"}
+	ackBlob, _ := json.Marshal(ackBody)
+	if rr := postJSON(t, h, "/v1/acks", ackBody, map[string]string{"X-Bus-Signature": sign("secret-b", ackBlob)}); rr.Code != http.StatusOK {
+		t.Fatalf("ack status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	var sawSend, sawAckLink bool
+	for _, span := range recorder.Ended() {
+		switch span.Name() {
+		case "bus.SendMessage":
+			if span.SpanContext().TraceID().String() == traceID {
+				sawSend = true
+			}
+		case "bus.Ack":
+			for _, link := range span.Links() {
+				if link.SpanContext.TraceID().String() == traceID {
+					sawAckLink = true
+				}
+			}
+		}
+	}
+	if !sawSend {
+		t.Fatalf("expected bus.SendMessage span in caller trace")
+	}
+	if !sawAckLink {
+		t.Fatalf("expected bus.Ack span linked to sender trace")
+	}
+}
@@ -0,0 +1,301 @@
+package httpapi
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/joelkehle/techtransfer-agency/internal/bus"
+	"github.com/joelkehle/techtransfer-agency/internal/telemetry"
+)
+
+const (
+	wsAuthTimeout = 10 * time.Second
+	wsPollWait    = 5 * time.Second
+)
+
+// wsFrame is the envelope for every frame exchanged on /v1/ws in either
+// direction. Only the fields relevant to a given type are set.
+type wsFrame struct {
+	Type string `json:"type"`
+	ID   string `json:"id,omitempty"`
+
+	// challenge / auth
+	Nonce     string `json:"nonce,omitempty"`
+	AgentID   string `json:"agent_id,omitempty"`
+	Signature string `json:"signature,omitempty"`
+
+	// auth / observe cursors and inbox/observe deliveries
+	Cursor int64 `json:"cursor,omitempty"`
+
+	// observe subscription filter
+	ConversationID string `json:"conversation_id,omitempty"`
+
+	// ack / event
+	MessageID   string           `json:"message_id,omitempty"`
+	Status      string           `json:"status,omitempty"`
+	Reason      string           `json:"reason,omitempty"`
+	EventType   string           `json:"event_type,omitempty"`
+	Body        string           `json:"body,omitempty"`
+	Meta        any              `json:"meta,omitempty"`
+	Attachments []bus.Attachment `json:"attachments,omitempty"`
+	ErrorCode   string           `json:"error_code,omitempty"`
+
+	// send
+	Message *wsSendMessage `json:"message,omitempty"`
+
+	// server deliveries and results
+	Event     any            `json:"event,omitempty"`
+	Data      any            `json:"data,omitempty"`
+	OK        bool           `json:"ok,omitempty"`
+	To        string         `json:"to,omitempty"`
+	Duplicate bool           `json:"duplicate,omitempty"`
+	Error     map[string]any `json:"error,omitempty"`
+}
+
+type wsSendMessage struct {
+	To              string           `json:"to"`
+	Recipients      []string         `json:"recipients"`
+	ConversationID  string           `json:"conversation_id"`
+	RequestID       string           `json:"request_id"`
+	Type            string           `json:"type"`
+	Body            string           `json:"body"`
+	Meta            any              `json:"meta"`
+	Attachments     []bus.Attachment `json:"attachments"`
+	TTL             int              `json:"ttl"`
+	InReplyTo       string           `json:"in_reply_to"`
+	CompleteRequest bool             `json:"complete_request"`
+	Capability      string           `json:"capability"`
+	Priority        string           `json:"priority"`
+	DeliverAt       time.Time        `json:"deliver_at"`
+	DelaySeconds    int              `json:"delay_seconds"`
+	TraceParent     string           `json:"trace_parent"`
+}
+
+// wsSession serializes writes to a single socket; x/net/websocket frames
+// must not be written concurrently.
+type wsSession struct {
+	conn *websocket.Conn
+	wmu  sync.Mutex
+	done chan struct{}
+	once sync.Once
+}
+
+func (ws *wsSession) send(frame wsFrame) error {
+	ws.wmu.Lock()
+	defer ws.wmu.Unlock()
+	return websocket.JSON.Send(ws.conn, frame)
+}
+
+func (ws *wsSession) close() {
+	ws.once.Do(func() { close(ws.done) })
+}
+
+func (ws *wsSession) closed() bool {
+	select {
+	case <-ws.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func wsErrorFrame(id string, err error) wsFrame {
+	return wsFrame{Type: "error", ID: id, Error: busErrorPayload(err)}
+}
+
+func newNonce() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	if !methodOnly(w, r, http.MethodGet) {
+		return
+	}
+	// Agents are not browsers and usually send no Origin; authentication
+	// happens in-band via the HMAC challenge instead.
+	websocket.Server{
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler:   s.serveWebSocket,
+	}.ServeHTTP(w, r)
+}
+
+func (s *Server) serveWebSocket(conn *websocket.Conn) {
+	ws := &wsSession{conn: conn, done: make(chan struct{})}
+	defer ws.close()
+
+	nonce, err := newNonce()
+	if err != nil {
+		_ = ws.send(wsErrorFrame("", bus.NewInternalError("nonce generation failed")))
+		return
+	}
+	if err := ws.send(wsFrame{Type: "challenge", Nonce: nonce}); err != nil {
+		return
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(wsAuthTimeout))
+	var hello wsFrame
+	if err := websocket.JSON.Receive(conn, &hello); err != nil {
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	switch hello.Type {
+	case "auth":
+		agentID := strings.TrimSpace(hello.AgentID)
+		if err := s.verifySignature(agentID, hello.Signature, []byte(nonce)); err != nil {
+			_ = ws.send(wsErrorFrame(hello.ID, err))
+			return
+		}
+		if err := ws.send(wsFrame{Type: "ready", ID: hello.ID, AgentID: agentID, Cursor: hello.Cursor}); err != nil {
+			return
+		}
+		go s.wsDeliverInbox(ws, agentID, int(hello.Cursor))
+		s.wsReadAgentFrames(ws, agentID)
+	case "observe":
+		// Deliberately unsigned, like GET /v1/observe and gRPC
+		// ObserveSince: the observe stream is the operator's view of the
+		// bus and is protected by network access control, not agent
+		// secrets. Only agent frames need the nonce signature.
+		filter := bus.ObserveFilter{
+			ConversationID: strings.TrimSpace(hello.ConversationID),
+			AgentID:        strings.TrimSpace(hello.AgentID),
+		}
+		if err := ws.send(wsFrame{Type: "ready", ID: hello.ID, Cursor: hello.Cursor}); err != nil {
+			return
+		}
+		go s.wsDeliverObserve(ws, hello.Cursor, filter)
+		// Observers only listen; drain reads so a client close is noticed.
+		var ignored wsFrame
+		for websocket.JSON.Receive(conn, &ignored) == nil {
+		}
+	default:
+		_ = ws.send(wsErrorFrame(hello.ID, &bus.Error{Code: bus.CodeValidation, Message: "first frame must be auth or observe", Status: 400}))
+	}
+}
+
+func (s *Server) wsDeliverInbox(ws *wsSession, agentID string, cursor int) {
+	defer ws.close()
+	for !ws.closed() {
+		events, next, err := s.store.PollInbox(bus.PollInboxInput{AgentID: agentID, Cursor: cursor, Wait: wsPollWait})
+		if err != nil {
+			_ = ws.send(wsErrorFrame("", err))
+			_ = ws.conn.Close()
+			return
+		}
+		for i, evt := range events {
+			// Each frame carries the cursor to resume from after that event.
+			frameCursor := next - len(events) + i + 1
+			if err := ws.send(wsFrame{Type: "inbox", Cursor: int64(frameCursor), Event: evt}); err != nil {
+				return
+			}
+		}
+		cursor = next
+	}
+}
+
+func (s *Server) wsDeliverObserve(ws *wsSession, cursor int64, filter bus.ObserveFilter) {
+	defer ws.close()
+	for !ws.closed() {
+		events, last := s.store.ObserveSince(cursor, filter, time.Second)
+		for _, evt := range events {
+			if err := ws.send(wsFrame{Type: "observe_event", Cursor: evt.ID, EventType: string(evt.Type), Data: evt.Data}); err != nil {
+				return
+			}
+		}
+		cursor = last
+	}
+}
+
+func (s *Server) wsReadAgentFrames(ws *wsSession, agentID string) {
+	for {
+		var frame wsFrame
+		if err := websocket.JSON.Receive(ws.conn, &frame); err != nil {
+			return
+		}
+		reply, err := s.wsHandleAgentFrame(agentID, frame)
+		if err != nil {
+			reply = wsErrorFrame(frame.ID, err)
+		}
+		if err := ws.send(reply); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) wsHandleAgentFrame(agentID string, frame wsFrame) (wsFrame, error) {
+	switch frame.Type {
+	case "ack":
+		_, span := startBusSpan(context.Background(), "Ack", s.messageLinks(strings.TrimSpace(frame.MessageID))...)
+		err := s.store.Ack(bus.AckInput{
+			AgentID:   agentID,
+			MessageID: frame.MessageID,
+			Status:    frame.Status,
+			Reason:    frame.Reason,
+		})
+		endBusSpan(span, err)
+		if err != nil {
+			return wsFrame{}, err
+		}
+		return wsFrame{Type: "result", ID: frame.ID, OK: true}, nil
+	case "event":
+		_, span := startBusSpan(context.Background(), "PostEvent", s.messageLinks(strings.TrimSpace(frame.MessageID))...)
+		err := s.store.PostEvent(bus.EventInput{
+			ActorAgentID: agentID,
+			MessageID:    frame.MessageID,
+			Type:         frame.EventType,
+			Body:         frame.Body,
+			Meta:         frame.Meta,
+			Attachments:  frame.Attachments,
+			ErrorCode:    frame.ErrorCode,
+		})
+		endBusSpan(span, err)
+		if err != nil {
+			return wsFrame{}, err
+		}
+		return wsFrame{Type: "result", ID: frame.ID, OK: true}, nil
+	case "send":
+		if frame.Message == nil {
+			return wsFrame{}, &bus.Error{Code: bus.CodeValidation, Message: "message is required", Status: 400}
+		}
+		req := frame.Message
+		ctx := telemetry.ContextWithTraceParent(context.Background(), req.TraceParent)
+		ctx, span := startBusSpan(ctx, "SendMessage", s.messageLinks(strings.TrimSpace(req.InReplyTo))...)
+		message, duplicate, err := s.store.SendMessage(bus.SendMessageInput{
+			To:              req.To,
+			Recipients:      req.Recipients,
+			From:            agentID,
+			ConversationID:  req.ConversationID,
+			RequestID:       req.RequestID,
+			Type:            bus.MessageType(req.Type),
+			Body:            req.Body,
+			Meta:            req.Meta,
+			Attachments:     req.Attachments,
+			TTLSeconds:      req.TTL,
+			InReplyTo:       req.InReplyTo,
+			CompleteRequest: req.CompleteRequest,
+			Capability:      req.Capability,
+			Priority:        bus.MessagePriority(req.Priority),
+			DeliverAt:       req.DeliverAt,
+			DelaySeconds:    req.DelaySeconds,
+			TraceParent:     telemetry.TraceParent(ctx),
+		})
+		endBusSpan(span, err)
+		if err != nil {
+			return wsFrame{}, err
+		}
+		return wsFrame{Type: "result", ID: frame.ID, OK: true, MessageID: message.MessageID, To: message.To, Duplicate: duplicate}, nil
+	default:
+		return wsFrame{}, &bus.Error{Code: bus.CodeValidation, Message: "type must be ack, event, or send", Status: 400}
+	}
+}
@@ -0,0 +1,161 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/joelkehle/techtransfer-agency/internal/bus"
+	"github.com/joelkehle/techtransfer-agency/pkg/busclient"
+)
+
+func TestWebSocketInboxAckAndSend(t *testing.T) {
+	store := bus.NewStore(bus.Config{InboxWaitMax: 1 * time.Second})
+	ts := httptest.NewServer(NewServer(store))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client := busclient.NewClient(ts.URL)
+	if err := client.RegisterAgent(ctx, "a", "secret-a", []string{"orchestrator"}); err != nil {
+		t.Fatalf("register a: %v", err)
+	}
+	if err := client.RegisterAgent(ctx, "b", "secret-b", []string{"worker"}); err != nil {
+		t.Fatalf("register b: %v", err)
+	}
+
+	if _, err := client.ConnectSocket(ctx, "b", "wrong-secret", 0); err == nil {
+		t.Fatalf("expected bad signature to be rejected")
+	}
+
+	observed, err := client.ObserveSocket(ctx, 0, "", "b")
+	if err != nil {
+		t.Fatalf("observe socket: %v", err)
+	}
+
+	sock, err := client.ConnectSocket(ctx, "b", "secret-b", 0)
+	if err != nil {
+		t.Fatalf("connect socket: %v", err)
+	}
+	defer sock.Close()
+
+	requestID, err := client.SendMessage(ctx, "a", "secret-a", "b", "", "rid-ws", "request", "do work", nil, nil)
+	if err != nil {
+		t.Fatalf("send request: %v", err)
+	}
+
+	var evt busclient.InboxEvent
+	select {
+	case evt = <-sock.Events():
+	case <-ctx.Done():
+		t.Fatalf("timed out waiting for inbox event")
+	}
+	if evt.MessageID != requestID || evt.Body != "do work" {
+		t.Fatalf("unexpected inbox event: %#v", evt)
+	}
+	if sock.Cursor() != 1 {
+		t.Fatalf("expected cursor 1 after first event, got %d", sock.Cursor())
+	}
+
+	if err := sock.Ack(ctx, requestID, "accepted", ""); err != nil {
+		t.Fatalf("socket ack: %v", err)
+	}
+	if err := sock.Event(ctx, requestID, "final", "done", nil); err != nil {
+		t.Fatalf("socket final event: %v", err)
+	}
+	if err := sock.Event(ctx, "m-missing", "final", "done", nil); err == nil {
+		t.Fatalf("expected error for unknown message")
+	}
+	responseID, err := sock.SendMessage(ctx, "a", evt.ConversationID, "resp-ws", "response", "report", nil, nil)
+	if err != nil {
+		t.Fatalf("socket send: %v", err)
+	}
+
+	stored, _ := store.GetMessageForTest(requestID)
+	if stored.State != bus.StateCompleted {
+		t.Fatalf("expected request completed, got %s", stored.State)
+	}
+	response, ok := store.GetMessageForTest(responseID)
+	if !ok || response.From != "b" {
+		t.Fatalf("expected response sent as b, got %#v", response)
+	}
+
+	sawStateChange := false
+	for !sawStateChange {
+		select {
+		case ev, ok := <-observed:
+			if !ok {
+				t.Fatalf("observe socket closed early")
+			}
+			if ev.Type == string(bus.ObserveStateChange) {
+				var data map[string]any
+				_ = json.Unmarshal(ev.Data, &data)
+				sawStateChange = data["to_state"] == string(bus.StateExecuting)
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for observe state change")
+		}
+	}
+}
+
+func TestWebSocketRequiresUpgrade(t *testing.T) {
+	ts := httptest.NewServer(newServerForTest())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/v1/ws")
+	if err != nil {
+		t.Fatalf("plain get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		t.Fatalf("expected non-upgrade request to be refused")
+	}
+}
+
+// Observing needs no agent identity, matching GET /v1/observe: an
+// unregistered client sees every conversation's message bodies.
+func TestWebSocketObserveIsUnauthenticated(t *testing.T) {
+	store := bus.NewStore(bus.Config{InboxWaitMax: 1 * time.Second})
+	ts := httptest.NewServer(NewServer(store))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client := busclient.NewClient(ts.URL)
+	if err := client.RegisterAgent(ctx, "a", "secret-a", []string{"orchestrator"}); err != nil {
+		t.Fatalf("register a: %v", err)
+	}
+	if err := client.RegisterAgent(ctx, "b", "secret-b", []string{"worker"}); err != nil {
+		t.Fatalf("register b: %v", err)
+	}
+	observed, err := client.ObserveSocket(ctx, 0, "", "")
+	if err != nil {
+		t.Fatalf("observe socket without credentials: %v", err)
+	}
+	if _, err := client.SendMessage(ctx, "a", "secret-a", "b", "", "rid-ws", "request", "confidential draft", nil, nil); err != nil {
+		t.Fatalf("send request: %v", err)
+	}
+
+	for {
+		select {
+		case ev, ok := <-observed:
+			if !ok {
+				t.Fatalf("observe socket closed early")
+			}
+			if ev.Type != string(bus.ObserveMessage) {
+				continue
+			}
+			var data map[string]any
+			_ = json.Unmarshal(ev.Data, &data)
+			if data["body"] != "confidential draft" {
+				t.Fatalf("expected the message body on the observe stream, got %s", ev.Data)
+			}
+			return
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for observe message event")
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package telemetry
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const TracerName = "github.com/joelkehle/techtransfer-agency"
+
+// traceContext is used directly rather than the global propagator so trace
+// context still flows through the bus when no exporter is configured.
+var traceContext = propagation.TraceContext{}
+
+func Tracer() trace.Tracer {
+	return otel.Tracer(TracerName)
+}
+
+// ExtractHTTP returns ctx carrying the remote span context found in W3C
+// traceparent/tracestate headers, if any.
+func ExtractHTTP(ctx context.Context, headers propagation.HeaderCarrier) context.Context {
+	return traceContext.Extract(ctx, headers)
+}
+
+// InjectHTTP writes the span context in ctx as W3C traceparent/tracestate headers.
+func InjectHTTP(ctx context.Context, headers propagation.HeaderCarrier) {
+	traceContext.Inject(ctx, headers)
+}
+
+// TraceParent formats the span context in ctx as a W3C traceparent value.
+// It returns "" when ctx carries no valid span context.
+func TraceParent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	traceContext.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// ContextWithTraceParent returns ctx carrying the remote span context encoded
+// in traceparent. Invalid or empty values leave ctx unchanged.
+func ContextWithTraceParent(ctx context.Context, traceParent string) context.Context {
+	traceParent = strings.TrimSpace(traceParent)
+	if traceParent == "" {
+		return ctx
+	}
+	return traceContext.Extract(ctx, propagation.MapCarrier{"traceparent": traceParent})
+}
+
+// LinkFromTraceParent builds a span link to the span encoded in traceParent.
+func LinkFromTraceParent(traceParent string) (trace.Link, bool) {
+	sc := trace.SpanContextFromContext(ContextWithTraceParent(context.Background(), traceParent))
+	if !sc.IsValid() {
+		return trace.Link{}, false
+	}
+	return trace.Link{SpanContext: sc}, true
+}
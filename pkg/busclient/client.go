@@ -14,6 +14,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"go.opentelemetry.io/otel/propagation"
 )
 
 type Attachment struct {
@@ -21,6 +23,21 @@ type Attachment struct {
 	Name        string `json:"name,omitempty"`
 	ContentType string `json:"content_type,omitempty"`
 	Size        int64  `json:"size,omitempty"`
+	SHA256      string `json:"sha256,omitempty"`
+	// Verification is filled in by a bus that verifies attachments; the bus
+	// ignores it on send.
+	Verification *AttachmentVerification `json:"verification,omitempty"`
+}
+
+// AttachmentVerification is the bus's check of an attachment's content.
+// Status is "verified" or "failed", with Reason explaining a failure.
+type AttachmentVerification struct {
+	Status      string    `json:"status"`
+	Reason      string    `json:"reason,omitempty"`
+	Size        int64     `json:"size,omitempty"`
+	SHA256      string    `json:"sha256,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	CheckedAt   time.Time `json:"checked_at"`
 }
 
 type InboxEvent struct {
@@ -28,9 +45,13 @@ type InboxEvent struct {
 	Type           string       `json:"type"`
 	From           string       `json:"from"`
 	ConversationID string       `json:"conversation_id,omitempty"`
+	ParentID       string       `json:"parent_id,omitempty"`
 	Body           string       `json:"body"`
 	Meta           any          `json:"meta,omitempty"`
 	Attachments    []Attachment `json:"attachments,omitempty"`
+	Capability     string       `json:"capability,omitempty"`
+	Priority       string       `json:"priority,omitempty"`
+	TraceParent    string       `json:"trace_parent,omitempty"`
 	CreatedAt      time.Time    `json:"created_at"`
 }
 
@@ -54,6 +75,18 @@ func NewClient(baseURL string) *Client {
 	}
 }
 
+var traceContext = propagation.TraceContext{}
+
+// ContextWithTraceParent returns ctx carrying the trace context the bus
+// attached to an inbox event, so work done for that event (and any messages
+// sent while handling it) joins the sender's trace.
+func ContextWithTraceParent(ctx context.Context, traceParent string) context.Context {
+	if strings.TrimSpace(traceParent) == "" {
+		return ctx
+	}
+	return traceContext.Extract(ctx, propagation.MapCarrier{"traceparent": traceParent})
+}
+
 func Sign(secret string, payload []byte) string {
 	mac := hmac.New(sha256.New, []byte(secret))
 	_, _ = mac.Write(payload)
@@ -68,6 +101,7 @@ func (c *Client) DoJSON(ctx context.Context, method, path string, payload []byte
 	if payload != nil {
 		req.Header.Set("Content-Type", "application/json")
 	}
+	traceContext.Inject(ctx, propagation.HeaderCarrier(req.Header))
 	for k, v := range headers {
 		req.Header.Set(k, v)
 	}
@@ -95,6 +129,35 @@ func (c *Client) RegisterAgent(ctx context.Context, agentID, secret string, capa
 	return err
 }
 
+// Heartbeat renews the agent's registration without re-sending its
+// declaration or secret. health may be nil; otherwise it is sent as the
+// heartbeat body (status, in_flight, load, checks).
+func (c *Client) Heartbeat(ctx context.Context, agentID, secret string, health map[string]any) error {
+	if health == nil {
+		health = map[string]any{}
+	}
+	blob, _ := json.Marshal(health)
+	headers := map[string]string{"X-Bus-Signature": Sign(secret, blob)}
+	_, _, err := c.DoJSON(ctx, http.MethodPost, "/v1/agents/"+url.PathEscape(agentID)+"/heartbeat", blob, headers)
+	return err
+}
+
+// Deregister takes the agent off the bus. Requests it has not accepted are
+// rerouted or failed by the bus.
+func (c *Client) Deregister(ctx context.Context, agentID, secret, reason string) error {
+	query := ""
+	if reason != "" {
+		query = "reason=" + url.QueryEscape(reason)
+	}
+	path := "/v1/agents/" + url.PathEscape(agentID)
+	if query != "" {
+		path += "?" + query
+	}
+	headers := map[string]string{"X-Bus-Signature": Sign(secret, []byte(query))}
+	_, _, err := c.DoJSON(ctx, http.MethodDelete, path, nil, headers)
+	return err
+}
+
 func (c *Client) SendMessage(ctx context.Context, from, secret, to, conversationID, requestID, messageType, bodyText string, attachments []Attachment, meta map[string]any) (string, error) {
 	payload := map[string]any{
 		"to":              to,
@@ -124,6 +187,216 @@ func (c *Client) SendMessage(ctx context.Context, from, secret, to, conversation
 	return resp.MessageID, nil
 }
 
+// Reply sends a response to a request this agent received. With complete
+// set, the bus also marks the request completed with the reply as its result.
+func (c *Client) Reply(ctx context.Context, from, secret, to, conversationID, requestID, inReplyTo, bodyText string, complete bool, attachments []Attachment, meta map[string]any) (string, error) {
+	blob, _ := json.Marshal(map[string]any{
+		"to":               to,
+		"from":             from,
+		"conversation_id":  conversationID,
+		"request_id":       requestID,
+		"type":             "response",
+		"body":             bodyText,
+		"attachments":      attachments,
+		"meta":             meta,
+		"in_reply_to":      inReplyTo,
+		"complete_request": complete,
+	})
+	headers := map[string]string{"X-Bus-Signature": Sign(secret, blob)}
+	out, _, err := c.DoJSON(ctx, http.MethodPost, "/v1/messages", blob, headers)
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		MessageID string `json:"message_id"`
+	}
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return "", err
+	}
+	return resp.MessageID, nil
+}
+
+// BatchMessage is one item of a SendMessageBatch call; the sender is shared.
+type BatchMessage struct {
+	To             string         `json:"to"`
+	ConversationID string         `json:"conversation_id,omitempty"`
+	RequestID      string         `json:"request_id"`
+	Type           string         `json:"type"`
+	Body           string         `json:"body"`
+	Attachments    []Attachment   `json:"attachments,omitempty"`
+	Meta           map[string]any `json:"meta,omitempty"`
+	Capability     string         `json:"capability,omitempty"`
+	// Priority is low, normal (the default), high or urgent.
+	Priority string `json:"priority,omitempty"`
+	// DeliverAt or DelaySeconds schedules the message for later delivery.
+	DeliverAt    *time.Time `json:"deliver_at,omitempty"`
+	DelaySeconds int        `json:"delay_seconds,omitempty"`
+	// Recipients multicasts an inform instead of sending it to To: agent
+	// IDs, "capability:<query>" or "conversation:<id>".
+	Recipients []string `json:"recipients,omitempty"`
+}
+
+// BatchResult reports the outcome of one BatchMessage, in request order.
+type BatchResult struct {
+	OK        bool   `json:"ok"`
+	MessageID string `json:"message_id,omitempty"`
+	To        string `json:"to,omitempty"`
+	Duplicate bool   `json:"duplicate,omitempty"`
+	Error     *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// SendMessageBatch sends messages from one sender in a single signed call.
+// With atomic set, the bus sends all of them or none.
+func (c *Client) SendMessageBatch(ctx context.Context, from, secret string, atomic bool, messages []BatchMessage) ([]BatchResult, error) {
+	blob, _ := json.Marshal(map[string]any{
+		"from":     from,
+		"atomic":   atomic,
+		"messages": messages,
+	})
+	headers := map[string]string{"X-Bus-Signature": Sign(secret, blob)}
+	out, _, err := c.DoJSON(ctx, http.MethodPost, "/v1/messages:batch", blob, headers)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Results []BatchResult `json:"results"`
+	}
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Results, nil
+}
+
+// CallResponse is the outcome of Call. Reply is the first response message
+// naming the request in in_reply_to; Result is set when the request itself
+// finished with a final or error event.
+type CallResponse struct {
+	MessageID string       `json:"message_id"`
+	Duplicate bool         `json:"duplicate"`
+	State     string       `json:"state"`
+	TimedOut  bool         `json:"timed_out"`
+	Result    *Result      `json:"result,omitempty"`
+	Reply     *CallMessage `json:"reply,omitempty"`
+}
+
+// CallMessage is the reply carried by a CallResponse.
+type CallMessage struct {
+	MessageID      string         `json:"message_id"`
+	From           string         `json:"from"`
+	ConversationID string         `json:"conversation_id,omitempty"`
+	InReplyTo      string         `json:"in_reply_to,omitempty"`
+	Body           string         `json:"body"`
+	Meta           map[string]any `json:"meta,omitempty"`
+	Attachments    []Attachment   `json:"attachments,omitempty"`
+}
+
+// Call sends a request and waits up to timeoutSec (capped by the bus) for it
+// to finish or be answered, replacing a send-then-poll loop. A timed out call
+// can be retried with the same requestID to keep waiting on the same request.
+func (c *Client) Call(ctx context.Context, from, secret, to, conversationID, requestID, bodyText string, timeoutSec int, attachments []Attachment, meta map[string]any) (*CallResponse, error) {
+	blob, _ := json.Marshal(map[string]any{
+		"to":              to,
+		"from":            from,
+		"conversation_id": conversationID,
+		"request_id":      requestID,
+		"body":            bodyText,
+		"attachments":     attachments,
+		"meta":            meta,
+		"timeout":         timeoutSec,
+	})
+	// The bus holds the response for up to timeoutSec, which may outlast the
+	// client's default HTTP timeout.
+	caller := c
+	if need := time.Duration(timeoutSec)*time.Second + 5*time.Second; c.http.Timeout > 0 && c.http.Timeout < need {
+		hc := *c.http
+		hc.Timeout = need
+		caller = &Client{baseURL: c.baseURL, http: &hc}
+	}
+	headers := map[string]string{"X-Bus-Signature": Sign(secret, blob)}
+	out, _, err := caller.DoJSON(ctx, http.MethodPost, "/v1/call", blob, headers)
+	if err != nil {
+		return nil, err
+	}
+	var resp CallResponse
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// MessageStatus is the subset of GET /v1/messages/{id} needed to follow a
+// request through its lifecycle.
+type MessageStatus struct {
+	MessageID    string     `json:"message_id"`
+	State        string     `json:"state"`
+	DeliverAt    *time.Time `json:"deliver_at,omitempty"`
+	DeliveredAt  *time.Time `json:"delivered_at,omitempty"`
+	TTLExpiresAt time.Time  `json:"ttl_expires_at"`
+	Result       *Result    `json:"result,omitempty"`
+	// Deliveries reports each recipient's copy of a multicast inform.
+	Deliveries []Delivery `json:"deliveries,omitempty"`
+}
+
+// Delivery is the state of one recipient's copy of a multicast inform.
+type Delivery struct {
+	AgentID     string     `json:"agent_id"`
+	MessageID   string     `json:"message_id"`
+	State       string     `json:"state"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// Result is the outcome a request's final or error event recorded.
+type Result struct {
+	Body        string         `json:"body"`
+	Meta        map[string]any `json:"meta,omitempty"`
+	Attachments []Attachment   `json:"attachments,omitempty"`
+	ErrorCode   string         `json:"error_code,omitempty"`
+}
+
+func (c *Client) GetMessage(ctx context.Context, messageID string) (*MessageStatus, error) {
+	out, _, err := c.DoJSON(ctx, http.MethodGet, "/v1/messages/"+url.PathEscape(messageID), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Message MessageStatus `json:"message"`
+	}
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Message, nil
+}
+
+// ListScheduled returns the messages agentID has scheduled that are not yet
+// due, earliest first.
+func (c *Client) ListScheduled(ctx context.Context, agentID, secret string) ([]MessageStatus, error) {
+	rawQuery := url.Values{"agent_id": {agentID}}.Encode()
+	headers := map[string]string{"X-Bus-Signature": Sign(secret, []byte(rawQuery))}
+	out, _, err := c.DoJSON(ctx, http.MethodGet, "/v1/messages/scheduled?"+rawQuery, nil, headers)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Messages []MessageStatus `json:"messages"`
+	}
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Messages, nil
+}
+
+// CancelScheduled cancels a message agentID scheduled before it is delivered.
+func (c *Client) CancelScheduled(ctx context.Context, agentID, secret, messageID string) error {
+	blob, _ := json.Marshal(map[string]any{"agent_id": agentID, "message_id": messageID})
+	headers := map[string]string{"X-Bus-Signature": Sign(secret, blob)}
+	_, _, err := c.DoJSON(ctx, http.MethodPost, "/v1/messages/"+url.PathEscape(messageID)+"/cancel", blob, headers)
+	return err
+}
+
 func (c *Client) PollInbox(ctx context.Context, agentID, secret string, cursor int, waitSec int) ([]InboxEvent, int, error) {
 	q := url.Values{}
 	q.Set("agent_id", agentID)
@@ -179,20 +452,38 @@ func (c *Client) Event(ctx context.Context, agentID, secret, messageID, eventTyp
 	return err
 }
 
+// ListAgents returns every matching agent, following next_cursor across
+// pages.
 func (c *Client) ListAgents(ctx context.Context, capability string) ([]AgentInfo, error) {
-	path := "/v1/agents"
-	if capability != "" {
-		path += "?capability=" + url.QueryEscape(capability)
-	}
-	out, _, err := c.DoJSON(ctx, http.MethodGet, path, nil, nil)
-	if err != nil {
-		return nil, err
-	}
-	var resp struct {
-		Agents []AgentInfo `json:"agents"`
-	}
-	if err := json.Unmarshal(out, &resp); err != nil {
-		return nil, err
+	var agents []AgentInfo
+	cursor := ""
+	for {
+		query := url.Values{}
+		if capability != "" {
+			query.Set("capability", capability)
+		}
+		if cursor != "" {
+			query.Set("cursor", cursor)
+		}
+		path := "/v1/agents"
+		if len(query) > 0 {
+			path += "?" + query.Encode()
+		}
+		out, _, err := c.DoJSON(ctx, http.MethodGet, path, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var resp struct {
+			Agents     []AgentInfo `json:"agents"`
+			NextCursor string      `json:"next_cursor"`
+		}
+		if err := json.Unmarshal(out, &resp); err != nil {
+			return nil, err
+		}
+		agents = append(agents, resp.Agents...)
+		if resp.NextCursor == "" {
+			return agents, nil
+		}
+		cursor = resp.NextCursor
 	}
-	return resp.Agents, nil
 }
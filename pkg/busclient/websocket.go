@@ -0,0 +1,352 @@
+package busclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/propagation"
+	"golang.org/x/net/websocket"
+)
+
+// ObserveEvent is one event delivered on an observe socket.
+type ObserveEvent struct {
+	ID   int64           `json:"id"`
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+type wsFrame struct {
+	Type           string          `json:"type"`
+	ID             string          `json:"id,omitempty"`
+	Nonce          string          `json:"nonce,omitempty"`
+	AgentID        string          `json:"agent_id,omitempty"`
+	Signature      string          `json:"signature,omitempty"`
+	Cursor         int64           `json:"cursor,omitempty"`
+	ConversationID string          `json:"conversation_id,omitempty"`
+	MessageID      string          `json:"message_id,omitempty"`
+	Status         string          `json:"status,omitempty"`
+	Reason         string          `json:"reason,omitempty"`
+	EventType      string          `json:"event_type,omitempty"`
+	Body           string          `json:"body,omitempty"`
+	Meta           any             `json:"meta,omitempty"`
+	Message        map[string]any  `json:"message,omitempty"`
+	Event          json.RawMessage `json:"event,omitempty"`
+	Data           json.RawMessage `json:"data,omitempty"`
+	OK             bool            `json:"ok,omitempty"`
+	Duplicate      bool            `json:"duplicate,omitempty"`
+	Error          *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// Socket is an authenticated agent connection to /v1/ws. Inbox events are
+// pushed to Events as the bus appends them; acks, progress events and
+// messages can be sent over the same connection without per-call signatures.
+type Socket struct {
+	conn   *websocket.Conn
+	events chan InboxEvent
+
+	wmu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  int
+	pending map[string]chan wsFrame
+	queue   []InboxEvent
+	notify  chan struct{}
+	ended   bool
+	cursor  int
+	err     error
+}
+
+func (c *Client) dialWS(ctx context.Context) (*websocket.Conn, error) {
+	wsURL := "ws" + strings.TrimPrefix(c.baseURL, "http") + "/v1/ws"
+	cfg, err := websocket.NewConfig(wsURL, c.baseURL)
+	if err != nil {
+		return nil, err
+	}
+	traceContext.Inject(ctx, propagation.HeaderCarrier(cfg.Header))
+	return cfg.DialContext(ctx)
+}
+
+func readChallenge(conn *websocket.Conn) (string, error) {
+	var challenge wsFrame
+	if err := websocket.JSON.Receive(conn, &challenge); err != nil {
+		return "", err
+	}
+	if challenge.Type != "challenge" || challenge.Nonce == "" {
+		return "", fmt.Errorf("unexpected first frame %q", challenge.Type)
+	}
+	return challenge.Nonce, nil
+}
+
+func expectReady(conn *websocket.Conn) error {
+	var ready wsFrame
+	if err := websocket.JSON.Receive(conn, &ready); err != nil {
+		return err
+	}
+	if ready.Type == "error" && ready.Error != nil {
+		return fmt.Errorf("%s: %s", ready.Error.Code, ready.Error.Message)
+	}
+	if ready.Type != "ready" {
+		return fmt.Errorf("unexpected frame %q", ready.Type)
+	}
+	return nil
+}
+
+// ConnectSocket opens /v1/ws, answers the HMAC challenge with secret and
+// starts receiving inbox events after cursor.
+func (c *Client) ConnectSocket(ctx context.Context, agentID, secret string, cursor int) (*Socket, error) {
+	conn, err := c.dialWS(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := readChallenge(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	auth := wsFrame{Type: "auth", AgentID: agentID, Signature: Sign(secret, []byte(nonce)), Cursor: int64(cursor)}
+	if err := websocket.JSON.Send(conn, auth); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := expectReady(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	s := &Socket{
+		conn:    conn,
+		events:  make(chan InboxEvent),
+		pending: map[string]chan wsFrame{},
+		notify:  make(chan struct{}, 1),
+		cursor:  cursor,
+	}
+	go s.readLoop()
+	go s.pump()
+	return s, nil
+}
+
+// Events returns inbox events in delivery order. The channel is closed when
+// the socket ends; Err then reports why.
+func (s *Socket) Events() <-chan InboxEvent {
+	return s.events
+}
+
+// Cursor returns the inbox cursor after the most recently received event,
+// suitable for resuming with ConnectSocket or PollInbox.
+func (s *Socket) Cursor() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cursor
+}
+
+func (s *Socket) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *Socket) Close() error {
+	return s.conn.Close()
+}
+
+// pump hands queued inbox events to the consumer. Queuing keeps readLoop
+// free to route call replies while the consumer is busy handling an event.
+func (s *Socket) pump() {
+	defer close(s.events)
+	for range s.notify {
+		for {
+			s.mu.Lock()
+			if len(s.queue) == 0 {
+				ended := s.ended
+				s.mu.Unlock()
+				if ended {
+					return
+				}
+				break
+			}
+			evt := s.queue[0]
+			s.queue = s.queue[1:]
+			s.mu.Unlock()
+			s.events <- evt
+		}
+	}
+}
+
+func (s *Socket) wake() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Socket) readLoop() {
+	for {
+		var frame wsFrame
+		if err := websocket.JSON.Receive(s.conn, &frame); err != nil {
+			s.fail(err)
+			return
+		}
+		switch {
+		case frame.Type == "inbox":
+			var evt InboxEvent
+			if err := json.Unmarshal(frame.Event, &evt); err != nil {
+				continue
+			}
+			s.mu.Lock()
+			s.queue = append(s.queue, evt)
+			s.cursor = int(frame.Cursor)
+			s.mu.Unlock()
+			s.wake()
+		case frame.ID != "":
+			s.mu.Lock()
+			ch, ok := s.pending[frame.ID]
+			delete(s.pending, frame.ID)
+			s.mu.Unlock()
+			if ok {
+				ch <- frame
+			}
+		case frame.Type == "error" && frame.Error != nil:
+			s.fail(fmt.Errorf("%s: %s", frame.Error.Code, frame.Error.Message))
+			return
+		}
+	}
+}
+
+func (s *Socket) fail(err error) {
+	s.mu.Lock()
+	defer s.wake()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.ended = true
+	for id, ch := range s.pending {
+		close(ch)
+		delete(s.pending, id)
+	}
+}
+
+func (s *Socket) call(ctx context.Context, frame wsFrame) (wsFrame, error) {
+	ch := make(chan wsFrame, 1)
+	s.mu.Lock()
+	if s.err != nil {
+		err := s.err
+		s.mu.Unlock()
+		return wsFrame{}, err
+	}
+	s.nextID++
+	frame.ID = strconv.Itoa(s.nextID)
+	s.pending[frame.ID] = ch
+	s.mu.Unlock()
+
+	s.wmu.Lock()
+	err := websocket.JSON.Send(s.conn, frame)
+	s.wmu.Unlock()
+	if err != nil {
+		s.mu.Lock()
+		delete(s.pending, frame.ID)
+		s.mu.Unlock()
+		return wsFrame{}, err
+	}
+
+	select {
+	case <-ctx.Done():
+		s.mu.Lock()
+		delete(s.pending, frame.ID)
+		s.mu.Unlock()
+		return wsFrame{}, ctx.Err()
+	case reply, ok := <-ch:
+		if !ok {
+			return wsFrame{}, s.Err()
+		}
+		if reply.Type == "error" && reply.Error != nil {
+			return reply, fmt.Errorf("%s: %s", reply.Error.Code, reply.Error.Message)
+		}
+		return reply, nil
+	}
+}
+
+func (s *Socket) Ack(ctx context.Context, messageID, status, reason string) error {
+	_, err := s.call(ctx, wsFrame{Type: "ack", MessageID: messageID, Status: status, Reason: reason})
+	return err
+}
+
+func (s *Socket) Event(ctx context.Context, messageID, eventType, body string, meta map[string]any) error {
+	_, err := s.call(ctx, wsFrame{Type: "event", MessageID: messageID, EventType: eventType, Body: body, Meta: meta})
+	return err
+}
+
+func (s *Socket) SendMessage(ctx context.Context, to, conversationID, requestID, messageType, bodyText string, attachments []Attachment, meta map[string]any) (string, error) {
+	carrier := propagation.MapCarrier{}
+	traceContext.Inject(ctx, carrier)
+	reply, err := s.call(ctx, wsFrame{Type: "send", Message: map[string]any{
+		"to":              to,
+		"conversation_id": conversationID,
+		"request_id":      requestID,
+		"type":            messageType,
+		"body":            bodyText,
+		"attachments":     attachments,
+		"meta":            meta,
+		"trace_parent":    carrier.Get("traceparent"),
+	}})
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(reply.MessageID) == "" {
+		return "", fmt.Errorf("missing message_id in response")
+	}
+	return reply.MessageID, nil
+}
+
+// ObserveSocket streams observe events matching the optional filters, starting
+// after cursor. Events are delivered until ctx is cancelled or the socket ends.
+func (c *Client) ObserveSocket(ctx context.Context, cursor int64, conversationID, agentID string) (<-chan ObserveEvent, error) {
+	conn, err := c.dialWS(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := readChallenge(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	sub := wsFrame{Type: "observe", Cursor: cursor, ConversationID: conversationID, AgentID: agentID}
+	if err := websocket.JSON.Send(conn, sub); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := expectReady(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	out := make(chan ObserveEvent, 64)
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	go func() {
+		defer close(out)
+		for {
+			var frame wsFrame
+			if err := websocket.JSON.Receive(conn, &frame); err != nil {
+				return
+			}
+			if frame.Type != "observe_event" {
+				continue
+			}
+			select {
+			case out <- ObserveEvent{ID: frame.Cursor, Type: frame.EventType, Data: frame.Data}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
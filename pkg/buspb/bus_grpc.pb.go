@@ -0,0 +1,552 @@
+// Typed gRPC contract for the techtransfer-agency bus. It mirrors bus.API and
+// the HTTP+JSON routes in docs/BUS_HTTP_CONTRACT.md; both transports share the
+// same store and agent credentials.
+//
+// Freeform JSON values (message meta, observe event data) travel as JSON
+// strings in *_json fields. Timestamps are RFC 3339 strings.
+//
+// Auth: calls that act as an agent must carry an `x-bus-signature` metadata
+// entry holding the hex HMAC-SHA256, keyed by that agent's secret, of the
+// request message in deterministic proto3 serialization (fields in number
+// order, as proto.Marshal in Go writes it).
+//
+// Go code is generated into pkg/buspb; see pkg/buspb/generate.go.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: bus/v1/bus.proto
+
+package buspb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Bus_RegisterAgent_FullMethodName   = "/techtransfer.bus.v1.Bus/RegisterAgent"
+	Bus_Heartbeat_FullMethodName       = "/techtransfer.bus.v1.Bus/Heartbeat"
+	Bus_Deregister_FullMethodName      = "/techtransfer.bus.v1.Bus/Deregister"
+	Bus_SendMessage_FullMethodName     = "/techtransfer.bus.v1.Bus/SendMessage"
+	Bus_ListScheduled_FullMethodName   = "/techtransfer.bus.v1.Bus/ListScheduled"
+	Bus_CancelScheduled_FullMethodName = "/techtransfer.bus.v1.Bus/CancelScheduled"
+	Bus_PollInbox_FullMethodName       = "/techtransfer.bus.v1.Bus/PollInbox"
+	Bus_Ack_FullMethodName             = "/techtransfer.bus.v1.Bus/Ack"
+	Bus_PostEvent_FullMethodName       = "/techtransfer.bus.v1.Bus/PostEvent"
+	Bus_ObserveSince_FullMethodName    = "/techtransfer.bus.v1.Bus/ObserveSince"
+	Bus_Inject_FullMethodName          = "/techtransfer.bus.v1.Bus/Inject"
+)
+
+// BusClient is the client API for Bus service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type BusClient interface {
+	// Registers or refreshes an agent. Carries the agent secret; unsigned.
+	RegisterAgent(ctx context.Context, in *RegisterAgentRequest, opts ...grpc.CallOption) (*RegisterAgentResponse, error)
+	// Signed by `agent_id`. Renews the registration and records the agent's
+	// health without re-sending its registration or secret.
+	Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error)
+	// Signed by `agent_id`. Takes the agent off the bus; requests it has not
+	// accepted are rerouted or failed.
+	Deregister(ctx context.Context, in *DeregisterRequest, opts ...grpc.CallOption) (*DeregisterResponse, error)
+	// Signed by `from`.
+	SendMessage(ctx context.Context, in *SendMessageRequest, opts ...grpc.CallOption) (*SendMessageResponse, error)
+	// Signed by `agent_id`. Lists the agent's messages still waiting for their
+	// delivery time, earliest first.
+	ListScheduled(ctx context.Context, in *ListScheduledRequest, opts ...grpc.CallOption) (*ListScheduledResponse, error)
+	// Signed by `agent_id`, the sender. Cancels a scheduled message before it
+	// is delivered.
+	CancelScheduled(ctx context.Context, in *CancelScheduledRequest, opts ...grpc.CallOption) (*CancelScheduledResponse, error)
+	// Signed by `agent_id`. Streams inbox events after `cursor` until cancelled.
+	PollInbox(ctx context.Context, in *PollInboxRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[InboxEvent], error)
+	// Signed by `agent_id`.
+	Ack(ctx context.Context, in *AckRequest, opts ...grpc.CallOption) (*AckResponse, error)
+	// Signed by `agent_id`, the agent that owns the message.
+	PostEvent(ctx context.Context, in *PostEventRequest, opts ...grpc.CallOption) (*PostEventResponse, error)
+	// Unsigned. Streams observe events after `cursor` until cancelled.
+	ObserveSince(ctx context.Context, in *ObserveRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ObserveEvent], error)
+	// Unsigned; gated by HUMAN_ALLOWLIST.
+	Inject(ctx context.Context, in *InjectRequest, opts ...grpc.CallOption) (*InjectResponse, error)
+}
+
+type busClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewBusClient(cc grpc.ClientConnInterface) BusClient {
+	return &busClient{cc}
+}
+
+func (c *busClient) RegisterAgent(ctx context.Context, in *RegisterAgentRequest, opts ...grpc.CallOption) (*RegisterAgentResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RegisterAgentResponse)
+	err := c.cc.Invoke(ctx, Bus_RegisterAgent_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *busClient) Heartbeat(ctx context.Context, in *HeartbeatRequest, opts ...grpc.CallOption) (*HeartbeatResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(HeartbeatResponse)
+	err := c.cc.Invoke(ctx, Bus_Heartbeat_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *busClient) Deregister(ctx context.Context, in *DeregisterRequest, opts ...grpc.CallOption) (*DeregisterResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeregisterResponse)
+	err := c.cc.Invoke(ctx, Bus_Deregister_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *busClient) SendMessage(ctx context.Context, in *SendMessageRequest, opts ...grpc.CallOption) (*SendMessageResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SendMessageResponse)
+	err := c.cc.Invoke(ctx, Bus_SendMessage_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *busClient) ListScheduled(ctx context.Context, in *ListScheduledRequest, opts ...grpc.CallOption) (*ListScheduledResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListScheduledResponse)
+	err := c.cc.Invoke(ctx, Bus_ListScheduled_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *busClient) CancelScheduled(ctx context.Context, in *CancelScheduledRequest, opts ...grpc.CallOption) (*CancelScheduledResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CancelScheduledResponse)
+	err := c.cc.Invoke(ctx, Bus_CancelScheduled_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *busClient) PollInbox(ctx context.Context, in *PollInboxRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[InboxEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Bus_ServiceDesc.Streams[0], Bus_PollInbox_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[PollInboxRequest, InboxEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Bus_PollInboxClient = grpc.ServerStreamingClient[InboxEvent]
+
+func (c *busClient) Ack(ctx context.Context, in *AckRequest, opts ...grpc.CallOption) (*AckResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AckResponse)
+	err := c.cc.Invoke(ctx, Bus_Ack_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *busClient) PostEvent(ctx context.Context, in *PostEventRequest, opts ...grpc.CallOption) (*PostEventResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PostEventResponse)
+	err := c.cc.Invoke(ctx, Bus_PostEvent_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *busClient) ObserveSince(ctx context.Context, in *ObserveRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ObserveEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Bus_ServiceDesc.Streams[1], Bus_ObserveSince_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ObserveRequest, ObserveEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Bus_ObserveSinceClient = grpc.ServerStreamingClient[ObserveEvent]
+
+func (c *busClient) Inject(ctx context.Context, in *InjectRequest, opts ...grpc.CallOption) (*InjectResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(InjectResponse)
+	err := c.cc.Invoke(ctx, Bus_Inject_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// BusServer is the server API for Bus service.
+// All implementations must embed UnimplementedBusServer
+// for forward compatibility.
+type BusServer interface {
+	// Registers or refreshes an agent. Carries the agent secret; unsigned.
+	RegisterAgent(context.Context, *RegisterAgentRequest) (*RegisterAgentResponse, error)
+	// Signed by `agent_id`. Renews the registration and records the agent's
+	// health without re-sending its registration or secret.
+	Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error)
+	// Signed by `agent_id`. Takes the agent off the bus; requests it has not
+	// accepted are rerouted or failed.
+	Deregister(context.Context, *DeregisterRequest) (*DeregisterResponse, error)
+	// Signed by `from`.
+	SendMessage(context.Context, *SendMessageRequest) (*SendMessageResponse, error)
+	// Signed by `agent_id`. Lists the agent's messages still waiting for their
+	// delivery time, earliest first.
+	ListScheduled(context.Context, *ListScheduledRequest) (*ListScheduledResponse, error)
+	// Signed by `agent_id`, the sender. Cancels a scheduled message before it
+	// is delivered.
+	CancelScheduled(context.Context, *CancelScheduledRequest) (*CancelScheduledResponse, error)
+	// Signed by `agent_id`. Streams inbox events after `cursor` until cancelled.
+	PollInbox(*PollInboxRequest, grpc.ServerStreamingServer[InboxEvent]) error
+	// Signed by `agent_id`.
+	Ack(context.Context, *AckRequest) (*AckResponse, error)
+	// Signed by `agent_id`, the agent that owns the message.
+	PostEvent(context.Context, *PostEventRequest) (*PostEventResponse, error)
+	// Unsigned. Streams observe events after `cursor` until cancelled.
+	ObserveSince(*ObserveRequest, grpc.ServerStreamingServer[ObserveEvent]) error
+	// Unsigned; gated by HUMAN_ALLOWLIST.
+	Inject(context.Context, *InjectRequest) (*InjectResponse, error)
+	mustEmbedUnimplementedBusServer()
+}
+
+// UnimplementedBusServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedBusServer struct{}
+
+func (UnimplementedBusServer) RegisterAgent(context.Context, *RegisterAgentRequest) (*RegisterAgentResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RegisterAgent not implemented")
+}
+func (UnimplementedBusServer) Heartbeat(context.Context, *HeartbeatRequest) (*HeartbeatResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Heartbeat not implemented")
+}
+func (UnimplementedBusServer) Deregister(context.Context, *DeregisterRequest) (*DeregisterResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Deregister not implemented")
+}
+func (UnimplementedBusServer) SendMessage(context.Context, *SendMessageRequest) (*SendMessageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SendMessage not implemented")
+}
+func (UnimplementedBusServer) ListScheduled(context.Context, *ListScheduledRequest) (*ListScheduledResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListScheduled not implemented")
+}
+func (UnimplementedBusServer) CancelScheduled(context.Context, *CancelScheduledRequest) (*CancelScheduledResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelScheduled not implemented")
+}
+func (UnimplementedBusServer) PollInbox(*PollInboxRequest, grpc.ServerStreamingServer[InboxEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method PollInbox not implemented")
+}
+func (UnimplementedBusServer) Ack(context.Context, *AckRequest) (*AckResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Ack not implemented")
+}
+func (UnimplementedBusServer) PostEvent(context.Context, *PostEventRequest) (*PostEventResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PostEvent not implemented")
+}
+func (UnimplementedBusServer) ObserveSince(*ObserveRequest, grpc.ServerStreamingServer[ObserveEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method ObserveSince not implemented")
+}
+func (UnimplementedBusServer) Inject(context.Context, *InjectRequest) (*InjectResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Inject not implemented")
+}
+func (UnimplementedBusServer) mustEmbedUnimplementedBusServer() {}
+func (UnimplementedBusServer) testEmbeddedByValue()             {}
+
+// UnsafeBusServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to BusServer will
+// result in compilation errors.
+type UnsafeBusServer interface {
+	mustEmbedUnimplementedBusServer()
+}
+
+func RegisterBusServer(s grpc.ServiceRegistrar, srv BusServer) {
+	// If the following call pancis, it indicates UnimplementedBusServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Bus_ServiceDesc, srv)
+}
+
+func _Bus_RegisterAgent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterAgentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BusServer).RegisterAgent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Bus_RegisterAgent_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BusServer).RegisterAgent(ctx, req.(*RegisterAgentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Bus_Heartbeat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HeartbeatRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BusServer).Heartbeat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Bus_Heartbeat_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BusServer).Heartbeat(ctx, req.(*HeartbeatRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Bus_Deregister_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeregisterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BusServer).Deregister(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Bus_Deregister_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BusServer).Deregister(ctx, req.(*DeregisterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Bus_SendMessage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SendMessageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BusServer).SendMessage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Bus_SendMessage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BusServer).SendMessage(ctx, req.(*SendMessageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Bus_ListScheduled_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListScheduledRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BusServer).ListScheduled(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Bus_ListScheduled_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BusServer).ListScheduled(ctx, req.(*ListScheduledRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Bus_CancelScheduled_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelScheduledRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BusServer).CancelScheduled(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Bus_CancelScheduled_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BusServer).CancelScheduled(ctx, req.(*CancelScheduledRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Bus_PollInbox_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(PollInboxRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BusServer).PollInbox(m, &grpc.GenericServerStream[PollInboxRequest, InboxEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Bus_PollInboxServer = grpc.ServerStreamingServer[InboxEvent]
+
+func _Bus_Ack_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AckRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BusServer).Ack(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Bus_Ack_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BusServer).Ack(ctx, req.(*AckRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Bus_PostEvent_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PostEventRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BusServer).PostEvent(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Bus_PostEvent_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BusServer).PostEvent(ctx, req.(*PostEventRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Bus_ObserveSince_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ObserveRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BusServer).ObserveSince(m, &grpc.GenericServerStream[ObserveRequest, ObserveEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Bus_ObserveSinceServer = grpc.ServerStreamingServer[ObserveEvent]
+
+func _Bus_Inject_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InjectRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BusServer).Inject(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Bus_Inject_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BusServer).Inject(ctx, req.(*InjectRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Bus_ServiceDesc is the grpc.ServiceDesc for Bus service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Bus_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "techtransfer.bus.v1.Bus",
+	HandlerType: (*BusServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "RegisterAgent",
+			Handler:    _Bus_RegisterAgent_Handler,
+		},
+		{
+			MethodName: "Heartbeat",
+			Handler:    _Bus_Heartbeat_Handler,
+		},
+		{
+			MethodName: "Deregister",
+			Handler:    _Bus_Deregister_Handler,
+		},
+		{
+			MethodName: "SendMessage",
+			Handler:    _Bus_SendMessage_Handler,
+		},
+		{
+			MethodName: "ListScheduled",
+			Handler:    _Bus_ListScheduled_Handler,
+		},
+		{
+			MethodName: "CancelScheduled",
+			Handler:    _Bus_CancelScheduled_Handler,
+		},
+		{
+			MethodName: "Ack",
+			Handler:    _Bus_Ack_Handler,
+		},
+		{
+			MethodName: "PostEvent",
+			Handler:    _Bus_PostEvent_Handler,
+		},
+		{
+			MethodName: "Inject",
+			Handler:    _Bus_Inject_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "PollInbox",
+			Handler:       _Bus_PollInbox_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ObserveSince",
+			Handler:       _Bus_ObserveSince_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "bus/v1/bus.proto",
+}
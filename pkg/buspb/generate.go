@@ -0,0 +1,7 @@
+// Package buspb is the Go code generated from proto/bus/v1/bus.proto: the bus
+// message types plus the Bus client and server stubs. Agents written in Go
+// can dial the bus gRPC port with NewBusClient; other languages generate
+// their own stubs from the same file.
+package buspb
+
+//go:generate protoc -I ../../proto --go_out=../.. --go_opt=module=github.com/joelkehle/techtransfer-agency --go-grpc_out=../.. --go-grpc_opt=module=github.com/joelkehle/techtransfer-agency bus/v1/bus.proto